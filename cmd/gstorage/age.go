@@ -0,0 +1,70 @@
+package gstorage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrNoFiles is returned when a directory holds no regular files
+var ErrNoFiles = errors.New("directory contains no files")
+
+// FileAge returns how long ago path was last modified
+func FileAge(path string) (time.Duration, error) {
+	stat, err := os.Stat(path)
+
+	if err != nil {
+		logger().Error("error reading file info", "path", path, "err", err)
+		return 0, err
+	}
+
+	return time.Since(stat.ModTime()), nil
+}
+
+// IsOlderThan reports whether path was last modified more than d ago
+func IsOlderThan(path string, d time.Duration) (bool, error) {
+	age, err := FileAge(path)
+
+	if err != nil {
+		return false, err
+	}
+
+	return age > d, nil
+}
+
+// NewestFileIn returns the path of the most recently modified regular file
+// directly inside dir. Subdirectories are not searched.
+//
+//	If dir has no regular files it returns ErrNoFiles
+func NewestFileIn(dir string) (string, error) {
+	entries, err := os.ReadDir(dir)
+
+	if err != nil {
+		logger().Error("error reading directory", "dir", dir, "err", err)
+		return "", err
+	}
+
+	newest := ""
+	var newestTime time.Time
+
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return "", err
+		}
+		if newest == "" || info.ModTime().After(newestTime) {
+			newest = filepath.Join(dir, entry.Name())
+			newestTime = info.ModTime()
+		}
+	}
+
+	if newest == "" {
+		return "", ErrNoFiles
+	}
+
+	return newest, nil
+}
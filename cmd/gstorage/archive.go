@@ -0,0 +1,589 @@
+package gstorage
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ArchiveCompression selects how CreateTar compresses the archive
+type ArchiveCompression int
+
+const (
+	// ArchiveUncompressed writes a plain tar file
+	ArchiveUncompressed ArchiveCompression = iota
+	// ArchiveGzip writes a gzip-compressed tar file (.tar.gz)
+	ArchiveGzip
+)
+
+// archivePermBits are the mode bits restored on extraction
+const archivePermBits = fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky
+
+// ErrUnsafeArchivePath is returned when an archive entry would be written
+// outside the extraction directory, whether by an absolute name, ".."
+// components or a symbolic link leading out ("zip slip")
+var ErrUnsafeArchivePath = errors.New("archive entry escapes destination")
+
+// ArchiveOptions tunes the archive functions. The zero value archives or
+// extracts everything, uncompressed.
+type ArchiveOptions struct {
+	// Compression is used by CreateTar. ExtractTar detects gzip by itself
+	// and zip archives always deflate their files
+	Compression ArchiveCompression
+
+	// Include, when set, limits the files archived or extracted to those
+	// matching one of its patterns. Exclude leaves out matching files and
+	// directories, with everything below them. Patterns use path.Match
+	// syntax, plus "**" for any number of directories, and are matched
+	// against the slash-separated path within the tree and against the
+	// base name, so "*.tmp" matches at any depth
+	Include []string
+	Exclude []string
+
+	// Handle, when set, lets the operation be paused and resumed between
+	// entries
+	Handle *OpHandle
+}
+
+func (o ArchiveOptions) filter() pathFilter {
+	return pathFilter{include: o.Include, exclude: o.Exclude}
+}
+
+// CreateTar packs the tree at srcDir into a tar file at dstFile, keeping
+// permissions, modification times and symbolic links. Special files are
+// left out. A partially written archive is removed on error
+func CreateTar(srcDir string, dstFile string, opts ArchiveOptions) (err error) {
+	summary := newOpSummary("CreateTar")
+	defer func() {
+		summary.finish(err)
+	}()
+
+	return createArchive(srcDir, dstFile, func(f io.Writer) (archiveWriter, error) {
+		var gz *gzip.Writer
+		switch opts.Compression {
+		case ArchiveUncompressed:
+		case ArchiveGzip:
+			gz = gzip.NewWriter(f)
+			f = gz
+		default:
+			return nil, fmt.Errorf("unknown archive compression %d", opts.Compression)
+		}
+		return &tarWriter{tw: tar.NewWriter(f), gz: gz}, nil
+	}, opts, summary)
+}
+
+// CreateZip packs the tree at srcDir into a zip file at dstFile, keeping
+// permissions, modification times and symbolic links. Special files are
+// left out. A partially written archive is removed on error
+func CreateZip(srcDir string, dstFile string, opts ArchiveOptions) (err error) {
+	summary := newOpSummary("CreateZip")
+	defer func() {
+		summary.finish(err)
+	}()
+
+	return createArchive(srcDir, dstFile, func(f io.Writer) (archiveWriter, error) {
+		return &zipWriter{zw: zip.NewWriter(f)}, nil
+	}, opts, summary)
+}
+
+// archiveWriter adds entries to an archive being created
+type archiveWriter interface {
+	// add writes one entry named rel. link is the target of symbolic links
+	add(path string, rel string, info fs.FileInfo, link string) error
+	close() error
+}
+
+func createArchive(srcDir string, dstFile string, open func(io.Writer) (archiveWriter, error), opts ArchiveOptions, summary *opSummary) error {
+	srcStat, err := os.Stat(srcDir)
+	if err != nil {
+		logger().Error("error while getting source info", "src", srcDir, "err", err)
+		return err
+	}
+	if !srcStat.IsDir() {
+		return pathError("archive", srcDir, ErrNotDirectory)
+	}
+	if err := checkOverwrite(dstFile); err != nil {
+		return err
+	}
+	opts.Handle.begin(summary, 1, nil)
+
+	f, err := os.Create(dstFile)
+	if err != nil {
+		logger().Error("unable to create archive", "path", dstFile, "err", err)
+		return err
+	}
+	aw, err := open(f)
+	if err == nil {
+		err = addArchiveTree(aw, srcDir, dstFile, opts, summary)
+		if closeErr := aw.close(); err == nil {
+			err = closeErr
+		}
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		logger().Error("error while creating archive", "src", srcDir, "path", dstFile, "err", err)
+		os.Remove(dstFile)
+		return err
+	}
+	return nil
+}
+
+// addArchiveTree adds every selected entry below srcDir to aw, leaving out
+// the archive itself when it is written inside the tree
+func addArchiveTree(aw archiveWriter, srcDir string, dstFile string, opts ArchiveOptions, summary *opSummary) error {
+	filter := opts.filter()
+	archiveInfo, _ := os.Stat(dstFile)
+
+	return filepath.WalkDir(srcDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(srcDir, path)
+		if rel == "." {
+			return nil
+		}
+		if filter.excluded(rel, d.IsDir()) {
+			logger().Debug("excluded from archive", "path", path)
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if isSpecial(d.Type()) {
+			logger().Debug("skipping special file", "path", path)
+			return nil
+		}
+		if err := opts.Handle.wait(context.Background()); err != nil {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if archiveInfo != nil && os.SameFile(info, archiveInfo) {
+			return nil
+		}
+		var link string
+		if isSymlink(info.Mode()) {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+
+		opts.Handle.working(1, path)
+		err = aw.add(path, filepath.ToSlash(rel), info, link)
+		opts.Handle.idle(1, info.Size(), err == nil && info.Mode().IsRegular())
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			summary.fileDone(info.Size())
+		}
+		return nil
+	})
+}
+
+type tarWriter struct {
+	tw *tar.Writer
+	gz *gzip.Writer
+}
+
+func (w *tarWriter) add(path string, rel string, info fs.FileInfo, link string) error {
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = rel
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+	if err := w.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	return copyArchiveFile(w.tw, path)
+}
+
+func (w *tarWriter) close() error {
+	err := w.tw.Close()
+	if w.gz != nil {
+		if gzErr := w.gz.Close(); err == nil {
+			err = gzErr
+		}
+	}
+	return err
+}
+
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func (w *zipWriter) add(path string, rel string, info fs.FileInfo, link string) error {
+	hdr, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	hdr.Name = rel
+	switch {
+	case info.IsDir():
+		hdr.Name += "/"
+	case info.Mode().IsRegular():
+		hdr.Method = zip.Deflate
+	}
+	entry, err := w.zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	switch {
+	case info.Mode().IsRegular():
+		return copyArchiveFile(entry, path)
+	case link != "":
+		// Zip stores the target of a link as its content
+		_, err := io.WriteString(entry, link)
+		return err
+	}
+	return nil
+}
+
+func (w *zipWriter) close() error {
+	return w.zw.Close()
+}
+
+func copyArchiveFile(dst io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(dst, f)
+	return err
+}
+
+// ExtractTar unpacks the tar file at srcFile, compressed with gzip or not,
+// into dstDir, restoring permissions, modification times and symbolic
+// links. Entries that would land outside dstDir fail the extraction with
+// ErrUnsafeArchivePath; hard links and special files are skipped
+func ExtractTar(srcFile string, dstDir string, opts ArchiveOptions) (err error) {
+	summary := newOpSummary("ExtractTar")
+	defer func() {
+		summary.finish(err)
+	}()
+
+	f, err := os.Open(srcFile)
+	if err != nil {
+		logger().Error("unable to open archive", "path", srcFile, "err", err)
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = bufio.NewReader(f)
+	if magic, _ := r.(*bufio.Reader).Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	x, err := newExtractor(dstDir, opts, summary)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			logger().Error("error reading archive", "path", srcFile, "err", err)
+			return err
+		}
+
+		var mode fs.FileMode
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			mode = fs.ModeDir
+		case tar.TypeReg:
+		case tar.TypeSymlink:
+			mode = fs.ModeSymlink
+		default:
+			logger().Debug("skipping unsupported archive entry", "name", hdr.Name, "type", hdr.Typeflag)
+			continue
+		}
+		mode |= hdr.FileInfo().Mode() & archivePermBits
+		if err := x.entry(hdr.Name, mode, hdr.ModTime, hdr.Linkname, tr); err != nil {
+			logger().Error("error extracting archive", "path", srcFile, "entry", hdr.Name, "err", err)
+			return err
+		}
+	}
+	return x.finish()
+}
+
+// ExtractZip unpacks the zip file at srcFile into dstDir, restoring
+// permissions, modification times and symbolic links. Entries that would
+// land outside dstDir fail the extraction with ErrUnsafeArchivePath
+func ExtractZip(srcFile string, dstDir string, opts ArchiveOptions) (err error) {
+	summary := newOpSummary("ExtractZip")
+	defer func() {
+		summary.finish(err)
+	}()
+
+	zr, err := zip.OpenReader(srcFile)
+	if err != nil {
+		logger().Error("unable to open archive", "path", srcFile, "err", err)
+		return err
+	}
+	defer zr.Close()
+
+	x, err := newExtractor(dstDir, opts, summary)
+	if err != nil {
+		return err
+	}
+	for _, file := range zr.File {
+		mode := file.Mode()
+		if !mode.IsDir() && !mode.IsRegular() && !isSymlink(mode) {
+			logger().Debug("skipping unsupported archive entry", "name", file.Name, "mode", mode)
+			continue
+		}
+		if err := x.zipEntry(file); err != nil {
+			logger().Error("error extracting archive", "path", srcFile, "entry", file.Name, "err", err)
+			return err
+		}
+	}
+	return x.finish()
+}
+
+// extractor writes archive entries below root, which has symbolic links
+// resolved so escapes can be detected
+type extractor struct {
+	root    string
+	opts    ArchiveOptions
+	filter  pathFilter
+	summary *opSummary
+
+	// dirs get their mode and times once everything inside is written,
+	// so read-only directories can still be filled
+	dirs []extractedDir
+}
+
+type extractedDir struct {
+	path  string
+	mode  fs.FileMode
+	mtime time.Time
+}
+
+func newExtractor(dstDir string, opts ArchiveOptions, summary *opSummary) (*extractor, error) {
+	if err := os.MkdirAll(dstDir, 0755); err != nil {
+		logger().Error("failed to create destination directory", "dst", dstDir, "err", err)
+		return nil, err
+	}
+	root, err := filepath.EvalSymlinks(dstDir)
+	if err != nil {
+		return nil, err
+	}
+	opts.Handle.begin(summary, 1, nil)
+	return &extractor{root: root, opts: opts, filter: opts.filter(), summary: summary}, nil
+}
+
+func (x *extractor) zipEntry(file *zip.File) error {
+	r, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	var link string
+	if isSymlink(file.Mode()) {
+		target, err := io.ReadAll(io.LimitReader(r, 4096))
+		if err != nil {
+			return err
+		}
+		link = string(target)
+	}
+	return x.entry(file.Name, file.Mode(), file.Modified, link, r)
+}
+
+// entry extracts one archive entry named name, reading file content from r
+func (x *extractor) entry(name string, mode fs.FileMode, mtime time.Time, link string, r io.Reader) error {
+	rel, err := archiveEntryPath(name)
+	if err != nil {
+		return err
+	}
+	if rel == "." {
+		return nil
+	}
+	if x.filter.excluded(rel, mode.IsDir()) || x.excludedParent(rel) {
+		logger().Debug("excluded from extraction", "name", name)
+		return nil
+	}
+	if err := x.opts.Handle.wait(context.Background()); err != nil {
+		return err
+	}
+
+	// The parent is resolved, links included, and checked before anything
+	// is created, so links planted by earlier entries cannot lead out
+	parent, err := x.resolve(x.root, filepath.Dir(rel))
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return err
+	}
+	target := filepath.Join(parent, filepath.Base(rel))
+
+	if mode.IsDir() {
+		dir, err := x.resolve(parent, filepath.Base(rel))
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		x.dirs = append(x.dirs, extractedDir{path: dir, mode: mode, mtime: mtime})
+		return nil
+	}
+
+	if err := checkOverwrite(target); err != nil {
+		return err
+	}
+	// Never write through a link already at target, which could lead out
+	if info, err := os.Lstat(target); err == nil && !info.IsDir() {
+		if err := os.Remove(target); err != nil {
+			return err
+		}
+	}
+
+	if isSymlink(mode) {
+		if filepath.IsAbs(link) || filepath.VolumeName(link) != "" {
+			return fmt.Errorf("%s -> %s: %w", name, link, ErrUnsafeArchivePath)
+		}
+		if _, err := x.resolve(parent, link); err != nil {
+			return fmt.Errorf("%s -> %s: %w", name, link, err)
+		}
+		return os.Symlink(link, target)
+	}
+
+	x.opts.Handle.working(1, name)
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		x.opts.Handle.idle(1, 0, false)
+		return err
+	}
+	n, err := io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(target, mode&archivePermBits)
+	}
+	if err == nil {
+		err = os.Chtimes(target, time.Time{}, mtime)
+	}
+	x.opts.Handle.idle(1, n, err == nil)
+	if err != nil {
+		os.Remove(target)
+		return err
+	}
+	x.summary.fileDone(n)
+	return nil
+}
+
+// excludedParent reports whether a directory above rel is excluded, which
+// excludes rel with it
+func (x *extractor) excludedParent(rel string) bool {
+	for dir := filepath.Dir(rel); dir != "."; dir = filepath.Dir(dir) {
+		if x.filter.excluded(dir, true) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve follows rel from the resolved directory base one element at a
+// time, as the filesystem would, resolving the links it meets on the way.
+// It fails with ErrUnsafeArchivePath if the result, or a link that cannot
+// be resolved, is not inside the extraction root. Elements that do not
+// exist yet are taken as they are
+func (x *extractor) resolve(base string, rel string) (string, error) {
+	path := base
+	for _, elem := range strings.Split(filepath.FromSlash(rel), string(filepath.Separator)) {
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			path = filepath.Dir(path)
+			continue
+		}
+		path = filepath.Join(path, elem)
+		info, err := os.Lstat(path)
+		if err != nil || !isSymlink(info.Mode()) {
+			continue
+		}
+		resolved, err := filepath.EvalSymlinks(path)
+		if err != nil || !x.inside(resolved) {
+			return "", ErrUnsafeArchivePath
+		}
+		path = resolved
+	}
+	if !x.inside(path) {
+		return "", ErrUnsafeArchivePath
+	}
+	return path, nil
+}
+
+// inside reports whether path is root or below it, comparing lexically
+func (x *extractor) inside(path string) bool {
+	rel, err := filepath.Rel(x.root, filepath.Clean(path))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// finish applies the modes and times of extracted directories, deepest
+// first so setting a parent's mtime is not undone by its children
+func (x *extractor) finish() error {
+	for i := len(x.dirs) - 1; i >= 0; i-- {
+		dir := x.dirs[i]
+		// Checked again in case the path no longer leads where it did
+		if resolved, err := filepath.EvalSymlinks(dir.path); err != nil || resolved != dir.path || !x.inside(resolved) {
+			return fmt.Errorf("%s: %w", dir.path, ErrUnsafeArchivePath)
+		}
+		if err := os.Chmod(dir.path, dir.mode&archivePermBits); err != nil {
+			return err
+		}
+		if err := os.Chtimes(dir.path, time.Time{}, dir.mtime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// archiveEntryPath turns an archive entry name into a relative local path,
+// refusing absolute names and names climbing out with ".."
+func archiveEntryPath(name string) (string, error) {
+	local := filepath.FromSlash(strings.ReplaceAll(name, `\`, "/"))
+	if filepath.IsAbs(local) || filepath.VolumeName(local) != "" || strings.HasPrefix(local, string(filepath.Separator)) {
+		return "", fmt.Errorf("%s: %w", name, ErrUnsafeArchivePath)
+	}
+	local = filepath.Clean(local)
+	if local == ".." || strings.HasPrefix(local, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s: %w", name, ErrUnsafeArchivePath)
+	}
+	return local, nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package gstorage
+
+import (
+	"os"
+	"time"
+)
+
+// accessTime falls back to the modification time where atime is unavailable
+func accessTime(info os.FileInfo) time.Time {
+	return info.ModTime()
+}
@@ -0,0 +1,142 @@
+package gstorage
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// BenchmarkOptions sizes the workload used by RunBenchmark. Zero fields take
+// the defaults noted on each field.
+type BenchmarkOptions struct {
+	// FileSize is the size of the large file used for sequential copy and
+	// hash throughput (default 64MB)
+	FileSize int64
+	// SmallFiles is the number of files in the small-file tree (default 1000)
+	SmallFiles int
+	// SmallFileSize is the size of each small file (default 4KB)
+	SmallFileSize int
+	// Workers lists the worker counts to measure WorkerPoolCopyDir with
+	// (default 1, 4 and 8). Each count is measured once; counts below 1
+	// are measured as 1
+	Workers []int
+}
+
+// BenchmarkResult holds the measurements taken by RunBenchmark. Every
+// measurement reads files RunBenchmark has just written, which are usually
+// still in the page cache, so these are warm-cache figures: the copies
+// mostly measure writing to the target, and Hash the speed of hashing
+// rather than of reading cold data
+type BenchmarkResult struct {
+	// SequentialCopy is CopyFile throughput in bytes per second
+	SequentialCopy float64
+	// Hash is CalculateFileMD5 throughput in bytes per second
+	Hash float64
+	// SmallFileOps is CopyDir throughput over the small-file tree in files per second
+	SmallFileOps float64
+	// ParallelCopy maps a worker count to WorkerPoolCopyDir throughput over
+	// the small-file tree in files per second
+	ParallelCopy map[int]float64
+}
+
+// RunBenchmark measures copy and hash throughput on the filesystem holding
+// target. It works in a temporary directory under target that is removed
+// before returning. Use it to pick a worker count for WorkerPoolCopyDir.
+func RunBenchmark(target string, opts BenchmarkOptions) (*BenchmarkResult, error) {
+	if opts.FileSize <= 0 {
+		opts.FileSize = 64 * 1024 * 1024
+	}
+	if opts.SmallFiles <= 0 {
+		opts.SmallFiles = 1000
+	}
+	if opts.SmallFileSize <= 0 {
+		opts.SmallFileSize = 4 * 1024
+	}
+	if len(opts.Workers) == 0 {
+		opts.Workers = []int{1, 4, 8}
+	}
+	workerCounts := make([]int, 0, len(opts.Workers))
+	for _, workers := range opts.Workers {
+		workerCounts = append(workerCounts, max(workers, 1))
+	}
+	slices.Sort(workerCounts)
+	workerCounts = slices.Compact(workerCounts)
+
+	workDir, err := os.MkdirTemp(target, "gstorage-bench-*")
+	if err != nil {
+		logger().Error("error creating benchmark directory", "target", target, "err", err)
+		return nil, err
+	}
+	defer os.RemoveAll(workDir)
+
+	result := &BenchmarkResult{ParallelCopy: make(map[int]float64)}
+
+	largeFile := filepath.Join(workDir, "large.bin")
+	if err := writeRandomFile(largeFile, opts.FileSize); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	if err := CopyFile(largeFile, filepath.Join(workDir, "large.copy")); err != nil {
+		return nil, err
+	}
+	result.SequentialCopy = rate(float64(opts.FileSize), time.Since(start))
+
+	start = time.Now()
+	if _, err := CalculateFileMD5(largeFile); err != nil {
+		return nil, err
+	}
+	result.Hash = rate(float64(opts.FileSize), time.Since(start))
+
+	smallDir := filepath.Join(workDir, "small")
+	if err := os.Mkdir(smallDir, 0755); err != nil {
+		return nil, err
+	}
+	for i := 0; i < opts.SmallFiles; i++ {
+		if err := writeRandomFile(filepath.Join(smallDir, fmt.Sprintf("file_%d", i)), int64(opts.SmallFileSize)); err != nil {
+			return nil, err
+		}
+	}
+
+	start = time.Now()
+	if err := CopyDir(smallDir, filepath.Join(workDir, "small.copy")); err != nil {
+		return nil, err
+	}
+	result.SmallFileOps = rate(float64(opts.SmallFiles), time.Since(start))
+
+	for _, workers := range workerCounts {
+		dst := filepath.Join(workDir, fmt.Sprintf("small.pool%d", workers))
+		if err := os.Mkdir(dst, 0755); err != nil {
+			return nil, err
+		}
+		start = time.Now()
+		if err := WorkerPoolCopyDir(smallDir, dst, workers); err != nil {
+			return nil, err
+		}
+		result.ParallelCopy[workers] = rate(float64(opts.SmallFiles), time.Since(start))
+	}
+
+	return result, nil
+}
+
+func writeRandomFile(path string, size int64) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.CopyN(file, rand.Reader, size)
+	return err
+}
+
+func rate(amount float64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		elapsed = time.Nanosecond
+	}
+	return amount / elapsed.Seconds()
+}
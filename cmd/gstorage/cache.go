@@ -0,0 +1,133 @@
+package gstorage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type cacheEntry[T any] struct {
+	value   T
+	err     error
+	expires time.Time
+}
+
+// MetadataCache remembers directory listings, file info and MD5 hashes for
+// a fixed TTL, so repeated lookups against slow filesystems skip the round
+// trip. Call Invalidate after changing a path through other means.
+//
+//	A MetadataCache is safe for concurrent use
+type MetadataCache struct {
+	ttl      time.Duration
+	mu       sync.Mutex
+	listings map[string]cacheEntry[[]os.DirEntry]
+	stats    map[string]cacheEntry[os.FileInfo]
+	hashes   map[string]cacheEntry[string]
+}
+
+// NewMetadataCache returns an empty cache whose entries live for ttl
+func NewMetadataCache(ttl time.Duration) *MetadataCache {
+	return &MetadataCache{
+		ttl:      ttl,
+		listings: make(map[string]cacheEntry[[]os.DirEntry]),
+		stats:    make(map[string]cacheEntry[os.FileInfo]),
+		hashes:   make(map[string]cacheEntry[string]),
+	}
+}
+
+func lookup[T any](c *MetadataCache, entries map[string]cacheEntry[T], key string, load func() (T, error), keepErr func(error) bool) (T, error) {
+	key = filepath.Clean(key)
+	now := time.Now()
+
+	c.mu.Lock()
+	entry, ok := entries[key]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.value, entry.err
+	}
+
+	value, err := load()
+	if err == nil || keepErr(err) {
+		c.mu.Lock()
+		entries[key] = cacheEntry[T]{value: value, err: err, expires: now.Add(c.ttl)}
+		c.mu.Unlock()
+	}
+	return value, err
+}
+
+func neverCacheErr(error) bool { return false }
+
+// ListDir returns the cached listing of dirPath, reading it with ListDir on a miss
+func (c *MetadataCache) ListDir(dirPath string) ([]os.DirEntry, error) {
+	return lookup(c, c.listings, dirPath, func() ([]os.DirEntry, error) {
+		return ListDir(dirPath)
+	}, neverCacheErr)
+}
+
+// Stat returns the cached file info of path. Missing paths are cached too.
+func (c *MetadataCache) Stat(path string) (os.FileInfo, error) {
+	return lookup(c, c.stats, path, func() (os.FileInfo, error) {
+		return os.Stat(path)
+	}, os.IsNotExist)
+}
+
+// FileExists reports whether path exists, using the cached file info
+func (c *MetadataCache) FileExists(path string) (bool, error) {
+	_, err := c.Stat(path)
+	if err == nil {
+		return true, nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+// CalculateFileMD5 returns the cached MD5 of path, hashing it on a miss
+func (c *MetadataCache) CalculateFileMD5(path string) (string, error) {
+	return lookup(c, c.hashes, path, func() (string, error) {
+		return CalculateFileMD5(path)
+	}, neverCacheErr)
+}
+
+// Invalidate drops everything cached for path, anything below it, and the
+// listing of its parent directory
+func (c *MetadataCache) Invalidate(path string) {
+	path = filepath.Clean(path)
+	prefix := path + string(filepath.Separator)
+	matches := func(key string) bool {
+		return key == path || strings.HasPrefix(key, prefix)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.listings, filepath.Dir(path))
+	for key := range c.listings {
+		if matches(key) {
+			delete(c.listings, key)
+		}
+	}
+	for key := range c.stats {
+		if matches(key) {
+			delete(c.stats, key)
+		}
+	}
+	for key := range c.hashes {
+		if matches(key) {
+			delete(c.hashes, key)
+		}
+	}
+}
+
+// InvalidateAll empties the cache
+func (c *MetadataCache) InvalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	clear(c.listings)
+	clear(c.stats)
+	clear(c.hashes)
+}
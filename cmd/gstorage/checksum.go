@@ -0,0 +1,110 @@
+package gstorage
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+)
+
+// HashAlgorithm selects the checksum computed by CalculateFileHash
+type HashAlgorithm int
+
+const (
+	HashMD5 HashAlgorithm = iota
+	HashSHA1
+	HashSHA256
+	HashSHA512
+	// HashCRC32 is the IEEE CRC-32 used by zip and gzip
+	HashCRC32
+	// HashXXH64 is the non-cryptographic xxHash64 with a seed of 0, as
+	// printed by xxhsum
+	HashXXH64
+)
+
+// ErrHashMismatch is returned by VerifyFileHash when a file does not hash
+// to the expected digest
+var ErrHashMismatch = errors.New("file does not match expected hash")
+
+func (a HashAlgorithm) String() string {
+	switch a {
+	case HashMD5:
+		return "md5"
+	case HashSHA1:
+		return "sha1"
+	case HashSHA256:
+		return "sha256"
+	case HashSHA512:
+		return "sha512"
+	case HashCRC32:
+		return "crc32"
+	case HashXXH64:
+		return "xxh64"
+	default:
+		return fmt.Sprintf("HashAlgorithm(%d)", int(a))
+	}
+}
+
+// newHash returns a fresh hash.Hash for algo
+func newHash(algo HashAlgorithm) (hash.Hash, error) {
+	switch algo {
+	case HashMD5:
+		return md5.New(), nil
+	case HashSHA1:
+		return sha1.New(), nil
+	case HashSHA256:
+		return sha256.New(), nil
+	case HashSHA512:
+		return sha512.New(), nil
+	case HashCRC32:
+		return crc32.NewIEEE(), nil
+	case HashXXH64:
+		return newXXHash64(), nil
+	default:
+		return nil, fmt.Errorf("unknown hash algorithm %d", algo)
+	}
+}
+
+// CalculateFileHash streams filename through algo and returns the digest
+// as lower-case hex. CalculateFileHash(path, HashMD5) equals
+// CalculateFileMD5(path)
+func CalculateFileHash(filename string, algo HashAlgorithm) (string, error) {
+	sum, err := newHash(algo)
+	if err != nil {
+		return "", err
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(sum, file); err != nil {
+		logger().Error("error hashing file", "path", filename, "algo", algo, "err", err)
+		return "", err
+	}
+	return hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+// VerifyFileHash hashes filename with algo and fails with ErrHashMismatch
+// unless it matches expected, a hex digest in either case
+func VerifyFileHash(filename string, algo HashAlgorithm, expected string) error {
+	actual, err := CalculateFileHash(filename, algo)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(actual, strings.TrimSpace(expected)) {
+		logger().Error("hash mismatch", "path", filename, "algo", algo, "want", expected, "got", actual)
+		return pathError("verify", filename, ErrHashMismatch)
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package gstorage
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+// Codec selects the compression format of CompressFile
+type Codec int
+
+const (
+	// CodecGzip is gzip (RFC 1952), readable by gzip and zcat
+	CodecGzip Codec = iota
+	// CodecLZ4 is the LZ4 frame format, readable by the lz4 tool. It is
+	// much faster than gzip at the cost of larger output
+	CodecLZ4
+	// CodecZstd is the Zstandard frame format (RFC 8878), readable by the
+	// zstd tool. It compresses better than gzip and faster
+	CodecZstd
+)
+
+func (c Codec) String() string {
+	switch c {
+	case CodecGzip:
+		return "gzip"
+	case CodecLZ4:
+		return "lz4"
+	case CodecZstd:
+		return "zstd"
+	default:
+		return fmt.Sprintf("Codec(%d)", int(c))
+	}
+}
+
+// ErrUnknownCodec is returned by DecompressFile for content in a format it
+// does not recognise or cannot decode
+var ErrUnknownCodec = errors.New("unknown compression format")
+
+// CompressOptions tunes CompressFileWithOptions. The zero value writes gzip
+// at its default level.
+type CompressOptions struct {
+	Codec Codec
+
+	// Level is the compression level, 0 being the codec's default: for
+	// gzip from gzip.BestSpeed (1) to gzip.BestCompression (9), for LZ4
+	// from 1 to 9, with levels above 1 using its slower high-compression
+	// mode, and for zstd from 1 to 22 as in the zstd tool, mapped onto the
+	// encoder's four speeds
+	Level int
+}
+
+// CompressFile compresses srcfile into dstfile with codec, streaming so
+// files of any size use constant memory
+func CompressFile(srcfile string, dstfile string, codec Codec) error {
+	return CompressFileWithOptions(srcfile, dstfile, CompressOptions{Codec: codec})
+}
+
+// CompressFileWithOptions compresses srcfile into dstfile like CompressFile,
+// applying opts. A partially written dstfile is removed on error
+func CompressFileWithOptions(srcfile string, dstfile string, opts CompressOptions) error {
+	return transcodeFile("compress", srcfile, dstfile, func(src io.Reader, dst io.Writer) error {
+		w, err := compressWriter(dst, opts)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(w, src); err != nil {
+			return err
+		}
+		return w.Close()
+	})
+}
+
+// compressWriter returns a writer compressing into dst as opts ask
+func compressWriter(dst io.Writer, opts CompressOptions) (io.WriteCloser, error) {
+	switch opts.Codec {
+	case CodecGzip:
+		level := opts.Level
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(dst, level)
+	case CodecLZ4:
+		if opts.Level < 0 || opts.Level >= len(lz4Levels) {
+			return nil, fmt.Errorf("lz4: invalid compression level: %d", opts.Level)
+		}
+		w := lz4.NewWriter(dst)
+		if err := w.Apply(lz4.ChecksumOption(true), lz4.CompressionLevelOption(lz4Levels[opts.Level])); err != nil {
+			return nil, err
+		}
+		return w, nil
+	case CodecZstd:
+		if opts.Level < 0 || opts.Level > 22 {
+			return nil, fmt.Errorf("zstd: invalid compression level: %d", opts.Level)
+		}
+		level := zstd.SpeedDefault
+		if opts.Level != 0 {
+			level = zstd.EncoderLevelFromZstd(opts.Level)
+		}
+		return zstd.NewWriter(dst, zstd.WithEncoderLevel(level))
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownCodec, opts.Codec)
+	}
+}
+
+// lz4Levels maps CompressOptions.Level onto the LZ4 encoder's levels
+var lz4Levels = []lz4.CompressionLevel{
+	lz4.Fast, lz4.Fast, lz4.Level2, lz4.Level3, lz4.Level4, lz4.Level5,
+	lz4.Level6, lz4.Level7, lz4.Level8, lz4.Level9,
+}
+
+// DecompressFile decompresses srcfile into dstfile, detecting the codec
+// from the magic bytes at the start of srcfile. Content that is not gzip,
+// LZ4 or zstd fails with ErrUnknownCodec. A partially written dstfile is
+// removed on error
+func DecompressFile(srcfile string, dstfile string) error {
+	return transcodeFile("decompress", srcfile, dstfile, func(src io.Reader, dst io.Writer) error {
+		br := bufio.NewReader(src)
+		magic, _ := br.Peek(4)
+
+		var r io.Reader
+		switch {
+		case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+			gz, err := gzip.NewReader(br)
+			if err != nil {
+				return err
+			}
+			defer gz.Close()
+			r = gz
+		case bytes.Equal(magic, []byte{0x04, 0x22, 0x4d, 0x18}):
+			r = lz4.NewReader(br)
+		case bytes.Equal(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+			zr, err := zstd.NewReader(br, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return err
+			}
+			defer zr.Close()
+			r = zr
+		default:
+			return ErrUnknownCodec
+		}
+
+		_, err := io.Copy(dst, r)
+		return err
+	})
+}
+
+// transcodeFile streams srcfile through fn into dstfile, removing dstfile
+// if anything fails. Errors are reported as op on srcfile
+func transcodeFile(op string, srcfile string, dstfile string, fn func(io.Reader, io.Writer) error) error {
+	src, err := os.Open(srcfile)
+	if err != nil {
+		logger().Error("error opening source file", "src", srcfile, "err", err)
+		return err
+	}
+	defer src.Close()
+	if err := checkOverwrite(dstfile); err != nil {
+		return err
+	}
+
+	dst, err := os.Create(dstfile)
+	if err != nil {
+		logger().Error("error creating destination file", "dst", dstfile, "err", err)
+		return err
+	}
+	err = fn(bufio.NewReader(src), dst)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		logger().Error("error transcoding file", "src", srcfile, "dst", dstfile, "err", err)
+		os.Remove(dstfile)
+		return pathError(op, srcfile, err)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package gstorage
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ctxReader fails reads once its context is done, so a long io.Copy stops
+// within one buffer of cancellation
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// CopyFileCtx copies srcfile to dstfile like CopyFile. If ctx is cancelled
+// mid-copy the partial dstfile is removed and ctx.Err() returned
+func CopyFileCtx(ctx context.Context, srcfile string, dstfile string) error {
+	return copyFile(ctx, srcfile, dstfile, nil, nil)
+}
+
+// MoveFileCtx moves srcfile to dstfile like MoveFile unless ctx is already
+// done. The rename itself is atomic and cannot be interrupted
+func MoveFileCtx(ctx context.Context, srcfile string, dstfile string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return MoveFile(srcfile, dstfile)
+}
+
+// RemoveFileCtx removes srcfile like RemoveFile unless ctx is already done
+func RemoveFileCtx(ctx context.Context, srcfile string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return RemoveFile(srcfile)
+}
+
+// RemoveDirAllCtx removes targetDir and everything below it like
+// RemoveDirAll, checking ctx between entries. A cancelled removal returns
+// ctx.Err() and leaves whatever was not yet removed in place
+func RemoveDirAllCtx(ctx context.Context, targetDir string) error {
+	if err := checkDelete(targetDir); err != nil {
+		return err
+	}
+	if moved, err := softDelete(targetDir); moved || err != nil {
+		return err
+	}
+	err := removeAllCtx(ctx, targetDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		logger().Error("unable to remove directory", "dir", targetDir, "err", err)
+	}
+	return err
+}
+
+func removeAllCtx(ctx context.Context, path string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return os.Remove(path)
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		err := removeAllCtx(ctx, filepath.Join(path, entry.Name()))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Remove(path)
+}
+
+// CopyDirCtx copies srcDir into dstDir like CopyDir, stopping with
+// ctx.Err() when ctx is cancelled. Files already copied are kept; the file
+// being copied at the time is removed
+func CopyDirCtx(ctx context.Context, srcDir string, dstDir string) error {
+	return copyDirWithOptions(ctx, srcDir, dstDir, CopyOptions{})
+}
+
+// CopyDirWithOptionsCtx is CopyDirCtx applying opts
+func CopyDirWithOptionsCtx(ctx context.Context, srcDir string, dstDir string, opts CopyOptions) error {
+	return copyDirWithOptions(ctx, srcDir, dstDir, opts)
+}
+
+// WorkerPoolCopyDirCtx copies srcDir to dstDir like WorkerPoolCopyDir,
+// stopping every worker with ctx.Err() when ctx is cancelled
+func WorkerPoolCopyDirCtx(ctx context.Context, srcDir, dstDir string, workers int) error {
+	return workerPoolCopyDir(ctx, srcDir, dstDir, PoolOptions{Workers: workers})
+}
+
+// WorkerPoolCopyDirWithOptionsCtx is WorkerPoolCopyDirCtx applying opts
+func WorkerPoolCopyDirWithOptionsCtx(ctx context.Context, srcDir, dstDir string, opts PoolOptions) error {
+	return workerPoolCopyDir(ctx, srcDir, dstDir, opts)
+}
@@ -0,0 +1,88 @@
+package gstorage
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// FileError is one file that failed during a copy run with ContinueOnError
+type FileError struct {
+	Path string
+	Err  error
+}
+
+func (e FileError) Error() string {
+	return e.Path + ": " + e.Err.Error()
+}
+
+func (e FileError) Unwrap() error {
+	return e.Err
+}
+
+// CopyError is returned by copies run with ContinueOnError when any file
+// failed. It lists every failure, in the order they happened, next to the
+// totals of what was copied. errors.Is and errors.As look through to the
+// individual causes
+type CopyError struct {
+	Failed []FileError
+	// Copied and Bytes count the files that were copied successfully
+	Copied int64
+	Bytes  int64
+}
+
+func (e *CopyError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "copy failed for %d file(s), %d copied", len(e.Failed), e.Copied)
+	for i, f := range e.Failed {
+		if i == 3 {
+			fmt.Fprintf(&b, "; and %d more", len(e.Failed)-i)
+			break
+		}
+		b.WriteString("; ")
+		b.WriteString(f.Error())
+	}
+	return b.String()
+}
+
+func (e *CopyError) Unwrap() []error {
+	errs := make([]error, len(e.Failed))
+	for i, f := range e.Failed {
+		errs[i] = f
+	}
+	return errs
+}
+
+// failureList collects the files that failed during a copy run with
+// ContinueOnError
+type failureList struct {
+	mu    sync.Mutex
+	files []FileError
+}
+
+func (l *failureList) add(path string, err error) {
+	logger().Error("error copying file, continuing", "path", path, "err", err)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.files = append(l.files, FileError{Path: path, Err: err})
+}
+
+// err returns the collected failures, plus any verification mismatches, as
+// a CopyError with the totals of summary, or nil if nothing failed
+func (l *failureList) err(summary *opSummary, mismatched *mismatchList) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.files) == 0 {
+		return nil
+	}
+	failed := append([]FileError(nil), l.files...)
+	mismatched.mu.Lock()
+	for _, path := range mismatched.files {
+		failed = append(failed, FileError{Path: path, Err: ErrChecksumMismatch})
+	}
+	mismatched.mu.Unlock()
+	return &CopyError{Failed: failed, Copied: summary.files.Load(), Bytes: summary.bytes.Load()}
+}
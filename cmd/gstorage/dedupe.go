@@ -0,0 +1,212 @@
+package gstorage
+
+import (
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// partialHashSize is how much of each file FindDuplicateFiles hashes to
+// tell same-sized files apart before hashing them in full
+const partialHashSize = 4096
+
+// DuplicateSet is a group of files with identical content
+type DuplicateSet struct {
+	Size int64
+	// Hash is the hex SHA-256 of the content
+	Hash  string
+	Paths []string
+}
+
+// Reclaimable is the space freed by keeping a single copy of the set
+func (s DuplicateSet) Reclaimable() int64 {
+	return s.Size * int64(len(s.Paths)-1)
+}
+
+// DuplicateReport is the result of FindDuplicateFiles
+type DuplicateReport struct {
+	// Sets are ordered by reclaimable space, largest first, with the paths
+	// of each set sorted
+	Sets []DuplicateSet
+	// Reclaimable is the total space freed by keeping one copy of each set
+	Reclaimable int64
+}
+
+// FindDuplicateFiles finds the regular files below roots with identical
+// content. Files are grouped by size first, then by a hash of their first
+// 4KB, and only files still sharing a group are hashed in full, so most
+// files are never read. Empty files, links and special files are ignored,
+// and hard links to one file count as a single file. Files and
+// directories that cannot be read are left out; only unreadable roots
+// fail the search
+func FindDuplicateFiles(roots []string) (*DuplicateReport, error) {
+	bySize := make(map[int64][]dupCandidate)
+	for _, root := range roots {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if d == nil {
+					return err
+				}
+				logger().Debug("skipping unreadable path", "path", path, "err", err)
+				return nil
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil || info.Size() == 0 {
+				return nil
+			}
+			bySize[info.Size()] = append(bySize[info.Size()], dupCandidate{path: path, info: info})
+			return nil
+		})
+		if err != nil {
+			logger().Error("error scanning for duplicates", "root", root, "err", err)
+			return nil, err
+		}
+	}
+
+	report := &DuplicateReport{}
+	for size, candidates := range bySize {
+		candidates = distinctFiles(candidates)
+		if len(candidates) < 2 {
+			continue
+		}
+		for partialHash, partial := range groupByHash(candidates, partialHashSize) {
+			full := map[string][]dupCandidate{partialHash: partial}
+			if size > partialHashSize {
+				full = groupByHash(partial, -1)
+			}
+			for hash, paths := range full {
+				set := DuplicateSet{Size: size, Hash: hash}
+				for _, c := range paths {
+					set.Paths = append(set.Paths, c.path)
+				}
+				sort.Strings(set.Paths)
+				report.Sets = append(report.Sets, set)
+				report.Reclaimable += set.Reclaimable()
+			}
+		}
+	}
+	sort.Slice(report.Sets, func(i, j int) bool {
+		a, b := report.Sets[i], report.Sets[j]
+		if a.Reclaimable() != b.Reclaimable() {
+			return a.Reclaimable() > b.Reclaimable()
+		}
+		return a.Paths[0] < b.Paths[0]
+	})
+	return report, nil
+}
+
+// dupCandidate is a file considered by FindDuplicateFiles
+type dupCandidate struct {
+	path string
+	info fs.FileInfo
+}
+
+// distinctFiles drops candidates that are hard links to, or repeated
+// visits of, an earlier candidate
+func distinctFiles(candidates []dupCandidate) []dupCandidate {
+	var distinct []dupCandidate
+next:
+	for _, c := range candidates {
+		for _, kept := range distinct {
+			if os.SameFile(c.info, kept.info) {
+				continue next
+			}
+		}
+		distinct = append(distinct, c)
+	}
+	return distinct
+}
+
+// groupByHash groups candidates by the SHA-256 of their first limit bytes,
+// or of their whole content for a negative limit, returning only groups
+// of two or more. Unreadable files are left out
+func groupByHash(candidates []dupCandidate, limit int64) map[string][]dupCandidate {
+	groups := make(map[string][]dupCandidate)
+	for _, c := range candidates {
+		hash, err := hashPrefix(c.path, limit)
+		if err != nil {
+			logger().Debug("skipping unreadable file", "path", c.path, "err", err)
+			continue
+		}
+		groups[hash] = append(groups[hash], c)
+	}
+	for hash, group := range groups {
+		if len(group) < 2 {
+			delete(groups, hash)
+		}
+	}
+	return groups
+}
+
+// hashPrefix returns the hex SHA-256 of the first limit bytes of path, or
+// of all of it for a negative limit
+func hashPrefix(path string, limit int64) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h, _ := newHash(HashSHA256)
+	var r io.Reader = f
+	if limit >= 0 {
+		r = io.LimitReader(f, limit)
+	}
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// DeduplicateWithHardlinks replaces every file of set but the first with a
+// hard link to the first, returning the bytes freed. Each file is compared
+// with the first byte for byte just before it is replaced, and files that
+// changed since the search are left alone. Replacements are atomic, but
+// all paths must be on one filesystem
+func DeduplicateWithHardlinks(set DuplicateSet) (int64, error) {
+	if len(set.Paths) < 2 {
+		return 0, nil
+	}
+	keep := set.Paths[0]
+	keepInfo, err := os.Stat(keep)
+	if err != nil {
+		return 0, err
+	}
+	var freed int64
+	for _, path := range set.Paths[1:] {
+		if info, err := os.Stat(path); err == nil && os.SameFile(keepInfo, info) {
+			continue
+		}
+		same, err := sameContent(keep, path)
+		if err != nil {
+			return freed, err
+		}
+		if !same {
+			logger().Debug("skipping changed duplicate", "path", path)
+			continue
+		}
+		if err := checkOverwrite(path); err != nil {
+			return freed, err
+		}
+
+		tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".gstorage-link")
+		if err := os.Link(keep, tmp); err != nil {
+			logger().Error("error linking duplicate", "src", keep, "dst", path, "err", err)
+			return freed, err
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			os.Remove(tmp)
+			logger().Error("error replacing duplicate", "path", path, "err", err)
+			return freed, err
+		}
+		freed += set.Size
+	}
+	logger().Info("deduplicated files", "keep", keep, "files", len(set.Paths)-1, "bytes", freed)
+	return freed, nil
+}
@@ -0,0 +1,21 @@
+package gstorage
+
+import (
+	"errors"
+	"io/fs"
+)
+
+// Errors returned inside an *fs.PathError naming the operation and path.
+// Test for them with errors.Is.
+var (
+	// ErrNotDirectory is returned when a directory was required
+	ErrNotDirectory = errors.New("not a directory")
+	// ErrIsDirectory is returned when a file was required but path is a directory
+	ErrIsDirectory = errors.New("is a directory")
+	// ErrDirectoryNotEmpty is returned by RemoveDir for non-empty directories
+	ErrDirectoryNotEmpty = errors.New("directory not empty")
+)
+
+func pathError(op string, path string, err error) error {
+	return &fs.PathError{Op: op, Path: path, Err: err}
+}
@@ -0,0 +1,8 @@
+package gstorage
+
+// UseHoldSidecars makes legal holds use sidecar files, as on filesystems
+// without extended attributes, until the returned function is called
+func UseHoldSidecars() (restore func()) {
+	holdXattrs = false
+	return func() { holdXattrs = true }
+}
@@ -0,0 +1,98 @@
+package gstorage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrInvalidKey is returned by FanoutStore for empty keys
+var ErrInvalidKey = errors.New("key must not be empty")
+
+// FanoutPath returns where key lives in a tree rooted at root that spreads
+// objects over levels of subdirectories, each named by the next width hex
+// digits of the SHA-256 of key. With 2 levels of width 2 that is 65536
+// leaf directories, so millions of objects never pile up in one directory:
+//
+//	FanoutPath("/blobs", "report.pdf", 2, 2) == "/blobs/64/66/report.pdf"
+//
+// The file name is key itself, escaped as a URL path segment so keys
+// containing separators or starting with a dot stay inside their leaf.
+// levels below 0 count as 0 and width below 1 as 1; levels are capped so
+// they use at most the 64 digits of the hash
+func FanoutPath(root string, key string, levels int, width int) string {
+	width = max(width, 1)
+	levels = min(max(levels, 0), sha256.Size*2/width)
+
+	sum := sha256.Sum256([]byte(key))
+	digits := hex.EncodeToString(sum[:])
+	parts := make([]string, 0, levels+2)
+	parts = append(parts, root)
+	for i := 0; i < levels; i++ {
+		parts = append(parts, digits[i*width:(i+1)*width])
+	}
+	return filepath.Join(append(parts, fanoutName(key))...)
+}
+
+// fanoutName escapes key into a single path element
+func fanoutName(key string) string {
+	name := url.PathEscape(key)
+	// PathEscape leaves dots and backslashes alone
+	name = strings.ReplaceAll(name, `\`, "%5C")
+	if strings.HasPrefix(name, ".") {
+		name = "%2E" + name[1:]
+	}
+	return name
+}
+
+// FanoutStore stores objects by key in a FanoutPath layout below Root
+type FanoutStore struct {
+	Root   string
+	Levels int
+	Width  int
+
+	// Write is applied to every Put. Objects are always staged and renamed
+	// into place; set Write.Atomic to fsync them as well
+	Write WriteOptions
+}
+
+// NewFanoutStore returns a store below root with the given layout
+func NewFanoutStore(root string, levels int, width int) *FanoutStore {
+	return &FanoutStore{Root: root, Levels: levels, Width: width}
+}
+
+// Path returns where key is stored
+func (s *FanoutStore) Path(key string) string {
+	return FanoutPath(s.Root, key, s.Levels, s.Width)
+}
+
+// Put streams r into the object for key, replacing any previous content.
+// Readers never see a partially written object
+func (s *FanoutStore) Put(key string, r io.Reader) error {
+	if key == "" {
+		return ErrInvalidKey
+	}
+	return WriteFileFromReaderWithOptions(s.Path(key), r, s.Write)
+}
+
+// Get opens the object for key. Missing objects fail with fs.ErrNotExist
+func (s *FanoutStore) Get(key string) (io.ReadCloser, error) {
+	if key == "" {
+		return nil, ErrInvalidKey
+	}
+	return os.Open(s.Path(key))
+}
+
+// Delete removes the object for key; removing a missing object is not an
+// error. Emptied directories are left in place for later objects
+func (s *FanoutStore) Delete(key string) error {
+	if key == "" {
+		return ErrInvalidKey
+	}
+	return RemoveFile(s.Path(key))
+}
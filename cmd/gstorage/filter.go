@@ -0,0 +1,97 @@
+package gstorage
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// pathFilter selects entries of a tree by glob pattern. Patterns use
+// path.Match syntax, plus "**" for any number of directories, and are
+// matched against both the slash-separated path relative to the root and
+// the base name, so "*.tmp" matches at any depth while "build/*.o" only
+// matches below the top-level build directory and "build/**/*.o" anywhere
+// below it.
+type pathFilter struct {
+	include []string
+	exclude []string
+}
+
+// excluded reports whether the entry at rel should be left out. Excluded
+// directories are left out with everything below them; include patterns
+// only limit files, so directories are kept to reach the files inside them
+func (f pathFilter) excluded(rel string, isDir bool) bool {
+	rel = filepath.ToSlash(rel)
+	if matchAny(f.exclude, rel) {
+		return true
+	}
+	return !isDir && len(f.include) > 0 && !matchAny(f.include, rel)
+}
+
+// matchAny reports whether rel or its base name matches any pattern.
+// Malformed patterns match nothing
+func matchAny(patterns []string, rel string) bool {
+	base := path.Base(rel)
+	for _, pattern := range patterns {
+		if ok, _ := matchGlob(pattern, rel); ok {
+			return true
+		}
+		if ok, _ := matchGlob(pattern, base); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob reports whether the slash-separated name matches pattern.
+// Elements of pattern are matched with path.Match, except "**", which
+// matches any number of elements, including none
+func matchGlob(pattern string, name string) (bool, error) {
+	if !strings.Contains(pattern, "**") {
+		return path.Match(pattern, name)
+	}
+	return matchElems(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchElems(pattern []string, name []string) (bool, error) {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// Collapse repeats, then try every split of the remaining names
+			for len(pattern) > 0 && pattern[0] == "**" {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true, nil
+			}
+			for i := range name {
+				if ok, err := matchElems(pattern, name[i:]); ok || err != nil {
+					return ok, err
+				}
+			}
+			return false, nil
+		}
+		if len(name) == 0 {
+			return false, nil
+		}
+		ok, err := path.Match(pattern[0], name[0])
+		if !ok || err != nil {
+			return false, err
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0, nil
+}
+
+// validatePatterns returns path.ErrBadPattern for the first malformed
+// pattern, if any
+func validatePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		for _, elem := range strings.Split(pattern, "/") {
+			if _, err := path.Match(elem, ""); err != nil {
+				return fmt.Errorf("%q: %w", pattern, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package gstorage
+
+import (
+	"io/fs"
+	"iter"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// FindType selects the kinds of entries FindFiles reports. Values combine
+// with |
+type FindType int
+
+const (
+	FindFile FindType = 1 << iota
+	FindDir
+	FindSymlink
+	// FindOther covers FIFOs, sockets and device nodes
+	FindOther
+)
+
+// FindOptions selects the entries FindFiles reports. Every set field must
+// match; the zero value matches everything below the root.
+type FindOptions struct {
+	// Patterns, when set, limits matches to paths matching one of them.
+	// They follow the ArchiveOptions.Include syntax: path.Match globs with
+	// "**" for any number of directories, tried against the slash-separated
+	// path relative to the root and against the base name
+	Patterns []string
+
+	// Name, when set, must match the base name
+	Name *regexp.Regexp
+
+	// MinSize and MaxSize bound the size of regular files in bytes; 0
+	// leaves that side open. Other entries are not size-checked
+	MinSize int64
+	MaxSize int64
+
+	// ModifiedAfter and ModifiedBefore bound the modification time; the
+	// zero time leaves that side open
+	ModifiedAfter  time.Time
+	ModifiedBefore time.Time
+
+	// Types limits matches to these kinds of entries; 0 matches all kinds.
+	// Symbolic links are reported as links and never followed
+	Types FindType
+
+	// ReadDirBatch behaves like CopyOptions.ReadDirBatch
+	ReadDirBatch int
+}
+
+// FindFiles walks the tree rooted at root and yields the path of every
+// entry matching opts, excluding root itself. The walk happens as the
+// sequence is consumed and stops when the consumer does, so it needs no
+// more memory for huge trees than a plain walk. Unreadable entries are
+// yielded with their error and the walk carries on; malformed Patterns
+// are yielded as an error for root before anything is walked
+func FindFiles(root string, opts FindOptions) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		if err := validatePatterns(opts.Patterns); err != nil {
+			yield(root, err)
+			return
+		}
+
+		walkDirBatched(root, opts.ReadDirBatch, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if !yield(path, err) || d == nil {
+					return filepath.SkipAll
+				}
+				return nil
+			}
+			if path == root {
+				return nil
+			}
+
+			ok, err := opts.match(root, path, d)
+			if err != nil {
+				ok = true
+			}
+			if ok && !yield(path, err) {
+				return filepath.SkipAll
+			}
+			return nil
+		})
+	}
+}
+
+// match reports whether the entry d at path matches o
+func (o FindOptions) match(root string, path string, d fs.DirEntry) (bool, error) {
+	if o.Types != 0 && o.Types&findType(d.Type()) == 0 {
+		return false, nil
+	}
+	if o.Name != nil && !o.Name.MatchString(d.Name()) {
+		return false, nil
+	}
+	if len(o.Patterns) > 0 {
+		rel, err := filepath.Rel(root, path)
+		if err != nil || !matchAny(o.Patterns, filepath.ToSlash(rel)) {
+			return false, err
+		}
+	}
+
+	sized := d.Type().IsRegular() && (o.MinSize > 0 || o.MaxSize > 0)
+	if !sized && o.ModifiedAfter.IsZero() && o.ModifiedBefore.IsZero() {
+		return true, nil
+	}
+	info, err := d.Info()
+	if err != nil {
+		return false, err
+	}
+	if sized && (info.Size() < o.MinSize || o.MaxSize > 0 && info.Size() > o.MaxSize) {
+		return false, nil
+	}
+	if !o.ModifiedAfter.IsZero() && !info.ModTime().After(o.ModifiedAfter) {
+		return false, nil
+	}
+	if !o.ModifiedBefore.IsZero() && !info.ModTime().Before(o.ModifiedBefore) {
+		return false, nil
+	}
+	return true, nil
+}
+
+// findType returns the FindType of an entry of type mode
+func findType(mode fs.FileMode) FindType {
+	switch {
+	case mode.IsDir():
+		return FindDir
+	case isSymlink(mode):
+		return FindSymlink
+	case mode.IsRegular():
+		return FindFile
+	default:
+		return FindOther
+	}
+}
@@ -2,93 +2,396 @@ package gstorage
 
 import (
 	"bufio"
+	"context"
 	"crypto/md5"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"io/fs"
-	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
+	"time"
 )
 
 // CopyFile copies files from srcFile to dstFile
 //
 //	If destinaiton file already exists, it will be overwritten
+//	If srcFile is a FIFO, socket or device it returns ErrSpecialFile
 func CopyFile(srcfile string, dstfile string) error {
-	sourcefile, err := os.Open(srcfile)
+	if src, srcName, dst, dstName, ok := mountedPair(srcfile, dstfile); ok {
+		return CopyFileBetween(src, srcName, dst, dstName)
+	}
+	return copyFile(context.Background(), srcfile, dstfile, nil, nil)
+}
+
+// CopyFileWithOptions copies srcfile to dstfile like CopyFile, applying the
+// per-file parts of opts: VerifySize, StoreHash, Symlinks, Transform and
+// SkipUnchanged. The filtering options only apply to directory copies
+func CopyFileWithOptions(srcfile string, dstfile string, opts CopyOptions) error {
+	if opts.Symlinks != SymlinkFollow {
+		if info, err := os.Lstat(srcfile); err == nil && isSymlink(info.Mode()) {
+			switch opts.Symlinks {
+			case SymlinkSkip:
+				opts.skip(srcfile, ErrSymlink)
+				return nil
+			case SymlinkError:
+				return fmt.Errorf("%s: %w", srcfile, ErrSymlink)
+			case SymlinkPreserve:
+				return preserveSymlink(srcfile, dstfile)
+			}
+		}
+	}
+
+	var progress *progressTracker
+	if opts.Progress != nil {
+		var size int64
+		if info, err := os.Stat(srcfile); err == nil {
+			size = info.Size()
+		}
+		progress = newProgressTracker(opts.Progress, size, 1)
+	}
+	err := copyFileWithOptions(context.Background(), srcfile, dstfile, nil, opts, progress)
+	progress.finish(err)
+	return err
+}
+
+// copyFileWithOptions implements CopyFileWithOptions. info is the source
+// file info when the caller already has it, or nil. The copied bytes are
+// counted in progress, which may be nil
+func copyFileWithOptions(ctx context.Context, srcfile string, dstfile string, info fs.FileInfo, opts CopyOptions, progress *progressTracker) (err error) {
+	var sum hash.Hash
+	if opts.StoreHash != HashStoreNone {
+		sum = md5.New()
+	}
+	fp := progress.file(srcfile)
+	defer func() {
+		fp.done(err)
+	}()
+
+	// Progress counts source bytes, while the stored hash is of the
+	// content that reaches the destination
+	transforms := opts.Transform
+	if sum != nil {
+		transforms = append(transforms[:len(transforms):len(transforms)], teeTransform(sum))
+	}
+
+	// Copies that skip unchanged files stage their output and remove it
+	// when they fail, so the destination itself is never truncated
+	partial := dstfile
+	if opts.SkipUnchanged {
+		partial = ""
+	}
+	changed := true
+	err = opts.Retry.do(ctx, srcfile, func() error {
+		return retryNoSpace(ctx, dstfile, partial, opts.NoSpaceRetries, opts.NoSpaceWait, opts.OnNoSpace, func() error {
+			if sum != nil {
+				sum.Reset()
+			}
+			fp.reset()
+			if opts.SkipUnchanged {
+				var err error
+				changed, err = copyFileIfChanged(ctx, srcfile, dstfile, fp.writer(), transforms)
+				return err
+			}
+			return copyFile(ctx, srcfile, dstfile, fp.writer(), transforms)
+		})
+	})
+	if err != nil {
+		return err
+	}
+	if !changed {
+		opts.skip(srcfile, ErrUnchanged)
+		return nil
+	}
+
+	// Transformed files differ from their source by design
+	transformed := len(opts.Transform) > 0
+	if info == nil && (opts.VerifySize && !transformed || opts.preservesMetadata()) {
+		var err error
+		if info, err = os.Stat(srcfile); err != nil {
+			logger().Error("error reading file info", "path", srcfile, "err", err)
+			return err
+		}
+	}
 
+	if err := preserveMetadata(info, dstfile, opts); err != nil {
+		return err
+	}
+
+	if opts.VerifySize && !transformed {
+		if err := verifyCopySize(info, dstfile); err != nil {
+			return err
+		}
+	}
+
+	if !transformed {
+		if err := verifyCopy(srcfile, dstfile, opts.Verify, opts.VerifyHash); err != nil {
+			if isVerifyMismatch(err) {
+				return &VerificationError{Files: []string{dstfile}}
+			}
+			return err
+		}
+	}
+
+	// Recorded last, once the destination's size and mtime are final
+	if sum != nil {
+		return StoreFileMD5(dstfile, hex.EncodeToString(sum.Sum(nil)), opts.StoreHash)
+	}
+	return nil
+}
+
+// copyFile copies srcfile to dstfile, feeding the content to tee on the way
+// through when tee is not nil, e.g. to hash it, and then through
+// transforms. If ctx is cancelled mid-copy the partial dstfile is removed
+// and ctx.Err() returned
+func copyFile(ctx context.Context, srcfile string, dstfile string, tee io.Writer, transforms []Transform) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := checkOverwrite(dstfile); err != nil {
+		return err
+	}
+
+	reader, closeSource, err := openSource(ctx, srcfile, tee, transforms)
 	if err != nil {
-		log.Println("Error reading source file: ", srcfile, err)
 		return err
 	}
-	defer sourcefile.Close()
+	defer closeSource()
 
 	destination, err := os.Create(dstfile)
 
 	if err != nil {
-		log.Println("Error creating destination file:", destination, err)
+		logger().Error("error creating destination file", "dst", dstfile, "err", err)
 		return err
 	}
-	defer destination.Close()
 
-	_, err = io.Copy(destination, sourcefile)
+	_, err = io.Copy(destination, reader)
 
 	if err != nil {
-		log.Println("Error while copying files: ", dstfile, srcfile, err)
+		destination.Close()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			logger().Debug("copy cancelled, removing partial file", "dst", dstfile)
+			os.Remove(dstfile)
+			return ctxErr
+		}
+		logger().Error("error while copying files", "src", srcfile, "dst", dstfile, "err", err)
 		return err
 	}
 
-	log.Printf("Successfully copied %s to %s\n", srcfile, dstfile)
+	// Close flushes to the filesystem; a failure here means lost data
+	if err := destination.Close(); err != nil {
+		logger().Error("error while closing destination file", "dst", dstfile, "err", err)
+		return err
+	}
+
+	logger().Debug("successfully copied file", "src", srcfile, "dst", dstfile)
 
 	return nil
 }
 
+// openSource opens srcfile for a copy, returning a reader of its content
+// after tee and transforms, and the func closing it all
+func openSource(ctx context.Context, srcfile string, tee io.Writer, transforms []Transform) (io.Reader, func(), error) {
+	stat, err := os.Stat(srcfile)
+
+	if err != nil {
+		logger().Error("error reading source file", "src", srcfile, "err", err)
+		return nil, nil, err
+	}
+
+	if isSpecial(stat.Mode()) {
+		logger().Error("cant copy special file", "src", srcfile)
+		return nil, nil, fmt.Errorf("%s: %w", srcfile, ErrSpecialFile)
+	}
+
+	sourcefile, err := os.Open(srcfile)
+
+	if err != nil {
+		logger().Error("error reading source file", "src", srcfile, "err", err)
+		return nil, nil, err
+	}
+
+	var reader io.Reader = sourcefile
+	if tee != nil {
+		reader = io.TeeReader(reader, tee)
+	}
+	if ctx.Done() != nil {
+		reader = &ctxReader{ctx: ctx, r: reader}
+	}
+	if len(transforms) == 0 {
+		return reader, func() { sourcefile.Close() }, nil
+	}
+
+	transformed, closeTransforms, err := applyTransforms(srcfile, reader, transforms)
+	if err != nil {
+		sourcefile.Close()
+		return nil, nil, err
+	}
+	return transformed, func() {
+		closeTransforms()
+		sourcefile.Close()
+	}, nil
+}
+
 // MoveFile moves files srcfile to dstfile
 func MoveFile(srcfile string, dstfile string) error {
+	if mounted, err := moveMounted(srcfile, dstfile); mounted {
+		return err
+	}
+
 	_, err := os.Stat(srcfile)
 
 	if err != nil {
-		log.Println("Error reading source file: ", srcfile, err)
+		logger().Error("error reading source file", "src", srcfile, "err", err)
+		return err
+	}
+
+	if err := checkDelete(srcfile); err != nil {
+		return err
+	}
+	if err := checkOverwrite(dstfile); err != nil {
 		return err
 	}
 
 	err = os.Rename(srcfile, dstfile)
 
 	if err != nil {
-		log.Println("Error while writing destiation file: ", dstfile, err)
+		logger().Error("error while writing destination file", "dst", dstfile, "err", err)
 		return err
 	}
 
-	log.Printf("Successfully moved %s to %s", srcfile, dstfile)
+	logger().Debug("successfully moved file", "src", srcfile, "dst", dstfile)
+
+	return nil
+}
+
+// ErrChecksumMismatch is returned when a copied file does not hash to the
+// same value as its source
+var ErrChecksumMismatch = errors.New("checksum mismatch after copy")
+
+// ErrSizeMismatch is returned when a copied file does not have the same
+// size as its source
+var ErrSizeMismatch = errors.New("size mismatch after copy")
+
+// SafeMoveFile moves srcfile to dstfile by copying it, verifying the MD5 of
+// the copy and only then removing srcfile. Use it instead of MoveFile when
+// the two paths may live on different devices.
+//
+//	If any step fails, srcfile and any previous dstfile are left as they were
+func SafeMoveFile(srcfile string, dstfile string) error {
+	srcHash, err := CalculateFileMD5(srcfile)
+	if err != nil {
+		logger().Error("error reading source file", "src", srcfile, "err", err)
+		return err
+	}
+
+	if err := checkDelete(srcfile); err != nil {
+		return err
+	}
+	if err := checkOverwrite(dstfile); err != nil {
+		return err
+	}
+
+	// Copy next to the destination so the final rename stays on one device
+	tmpfile := filepath.Join(filepath.Dir(dstfile), "."+filepath.Base(dstfile)+".gstorage-move")
+	if err := CopyFile(srcfile, tmpfile); err != nil {
+		os.Remove(tmpfile)
+		return err
+	}
+
+	dstHash, err := CalculateFileMD5(tmpfile)
+	if err != nil {
+		os.Remove(tmpfile)
+		return err
+	}
+	if dstHash != srcHash {
+		logger().Error("checksum mismatch while moving", "src", srcfile, "dst", dstfile)
+		os.Remove(tmpfile)
+		return ErrChecksumMismatch
+	}
+
+	// Keep any existing destination until the source is gone
+	backup := ""
+	if _, err := os.Lstat(dstfile); err == nil {
+		backup = tmpfile + ".bak"
+		if err := os.Rename(dstfile, backup); err != nil {
+			os.Remove(tmpfile)
+			return err
+		}
+	}
+
+	if err := os.Rename(tmpfile, dstfile); err != nil {
+		logger().Error("error while writing destination file", "dst", dstfile, "err", err)
+		os.Remove(tmpfile)
+		if backup != "" {
+			os.Rename(backup, dstfile)
+		}
+		return err
+	}
+
+	if err := os.Remove(srcfile); err != nil {
+		logger().Error("error removing source file, rolling back", "src", srcfile, "err", err)
+		if backup != "" {
+			os.Rename(backup, dstfile)
+		} else {
+			os.Remove(dstfile)
+		}
+		return err
+	}
+
+	if backup != "" {
+		os.Remove(backup)
+	}
+
+	logger().Debug("successfully moved file", "src", srcfile, "dst", dstfile)
 
 	return nil
 }
 
-// RemoveFile removes/deletes a file or directory
-// If srcFile does not exist it returns an error
-// If the srcFile is a directory it returns an error
+// RemoveFile removes/deletes a file
+// If srcFile does not exist it returns nil
+// Below a soft-delete root the file is moved to its .deleted area instead
+// If the srcFile is a directory it returns ErrIsDirectory
 func RemoveFile(srcfile string) error {
+	if s, name, ok := mountedStorage(srcfile); ok {
+		return removeStorageFile(s, name)
+	}
+	return RemoveFileWithOptions(srcfile, RemoveOptions{IgnoreNotExist: true})
+}
+
+// RemoveFileWithOptions removes a file like RemoveFile, applying opts
+func RemoveFileWithOptions(srcfile string, opts RemoveOptions) error {
 
 	stat, err := os.Stat(srcfile)
 
 	if err != nil {
-		if os.IsNotExist(err) {
+		if os.IsNotExist(err) && opts.IgnoreNotExist {
 			return nil
 		}
+		logger().Error("error reading file info", "path", srcfile, "err", err)
+		return err
 	}
 
 	if stat.IsDir() {
-		log.Println("Cant remove directory ", srcfile)
-		return errors.New("cant remove a directory")
+		logger().Error("cant remove directory", "path", srcfile)
+		return pathError("remove", srcfile, ErrIsDirectory)
+	}
+
+	if err := checkDelete(srcfile); err != nil {
+		return err
+	}
+	if moved, err := softDelete(srcfile); moved || err != nil {
+		return err
 	}
 
 	err = os.Remove(srcfile)
 
 	if err != nil {
-		log.Println("Error removing file:", srcfile, err)
+		logger().Error("error removing file", "path", srcfile, "err", err)
 		return err
 	}
 	return nil
@@ -97,38 +400,163 @@ func RemoveFile(srcfile string) error {
 // ReadFile reads srcfile and it returns its byte size
 // It there are errors reading srcfile it returns an error
 func ReadFile(srcfile string) ([]byte, error) {
+	if s, name, ok := mountedStorage(srcfile); ok {
+		return readStorageFile(s, name)
+	}
 	content, err := os.ReadFile(srcfile)
 	if err != nil {
-		log.Println("Error readhing file", srcfile)
+		logger().Error("error reading file", "path", srcfile, "err", err)
 		return []byte{}, err
 	}
 	return content, nil
 }
 
+// WriteFile writes content to dstFile, creating missing parent directories
+// and truncating any existing file. Write, flush and close errors are all
+// returned, so a nil error means every byte reached the filesystem.
 func WriteFile(dstFile string, content []byte) error {
+	if s, name, ok := mountedStorage(dstFile); ok {
+		return writeStorageFile(s, name, content)
+	}
+	return WriteFileWithOptions(dstFile, content, WriteOptions{})
+}
+
+// WriteFileAtomic writes content to dstFile so that after a crash dstFile
+// holds either its previous content or all of content, never a mix. The
+// data goes to a temporary file in the same directory, is fsynced, and is
+// renamed over dstFile; on failure the original is left untouched
+func WriteFileAtomic(dstFile string, content []byte) error {
+	return WriteFileWithOptions(dstFile, content, WriteOptions{Atomic: true})
+}
+
+// WriteFileWithOptions writes content to dstFile like WriteFile, applying opts
+func WriteFileWithOptions(dstFile string, content []byte, opts WriteOptions) error {
+	if err := checkOverwrite(dstFile); err != nil {
+		return err
+	}
+	if opts.Scanner != nil || opts.Atomic {
+		return writeStagedBytes(dstFile, content, opts)
+	}
 
 	dirpath := filepath.Dir(dstFile)
 
 	err := os.MkdirAll(dirpath, 0755)
 
 	if err != nil {
-		log.Println("Unable to create path: ", dirpath)
-		return errors.New("unable to create file path")
+		logger().Error("unable to create path", "dir", dirpath, "err", err)
+		return err
 	}
 
 	file, err := os.Create(dstFile)
 	if err != nil {
-		log.Panicln("error while creating destination", err)
+		logger().Error("error while creating destination", "dst", dstFile, "err", err)
 		return err
 	}
-	defer file.Close()
+
 	writer := bufio.NewWriter(file)
-	defer writer.Flush()
-	writer.Write(content)
+	if _, err := writer.Write(content); err != nil {
+		file.Close()
+		logger().Error("error while writing destination", "dst", dstFile, "err", err)
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		logger().Error("error while flushing destination", "dst", dstFile, "err", err)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		logger().Error("error while closing destination", "dst", dstFile, "err", err)
+		return err
+	}
+
+	if opts.VerifyLength {
+		stat, err := os.Stat(dstFile)
+		if err != nil {
+			return err
+		}
+		if stat.Size() != int64(len(content)) {
+			logger().Error("short write", "dst", dstFile, "want", len(content), "got", stat.Size())
+			return pathError("write", dstFile, io.ErrShortWrite)
+		}
+	}
+
+	if opts.StoreHash != HashStoreNone {
+		sum := md5.Sum(content)
+		return StoreFileMD5(dstFile, hex.EncodeToString(sum[:]), opts.StoreHash)
+	}
+
+	return nil
+}
+
+// AppendFile appends data to path, creating it and missing parent
+// directories if needed. The file is opened for appending, so the data of
+// concurrent appenders lands one call after another rather than one over
+// the other, which suits log-style files
+func AppendFile(path string, data []byte) error {
+	return writeFileAt(path, os.O_APPEND, func(file *os.File) error {
+		_, err := file.Write(data)
+		return err
+	})
+}
+
+// WriteAt writes data to path starting at offset, leaving the rest of the
+// file as it is. The file and missing parent directories are created if
+// needed; writing past the end extends the file, with a gap reading as
+// zeros
+func WriteAt(path string, data []byte, offset int64) error {
+	return writeFileAt(path, 0, func(file *os.File) error {
+		_, err := file.WriteAt(data, offset)
+		return err
+	})
+}
+
+// TruncateFile changes the size of the existing file at path to size,
+// dropping data past it or extending the file with zeros
+func TruncateFile(path string, size int64) error {
+	if err := checkOverwrite(path); err != nil {
+		return err
+	}
+	if err := os.Truncate(path, size); err != nil {
+		logger().Error("error while truncating file", "path", path, "size", size, "err", err)
+		return err
+	}
+	return nil
+}
+
+// writeFileAt opens path for writing with the extra flag, creating it and
+// its parent directories if needed, and runs write on it
+func writeFileAt(path string, flag int, write func(file *os.File) error) error {
+	if err := checkOverwrite(path); err != nil {
+		return err
+	}
+
+	dirpath := filepath.Dir(path)
+	if err := os.MkdirAll(dirpath, 0755); err != nil {
+		logger().Error("unable to create path", "dir", dirpath, "err", err)
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|flag, 0644)
+	if err != nil {
+		logger().Error("error while opening destination", "dst", path, "err", err)
+		return err
+	}
+	if err := write(file); err != nil {
+		file.Close()
+		logger().Error("error while writing destination", "dst", path, "err", err)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		logger().Error("error while closing destination", "dst", path, "err", err)
+		return err
+	}
 	return nil
 }
 
 func ListDir(dirPath string) ([]os.DirEntry, error) {
+	if s, name, ok := mountedStorage(dirPath); ok {
+		return listStorageDir(s, name)
+	}
 	entries, err := os.ReadDir(dirPath)
 
 	if err != nil {
@@ -138,101 +566,281 @@ func ListDir(dirPath string) ([]os.DirEntry, error) {
 	return entries, nil
 }
 
+// listBatchSize is the number of entries ListDirFunc reads per call
+const listBatchSize = 256
+
+// ListDirFunc calls fn for every entry in dirPath, in directory order.
+// Unlike ListDir it never holds more than one batch of entries in memory,
+// so it is the better choice for directories with millions of entries.
+// Returning fs.SkipAll from fn stops the listing without error; any other
+// error stops it and is returned.
+func ListDirFunc(dirPath string, fn func(entry os.DirEntry) error) error {
+	dir, err := os.Open(dirPath)
+
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	for {
+		entries, err := dir.ReadDir(listBatchSize)
+		for _, entry := range entries {
+			if fnErr := fn(entry); fnErr != nil {
+				if fnErr == fs.SkipAll {
+					return nil
+				}
+				return fnErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			logger().Error("error reading directory", "dir", dirPath, "err", err)
+			return err
+		}
+	}
+}
+
 func CreateDir(dirPath string, recursive bool) error {
 	path := filepath.Dir(dirPath)
 	if !recursive {
 		_, err := os.ReadDir(path)
 		if err != nil {
-			log.Println("error creating directory. parent does not exist", path)
+			logger().Error("error creating directory, parent does not exist", "dir", path, "err", err)
 			return err
 		} else {
 			err := os.Mkdir(dirPath, 0755)
 			if err != nil {
-				log.Println("error creating directory", dirPath, err)
+				logger().Error("error creating directory", "dir", dirPath, "err", err)
 				return err
 			}
 		}
 	} else {
 		err := os.MkdirAll(dirPath, 0775)
 		if err != nil {
-			log.Println("error creating directory", path)
+			logger().Error("error creating directory", "dir", dirPath, "err", err)
 			return err
 		}
 	}
-	log.Printf("successfully created directory %s with recursive %v", dirPath, recursive)
+	logger().Debug("successfully created directory", "dir", dirPath, "recursive", recursive)
 	return nil
 }
 
+// RemoveDir removes an empty directory
+// If targetDir does not exist it returns an error
+// If targetDir is not empty it returns ErrDirectoryNotEmpty
 func RemoveDir(targetDir string) error {
+	return RemoveDirWithOptions(targetDir, RemoveOptions{})
+}
+
+// RemoveDirWithOptions removes an empty directory like RemoveDir, applying opts
+func RemoveDirWithOptions(targetDir string, opts RemoveOptions) error {
 
 	files, err := os.ReadDir(targetDir)
 
 	if err != nil {
-		log.Println("Unable to remove directory.", targetDir, err)
+		if os.IsNotExist(err) && opts.IgnoreNotExist {
+			return nil
+		}
+		logger().Error("unable to remove directory", "dir", targetDir, "err", err)
 		return err
 	}
 	if len(files) > 0 {
-		log.Println("Unable to delete directory, directory not empty")
-		return errors.New("unable to delete directory, directory not empty")
+		logger().Error("unable to delete directory, directory not empty", "dir", targetDir)
+		return pathError("rmdir", targetDir, ErrDirectoryNotEmpty)
 	}
 	err = os.Remove(targetDir)
 	if err != nil {
-		log.Println("Unable to remove directory", targetDir)
+		logger().Error("unable to remove directory", "dir", targetDir, "err", err)
 		return err
 	}
 	return nil
 }
 
+// RemoveDirAll removes targetDir and everything below it
+// If targetDir does not exist it returns nil
+// Below a soft-delete root the tree is moved to its .deleted area instead
 func RemoveDirAll(targetDir string) error {
+	return RemoveDirAllWithOptions(targetDir, RemoveOptions{IgnoreNotExist: true})
+}
+
+// RemoveDirAllWithOptions removes a tree like RemoveDirAll, applying opts
+func RemoveDirAllWithOptions(targetDir string, opts RemoveOptions) error {
+	if err := checkDelete(targetDir); err != nil {
+		return err
+	}
+	if !opts.IgnoreNotExist {
+		if _, err := os.Lstat(targetDir); err != nil {
+			logger().Error("unable to remove directory", "dir", targetDir, "err", err)
+			return err
+		}
+	}
+	if moved, err := softDelete(targetDir); moved || err != nil {
+		return err
+	}
+
 	err := os.RemoveAll(targetDir)
 	if err != nil {
-		log.Println("Unable to remove directory", targetDir, err)
+		logger().Error("unable to remove directory", "dir", targetDir, "err", err)
 		return err
 	}
 	return nil
 }
 
 func CopyDir(srcDir string, dstDir string) error {
+	if src, srcName, dst, dstName, ok := mountedPair(srcDir, dstDir); ok {
+		return CopyDirBetween(src, srcName, dst, dstName)
+	}
+	return CopyDirWithOptions(srcDir, dstDir, CopyOptions{})
+}
+
+// CopyDirWithOptions copies srcDir into dstDir like CopyDir, applying opts
+func CopyDirWithOptions(srcDir string, dstDir string, opts CopyOptions) error {
+	return copyDirWithOptions(context.Background(), srcDir, dstDir, opts)
+}
+
+func copyDirWithOptions(ctx context.Context, srcDir string, dstDir string, opts CopyOptions) error {
+	c := &dirCopy{ctx: ctx, opts: opts, root: srcDir, now: time.Now(), summary: newOpSummary("CopyDir")}
+	opts.Handle.begin(c.summary, 1, nil)
+	c.progress = newDirProgressTracker(opts.Progress, srcDir, opts.SkipProgressTotals, DirSizeOptions{
+		FollowSymlinks: opts.Symlinks == SymlinkFollow,
+		Include:        opts.Include,
+		Exclude:        opts.Exclude,
+		Filter:         opts.Filter,
+	})
+	c.names = newNameMapper(opts.SanitizeNames, opts.SanitizePlatform)
+	c.manifest = newCopyManifest(opts.Manifest, dstDir, opts.Transform)
+	err := c.copyDir(srcDir, dstDir)
+	if err == nil {
+		err = c.mismatched.err()
+	}
+	// Written even after a failure, so a partial copy can be restored too
+	if manifestErr := c.manifest.write(opts.Manifest); err == nil {
+		err = manifestErr
+	}
+	c.progress.finish(err)
+	c.summary.finish(err)
+	return err
+}
+
+// dirCopy carries the options and running totals of one CopyDirWithOptions call
+type dirCopy struct {
+	ctx      context.Context
+	opts     CopyOptions
+	root     string // source root, for Include and Exclude patterns
+	now      time.Time
+	summary  *opSummary
+	progress *progressTracker
+	names    *nameMapper
+	manifest *copyManifest
+	stack    []string // directories being copied, for symlink loop checks
+
+	mismatched mismatchList
+}
+
+func (c *dirCopy) copyDir(srcDir string, dstDir string) error {
+	c.stack = append(c.stack, srcDir)
+	defer func() {
+		c.stack = c.stack[:len(c.stack)-1]
+	}()
+
 	source, err := os.Stat(srcDir)
 
 	if err != nil {
-		log.Println("error occurred while validating", srcDir, err)
+		logger().Error("error occurred while validating", "src", srcDir, "err", err)
 		return err
 	}
 	if !source.IsDir() {
-		log.Println("source is not a directory", srcDir)
-		return errors.New("source is not a directory")
+		logger().Error("source is not a directory", "src", srcDir)
+		return pathError("copydir", srcDir, ErrNotDirectory)
 	}
 	destination, err := os.Stat(dstDir)
 	if err != nil {
 
 		if err := os.MkdirAll(dstDir, source.Mode()); err != nil {
-			log.Println("failed to create destination directory", dstDir)
-			return errors.New("failed to create destination directory")
+			logger().Error("failed to create destination directory", "dst", dstDir, "err", err)
+			return err
+		}
+		destination, err = os.Stat(dstDir)
+		if err != nil {
+			return err
 		}
-		destination, _ = os.Stat(dstDir)
 	}
 	if !destination.IsDir() {
-		log.Println("destication is not a directory", dstDir)
-		return errors.New("destination is not a directory")
+		logger().Error("destination is not a directory", "dst", dstDir)
+		return pathError("copydir", dstDir, ErrNotDirectory)
 	}
 
-	entries, err := os.ReadDir(srcDir)
+	// Errors copying entries are kept apart from errors reading srcDir
+	var copyErr error
+	err = readDirBatches(srcDir, c.opts.ReadDirBatch, func(entries []fs.DirEntry) error {
+		copyErr = c.copyEntries(srcDir, dstDir, entries)
+		return copyErr
+	})
+	if copyErr != nil {
+		return copyErr
+	}
 	if err != nil {
-		log.Println("error reading source directory", srcDir, err)
+		logger().Error("error reading source directory", "src", srcDir, "err", err)
 		return err
 	}
 
+	// Applied after the contents, whose copying updates the directory mtime
+	return preserveMetadata(source, dstDir, c.opts)
+}
+
+// copyEntries copies entries, read from srcDir, into dstDir
+func (c *dirCopy) copyEntries(srcDir string, dstDir string, entries []fs.DirEntry) error {
 	for _, entry := range entries {
+		if err := c.opts.Handle.wait(c.ctx); err != nil {
+			return err
+		}
+		if err := c.ctx.Err(); err != nil {
+			return err
+		}
+
 		srcPath := filepath.Join(srcDir, entry.Name())
-		dstPath := filepath.Join(dstDir, entry.Name())
+		rel, _ := filepath.Rel(c.root, srcPath)
+		if c.opts.filtered(srcPath, rel, entry) {
+			c.opts.skip(srcPath, ErrFiltered)
+			continue
+		}
+		name := c.names.name(srcDir, entry.Name())
+		render := c.opts.Templates.matches(rel) &&
+			(entry.Type().IsRegular() || isSymlink(entry.Type()) && c.opts.Symlinks == SymlinkFollow)
+		if render {
+			name = c.opts.Templates.renderedName(name)
+		}
+		name, err := c.opts.Templates.renderName(name)
+		if err != nil {
+			logger().Error("error rendering name", "path", srcPath, "err", err)
+			return fmt.Errorf("%s: %w", srcPath, err)
+		}
+		if name == "" {
+			c.opts.skip(srcPath, ErrFiltered)
+			continue
+		}
+		dstPath := filepath.Join(dstDir, name)
+		c.manifest.add(srcPath, rel, dstPath, entry, c.opts.Symlinks == SymlinkFollow)
+		if render {
+			c.manifest.rendered(dstPath)
+		}
 
 		if entry.IsDir() {
-			if err := CopyDir(srcPath, dstPath); err != nil {
+			if err := c.copyDir(srcPath, dstPath); err != nil {
+				return err
+			}
+		} else if isSymlink(entry.Type()) {
+			if err := c.copySymlink(srcPath, dstPath, render); err != nil {
+				return err
+			}
+		} else if isSpecial(entry.Type()) {
+			if err := copySpecial(srcPath, dstPath, c.opts.SpecialFiles, c.opts.skip); err != nil {
 				return err
 			}
 		} else {
-			if err := CopyFile(srcPath, dstPath); err != nil {
+			if err := c.copyFile(srcPath, dstPath, entry, render); err != nil {
 				return err
 			}
 		}
@@ -240,32 +848,134 @@ func CopyDir(srcDir string, dstDir string) error {
 	return nil
 }
 
+// copyFile applies the per-file options before copying srcPath, rendering
+// it as a template if render is set
+func (c *dirCopy) copyFile(srcPath string, dstPath string, entry fs.DirEntry, render bool) error {
+	info, err := entry.Info()
+	if err != nil {
+		logger().Error("error reading file info", "path", srcPath, "err", err)
+		return err
+	}
+
+	if c.opts.MinFileAge > 0 && c.now.Sub(info.ModTime()) < c.opts.MinFileAge {
+		c.opts.skip(srcPath, ErrFileTooRecent)
+		return nil
+	}
+
+	if c.opts.MaxFileSize > 0 && info.Size() > c.opts.MaxFileSize {
+		if c.opts.FailOnMaxFileSize {
+			logger().Error("file exceeds maximum size", "path", srcPath, "size", info.Size())
+			return fmt.Errorf("%s: %w", srcPath, ErrFileTooLarge)
+		}
+		c.opts.skip(srcPath, ErrFileTooLarge)
+		return nil
+	}
+
+	if c.opts.MaxTotalSize > 0 && c.summary.bytes.Load()+info.Size() > c.opts.MaxTotalSize {
+		logger().Error("copy would exceed maximum total size", "path", srcPath)
+		return fmt.Errorf("%s: %w", srcPath, ErrTotalSizeExceeded)
+	}
+
+	opts := c.opts
+	if render {
+		opts.Transform = append([]Transform{opts.Templates.transform()}, opts.Transform...)
+	}
+
+	c.opts.Handle.working(1, srcPath)
+	err = copyFileWithOptions(c.ctx, srcPath, dstPath, info, opts, c.progress)
+	c.opts.Handle.idle(1, info.Size(), err == nil)
+	if err != nil {
+		// Verification failures are reported together at the end
+		if isVerifyMismatch(err) {
+			c.mismatched.add(dstPath)
+			return nil
+		}
+		return err
+	}
+	c.summary.fileDone(info.Size())
+	return nil
+}
+
+// verifyCopySize stamps dstPath with the modification time of src and checks
+// that its size matches. It is far cheaper than hashing and still catches
+// truncated writes
+func verifyCopySize(src fs.FileInfo, dstPath string) error {
+	// A zero access time leaves it unchanged
+	if err := os.Chtimes(dstPath, time.Time{}, src.ModTime()); err != nil {
+		logger().Error("error setting modification time", "path", dstPath, "err", err)
+		return err
+	}
+
+	dst, err := os.Stat(dstPath)
+	if err != nil {
+		logger().Error("error reading file info", "path", dstPath, "err", err)
+		return err
+	}
+
+	if dst.Size() != src.Size() {
+		logger().Error("size mismatch after copy", "path", dstPath, "expected", src.Size(), "actual", dst.Size())
+		return pathError("verify", dstPath, ErrSizeMismatch)
+	}
+	return nil
+}
+
+// FileExists reports whether anything exists at filename, following symlinks.
+// Directories count as existing; use FileExistsStrict or DirExists to tell
+// them apart
 func FileExists(filename string) (bool, error) {
+	return Exists(filename)
+}
+
+// Exists reports whether anything exists at path, following symlinks
+func Exists(path string) (bool, error) {
+	return existsAs(path, mountedStat, func(fs.FileMode) bool { return true })
+}
+
+// DirExists reports whether path exists and is a directory, following symlinks
+func DirExists(path string) (bool, error) {
+	return existsAs(path, mountedStat, fs.FileMode.IsDir)
+}
 
-	_, err := os.Stat(filename)
+// FileExistsStrict reports whether path exists and is a regular file,
+// following symlinks. Directories, devices, pipes and sockets report false
+func FileExistsStrict(path string) (bool, error) {
+	return existsAs(path, mountedStat, fs.FileMode.IsRegular)
+}
+
+// LinkExists reports whether path is a symlink. The link itself is checked,
+// not its target, so dangling links report true
+func LinkExists(path string) (bool, error) {
+	return existsAs(path, os.Lstat, func(m fs.FileMode) bool { return m&fs.ModeSymlink != 0 })
+}
+
+// existsAs stats path with stat and reports whether it exists with a mode
+// accepted by match. A missing path is not an error
+func existsAs(path string, stat func(string) (fs.FileInfo, error), match func(fs.FileMode) bool) (bool, error) {
+
+	info, err := stat(path)
 
 	if err == nil {
-		return true, nil
+		return match(info.Mode()), nil
 	}
 
-	if os.IsNotExist(err) {
-		log.Println("file does not exist", err)
+	if errors.Is(err, fs.ErrNotExist) {
+		logger().Debug("file does not exist", "path", path)
 		return false, nil
 	}
-	log.Println("file not found", filename, err)
+	logger().Error("file not found", "path", path, "err", err)
 	return false, err
 }
 
 func GetFileSize(filename string) (int64, error) {
 
-	stat, err := os.Stat(filename)
+	stat, err := mountedStat(filename)
 
 	if err == nil {
 		return stat.Size(), nil
 	}
 
 	if os.IsNotExist(err) {
-		log.Println("file does not exist", err)
+		logger().Debug("file does not exist", "path", filename)
 		return int64(0), err
 	}
 
@@ -294,7 +1004,47 @@ func CalculateFileMD5(filename string) (string, error) {
 	return hashString, nil
 }
 
-func CopyFileWithProgress(src, dst string, chunkSize int) (<-chan int64, error) {
+const (
+	minAutoChunkSize     = 32 * 1024
+	maxAutoChunkSize     = 4 * 1024 * 1024
+	defaultAutoChunkSize = 64 * 1024
+	maxProgressBuffer    = 64
+)
+
+// ErrInvalidChunkSize is returned when a negative chunk size is requested
+var ErrInvalidChunkSize = errors.New("chunk size must not be negative")
+
+// autoChunkSize picks a chunk size that yields roughly a hundred progress
+// updates for regular files. Devices, pipes and other files without a
+// meaningful size get a fixed default.
+func autoChunkSize(info os.FileInfo) int {
+	if !info.Mode().IsRegular() || info.Size() == 0 {
+		return defaultAutoChunkSize
+	}
+	chunk := info.Size() / 100
+	chunk = chunk &^ (4096 - 1) // align to 4KB pages
+	if chunk < minAutoChunkSize {
+		return minAutoChunkSize
+	}
+	if chunk > maxAutoChunkSize {
+		return maxAutoChunkSize
+	}
+	return int(chunk)
+}
+
+// chunkedCopy is the copy engine shared by the progress reporting copies
+type chunkedCopy struct {
+	src       *os.File
+	dst       *os.File
+	size      int64
+	chunkSize int
+}
+
+// newChunkedCopy opens src, creates dst and resolves chunkSize
+func newChunkedCopy(src, dst string, chunkSize int) (*chunkedCopy, error) {
+	if chunkSize < 0 {
+		return nil, ErrInvalidChunkSize
+	}
 
 	srcFile, err := os.Open(src)
 
@@ -302,6 +1052,22 @@ func CopyFileWithProgress(src, dst string, chunkSize int) (<-chan int64, error)
 		return nil, err
 	}
 
+	info, err := srcFile.Stat()
+
+	if err != nil {
+		srcFile.Close()
+		return nil, err
+	}
+
+	if chunkSize == 0 {
+		chunkSize = autoChunkSize(info)
+	}
+
+	if err := checkOverwrite(dst); err != nil {
+		srcFile.Close()
+		return nil, err
+	}
+
 	dstFile, err := os.Create(dst)
 
 	if err != nil {
@@ -309,162 +1075,539 @@ func CopyFileWithProgress(src, dst string, chunkSize int) (<-chan int64, error)
 		return nil, err
 	}
 
-	progressChan := make(chan int64, 10)
+	return &chunkedCopy{src: srcFile, dst: dstFile, size: info.Size(), chunkSize: chunkSize}, nil
+}
 
-	go func() {
-		defer srcFile.Close()
-		defer dstFile.Close()
-		defer close(progressChan)
+// run copies every chunk, calling report with the size of each written chunk
+func (c *chunkedCopy) run(report func(n int64)) error {
+	defer c.src.Close()
 
-		buffer := make([]byte, chunkSize)
+	buffer := make([]byte, c.chunkSize)
 
-		for {
-			n, err := srcFile.Read(buffer)
-			if n > 0 {
-				_, writeErr := dstFile.Write(buffer[:n])
-				if writeErr != nil {
-					log.Println("Error writing to destination file:", writeErr)
-					return
-				}
-				progressChan <- int64(n)
+	for {
+		n, err := c.src.Read(buffer)
+		if n > 0 {
+			_, writeErr := c.dst.Write(buffer[:n])
+			if writeErr != nil {
+				c.dst.Close()
+				logger().Error("error writing to destination file", "dst", c.dst.Name(), "err", writeErr)
+				return writeErr
 			}
+			report(int64(n))
+		}
 
-			if err == io.EOF {
-				break
-			}
-			if err != nil {
-				log.Println("Error reading source file:", err)
-				return
+		if err == io.EOF {
+			if closeErr := c.dst.Close(); closeErr != nil {
+				logger().Error("error closing destination file", "dst", c.dst.Name(), "err", closeErr)
+				return closeErr
 			}
+			return nil
 		}
+		if err != nil {
+			c.dst.Close()
+			logger().Error("error reading source file", "src", c.src.Name(), "err", err)
+			return err
+		}
+	}
+}
+
+// CopyFileWithProgress copies src to dst in chunkSize pieces, sending the
+// size of each written chunk on the returned channel until the copy ends.
+//
+//	A chunkSize of 0 picks a size automatically from the source file
+//
+// Deprecated: the channel carries raw chunk sizes and cannot report errors
+// that happen once the copy has started. Use CopyFileWithOptions with
+// CopyOptions.Progress, which reports totals, rate and ETA
+func CopyFileWithProgress(src, dst string, chunkSize int) (<-chan int64, error) {
+
+	c, err := newChunkedCopy(src, dst, chunkSize)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Buffer every update for small files, but never more than maxProgressBuffer
+	buffered := c.size/int64(c.chunkSize) + 1
+	if buffered > maxProgressBuffer {
+		buffered = maxProgressBuffer
+	}
+	progressChan := make(chan int64, buffered)
+
+	go func() {
+		defer close(progressChan)
+		c.run(func(n int64) {
+			progressChan <- n
+		})
 	}()
 
 	return progressChan, nil
 }
 
+// CopyFileWithCallback copies src to dst, calling cb after every chunk with
+// the bytes copied so far and the total size of src. It returns once the
+// copy has finished.
+func CopyFileWithCallback(src, dst string, cb func(copied, total int64)) error {
+
+	c, err := newChunkedCopy(src, dst, 0)
+
+	if err != nil {
+		return err
+	}
+
+	var copied int64
+	return c.run(func(n int64) {
+		copied += n
+		cb(copied, c.size)
+	})
+}
+
+// createdDirs records the directories a copy creates, so a failed copy
+// can remove them without touching any that existed before. It is safe
+// for concurrent use
+type createdDirs struct {
+	mu    sync.Mutex
+	paths []string
+}
+
+// mkdir creates path like os.MkdirAll, recording it if it did not exist.
+// Parents are expected to have been created, and recorded, first
+func (c *createdDirs) mkdir(path string, perm fs.FileMode) error {
+	if _, err := os.Lstat(path); err == nil || !os.IsNotExist(err) {
+		return os.MkdirAll(path, perm)
+	}
+	if err := os.MkdirAll(path, perm); err != nil {
+		return err
+	}
+	c.add(path)
+	return nil
+}
+
+func (c *createdDirs) add(path string) {
+	c.mu.Lock()
+	c.paths = append(c.paths, path)
+	c.mu.Unlock()
+}
+
+// remove removes the recorded directories, deepest first. Directories
+// that are no longer empty are left in place
+func (c *createdDirs) remove() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sort.Slice(c.paths, func(i, j int) bool { return len(c.paths[i]) > len(c.paths[j]) })
+	for _, path := range c.paths {
+		if err := os.Remove(path); err != nil {
+			logger().Error("failed to clean up destination", "dst", path, "err", err)
+		}
+	}
+	c.paths = nil
+}
+
 type copyJob struct {
 	srcPath string
 	dstPath string
+	size    int64
 }
 
-func copyWorker(id int, jobs <-chan copyJob, errors chan<- error, wg *sync.WaitGroup) {
+// copyPoolFile copies a single worker-pool job according to opts, counting
+// its bytes in fp, which may be nil
+func copyPoolFile(ctx context.Context, job copyJob, opts PoolOptions, fp *fileProgress) error {
+	if !opts.TempRename {
+		return copyPoolTarget(ctx, job.srcPath, job.dstPath, opts, fp)
+	}
+
+	// Readers of the destination never see a partially written file: the
+	// copy lands under a hidden name and is renamed into place when complete
+	tmpfile := filepath.Join(filepath.Dir(job.dstPath), "."+filepath.Base(job.dstPath)+".gstorage-tmp")
+	if err := checkOverwrite(job.dstPath); err != nil {
+		return err
+	}
+	if err := copyPoolTarget(ctx, job.srcPath, tmpfile, opts, fp); err != nil {
+		os.Remove(tmpfile)
+		return err
+	}
+	if err := os.Rename(tmpfile, job.dstPath); err != nil {
+		os.Remove(tmpfile)
+		return err
+	}
+	return nil
+}
+
+// copyPoolTarget copies srcPath to target, verifying it if opts ask for it
+func copyPoolTarget(ctx context.Context, srcPath, target string, opts PoolOptions, fp *fileProgress) error {
+	err := retryNoSpace(ctx, target, target, opts.NoSpaceRetries, opts.NoSpaceWait, opts.OnNoSpace, func() error {
+		fp.reset()
+		return copyFile(ctx, srcPath, target, fp.writer(), opts.Transform)
+	})
+	if err != nil {
+		return err
+	}
+	if len(opts.Transform) > 0 {
+		// Transformed files differ from their source by design
+		return nil
+	}
+	if opts.VerifySize {
+		info, err := os.Stat(srcPath)
+		if err != nil {
+			logger().Error("error reading file info", "path", srcPath, "err", err)
+			return err
+		}
+		if err := verifyCopySize(info, target); err != nil {
+			return err
+		}
+	}
+	return verifyCopy(srcPath, target, opts.Verify, opts.VerifyHash)
+}
+
+func copyWorker(ctx context.Context, id int, jobs <-chan copyJob, errors chan<- error, opts PoolOptions, summary *opSummary, progress *progressTracker, mismatched *mismatchList, failures *failureList, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	for job := range jobs {
-		// Copy individual file
-		err := CopyFile(job.srcPath, job.dstPath)
+		// Paused workers hold their next job until resumed
+		err := opts.Handle.wait(ctx)
+		if err == nil {
+			// Copy individual file
+			opts.Handle.working(id, job.srcPath)
+			fp := progress.file(job.srcPath)
+			err = opts.Retry.do(ctx, job.srcPath, func() error {
+				return copyPoolFile(ctx, job, opts, fp)
+			})
+			fp.done(err)
+			opts.Handle.idle(id, job.size, err == nil)
+		}
+		if isVerifyMismatch(err) {
+			// Reported together once every worker is done
+			mismatched.add(job.dstPath)
+			continue
+		}
+		if err != nil && failures != nil && ctx.Err() == nil {
+			failures.add(job.srcPath, err)
+			summary.fileFailed()
+			continue
+		}
 		if err != nil {
-			errors <- fmt.Errorf("worker %d failed copying %s: %w", id, job.srcPath, err)
-			return // Exit on first error
+			// Only the first error is kept; never block on a full channel
+			select {
+			case errors <- fmt.Errorf("worker %d failed copying %s: %w", id, job.srcPath, err):
+			default:
+			}
+			// Exit on first error, draining the queue so the walk never blocks
+			for range jobs {
+			}
+			return
 		}
+		summary.fileDone(job.size)
 	}
 }
 
+// WorkerPoolCopyDir copies srcDir to dstDir, copying files in parallel with
+// the given number of workers
 func WorkerPoolCopyDir(srcDir, dstDir string, workers int) error {
+	return WorkerPoolCopyDirWithOptions(srcDir, dstDir, PoolOptions{Workers: workers})
+}
+
+// WorkerPoolCopyDirWithOptions copies srcDir to dstDir like WorkerPoolCopyDir,
+// applying opts
+func WorkerPoolCopyDirWithOptions(srcDir, dstDir string, opts PoolOptions) error {
+	return workerPoolCopyDir(context.Background(), srcDir, dstDir, opts)
+}
+
+func workerPoolCopyDir(ctx context.Context, srcDir, dstDir string, opts PoolOptions) (err error) {
+	summary := newOpSummary("WorkerPoolCopyDir")
+	var progress *progressTracker
+	defer func() {
+		progress.finish(err)
+		summary.finish(err)
+	}()
+
+	if opts.Workers < 1 {
+		opts.Workers = 1
+	}
+	if opts.LargeFileWorkers < 1 {
+		opts.LargeFileWorkers = 1
+	}
 
 	srcStat, err := os.Stat(srcDir)
 
 	if err != nil {
-		log.Println("error while getting source info", srcDir, err)
+		logger().Error("error while getting source info", "src", srcDir, "err", err)
 		return err
 	}
 
 	if !srcStat.IsDir() {
-		log.Println("source is not a directory", srcDir, err)
-		return errors.New("source is not a directory")
+		logger().Error("source is not a directory", "src", srcDir)
+		return pathError("copydir", srcDir, ErrNotDirectory)
 	}
 
+	// Only directories this call creates are removed again if laying out
+	// the tree fails; a destination that already existed is never touched
+	var created createdDirs
 	dstStat, err := os.Stat(dstDir)
 
-	if err != nil {
-		if os.IsNotExist(err) {
-			// If dst does not exit, create it
-			log.Println("destination does not exist. creating")
-			err := os.MkdirAll(dstDir, srcStat.Mode())
-			if err != nil {
-				log.Println("error while creating destination directory", dstDir, err)
-				return err
-			}
-			log.Println("created destination directory")
+	if os.IsNotExist(err) {
+		// If dst does not exit, create it
+		logger().Info("destination does not exist, creating", "dst", dstDir)
+		err = os.MkdirAll(dstDir, srcStat.Mode())
+		if err != nil {
+			logger().Error("error while creating destination directory", "dst", dstDir, "err", err)
+			return err
 		}
+		logger().Debug("created destination directory", "dst", dstDir)
+		created.add(dstDir)
+		dstStat, err = os.Stat(dstDir)
+	}
+
+	if err != nil {
 		// Pass any other error
-		log.Println("error while getting destination info", err)
+		logger().Error("error while getting destination info", "dst", dstDir, "err", err)
 		return err
 	}
 
 	if !dstStat.IsDir() {
-		return errors.New("destination is not a directory")
+		return pathError("copydir", dstDir, ErrNotDirectory)
 	}
 
+	progress = newDirProgressTracker(opts.Progress, srcDir, opts.SkipProgressTotals, DirSizeOptions{
+		FollowSymlinks: opts.Symlinks == SymlinkFollow,
+		Include:        opts.Include,
+		Exclude:        opts.Exclude,
+		Filter:         opts.Filter,
+		Workers:        opts.WalkWorkers,
+	})
+	names := newNameMapper(opts.SanitizeNames, opts.SanitizePlatform)
+	manifest := newCopyManifest(opts.Manifest, dstDir, opts.Transform)
+	defer func() {
+		if manifestErr := manifest.write(opts.Manifest); err == nil {
+			err = manifestErr
+		}
+	}()
+
 	// Create directory structure frist
 
 	// HINT: Create all directories BEFORE starting file workers
 	// This avoids race conditions where workers try to copy files
 	// to directories that don't exist yet
 
-	walkErr := filepath.WalkDir(srcDir, func(path string, d fs.DirEntry, err error) error {
+	walk := filepath.WalkDir
+	if opts.WalkWorkers > 1 {
+		walk = func(root string, fn fs.WalkDirFunc) error {
+			return ParallelWalkDir(root, opts.WalkWorkers, fn)
+		}
+	} else if opts.ReadDirBatch > 0 {
+		walk = func(root string, fn fs.WalkDirFunc) error {
+			return walkDirBatched(root, opts.ReadDirBatch, fn)
+		}
+	}
+
+	walkErr := walk(srcDir, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
+			if opts.ContinueOnError && d != nil {
+				// Reported per path by the second walk
+				return nil
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
 			return err
 		}
 		if d.IsDir() {
 			// Calculate relative path and create in destination
 			relPath, _ := filepath.Rel(srcDir, path)
-			dstPath := filepath.Join(dstDir, relPath)
-			return os.MkdirAll(dstPath, 0755)
+			if relPath != "." && opts.filtered(path, relPath, d) {
+				return filepath.SkipDir
+			}
+			dstPath := filepath.Join(dstDir, names.rel(srcDir, relPath))
+			return created.mkdir(dstPath, 0755)
 		}
 		return nil
 	})
 
 	if walkErr != nil {
-		log.Println("error while creating directory structure:", walkErr)
-		if removeErr := os.RemoveAll(dstDir); removeErr != nil {
-			log.Println("failed to clean up destination:", removeErr)
+		if err := ctx.Err(); err != nil {
+			// Cancelled: leave whatever was laid out for the caller
+			return err
 		}
+		logger().Error("error while creating directory structure", "err", walkErr)
+		created.remove()
 		return walkErr // <-- Return original error, not cleanup error
 	}
 
 	var wg sync.WaitGroup
 	jobQueue := make(chan copyJob, 100) // Buffer jobs
 	errorChan := make(chan error, 1)    // Collect errors
+	var mismatched mismatchList
+	var failures *failureList
+	if opts.ContinueOnError {
+		failures = &failureList{}
+	}
+
+	// Large files get their own lane so a handful of huge copies cannot
+	// hold up thousands of small ones queued behind them
+	largeQueue := jobQueue
+	workers := opts.Workers
+	if opts.LargeFileThreshold > 0 {
+		largeQueue = make(chan copyJob, 100)
+		workers += opts.LargeFileWorkers
+	}
+	opts.Handle.begin(summary, workers, func() int {
+		if largeQueue != jobQueue {
+			return len(jobQueue) + len(largeQueue)
+		}
+		return len(jobQueue)
+	})
 
 	// start worker pool
-	for i := 1; i <= workers; i++ {
+	for i := 1; i <= opts.Workers; i++ {
 		wg.Add(1)
-		go copyWorker(i, jobQueue, errorChan, &wg)
+		go copyWorker(ctx, i, jobQueue, errorChan, opts, summary, progress, &mismatched, failures, &wg)
+	}
+	if largeQueue != jobQueue {
+		for i := 1; i <= opts.LargeFileWorkers; i++ {
+			wg.Add(1)
+			go copyWorker(ctx, opts.Workers+i, largeQueue, errorChan, opts, summary, progress, &mismatched, failures, &wg)
+		}
 	}
 
-	// Send only FILE jobs to workers (directories already created)
-	walkErr = filepath.WalkDir(srcDir, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
+	enqueue := func(job copyJob) {
+		if opts.LargeFileThreshold > 0 && job.size >= opts.LargeFileThreshold {
+			largeQueue <- job
+		} else {
+			jobQueue <- job
 		}
-		if !d.IsDir() { // Only send files
-			relPath, _ := filepath.Rel(srcDir, path)
-			dstPath := filepath.Join(dstDir, relPath)
+	}
 
-			jobQueue <- copyJob{
+	// Send only FILE jobs to workers (directories already created). Linked
+	// directories followed under SymlinkFollow are walked with the same
+	// function, rooted at their target; chain holds the roots walked so far
+	var visit func(srcRoot, dstRoot, relRoot string, chain []string) fs.WalkDirFunc
+	// tolerate records the failures of fn under ContinueOnError and carries
+	// on with the walk, skipping failed directories
+	tolerate := func(fn fs.WalkDirFunc) fs.WalkDirFunc {
+		if failures == nil {
+			return fn
+		}
+		return func(path string, d fs.DirEntry, err error) error {
+			err = fn(path, d, err)
+			if err == nil || err == filepath.SkipDir || err == filepath.SkipAll || d == nil || ctx.Err() != nil {
+				return err
+			}
+			failures.add(path, err)
+			summary.fileFailed()
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+	}
+	visit = func(srcRoot, dstRoot, relRoot string, chain []string) fs.WalkDirFunc {
+		return func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			relPath, _ := filepath.Rel(srcRoot, path)
+			dstPath := filepath.Join(dstRoot, names.rel(srcRoot, relPath))
+			// Patterns apply to the path within the source tree, including
+			// below followed links
+			if rel := filepath.Join(relRoot, relPath); rel != "." {
+				if opts.filtered(path, rel, d) {
+					opts.skip(path, ErrFiltered)
+					if d.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				manifest.add(path, rel, dstPath, d, opts.Symlinks == SymlinkFollow)
+			}
+
+			if d.IsDir() {
+				// The source tree itself was created in the first pass
+				if len(chain) > 1 {
+					return os.MkdirAll(dstPath, 0755)
+				}
+				return nil
+			}
+			if isSpecial(d.Type()) {
+				return copySpecial(path, dstPath, opts.SpecialFiles, opts.skip)
+			}
+
+			var info fs.FileInfo
+			if isSymlink(d.Type()) {
+				switch opts.Symlinks {
+				case SymlinkSkip:
+					opts.skip(path, ErrSymlink)
+					return nil
+				case SymlinkError:
+					logger().Error("refusing to copy symlink", "path", path)
+					return fmt.Errorf("%s: %w", path, ErrSymlink)
+				case SymlinkPreserve:
+					return preserveSymlink(path, dstPath)
+				}
+
+				if info, err = os.Stat(path); err != nil {
+					logger().Error("error following symlink", "path", path, "err", err)
+					return err
+				}
+				if info.IsDir() {
+					loops, err := symlinkLoops(path, chain)
+					if err != nil {
+						return err
+					}
+					if loops {
+						opts.skip(path, ErrSymlinkLoop)
+						return nil
+					}
+					target, err := filepath.EvalSymlinks(path)
+					if err != nil {
+						return err
+					}
+					next := append(chain[:len(chain):len(chain)], target)
+					return filepath.WalkDir(target, tolerate(visit(target, dstPath, filepath.Join(relRoot, relPath), next)))
+				}
+			} else if fi, err := d.Info(); err == nil {
+				info = fi
+			}
+
+			var size int64
+			if info != nil {
+				size = info.Size()
+			}
+			enqueue(copyJob{
 				srcPath: path,
 				dstPath: dstPath,
-			}
+				size:    size,
+			})
+			return nil
 		}
-		return nil
-	})
+	}
+	walkErr = walk(srcDir, tolerate(visit(srcDir, dstDir, ".", []string{srcDir})))
 
 	close(jobQueue)
+	if largeQueue != jobQueue {
+		close(largeQueue)
+	}
 	wg.Wait()
 	close(errorChan)
 
+	if err := ctx.Err(); err != nil {
+		logger().Error("copy cancelled", "src", srcDir, "err", err)
+		return err
+	}
+
 	// Check walkErr first
 	if walkErr != nil {
-		log.Println("error while walking directory:", walkErr)
+		logger().Error("error while walking directory", "err", walkErr)
 		return walkErr
 	}
 
-	// Then check worker errors
-	select {
-	case err := <-errorChan:
+	// Then check worker errors; errorChan is closed, so this never blocks
+	if err := <-errorChan; err != nil {
+		return err
+	}
+	if err := failures.err(summary, &mismatched); err != nil {
 		return err
-	default:
-		return nil
 	}
+	return mismatched.err()
 }
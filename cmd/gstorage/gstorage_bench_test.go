@@ -0,0 +1,98 @@
+package gstorage_test
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "storage/cmd/gstorage"
+)
+
+func createListingFixture(b *testing.B, entries int) string {
+	dir := b.TempDir()
+	for i := 0; i < entries; i++ {
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("file_%d", i)), nil, 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// BenchmarkListDir and BenchmarkListDirFunc compare the slice-returning
+// listing with the batched callback listing. Run with -benchmem:
+//
+//	go test -run ^$ -bench ListDir -benchmem ./cmd/gstorage
+func BenchmarkListDir(b *testing.B) {
+	dir := createListingFixture(b, 10000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		entries, err := ListDir(dir)
+		if err != nil {
+			b.Fatal(err)
+		}
+		_ = len(entries)
+	}
+}
+
+func BenchmarkListDirFunc(b *testing.B) {
+	dir := createListingFixture(b, 10000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		count := 0
+		err := ListDirFunc(dir, func(entry os.DirEntry) error {
+			count++
+			return nil
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func createWalkFixture(b *testing.B, dirs, filesPerDir int) string {
+	root := b.TempDir()
+	for i := 0; i < dirs; i++ {
+		dir := filepath.Join(root, fmt.Sprintf("dir_%d", i/10), fmt.Sprintf("dir_%d", i))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			b.Fatal(err)
+		}
+		for j := 0; j < filesPerDir; j++ {
+			if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("file_%d", j)), nil, 0644); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	return root
+}
+
+// BenchmarkWalkDir and BenchmarkParallelWalkDir compare the single-threaded
+// walk with the work-stealing one on a wide tree:
+//
+//	go test -run ^$ -bench WalkDir ./cmd/gstorage
+func BenchmarkWalkDir(b *testing.B) {
+	root := createWalkFixture(b, 200, 50)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			return err
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParallelWalkDir(b *testing.B) {
+	root := createWalkFixture(b, 200, 50)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := ParallelWalkDir(root, 8, func(path string, d fs.DirEntry, err error) error {
+			return err
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
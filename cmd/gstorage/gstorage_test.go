@@ -1,13 +1,40 @@
 package gstorage_test
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
 	"crypto/md5"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"io/fs"
 	"log"
+	"log/slog"
+	"net"
+	"net/http/httptest"
 	"os"
+	"os/exec"
+	"path"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing/iotest"
 	"time"
+	"unicode/utf8"
 
 	. "storage/cmd/gstorage"
 
@@ -142,6 +169,95 @@ var _ = Describe("Gstorage File Operations Library", func() {
 				})
 			})
 		})
+		Describe("SafeMoveFile", func() {
+			var srcFile, dstFile string
+			BeforeEach(func() {
+				srcFile = filepath.Join(tempDir, "source.txt")
+				dstFile = filepath.Join(tempDir, "destination.txt")
+				createTestFile(srcFile, "Move me safely!")
+			})
+
+			It("should move the file and remove the source", func() {
+				err := SafeMoveFile(srcFile, dstFile)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(fileExists(srcFile)).To(BeFalse())
+				Expect(readFileContent(dstFile)).To(Equal("Move me safely!"))
+			})
+
+			It("should replace an existing destination without leaving temp files", func() {
+				createTestFile(dstFile, "Old content")
+				err := SafeMoveFile(srcFile, dstFile)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(readFileContent(dstFile)).To(Equal("Move me safely!"))
+
+				entries, err := os.ReadDir(tempDir)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(entries).To(HaveLen(1))
+			})
+
+			Context("when the destination directory does not exist", func() {
+				It("should return an error and keep the source", func() {
+					err := SafeMoveFile(srcFile, filepath.Join(tempDir, "missing", "file.txt"))
+					Expect(err).To(HaveOccurred())
+					Expect(readFileContent(srcFile)).To(Equal("Move me safely!"))
+				})
+			})
+
+			Context("when source file does not exist", func() {
+				It("should return an error", func() {
+					err := SafeMoveFile(filepath.Join(tempDir, "nonexistent.txt"), dstFile)
+					Expect(err).To(HaveOccurred())
+					Expect(os.IsNotExist(err)).To(BeTrue())
+				})
+			})
+		})
+		Describe("Publish", func() {
+			var srcFile string
+			var dsts []string
+			BeforeEach(func() {
+				srcFile = filepath.Join(tempDir, "release.txt")
+				createTestFile(srcFile, "v2")
+				createTestDir(filepath.Join(tempDir, "a"))
+				createTestDir(filepath.Join(tempDir, "b"))
+				dsts = []string{
+					filepath.Join(tempDir, "a", "release.txt"),
+					filepath.Join(tempDir, "b", "release.txt"),
+				}
+			})
+
+			It("should publish the file to every destination", func() {
+				createTestFile(dsts[0], "v1")
+				err := Publish(srcFile, dsts)
+				Expect(err).NotTo(HaveOccurred())
+				for _, dst := range dsts {
+					Expect(readFileContent(dst)).To(Equal("v2"))
+					entries, err := os.ReadDir(filepath.Dir(dst))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(entries).To(HaveLen(1))
+				}
+				Expect(readFileContent(srcFile)).To(Equal("v2"))
+			})
+
+			It("should publish nothing when one destination cannot be staged", func() {
+				createTestFile(dsts[0], "v1")
+				dsts = append(dsts, filepath.Join(tempDir, "missing", "release.txt"))
+
+				err := Publish(srcFile, dsts)
+				Expect(err).To(HaveOccurred())
+				Expect(readFileContent(dsts[0])).To(Equal("v1"))
+				Expect(fileExists(dsts[1])).To(BeFalse())
+
+				entries, err := os.ReadDir(filepath.Join(tempDir, "a"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(entries).To(HaveLen(1))
+			})
+
+			It("should return an error when the source does not exist", func() {
+				err := Publish(filepath.Join(tempDir, "nonexistent.txt"), dsts)
+				Expect(err).To(HaveOccurred())
+				Expect(os.IsNotExist(err)).To(BeTrue())
+			})
+		})
 		Describe("RemoveFile", func() {
 			var testFile string
 
@@ -176,6 +292,178 @@ var _ = Describe("Gstorage File Operations Library", func() {
 				})
 			})
 		})
+		Describe("Remove with options", func() {
+			var missing string
+			BeforeEach(func() {
+				missing = filepath.Join(tempDir, "missing")
+			})
+
+			It("should fail on missing paths by default", func() {
+				Expect(os.IsNotExist(RemoveFileWithOptions(missing, RemoveOptions{}))).To(BeTrue())
+				Expect(os.IsNotExist(RemoveDirWithOptions(missing, RemoveOptions{}))).To(BeTrue())
+				Expect(os.IsNotExist(RemoveDirAllWithOptions(missing, RemoveOptions{}))).To(BeTrue())
+			})
+
+			It("should succeed on missing paths with IgnoreNotExist", func() {
+				opts := RemoveOptions{IgnoreNotExist: true}
+				Expect(RemoveFileWithOptions(missing, opts)).To(Succeed())
+				Expect(RemoveDirWithOptions(missing, opts)).To(Succeed())
+				Expect(RemoveDirAllWithOptions(missing, opts)).To(Succeed())
+			})
+
+			It("should still remove existing paths", func() {
+				file := filepath.Join(tempDir, "file.txt")
+				dir := filepath.Join(tempDir, "dir")
+				tree := filepath.Join(tempDir, "tree")
+				createTestFile(file, "content")
+				createTestDir(dir)
+				createTestDir(filepath.Join(tree, "nested"))
+
+				Expect(RemoveFileWithOptions(file, RemoveOptions{})).To(Succeed())
+				Expect(RemoveDirWithOptions(dir, RemoveOptions{})).To(Succeed())
+				Expect(RemoveDirAllWithOptions(tree, RemoveOptions{})).To(Succeed())
+				Expect(fileExists(file)).To(BeFalse())
+				Expect(fileExists(dir)).To(BeFalse())
+				Expect(fileExists(tree)).To(BeFalse())
+			})
+		})
+		Describe("WriteFileAtomic", func() {
+			It("should create and replace files", func() {
+				dst := filepath.Join(tempDir, "nested", "config.json")
+				Expect(WriteFileAtomic(dst, []byte(`{"v":1}`))).To(Succeed())
+				Expect(WriteFileAtomic(dst, []byte(`{"v":2}`))).To(Succeed())
+				Expect(readFileContent(dst)).To(Equal(`{"v":2}`))
+
+				staged, _ := filepath.Glob(filepath.Join(tempDir, "nested", "*.gstorage-ingest"))
+				Expect(staged).To(BeEmpty())
+			})
+
+			It("should keep the permissions of the file it replaces", func() {
+				dst := filepath.Join(tempDir, "secret.txt")
+				Expect(os.WriteFile(dst, []byte("old"), 0600)).To(Succeed())
+
+				Expect(WriteFileAtomic(dst, []byte("new"))).To(Succeed())
+				info, err := os.Stat(dst)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(info.Mode().Perm()).To(Equal(fs.FileMode(0600)))
+			})
+
+			It("should leave the original in place when the write fails", func() {
+				dst := filepath.Join(tempDir, "keep.txt")
+				createTestFile(dst, "original")
+
+				err := WriteFileFromReaderWithOptions(dst, iotest.ErrReader(errors.New("connection reset")), WriteOptions{Atomic: true})
+				Expect(err).To(HaveOccurred())
+				Expect(readFileContent(dst)).To(Equal("original"))
+			})
+		})
+
+		Describe("WriteFileFromReader", func() {
+			rejectEicar := ScannerFunc(func(path string) error {
+				data, err := os.ReadFile(path)
+				if err != nil {
+					return err
+				}
+				if bytes.Contains(data, []byte("EICAR")) {
+					return fmt.Errorf("%w: test signature", ErrRejected)
+				}
+				return nil
+			})
+
+			It("should stream content into place", func() {
+				dst := filepath.Join(tempDir, "upload", "file.txt")
+				Expect(WriteFileFromReader(dst, strings.NewReader("streamed"))).To(Succeed())
+				Expect(readFileContent(dst)).To(Equal("streamed"))
+				staged, _ := filepath.Glob(filepath.Join(tempDir, "upload", "*.gstorage-ingest"))
+				Expect(staged).To(BeEmpty())
+			})
+
+			It("should accept files the scanner passes", func() {
+				dst := filepath.Join(tempDir, "clean.txt")
+				err := WriteFileFromReaderWithOptions(dst, strings.NewReader("clean"), WriteOptions{Scanner: rejectEicar})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(readFileContent(dst)).To(Equal("clean"))
+			})
+
+			It("should keep refused files out and the original intact", func() {
+				dst := filepath.Join(tempDir, "existing.txt")
+				createTestFile(dst, "original")
+
+				err := WriteFileFromReaderWithOptions(dst, strings.NewReader("X5O EICAR payload"), WriteOptions{Scanner: rejectEicar})
+				Expect(err).To(MatchError(ErrRejected))
+				Expect(readFileContent(dst)).To(Equal("original"))
+				staged, _ := filepath.Glob(filepath.Join(tempDir, "*.gstorage-ingest"))
+				Expect(staged).To(BeEmpty())
+
+				err = WriteFileWithOptions(dst, []byte("EICAR again"), WriteOptions{Scanner: rejectEicar})
+				Expect(err).To(MatchError(ErrRejected))
+				Expect(readFileContent(dst)).To(Equal("original"))
+			})
+
+			Describe("ClamdScanner", func() {
+				var listener net.Listener
+				BeforeEach(func() {
+					var err error
+					listener, err = net.Listen("tcp", "127.0.0.1:0")
+					Expect(err).NotTo(HaveOccurred())
+
+					// A minimal clamd: read INSTREAM chunks, flag EICAR
+					go func() {
+						for {
+							conn, err := listener.Accept()
+							if err != nil {
+								return
+							}
+							go func(conn net.Conn) {
+								defer conn.Close()
+								reader := bufio.NewReader(conn)
+								if _, err := reader.ReadString(0); err != nil {
+									return
+								}
+								var data []byte
+								for {
+									var size uint32
+									if binary.Read(reader, binary.BigEndian, &size) != nil {
+										return
+									}
+									if size == 0 {
+										break
+									}
+									chunk := make([]byte, size)
+									if _, err := io.ReadFull(reader, chunk); err != nil {
+										return
+									}
+									data = append(data, chunk...)
+								}
+								if bytes.Contains(data, []byte("EICAR")) {
+									io.WriteString(conn, "stream: Eicar-Test-Signature FOUND\x00")
+								} else {
+									io.WriteString(conn, "stream: OK\x00")
+								}
+							}(conn)
+						}
+					}()
+				})
+
+				AfterEach(func() {
+					listener.Close()
+				})
+
+				It("should pass clean files and reject infected ones", func() {
+					scanner := ClamdScanner{Network: "tcp", Address: listener.Addr().String(), Timeout: 5 * time.Second}
+
+					clean := filepath.Join(tempDir, "clean.txt")
+					Expect(WriteFileWithOptions(clean, []byte("nothing to see"), WriteOptions{Scanner: scanner})).To(Succeed())
+
+					infected := filepath.Join(tempDir, "infected.txt")
+					err := WriteFileWithOptions(infected, []byte("X5O EICAR"), WriteOptions{Scanner: scanner})
+					Expect(err).To(MatchError(ErrRejected))
+					Expect(err.Error()).To(ContainSubstring("Eicar-Test-Signature"))
+					Expect(fileExists(infected)).To(BeFalse())
+				})
+			})
+		})
+
 		Describe("ReadFile", func() {
 			var testFile string
 			BeforeEach(func() {
@@ -251,6 +539,96 @@ var _ = Describe("Gstorage File Operations Library", func() {
 				})
 			})
 		})
+		Describe("Streaming reads", func() {
+			var testFile string
+			BeforeEach(func() {
+				testFile = filepath.Join(tempDir, "stream.txt")
+			})
+
+			collectLines := func(path string) ([]string, error) {
+				var lines []string
+				for line, err := range ReadFileLines(path) {
+					if err != nil {
+						return lines, err
+					}
+					lines = append(lines, line)
+				}
+				return lines, nil
+			}
+
+			DescribeTable("ReadFileLines",
+				func(content string, expected []string) {
+					createTestFile(testFile, content)
+					lines, err := collectLines(testFile)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(lines).To(Equal(expected))
+				},
+				Entry("LF endings", "one\ntwo\n", []string{"one", "two"}),
+				Entry("CRLF endings", "one\r\ntwo\r\n", []string{"one", "two"}),
+				Entry("no final line break", "one\ntwo", []string{"one", "two"}),
+				Entry("blank lines", "\n\none\n", []string{"", "", "one"}),
+				Entry("empty file", "", nil),
+			)
+
+			It("should read lines longer than any buffer", func() {
+				long := strings.Repeat("x", 1024*1024)
+				createTestFile(testFile, long+"\nshort\n")
+				lines, err := collectLines(testFile)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(lines).To(Equal([]string{long, "short"}))
+			})
+
+			It("should stop reading when the consumer stops", func() {
+				createTestFile(testFile, "one\ntwo\nthree\n")
+				var lines []string
+				for line := range ReadFileLines(testFile) {
+					lines = append(lines, line)
+					if len(lines) == 2 {
+						break
+					}
+				}
+				Expect(lines).To(Equal([]string{"one", "two"}))
+			})
+
+			It("should yield an error for a missing file", func() {
+				_, err := collectLines(filepath.Join(tempDir, "missing.txt"))
+				Expect(err).To(MatchError(os.ErrNotExist))
+
+				for chunk, err := range ReadFileChunks(filepath.Join(tempDir, "missing.txt"), 4) {
+					Expect(chunk).To(BeNil())
+					Expect(err).To(MatchError(os.ErrNotExist))
+				}
+			})
+
+			It("should read chunks of the requested size", func() {
+				createTestFile(testFile, "abcdefghij")
+				var chunks []string
+				for chunk, err := range ReadFileChunks(testFile, 4) {
+					Expect(err).NotTo(HaveOccurred())
+					chunks = append(chunks, string(chunk))
+				}
+				Expect(chunks).To(Equal([]string{"abcd", "efgh", "ij"}))
+			})
+
+			It("should use a default chunk size and read everything", func() {
+				content := strings.Repeat("0123456789", 20000)
+				createTestFile(testFile, content)
+				var data []byte
+				for chunk, err := range ReadFileChunks(testFile, 0) {
+					Expect(err).NotTo(HaveOccurred())
+					Expect(len(chunk)).To(BeNumerically("<=", 64*1024))
+					data = append(data, chunk...)
+				}
+				Expect(string(data)).To(Equal(content))
+			})
+
+			It("should reject a negative chunk size", func() {
+				createTestFile(testFile, "abc")
+				for _, err := range ReadFileChunks(testFile, -1) {
+					Expect(err).To(MatchError(ErrInvalidChunkSize))
+				}
+			})
+		})
 		Describe("WriteFile", func() {
 			var testFile string
 
@@ -274,6 +652,36 @@ var _ = Describe("Gstorage File Operations Library", func() {
 				Expect(readFileContent(testFile)).To(Equal(string(newContent)))
 			})
 
+			It("should return an error instead of panicking when the file cannot be created", func() {
+				dirTarget := filepath.Join(tempDir, "is_a_dir")
+				createTestDir(dirTarget)
+
+				var err error
+				Expect(func() { err = WriteFile(dirTarget, []byte("content")) }).NotTo(Panic())
+				Expect(err).To(HaveOccurred())
+			})
+
+			It("should return an error when a parent path is a file", func() {
+				createTestFile(filepath.Join(tempDir, "blocker"), "file")
+				err := WriteFile(filepath.Join(tempDir, "blocker", "file.txt"), []byte("content"))
+				Expect(err).To(HaveOccurred())
+			})
+
+			It("should verify the written length when asked", func() {
+				content := []byte(strings.Repeat("v", 10000))
+				err := WriteFileWithOptions(testFile, content, WriteOptions{VerifyLength: true})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(readFileContent(testFile)).To(Equal(string(content)))
+			})
+
+			It("should report write errors from the underlying device", func() {
+				if _, err := os.Stat("/dev/full"); err != nil {
+					Skip("/dev/full is not available")
+				}
+				err := WriteFile("/dev/full", []byte(strings.Repeat("x", 8192)))
+				Expect(err).To(HaveOccurred())
+			})
+
 			It("should create a directory path if it doesn't exist", func() {
 				nestedFile := filepath.Join(tempDir, "nested", "deep", "file.txt")
 				content := []byte("Nested content")
@@ -283,6 +691,71 @@ var _ = Describe("Gstorage File Operations Library", func() {
 				Expect(readFileContent(nestedFile)).To(Equal(string(content)))
 			})
 		})
+		Describe("Partial writes", func() {
+			var testFile string
+
+			BeforeEach(func() {
+				testFile = filepath.Join(tempDir, "partial.log")
+			})
+
+			It("should append to a file, creating it first", func() {
+				nested := filepath.Join(tempDir, "logs", "app.log")
+				Expect(AppendFile(nested, []byte("one\n"))).To(Succeed())
+				Expect(AppendFile(nested, []byte("two\n"))).To(Succeed())
+				Expect(readFileContent(nested)).To(Equal("one\ntwo\n"))
+			})
+
+			It("should keep concurrent appends whole", func() {
+				var wg sync.WaitGroup
+				for i := range 20 {
+					wg.Add(1)
+					go func() {
+						defer GinkgoRecover()
+						defer wg.Done()
+						Expect(AppendFile(testFile, []byte(fmt.Sprintf("line %02d\n", i)))).To(Succeed())
+					}()
+				}
+				wg.Wait()
+
+				lines := strings.Split(strings.TrimSuffix(readFileContent(testFile), "\n"), "\n")
+				sort.Strings(lines)
+				Expect(lines).To(HaveLen(20))
+				Expect(lines[0]).To(Equal("line 00"))
+				Expect(lines[19]).To(Equal("line 19"))
+			})
+
+			It("should write at an offset without touching the rest", func() {
+				createTestFile(testFile, "hello world")
+				Expect(WriteAt(testFile, []byte("WORLD"), 6)).To(Succeed())
+				Expect(readFileContent(testFile)).To(Equal("hello WORLD"))
+			})
+
+			It("should extend the file with zeros when writing past its end", func() {
+				createTestFile(testFile, "ab")
+				Expect(WriteAt(testFile, []byte("z"), 4)).To(Succeed())
+				Expect(readFileContent(testFile)).To(Equal("ab\x00\x00z"))
+			})
+
+			It("should refuse a negative offset", func() {
+				createTestFile(testFile, "ab")
+				Expect(WriteAt(testFile, []byte("z"), -1)).To(HaveOccurred())
+				Expect(readFileContent(testFile)).To(Equal("ab"))
+			})
+
+			It("should truncate and extend files", func() {
+				createTestFile(testFile, "hello world")
+				Expect(TruncateFile(testFile, 5)).To(Succeed())
+				Expect(readFileContent(testFile)).To(Equal("hello"))
+				Expect(TruncateFile(testFile, 7)).To(Succeed())
+				Expect(readFileContent(testFile)).To(Equal("hello\x00\x00"))
+			})
+
+			It("should not create a missing file when truncating", func() {
+				Expect(TruncateFile(testFile, 0)).To(MatchError(os.ErrNotExist))
+				Expect(fileExists(testFile)).To(BeFalse())
+			})
+		})
+
 		Describe("Directory Operations", func() {
 			Describe("ListDir", func() {
 				var testDir string
@@ -321,6 +794,51 @@ var _ = Describe("Gstorage File Operations Library", func() {
 					Expect(err).To(HaveOccurred())
 				})
 			})
+			Describe("ListDirFunc", func() {
+				var testDir string
+				BeforeEach(func() {
+					testDir = filepath.Join(tempDir, "listfunctest")
+					createTestDir(testDir)
+					for i := 0; i < 600; i++ {
+						createTestFile(filepath.Join(testDir, fmt.Sprintf("file_%d.txt", i)), "x")
+					}
+				})
+
+				It("should visit every entry across batches", func() {
+					seen := map[string]bool{}
+					err := ListDirFunc(testDir, func(entry os.DirEntry) error {
+						seen[entry.Name()] = true
+						return nil
+					})
+					Expect(err).NotTo(HaveOccurred())
+					Expect(seen).To(HaveLen(600))
+				})
+
+				It("should stop early on fs.SkipAll", func() {
+					visited := 0
+					err := ListDirFunc(testDir, func(entry os.DirEntry) error {
+						visited++
+						if visited == 10 {
+							return fs.SkipAll
+						}
+						return nil
+					})
+					Expect(err).NotTo(HaveOccurred())
+					Expect(visited).To(Equal(10))
+				})
+
+				It("should return errors from the callback", func() {
+					stop := errors.New("stop")
+					err := ListDirFunc(testDir, func(entry os.DirEntry) error { return stop })
+					Expect(err).To(MatchError(stop))
+				})
+
+				It("should return an error when the directory does not exist", func() {
+					err := ListDirFunc(filepath.Join(testDir, "nonexistent"), func(entry os.DirEntry) error { return nil })
+					Expect(err).To(HaveOccurred())
+					Expect(os.IsNotExist(err)).To(BeTrue())
+				})
+			})
 			Describe("CreateDir", func() {
 				var testDir string
 				BeforeEach(func() {
@@ -513,265 +1031,4392 @@ var _ = Describe("Gstorage File Operations Library", func() {
 					Expect(fileExists(filepath.Join(multiDst, "dir2", "nested2.txt"))).To(BeTrue())
 				})
 			})
-		})
-	})
-	Describe("Advanced Operations", func() {
-		Describe("FileExists", func() {
-			It("should return true for existing file", func() {
-				testFile := filepath.Join(tempDir, "exists.txt")
-				createTestFile(testFile, "I exist")
+			Describe("CopyDirWithOptions", func() {
+				var srcDir, dstDir string
 
-				exists, err := FileExists(testFile)
+				BeforeEach(func() {
+					srcDir = filepath.Join(tempDir, "src")
+					dstDir = filepath.Join(tempDir, "dst")
 
-				Expect(err).NotTo(HaveOccurred())
-				Expect(exists).To(BeTrue())
-			})
+					createTestDir(filepath.Join(srcDir, "subdir"))
+					createTestFile(filepath.Join(srcDir, "old.txt"), "old")
+					createTestFile(filepath.Join(srcDir, "subdir", "old.txt"), "old nested")
+					createTestFile(filepath.Join(srcDir, "fresh.log"), "still being written")
 
-			It("should return false for non-existing file", func() {
-				testFile := filepath.Join(tempDir, "nonexistent.txt")
+					past := time.Now().Add(-time.Hour)
+					Expect(os.Chtimes(filepath.Join(srcDir, "old.txt"), past, past)).To(Succeed())
+					Expect(os.Chtimes(filepath.Join(srcDir, "subdir", "old.txt"), past, past)).To(Succeed())
+				})
 
-				exists, err := FileExists(testFile)
-				Expect(err).NotTo(HaveOccurred())
-				Expect(exists).To(BeFalse())
-			})
-			It("should return true for existing directory", func() {
-				testDir := filepath.Join(tempDir, "existDir")
-				createTestDir(testDir)
+				It("should copy everything with zero options", func() {
+					err := CopyDirWithOptions(srcDir, dstDir, CopyOptions{})
+					Expect(err).NotTo(HaveOccurred())
+					Expect(fileExists(filepath.Join(dstDir, "fresh.log"))).To(BeTrue())
+					Expect(fileExists(filepath.Join(dstDir, "old.txt"))).To(BeTrue())
+				})
 
-				exist, err := FileExists(testDir)
+				It("should skip files younger than MinFileAge", func() {
+					skipped := map[string]error{}
+					err := CopyDirWithOptions(srcDir, dstDir, CopyOptions{
+						MinFileAge: time.Minute,
+						OnSkip:     func(path string, reason error) { skipped[path] = reason },
+					})
+					Expect(err).NotTo(HaveOccurred())
+					Expect(skipped).To(HaveKeyWithValue(filepath.Join(srcDir, "fresh.log"), ErrFileTooRecent))
+					Expect(fileExists(filepath.Join(dstDir, "fresh.log"))).To(BeFalse())
+					Expect(readFileContent(filepath.Join(dstDir, "old.txt"))).To(Equal("old"))
+					Expect(readFileContent(filepath.Join(dstDir, "subdir", "old.txt"))).To(Equal("old nested"))
+				})
 
-				Expect(err).NotTo(HaveOccurred())
-				Expect(exist).To(BeTrue())
-			})
-		})
-	})
-	Describe("GetFileSize", func() {
-		It("should return correct file size", func() {
-			testFile := filepath.Join(tempDir, "sizefile.txt")
-			content := "Hello, World!" // 13 bytes
-			createTestFile(testFile, content)
+				It("should skip files larger than MaxFileSize", func() {
+					var skipped []string
+					err := CopyDirWithOptions(srcDir, dstDir, CopyOptions{
+						MaxFileSize: 10,
+						OnSkip:      func(path string, reason error) { skipped = append(skipped, path) },
+					})
+					Expect(err).NotTo(HaveOccurred())
+					Expect(skipped).To(ConsistOf(filepath.Join(srcDir, "fresh.log")))
+					Expect(fileExists(filepath.Join(dstDir, "subdir", "old.txt"))).To(BeTrue())
+				})
 
-			size, err := GetFileSize(testFile)
-			Expect(err).NotTo(HaveOccurred())
-			Expect(size).To(Equal(int64(size)))
-		})
-		It("should return 0 for empty file", func() {
-			testFile := filepath.Join(tempDir, "emptyfile.txt")
-			createTestFile(testFile, "")
+				It("should fail on large files with FailOnMaxFileSize", func() {
+					err := CopyDirWithOptions(srcDir, dstDir, CopyOptions{MaxFileSize: 10, FailOnMaxFileSize: true})
+					Expect(err).To(MatchError(ErrFileTooLarge))
+				})
 
-			size, err := GetFileSize(testFile)
-			Expect(err).NotTo(HaveOccurred())
-			Expect(size).To(Equal(int64(0)))
-		})
-		It("should return error for non-existent file", func() {
-			testFile := filepath.Join(tempDir, "non-existintent.txt")
+				It("should abort before exceeding MaxTotalSize", func() {
+					err := CopyDirWithOptions(srcDir, dstDir, CopyOptions{MaxTotalSize: 25})
+					Expect(err).To(MatchError(ErrTotalSizeExceeded))
 
-			_, err := GetFileSize(testFile)
-			Expect(err).To(HaveOccurred())
-			Expect(os.IsNotExist(err)).To(BeTrue())
-		})
-	})
-	Describe("CalculateFileMD5", func() {
-		It("should calculate correct MD5 hash", func() {
-			testFile := filepath.Join(tempDir, "hashfile.txt")
-			content := "Hello, World!"
-			createTestFile(testFile, content)
+					var copied int64
+					filepath.WalkDir(dstDir, func(path string, d os.DirEntry, err error) error {
+						if err == nil && !d.IsDir() {
+							info, _ := d.Info()
+							copied += info.Size()
+						}
+						return nil
+					})
+					Expect(copied).To(BeNumerically("<=", 25))
+				})
 
-			expectedHash := fmt.Sprintf("%x", md5.Sum([]byte(content)))
+				It("should carry modification times over with VerifySize", func() {
+					err := CopyDirWithOptions(srcDir, dstDir, CopyOptions{VerifySize: true})
+					Expect(err).NotTo(HaveOccurred())
 
-			hash, err := CalculateFileMD5(testFile)
-			Expect(err).NotTo(HaveOccurred())
-			Expect(hash).To(Equal(expectedHash))
-		})
-		It("should handle empty files", func() {
-			testFile := filepath.Join(tempDir, "emptyhashfile.txt")
+					srcInfo, err := os.Stat(filepath.Join(srcDir, "subdir", "old.txt"))
+					Expect(err).NotTo(HaveOccurred())
+					dstInfo, err := os.Stat(filepath.Join(dstDir, "subdir", "old.txt"))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(dstInfo.Size()).To(Equal(srcInfo.Size()))
+					Expect(dstInfo.ModTime()).To(BeTemporally("==", srcInfo.ModTime()))
+				})
+
+				It("should preserve mode and times when asked", func() {
+					nested := filepath.Join(srcDir, "subdir", "old.txt")
+					Expect(os.Chmod(nested, 0640)).To(Succeed())
+					past := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+					Expect(os.Chtimes(filepath.Join(srcDir, "subdir"), past, past)).To(Succeed())
+
+					err := CopyDirWithOptions(srcDir, dstDir, CopyOptions{
+						PreserveMode:  true,
+						PreserveTimes: true,
+						PreserveOwner: true,
+					})
+					Expect(err).NotTo(HaveOccurred())
+
+					srcInfo, err := os.Stat(nested)
+					Expect(err).NotTo(HaveOccurred())
+					dstInfo, err := os.Stat(filepath.Join(dstDir, "subdir", "old.txt"))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(dstInfo.Mode()).To(Equal(srcInfo.Mode()))
+					Expect(dstInfo.ModTime()).To(BeTemporally("==", srcInfo.ModTime()))
+
+					dirInfo, err := os.Stat(filepath.Join(dstDir, "subdir"))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(dirInfo.ModTime()).To(BeTemporally("==", past))
+				})
+
+				It("should preserve metadata in CopyFileWithOptions", func() {
+					src := filepath.Join(srcDir, "old.txt")
+					Expect(os.Chmod(src, 0600)).To(Succeed())
+					dst := filepath.Join(tempDir, "old-copy.txt")
+
+					Expect(CopyFileWithOptions(src, dst, CopyOptions{PreserveMode: true, PreserveTimes: true})).To(Succeed())
+					srcInfo, err := os.Stat(src)
+					Expect(err).NotTo(HaveOccurred())
+					dstInfo, err := os.Stat(dst)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(dstInfo.Mode().Perm()).To(Equal(srcInfo.Mode().Perm()))
+					Expect(dstInfo.ModTime()).To(BeTemporally("==", srcInfo.ModTime()))
+				})
+
+				Context("with verification", func() {
+					// Every read of this file returns a fresh UUID, so a copy
+					// of it can never match its source
+					const unstable = "/proc/sys/kernel/random/uuid"
+					BeforeEach(func() {
+						if _, err := os.Stat(unstable); err != nil {
+							Skip("no unstable source file: " + err.Error())
+						}
+					})
+
+					It("should pass faithful copies", func() {
+						for _, mode := range []VerifyMode{VerifyChecksum, VerifyBytes} {
+							err := CopyDirWithOptions(srcDir, dstDir, CopyOptions{Verify: mode, VerifyHash: HashSHA256})
+							Expect(err).NotTo(HaveOccurred())
+						}
+						err := CopyFileWithOptions(filepath.Join(srcDir, "old.txt"), filepath.Join(tempDir, "old.txt"), CopyOptions{Verify: VerifyBytes})
+						Expect(err).NotTo(HaveOccurred())
+					})
+
+					It("should report a mismatched file copy", func() {
+						dst := filepath.Join(tempDir, "uuid")
+						err := CopyFileWithOptions(unstable, dst, CopyOptions{Verify: VerifyBytes})
+						var verr *VerificationError
+						Expect(errors.As(err, &verr)).To(BeTrue())
+						Expect(verr.Files).To(Equal([]string{dst}))
+						Expect(err).To(MatchError(ErrChecksumMismatch))
+					})
+
+					It("should list every mismatch after copying the rest", func() {
+						Expect(os.Symlink(unstable, filepath.Join(srcDir, "uuid"))).To(Succeed())
+						Expect(os.Symlink(unstable, filepath.Join(srcDir, "subdir", "uuid"))).To(Succeed())
+
+						err := CopyDirWithOptions(srcDir, dstDir, CopyOptions{Verify: VerifyChecksum})
+						var verr *VerificationError
+						Expect(errors.As(err, &verr)).To(BeTrue())
+						Expect(verr.Files).To(ConsistOf(filepath.Join(dstDir, "uuid"), filepath.Join(dstDir, "subdir", "uuid")))
+						Expect(fileExists(filepath.Join(dstDir, "subdir", "old.txt"))).To(BeTrue())
+					})
+
+					It("should discard mismatched copies in WorkerPoolCopyDir with TempRename", func() {
+						Expect(os.Symlink(unstable, filepath.Join(srcDir, "uuid"))).To(Succeed())
+
+						err := WorkerPoolCopyDirWithOptions(srcDir, dstDir, PoolOptions{Workers: 2, TempRename: true, Verify: VerifyBytes})
+						var verr *VerificationError
+						Expect(errors.As(err, &verr)).To(BeTrue())
+						Expect(verr.Files).To(Equal([]string{filepath.Join(dstDir, "uuid")}))
+						Expect(fileExists(filepath.Join(dstDir, "uuid"))).To(BeFalse())
+						Expect(fileExists(filepath.Join(dstDir, "old.txt"))).To(BeTrue())
+					})
+				})
+
+				Context("when the destination is full", func() {
+					// Writes to /dev/full always fail with ENOSPC; a destination
+					// linked to it is full until the link is removed
+					var full string
+					BeforeEach(func() {
+						createTestDir(dstDir)
+						full = filepath.Join(dstDir, "old.txt")
+						if err := os.Symlink("/dev/full", full); err != nil {
+							Skip("cannot link to /dev/full: " + err.Error())
+						}
+						if _, err := os.Stat(full); err != nil {
+							Skip("no /dev/full: " + err.Error())
+						}
+					})
+
+					It("should fail without a handler", func() {
+						err := CopyDirWithOptions(srcDir, dstDir, CopyOptions{})
+						Expect(err).To(MatchError(syscall.ENOSPC))
+					})
+
+					It("should call the handler and retry", func() {
+						var calls []string
+						err := CopyDirWithOptions(srcDir, dstDir, CopyOptions{
+							OnNoSpace: func(dst string) error {
+								calls = append(calls, dst)
+								return os.Remove(dst)
+							},
+						})
+						Expect(err).NotTo(HaveOccurred())
+						Expect(calls).To(Equal([]string{full}))
+						Expect(readFileContent(full)).To(Equal("old"))
+					})
+
+					It("should give up after NoSpaceRetries or when the handler fails", func() {
+						calls := 0
+						err := CopyFileWithOptions(filepath.Join(srcDir, "old.txt"), full, CopyOptions{
+							NoSpaceRetries: 3,
+							OnNoSpace: func(dst string) error {
+								calls++
+								return nil
+							},
+						})
+						Expect(err).To(MatchError(syscall.ENOSPC))
+						Expect(calls).To(Equal(3))
+
+						err = CopyFileWithOptions(filepath.Join(srcDir, "old.txt"), full, CopyOptions{
+							NoSpaceRetries: 3,
+							OnNoSpace: func(dst string) error {
+								return errors.New("nothing left to prune")
+							},
+						})
+						Expect(err).To(MatchError(syscall.ENOSPC))
+					})
+
+					It("should retry in WorkerPoolCopyDir", func() {
+						err := WorkerPoolCopyDirWithOptions(srcDir, dstDir, PoolOptions{
+							Workers: 2,
+							OnNoSpace: func(dst string) error {
+								return os.Remove(dst)
+							},
+						})
+						Expect(err).NotTo(HaveOccurred())
+						Expect(readFileContent(full)).To(Equal("old"))
+					})
+				})
+
+				Context("when the source contains special files", func() {
+					var fifo string
+					BeforeEach(func() {
+						fifo = filepath.Join(srcDir, "subdir", "events.fifo")
+						if err := mkfifo(fifo); err != nil {
+							Skip("cannot create FIFO: " + err.Error())
+						}
+					})
+
+					It("should skip and report them by default", func() {
+						var skipped []string
+						err := CopyDirWithOptions(srcDir, dstDir, CopyOptions{
+							OnSkip: func(path string, reason error) {
+								Expect(reason).To(MatchError(ErrSpecialFile))
+								skipped = append(skipped, path)
+							},
+						})
+						Expect(err).NotTo(HaveOccurred())
+						Expect(skipped).To(ConsistOf(fifo))
+						Expect(fileExists(filepath.Join(dstDir, "subdir", "events.fifo"))).To(BeFalse())
+						Expect(fileExists(filepath.Join(dstDir, "subdir", "old.txt"))).To(BeTrue())
+					})
+
+					It("should fail with SpecialFileError", func() {
+						err := CopyDirWithOptions(srcDir, dstDir, CopyOptions{SpecialFiles: SpecialFileError})
+						Expect(err).To(MatchError(ErrSpecialFile))
+					})
+
+					It("should recreate FIFOs with SpecialFileRecreate", func() {
+						err := CopyDirWithOptions(srcDir, dstDir, CopyOptions{SpecialFiles: SpecialFileRecreate})
+						Expect(err).NotTo(HaveOccurred())
+
+						info, err := os.Lstat(filepath.Join(dstDir, "subdir", "events.fifo"))
+						Expect(err).NotTo(HaveOccurred())
+						Expect(info.Mode() & os.ModeNamedPipe).NotTo(BeZero())
+					})
+
+					It("should not replace a protected destination with SpecialFileRecreate", func() {
+						existing := filepath.Join(dstDir, "subdir", "events.fifo")
+						createTestDir(filepath.Dir(existing))
+						createTestFile(existing, "archived")
+						Expect(SetWriteOnceRoot(dstDir, time.Hour)).To(Succeed())
+
+						err := CopyDirWithOptions(srcDir, dstDir, CopyOptions{SpecialFiles: SpecialFileRecreate})
+						Expect(err).To(MatchError(ErrWriteOnce))
+						Expect(readFileContent(existing)).To(Equal("archived"))
+					})
+
+					It("should be skipped and reported by WorkerPoolCopyDir", func() {
+						createTestDir(dstDir)
+						var mu sync.Mutex
+						var skipped []string
+						err := WorkerPoolCopyDirWithOptions(srcDir, dstDir, PoolOptions{
+							Workers: 2,
+							OnSkip: func(path string, reason error) {
+								defer GinkgoRecover()
+								Expect(reason).To(MatchError(ErrSpecialFile))
+								mu.Lock()
+								skipped = append(skipped, path)
+								mu.Unlock()
+							},
+						})
+						Expect(err).NotTo(HaveOccurred())
+						Expect(skipped).To(ConsistOf(fifo))
+						Expect(fileExists(filepath.Join(dstDir, "subdir", "events.fifo"))).To(BeFalse())
+					})
+
+					It("should follow SpecialFiles in WorkerPoolCopyDir", func() {
+						createTestDir(dstDir)
+						err := WorkerPoolCopyDirWithOptions(srcDir, dstDir, PoolOptions{Workers: 2, SpecialFiles: SpecialFileError})
+						Expect(err).To(MatchError(ErrSpecialFile))
+
+						err = WorkerPoolCopyDirWithOptions(srcDir, dstDir, PoolOptions{Workers: 2, SpecialFiles: SpecialFileRecreate})
+						Expect(err).NotTo(HaveOccurred())
+						info, err := os.Lstat(filepath.Join(dstDir, "subdir", "events.fifo"))
+						Expect(err).NotTo(HaveOccurred())
+						Expect(info.Mode() & os.ModeNamedPipe).NotTo(BeZero())
+					})
+
+					It("should not block CopyFile", func() {
+						err := CopyFile(fifo, filepath.Join(tempDir, "copy.fifo"))
+						Expect(err).To(MatchError(ErrSpecialFile))
+					})
+				})
+
+				Context("when the source contains symlinks", func() {
+					BeforeEach(func() {
+						if err := os.Symlink("old.txt", filepath.Join(srcDir, "link.txt")); err != nil {
+							Skip("cannot create symlinks: " + err.Error())
+						}
+						Expect(os.Symlink("subdir", filepath.Join(srcDir, "linked"))).To(Succeed())
+						Expect(os.Symlink("..", filepath.Join(srcDir, "subdir", "loop"))).To(Succeed())
+					})
+
+					It("should follow them by default and skip loops", func() {
+						var loops []string
+						err := CopyDirWithOptions(srcDir, dstDir, CopyOptions{
+							OnSkip: func(path string, reason error) {
+								Expect(reason).To(MatchError(ErrSymlinkLoop))
+								loops = append(loops, path)
+							},
+						})
+						Expect(err).NotTo(HaveOccurred())
+						Expect(loops).To(ConsistOf(
+							filepath.Join(srcDir, "subdir", "loop"),
+							filepath.Join(srcDir, "linked", "loop"),
+						))
+
+						info, err := os.Lstat(filepath.Join(dstDir, "link.txt"))
+						Expect(err).NotTo(HaveOccurred())
+						Expect(info.Mode().IsRegular()).To(BeTrue())
+						Expect(readFileContent(filepath.Join(dstDir, "link.txt"))).To(Equal("old"))
+						Expect(readFileContent(filepath.Join(dstDir, "linked", "old.txt"))).To(Equal("old nested"))
+						Expect(fileExists(filepath.Join(dstDir, "subdir", "loop"))).To(BeFalse())
+					})
+
+					It("should recreate them with SymlinkPreserve", func() {
+						err := CopyDirWithOptions(srcDir, dstDir, CopyOptions{Symlinks: SymlinkPreserve})
+						Expect(err).NotTo(HaveOccurred())
+
+						target, err := os.Readlink(filepath.Join(dstDir, "link.txt"))
+						Expect(err).NotTo(HaveOccurred())
+						Expect(target).To(Equal("old.txt"))
+						target, err = os.Readlink(filepath.Join(dstDir, "subdir", "loop"))
+						Expect(err).NotTo(HaveOccurred())
+						Expect(target).To(Equal(".."))
+					})
+
+					It("should skip and report them with SymlinkSkip", func() {
+						var skipped []string
+						err := CopyDirWithOptions(srcDir, dstDir, CopyOptions{
+							Symlinks: SymlinkSkip,
+							OnSkip: func(path string, reason error) {
+								Expect(reason).To(MatchError(ErrSymlink))
+								skipped = append(skipped, path)
+							},
+						})
+						Expect(err).NotTo(HaveOccurred())
+						Expect(skipped).To(HaveLen(3))
+						Expect(LinkExists(filepath.Join(dstDir, "link.txt"))).To(BeFalse())
+						Expect(fileExists(filepath.Join(dstDir, "linked"))).To(BeFalse())
+						Expect(fileExists(filepath.Join(dstDir, "old.txt"))).To(BeTrue())
+					})
+
+					It("should fail with SymlinkError", func() {
+						err := CopyDirWithOptions(srcDir, dstDir, CopyOptions{Symlinks: SymlinkError})
+						Expect(err).To(MatchError(ErrSymlink))
+					})
+
+					It("should apply the same policies in WorkerPoolCopyDir", func() {
+						err := WorkerPoolCopyDirWithOptions(srcDir, dstDir, PoolOptions{Workers: 2})
+						Expect(err).NotTo(HaveOccurred())
+						Expect(readFileContent(filepath.Join(dstDir, "link.txt"))).To(Equal("old"))
+						Expect(readFileContent(filepath.Join(dstDir, "linked", "old.txt"))).To(Equal("old nested"))
+						Expect(fileExists(filepath.Join(dstDir, "subdir", "loop"))).To(BeFalse())
+
+						preserved := filepath.Join(tempDir, "preserved")
+						err = WorkerPoolCopyDirWithOptions(srcDir, preserved, PoolOptions{Workers: 2, Symlinks: SymlinkPreserve})
+						Expect(err).NotTo(HaveOccurred())
+						Expect(LinkExists(filepath.Join(preserved, "linked"))).To(BeTrue())
+
+						err = WorkerPoolCopyDirWithOptions(srcDir, filepath.Join(tempDir, "refused"), PoolOptions{Symlinks: SymlinkError})
+						Expect(err).To(MatchError(ErrSymlink))
+					})
+
+					It("should apply them in CopyFileWithOptions", func() {
+						link := filepath.Join(srcDir, "link.txt")
+						dst := filepath.Join(tempDir, "link-copy.txt")
+						Expect(CopyFileWithOptions(link, dst, CopyOptions{Symlinks: SymlinkPreserve})).To(Succeed())
+						Expect(LinkExists(dst)).To(BeTrue())
+
+						broken := filepath.Join(srcDir, "broken")
+						Expect(os.Symlink("missing.txt", broken)).To(Succeed())
+						Expect(CopyFileWithOptions(broken, filepath.Join(tempDir, "broken-copy"), CopyOptions{Symlinks: SymlinkSkip})).To(Succeed())
+						Expect(CopyFileWithOptions(broken, filepath.Join(tempDir, "broken-copy"), CopyOptions{Symlinks: SymlinkError})).To(MatchError(ErrSymlink))
+					})
+				})
+			})
+		})
+	})
+	Describe("Advanced Operations", func() {
+		Describe("FileExists", func() {
+			It("should return true for existing file", func() {
+				testFile := filepath.Join(tempDir, "exists.txt")
+				createTestFile(testFile, "I exist")
+
+				exists, err := FileExists(testFile)
+
+				Expect(err).NotTo(HaveOccurred())
+				Expect(exists).To(BeTrue())
+			})
+
+			It("should return false for non-existing file", func() {
+				testFile := filepath.Join(tempDir, "nonexistent.txt")
+
+				exists, err := FileExists(testFile)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(exists).To(BeFalse())
+			})
+			It("should return true for existing directory", func() {
+				testDir := filepath.Join(tempDir, "existDir")
+				createTestDir(testDir)
+
+				exist, err := FileExists(testDir)
+
+				Expect(err).NotTo(HaveOccurred())
+				Expect(exist).To(BeTrue())
+			})
+		})
+
+		Describe("Exists variants", func() {
+			var file, dir, link, dangling, missing string
+			BeforeEach(func() {
+				file = filepath.Join(tempDir, "file.txt")
+				dir = filepath.Join(tempDir, "dir")
+				link = filepath.Join(tempDir, "link")
+				dangling = filepath.Join(tempDir, "dangling")
+				missing = filepath.Join(tempDir, "missing")
+				createTestFile(file, "content")
+				createTestDir(dir)
+				Expect(os.Symlink(file, link)).To(Succeed())
+				Expect(os.Symlink(missing, dangling)).To(Succeed())
+			})
+
+			DescribeTable("should distinguish entry types",
+				func(check func(string) (bool, error), wantFile, wantDir, wantLink, wantDangling bool) {
+					for path, want := range map[string]bool{
+						file: wantFile, dir: wantDir, link: wantLink, dangling: wantDangling, missing: false,
+					} {
+						got, err := check(path)
+						Expect(err).NotTo(HaveOccurred())
+						Expect(got).To(Equal(want), path)
+					}
+				},
+				Entry("Exists", Exists, true, true, true, false),
+				Entry("DirExists", DirExists, false, true, false, false),
+				Entry("FileExistsStrict", FileExistsStrict, true, false, true, false),
+				Entry("LinkExists", LinkExists, false, false, true, true),
+			)
+		})
+	})
+	Describe("GetFileSize", func() {
+		It("should return correct file size", func() {
+			testFile := filepath.Join(tempDir, "sizefile.txt")
+			content := "Hello, World!" // 13 bytes
+			createTestFile(testFile, content)
+
+			size, err := GetFileSize(testFile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(size).To(Equal(int64(size)))
+		})
+		It("should return 0 for empty file", func() {
+			testFile := filepath.Join(tempDir, "emptyfile.txt")
 			createTestFile(testFile, "")
 
-			expectedHash := fmt.Sprintf("%x", md5.Sum([]byte("")))
+			size, err := GetFileSize(testFile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(size).To(Equal(int64(0)))
+		})
+		It("should return error for non-existent file", func() {
+			testFile := filepath.Join(tempDir, "non-existintent.txt")
+
+			_, err := GetFileSize(testFile)
+			Expect(err).To(HaveOccurred())
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+	})
+	Describe("GetDirSize", func() {
+		var root string
+		BeforeEach(func() {
+			root = filepath.Join(tempDir, "sized")
+			createTestDir(filepath.Join(root, "a", "b"))
+			createTestFile(filepath.Join(root, "top.txt"), "12345")
+			createTestFile(filepath.Join(root, "a", "mid.txt"), "123")
+			createTestFile(filepath.Join(root, "a", "b", "deep.txt"), "1")
+		})
+
+		It("should total files and directories below root", func() {
+			size, err := GetDirSize(root)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(size).To(Equal(DirSize{Bytes: 9, Files: 3, Dirs: 2}))
+		})
+
+		It("should give the same result with parallel walking", func() {
+			size, err := GetDirSizeWithOptions(root, DirSizeOptions{Workers: 4})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(size).To(Equal(DirSize{Bytes: 9, Files: 3, Dirs: 2}))
+		})
+
+		It("should follow symlinks only when asked", func() {
+			if err := os.Symlink("a", filepath.Join(root, "linked")); err != nil {
+				Skip("cannot create symlinks: " + err.Error())
+			}
+			Expect(os.Symlink("..", filepath.Join(root, "a", "b", "loop"))).To(Succeed())
+
+			size, err := GetDirSize(root)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(size).To(Equal(DirSize{Bytes: 9, Files: 3, Dirs: 2}))
+
+			size, err = GetDirSizeWithOptions(root, DirSizeOptions{FollowSymlinks: true})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(size).To(Equal(DirSize{Bytes: 13, Files: 5, Dirs: 4}))
+		})
+
+		It("should leave out what Include and Exclude filter", func() {
+			size, err := GetDirSizeWithOptions(root, DirSizeOptions{Exclude: []string{"b"}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(size).To(Equal(DirSize{Bytes: 8, Files: 2, Dirs: 1}))
+
+			size, err = GetDirSizeWithOptions(root, DirSizeOptions{Include: []string{"*.txt"}, Exclude: []string{"top.txt"}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(size).To(Equal(DirSize{Bytes: 4, Files: 2, Dirs: 2}))
+		})
+
+		It("should walk a linked root at its target", func() {
+			linked := filepath.Join(tempDir, "linked-root")
+			if err := os.Symlink(root, linked); err != nil {
+				Skip("cannot create symlinks: " + err.Error())
+			}
+
+			for _, workers := range []int{0, 4} {
+				size, err := GetDirSizeWithOptions(linked, DirSizeOptions{Workers: workers})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(size).To(Equal(DirSize{Bytes: 9, Files: 3, Dirs: 2}))
+			}
+		})
+
+		It("should refuse files", func() {
+			_, err := GetDirSize(filepath.Join(root, "top.txt"))
+			Expect(err).To(MatchError(ErrNotDirectory))
+		})
+	})
+	Describe("UsageMonitor", func() {
+		var root string
+		var events []UsageEvent
+		var monitor *UsageMonitor
+		BeforeEach(func() {
+			root = filepath.Join(tempDir, "managed")
+			createTestDir(root)
+			createTestFile(filepath.Join(root, "a.bin"), strings.Repeat("x", 60))
+			events = nil
+			monitor = NewUsageMonitor(root, 10*time.Millisecond, func(e UsageEvent) {
+				events = append(events, e)
+			})
+			monitor.AddThreshold(UsageThreshold{Name: "bytes", MaxBytes: 100})
+			monitor.AddThreshold(UsageThreshold{Name: "inodes", MaxInodes: 2})
+		})
+
+		It("should fire once per crossing in each direction", func() {
+			usage, err := monitor.Check()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(usage.Bytes).To(Equal(int64(60)))
+			Expect(events).To(BeEmpty())
+
+			createTestFile(filepath.Join(root, "b.bin"), strings.Repeat("x", 60))
+			_, err = monitor.Check()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(events).To(HaveLen(1))
+			Expect(events[0].Threshold.Name).To(Equal("bytes"))
+			Expect(events[0].Exceeded).To(BeTrue())
+
+			_, err = monitor.Check()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(events).To(HaveLen(1))
+
+			createTestFile(filepath.Join(root, "c.bin"), "")
+			Expect(os.Remove(filepath.Join(root, "b.bin"))).To(Succeed())
+			_, err = monitor.Check()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(events).To(HaveLen(2))
+			Expect(events[1].Threshold.Name).To(Equal("bytes"))
+			Expect(events[1].Exceeded).To(BeFalse())
+
+			createTestFile(filepath.Join(root, "d.bin"), "")
+			_, err = monitor.Check()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(events).To(HaveLen(3))
+			Expect(events[2].Threshold.Name).To(Equal("inodes"))
+			Expect(events[2].Usage.Files).To(Equal(int64(3)))
+		})
+
+		It("should measure in the background until stopped", func() {
+			monitor.Start()
+			createTestFile(filepath.Join(root, "b.bin"), strings.Repeat("x", 60))
+			Eventually(monitor.Usage).Should(Equal(DirSize{Bytes: 120, Files: 2}))
+			monitor.Stop()
+			Expect(events).To(HaveLen(1))
+		})
+	})
+	Describe("StatsByExtension", func() {
+		var root string
+		BeforeEach(func() {
+			root = filepath.Join(tempDir, "stats")
+			createTestDir(filepath.Join(root, "nested"))
+			createTestFile(filepath.Join(root, "a.txt"), "12345")
+			createTestFile(filepath.Join(root, "nested", "b.TXT"), "123")
+			createTestFile(filepath.Join(root, "page.html"), "<html><body>hi</body></html>")
+			createTestFile(filepath.Join(root, "Makefile"), "all:")
+		})
+
+		It("should group count and size by extension", func() {
+			stats, err := StatsByExtension(root)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stats).To(HaveLen(3))
+			Expect(stats[".txt"]).To(Equal(TypeStats{Count: 2, Size: 8}))
+			Expect(stats[".html"].Count).To(Equal(int64(1)))
+			Expect(stats[""]).To(Equal(TypeStats{Count: 1, Size: 4}))
+		})
+
+		It("should group by detected MIME type", func() {
+			stats, err := StatsByMIMEType(root)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stats["text/plain; charset=utf-8"].Count).To(Equal(int64(3)))
+			Expect(stats["text/html; charset=utf-8"].Count).To(Equal(int64(1)))
+		})
+
+		It("should return an error for a missing root", func() {
+			_, err := StatsByExtension(filepath.Join(tempDir, "nonexistent"))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Describe("TopFiles", func() {
+		var root string
+		BeforeEach(func() {
+			root = filepath.Join(tempDir, "top")
+			createTestDir(filepath.Join(root, "nested"))
+			for i := 1; i <= 5; i++ {
+				path := filepath.Join(root, fmt.Sprintf("file_%d.txt", i))
+				if i%2 == 0 {
+					path = filepath.Join(root, "nested", fmt.Sprintf("file_%d.txt", i))
+				}
+				createTestFile(path, strings.Repeat("x", i*10))
+				modTime := time.Now().Add(-time.Duration(i) * time.Hour)
+				Expect(os.Chtimes(path, modTime, modTime)).To(Succeed())
+			}
+		})
+
+		It("should return the largest files first", func() {
+			files, err := TopFiles(root, 2, SortBySize)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(files).To(HaveLen(2))
+			Expect(files[0].Size).To(Equal(int64(50)))
+			Expect(files[1].Size).To(Equal(int64(40)))
+			Expect(files[1].Path).To(Equal(filepath.Join(root, "nested", "file_4.txt")))
+		})
+
+		It("should return the oldest files first", func() {
+			files, err := TopFiles(root, 3, SortByOldest)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(files).To(HaveLen(3))
+			Expect(filepath.Base(files[0].Path)).To(Equal("file_5.txt"))
+			Expect(filepath.Base(files[2].Path)).To(Equal("file_3.txt"))
+		})
+
+		It("should return the newest files first", func() {
+			files, err := TopFiles(root, 1, SortByNewest)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(files).To(HaveLen(1))
+			Expect(filepath.Base(files[0].Path)).To(Equal("file_1.txt"))
+		})
+
+		It("should return every file when n exceeds the file count", func() {
+			files, err := TopFiles(root, 100, SortBySize)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(files).To(HaveLen(5))
+		})
+	})
+	Describe("FindColdFiles", func() {
+		var root string
+		BeforeEach(func() {
+			root = filepath.Join(tempDir, "cold")
+			createTestDir(filepath.Join(root, "nested"))
+			createTestFile(filepath.Join(root, "hot.txt"), "in use")
+			createTestFile(filepath.Join(root, "nested", "cold.txt"), "forgotten")
+			createTestFile(filepath.Join(root, "read_recently.txt"), "old but read")
+
+			old := time.Now().Add(-72 * time.Hour)
+			Expect(os.Chtimes(filepath.Join(root, "nested", "cold.txt"), old, old)).To(Succeed())
+			Expect(os.Chtimes(filepath.Join(root, "read_recently.txt"), time.Now(), old)).To(Succeed())
+		})
+
+		It("should return files not accessed or modified within the window", func() {
+			files, err := FindColdFiles(root, 48*time.Hour)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(files).To(HaveLen(1))
+			Expect(files[0].Path).To(Equal(filepath.Join(root, "nested", "cold.txt")))
+		})
+
+		It("should return nothing when every file is recent enough", func() {
+			files, err := FindColdFiles(root, 96*time.Hour)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(files).To(BeEmpty())
+		})
+	})
+	Describe("File age helpers", func() {
+		var oldFile, newFile string
+		BeforeEach(func() {
+			oldFile = filepath.Join(tempDir, "old.txt")
+			newFile = filepath.Join(tempDir, "new.txt")
+			createTestFile(oldFile, "old")
+			createTestFile(newFile, "new")
+			past := time.Now().Add(-2 * time.Hour)
+			Expect(os.Chtimes(oldFile, past, past)).To(Succeed())
+		})
+
+		It("should report the age of a file", func() {
+			age, err := FileAge(oldFile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(age).To(BeNumerically("~", 2*time.Hour, time.Minute))
+		})
+
+		It("should compare age against a duration", func() {
+			older, err := IsOlderThan(oldFile, time.Hour)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(older).To(BeTrue())
+
+			older, err = IsOlderThan(newFile, time.Hour)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(older).To(BeFalse())
+		})
+
+		It("should return an error for a missing file", func() {
+			_, err := IsOlderThan(filepath.Join(tempDir, "nonexistent.txt"), time.Hour)
+			Expect(err).To(HaveOccurred())
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+
+		It("should find the newest file in a directory", func() {
+			createTestDir(filepath.Join(tempDir, "subdir"))
+			newest, err := NewestFileIn(tempDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(newest).To(Equal(newFile))
+		})
+
+		It("should return ErrNoFiles for a directory without files", func() {
+			emptyDir := filepath.Join(tempDir, "empty")
+			createTestDir(emptyDir)
+			_, err := NewestFileIn(emptyDir)
+			Expect(err).To(MatchError(ErrNoFiles))
+		})
+	})
+	Describe("NextSequence", func() {
+		var seqFile string
+		BeforeEach(func() {
+			seqFile = filepath.Join(tempDir, "ids.seq")
+		})
+
+		It("should start at 1 and increment on every call", func() {
+			for want := uint64(1); want <= 3; want++ {
+				next, err := NextSequence(seqFile)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(next).To(Equal(want))
+			}
+			Expect(readFileContent(seqFile)).To(Equal("3\n"))
+		})
+
+		It("should hand out unique values to concurrent callers", func() {
+			const callers = 20
+			results := make(chan uint64, callers)
+			for i := 0; i < callers; i++ {
+				go func() {
+					defer GinkgoRecover()
+					next, err := NextSequence(seqFile)
+					Expect(err).NotTo(HaveOccurred())
+					results <- next
+				}()
+			}
+
+			seen := map[uint64]bool{}
+			for i := 0; i < callers; i++ {
+				seen[<-results] = true
+			}
+			Expect(seen).To(HaveLen(callers))
+			Expect(seen).To(HaveKey(uint64(callers)))
+		})
+
+		It("should return an error for a corrupt sequence file", func() {
+			createTestFile(seqFile, "not a number")
+			_, err := NextSequence(seqFile)
+			Expect(err).To(HaveOccurred())
+			Expect(readFileContent(seqFile)).To(Equal("not a number"))
+		})
+	})
+	Describe("AcquirePIDLock", func() {
+		var pidFile string
+		BeforeEach(func() {
+			pidFile = filepath.Join(tempDir, "daemon.pid")
+		})
+
+		It("should write the current PID and remove it on release", func() {
+			lock, err := AcquirePIDLock(pidFile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(readFileContent(pidFile)).To(Equal(fmt.Sprintf("%d\n", os.Getpid())))
+
+			Expect(lock.Release()).To(Succeed())
+			Expect(fileExists(pidFile)).To(BeFalse())
+		})
+
+		It("should refuse a second lock while the first is held", func() {
+			lock, err := AcquirePIDLock(pidFile)
+			Expect(err).NotTo(HaveOccurred())
+			defer lock.Release()
+
+			_, err = AcquirePIDLock(pidFile)
+			Expect(err).To(MatchError(ErrAlreadyRunning))
+			Expect(err.Error()).To(ContainSubstring(fmt.Sprintf("%d", os.Getpid())))
+		})
+
+		It("should take over a stale PID file", func() {
+			createTestFile(pidFile, "999999\n")
+
+			lock, err := AcquirePIDLock(pidFile)
+			Expect(err).NotTo(HaveOccurred())
+			defer lock.Release()
+			Expect(readFileContent(pidFile)).To(Equal(fmt.Sprintf("%d\n", os.Getpid())))
+		})
+
+		It("should never grant the lock twice while owners come and go", func() {
+			var holders, overlaps atomic.Int32
+			var wg sync.WaitGroup
+			for range 16 {
+				wg.Add(1)
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+					for range 2000 {
+						lock, err := AcquirePIDLock(pidFile)
+						if errors.Is(err, ErrAlreadyRunning) {
+							continue
+						}
+						Expect(err).NotTo(HaveOccurred())
+						if holders.Add(1) > 1 {
+							overlaps.Add(1)
+						}
+						runtime.Gosched()
+						holders.Add(-1)
+						Expect(lock.Release()).To(Succeed())
+					}
+				}()
+			}
+			wg.Wait()
+			Expect(overlaps.Load()).To(BeZero())
+		})
+	})
+	Describe("LockFile", func() {
+		var lockPath string
+		BeforeEach(func() {
+			lockPath = filepath.Join(tempDir, "shared.lock")
+		})
+
+		It("should refuse a second exclusive lock until the first is released", func() {
+			lock, err := TryLockFile(lockPath, LockExclusive)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fileExists(lockPath)).To(BeTrue())
+
+			_, err = TryLockFile(lockPath, LockExclusive)
+			Expect(err).To(MatchError(ErrLocked))
+			_, err = TryLockFile(lockPath, LockShared)
+			Expect(err).To(MatchError(ErrLocked))
+
+			Expect(lock.Unlock()).To(Succeed())
+			Expect(lock.Unlock()).To(Succeed())
+			lock, err = TryLockFile(lockPath, LockExclusive)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lock.Unlock()).To(Succeed())
+		})
+
+		It("should let shared locks coexist", func() {
+			first, err := TryLockFile(lockPath, LockShared)
+			Expect(err).NotTo(HaveOccurred())
+			defer first.Unlock()
+			second, err := TryLockFile(lockPath, LockShared)
+			Expect(err).NotTo(HaveOccurred())
+			defer second.Unlock()
+
+			_, err = TryLockFile(lockPath, LockExclusive)
+			Expect(err).To(MatchError(ErrLocked))
+		})
+
+		It("should give up when the context expires", func() {
+			lock, err := TryLockFile(lockPath, LockExclusive)
+			Expect(err).NotTo(HaveOccurred())
+			defer lock.Unlock()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			_, err = LockFile(ctx, lockPath, LockShared)
+			Expect(err).To(MatchError(context.DeadlineExceeded))
+		})
+
+		It("should wait for the lock to be released", func() {
+			lock, err := TryLockFile(lockPath, LockExclusive)
+			Expect(err).NotTo(HaveOccurred())
+			time.AfterFunc(50*time.Millisecond, func() { lock.Unlock() })
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			waited, err := LockFile(ctx, lockPath, LockExclusive)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(waited.Path()).To(Equal(lockPath))
+			Expect(waited.Unlock()).To(Succeed())
+		})
+	})
+
+	Describe("Transforms", func() {
+		upper := Transform{Name: "upper", Apply: func(path string, r io.Reader) (io.Reader, error) {
+			data, err := io.ReadAll(r)
+			return bytes.NewReader(bytes.ToUpper(data)), err
+		}}
+		prefix := Transform{Name: "prefix", Apply: func(path string, r io.Reader) (io.Reader, error) {
+			return io.MultiReader(strings.NewReader(filepath.Base(path)+": "), r), nil
+		}}
+
+		var srcDir, dstDir string
+		BeforeEach(func() {
+			srcDir = filepath.Join(tempDir, "src")
+			dstDir = filepath.Join(tempDir, "dst")
+			createTestDir(filepath.Join(srcDir, "sub"))
+			createTestFile(filepath.Join(srcDir, "a.txt"), "alpha")
+			createTestFile(filepath.Join(srcDir, "sub", "b.txt"), strings.Repeat("beta ", 1000))
+		})
+
+		It("should chain transforms in order in CopyFileWithOptions", func() {
+			dst := filepath.Join(tempDir, "a.out")
+			opts := CopyOptions{Transform: []Transform{upper, prefix}, VerifySize: true, Verify: VerifyChecksum}
+			Expect(CopyFileWithOptions(filepath.Join(srcDir, "a.txt"), dst, opts)).To(Succeed())
+			Expect(readFileContent(dst)).To(Equal("a.txt: ALPHA"))
+		})
+
+		It("should store the hash of the transformed content", func() {
+			dst := filepath.Join(tempDir, "a.out")
+			opts := CopyOptions{Transform: []Transform{upper}, StoreHash: HashStoreSidecar}
+			Expect(CopyFileWithOptions(filepath.Join(srcDir, "a.txt"), dst, opts)).To(Succeed())
+
+			stored, ok, err := StoredFileMD5(dst, HashStoreSidecar)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ok).To(BeTrue())
+			Expect(CalculateFileMD5(dst)).To(Equal(stored))
+		})
+
+		It("should transform every file of a directory copy", func() {
+			Expect(CopyDirWithOptions(srcDir, dstDir, CopyOptions{Transform: []Transform{upper}})).To(Succeed())
+			Expect(readFileContent(filepath.Join(dstDir, "a.txt"))).To(Equal("ALPHA"))
+			Expect(readFileContent(filepath.Join(dstDir, "sub", "b.txt"))).To(Equal(strings.Repeat("BETA ", 1000)))
+		})
+
+		It("should compress files in a worker pool copy", func() {
+			opts := PoolOptions{Workers: 2, Transform: []Transform{CompressTransform(CompressOptions{Codec: CodecLZ4})}}
+			Expect(WorkerPoolCopyDirWithOptions(srcDir, dstDir, opts)).To(Succeed())
+
+			out := filepath.Join(tempDir, "b.txt")
+			Expect(DecompressFile(filepath.Join(dstDir, "sub", "b.txt"), out)).To(Succeed())
+			Expect(readFileContent(out)).To(Equal(strings.Repeat("beta ", 1000)))
+		})
+
+		It("should record transforms in the manifest", func() {
+			manifest := filepath.Join(tempDir, "manifest.json")
+			opts := CopyOptions{Transform: []Transform{CompressTransform(CompressOptions{})}, Manifest: manifest}
+			Expect(CopyDirWithOptions(srcDir, dstDir, opts)).To(Succeed())
+
+			m, err := ReadManifest(manifest)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(m.Entries).To(ContainElement(And(HaveField("Path", "a.txt"), HaveField("Transforms", []string{"gzip"}))))
+			Expect(m.Entries).To(ContainElement(And(HaveField("Path", "sub"), HaveField("Transforms", BeNil()))))
+		})
+
+		It("should fail without leaving a partial file when a transform fails", func() {
+			failing := Transform{Name: "failing", Apply: func(path string, r io.Reader) (io.Reader, error) {
+				return nil, errors.New("no key")
+			}}
+			dst := filepath.Join(tempDir, "a.out")
+			Expect(CopyFileWithOptions(filepath.Join(srcDir, "a.txt"), dst, CopyOptions{Transform: []Transform{failing}})).To(MatchError("no key"))
+			Expect(fileExists(dst)).To(BeFalse())
+		})
+	})
+
+	Describe("Text conversions", func() {
+		var textFile string
+		BeforeEach(func() {
+			textFile = filepath.Join(tempDir, "notes.txt")
+		})
+
+		DescribeTable("ConvertLineEndings",
+			func(content string, ending LineEnding, expected string) {
+				createTestFile(textFile, content)
+				Expect(ConvertLineEndings(textFile, ending)).To(Succeed())
+				Expect(readFileContent(textFile)).To(Equal(expected))
+			},
+			Entry("LF to CRLF", "a\nb\n", LineEndingCRLF, "a\r\nb\r\n"),
+			Entry("CRLF to LF", "a\r\nb\r\n", LineEndingLF, "a\nb\n"),
+			Entry("mixed to CRLF", "a\r\nb\nc", LineEndingCRLF, "a\r\nb\r\nc"),
+			Entry("lone CR kept", "a\rb\r\n", LineEndingLF, "a\rb\n"),
+			Entry("trailing CR kept", "a\r", LineEndingCRLF, "a\r"),
+			Entry("empty file", "", LineEndingCRLF, ""),
+		)
+
+		It("should convert line breaks split across reads", func() {
+			content := strings.Repeat("line\r\n", 10000)
+			createTestFile(textFile, content)
+			Expect(ConvertLineEndings(textFile, LineEndingLF)).To(Succeed())
+			Expect(readFileContent(textFile)).To(Equal(strings.Repeat("line\n", 10000)))
+		})
+
+		It("should keep permissions", func() {
+			createTestFile(textFile, "a\n")
+			Expect(os.Chmod(textFile, 0600)).To(Succeed())
+			Expect(ConvertLineEndings(textFile, LineEndingCRLF)).To(Succeed())
+			info, err := os.Stat(textFile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+		})
+
+		It("should refuse binary files", func() {
+			createTestFile(textFile, "a\n\x00b\n")
+			Expect(ConvertLineEndings(textFile, LineEndingCRLF)).To(MatchError(ErrBinaryContent))
+			Expect(ConvertToUTF8(textFile, CharsetLatin1)).To(MatchError(ErrBinaryContent))
+			Expect(readFileContent(textFile)).To(Equal("a\n\x00b\n"))
+		})
+
+		DescribeTable("ConvertToUTF8",
+			func(content string, from Charset, expected string) {
+				createTestFile(textFile, content)
+				Expect(ConvertToUTF8(textFile, from)).To(Succeed())
+				Expect(readFileContent(textFile)).To(Equal(expected))
+			},
+			Entry("Latin-1", "caf\xe9 \xa3\xff", CharsetLatin1, "café £ÿ"),
+			Entry("Latin-1 C1 controls", "\x80", CharsetLatin1, "\u0080"),
+			Entry("Windows-1252", "\x80 \x93quoted\x94 \xe9", CharsetWindows1252, "€ “quoted” é"),
+			Entry("Windows-1252 undefined byte", "\x81", CharsetWindows1252, "\u0081"),
+			Entry("ASCII", "plain text\n", CharsetLatin1, "plain text\n"),
+		)
+
+		It("should convert text files and pass binary files through during copies", func() {
+			srcDir := filepath.Join(tempDir, "legacy")
+			dstDir := filepath.Join(tempDir, "migrated")
+			createTestDir(srcDir)
+			createTestFile(filepath.Join(srcDir, "readme.txt"), "caf\xe9\r\nbar\r\n")
+			binary := "\x00\x01\r\n\xe9"
+			createTestFile(filepath.Join(srcDir, "blob.bin"), binary)
+
+			err := CopyDirWithOptions(srcDir, dstDir, CopyOptions{
+				Transform: []Transform{CharsetTransform(CharsetLatin1), LineEndingTransform(LineEndingLF)},
+				Manifest:  filepath.Join(tempDir, "manifest.json"),
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(readFileContent(filepath.Join(dstDir, "readme.txt"))).To(Equal("café\nbar\n"))
+			Expect(readFileContent(filepath.Join(dstDir, "blob.bin"))).To(Equal(binary))
+
+			manifest, err := ReadManifest(filepath.Join(tempDir, "manifest.json"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(manifest.Entries).To(ContainElement(HaveField("Transforms", Equal([]string{"latin1-to-utf8", "lf"}))))
+		})
+	})
+
+	Describe("Template copies", func() {
+		var srcDir, dstDir string
+		BeforeEach(func() {
+			srcDir = filepath.Join(tempDir, "skeleton")
+			dstDir = filepath.Join(tempDir, "project")
+			createTestDir(filepath.Join(srcDir, "cmd"))
+			createTestFile(filepath.Join(srcDir, "README.md.tmpl"), "# {{.Name}}\n")
+			createTestFile(filepath.Join(srcDir, "cmd", "main.go.tmpl"), "package {{.Package}}\n")
+			createTestFile(filepath.Join(srcDir, "LICENSE"), "{{ not a template }}")
+		})
+
+		It("should render matching files and copy the rest verbatim", func() {
+			err := CopyDirWithOptions(srcDir, dstDir, CopyOptions{Templates: TemplateOptions{
+				Patterns:   []string{"*.tmpl"},
+				Vars:       map[string]any{"Name": "demo", "Package": "main"},
+				TrimSuffix: ".tmpl",
+			}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(readFileContent(filepath.Join(dstDir, "README.md"))).To(Equal("# demo\n"))
+			Expect(readFileContent(filepath.Join(dstDir, "cmd", "main.go"))).To(Equal("package main\n"))
+			Expect(readFileContent(filepath.Join(dstDir, "LICENSE"))).To(Equal("{{ not a template }}"))
+			Expect(fileExists(filepath.Join(dstDir, "README.md.tmpl"))).To(BeFalse())
+		})
+
+		It("should keep template names without TrimSuffix", func() {
+			err := CopyDirWithOptions(srcDir, dstDir, CopyOptions{Templates: TemplateOptions{
+				Patterns: []string{"README.md.tmpl"},
+				Vars:     map[string]any{"Name": "demo"},
+			}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(readFileContent(filepath.Join(dstDir, "README.md.tmpl"))).To(Equal("# demo\n"))
+			Expect(readFileContent(filepath.Join(dstDir, "cmd", "main.go.tmpl"))).To(Equal("package {{.Package}}\n"))
+		})
+
+		It("should fail on missing variables without leaving output", func() {
+			err := CopyDirWithOptions(srcDir, dstDir, CopyOptions{Templates: TemplateOptions{
+				Patterns:   []string{"README.md.tmpl"},
+				TrimSuffix: ".tmpl",
+			}})
+			Expect(err).To(MatchError(ContainSubstring("Name")))
+			Expect(fileExists(filepath.Join(dstDir, "README.md"))).To(BeFalse())
+		})
+
+		It("should support custom delimiters, functions and transforms", func() {
+			createTestFile(filepath.Join(srcDir, "chart.yaml.tmpl"), "name: [[upper .Name]]\nvalue: {{ .Values.x }}\n")
+			err := CopyDirWithOptions(srcDir, dstDir, CopyOptions{
+				Include: []string{"chart.yaml.tmpl"},
+				Templates: TemplateOptions{
+					Patterns:   []string{"*.tmpl"},
+					Vars:       map[string]any{"Name": "demo"},
+					Funcs:      map[string]any{"upper": strings.ToUpper},
+					LeftDelim:  "[[",
+					RightDelim: "]]",
+					TrimSuffix: ".tmpl",
+				},
+				Transform: []Transform{LineEndingTransform(LineEndingCRLF)},
+				Manifest:  filepath.Join(tempDir, "manifest.json"),
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(readFileContent(filepath.Join(dstDir, "chart.yaml"))).To(Equal("name: DEMO\r\nvalue: {{ .Values.x }}\r\n"))
+
+			manifest, err := ReadManifest(filepath.Join(tempDir, "manifest.json"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(manifest.Entries).To(ContainElement(SatisfyAll(
+				HaveField("Path", "chart.yaml"),
+				HaveField("Source", "chart.yaml.tmpl"),
+				HaveField("Transforms", Equal([]string{"template", "crlf"})),
+			)))
+		})
+	})
+
+	Describe("ScaffoldFromTemplateDir", func() {
+		var templateRoot, dst string
+		var vars map[string]any
+		BeforeEach(func() {
+			templateRoot = filepath.Join(tempDir, "templates", "service")
+			dst = filepath.Join(tempDir, "out", "billing")
+			createTestDir(filepath.Join(templateRoot, "cmd", "{{.Name}}"))
+			createTestDir(filepath.Join(templateRoot, ".git"))
+			createTestFile(filepath.Join(templateRoot, "cmd", "{{.Name}}", "main.go.tmpl"), "// {{.Name}} service\n")
+			createTestFile(filepath.Join(templateRoot, "{{if .Docker}}Dockerfile{{end}}.tmpl"), "FROM {{.Base}}\n")
+			createTestFile(filepath.Join(templateRoot, "LICENSE"), "MIT {{.Name}}")
+			createTestFile(filepath.Join(templateRoot, ".git", "HEAD"), "ref")
+			vars = map[string]any{"Name": "billing", "Docker": true, "Base": "alpine"}
+		})
+
+		It("should render contents and names", func() {
+			Expect(ScaffoldFromTemplateDir(templateRoot, dst, vars)).To(Succeed())
+			Expect(readFileContent(filepath.Join(dst, "cmd", "billing", "main.go"))).To(Equal("// billing service\n"))
+			Expect(readFileContent(filepath.Join(dst, "Dockerfile"))).To(Equal("FROM alpine\n"))
+			Expect(readFileContent(filepath.Join(dst, "LICENSE"))).To(Equal("MIT {{.Name}}"))
+		})
+
+		It("should leave out entries whose names render empty", func() {
+			vars["Docker"] = false
+			Expect(ScaffoldFromTemplateDir(templateRoot, dst, vars)).To(Succeed())
+			entries, err := os.ReadDir(dst)
+			Expect(err).NotTo(HaveOccurred())
+			var names []string
+			for _, entry := range entries {
+				names = append(names, entry.Name())
+			}
+			Expect(names).To(ConsistOf(".git", "LICENSE", "cmd"))
+		})
+
+		It("should filter the template tree and run post-hooks in order", func() {
+			var ran []string
+			err := ScaffoldFromTemplateDirWithOptions(templateRoot, dst, vars, ScaffoldOptions{
+				Exclude: []string{".git"},
+				PostHooks: []ScaffoldHook{
+					func(dir string, vars map[string]any) error {
+						ran = append(ran, "first:"+vars["Name"].(string))
+						return os.Chmod(filepath.Join(dir, "LICENSE"), 0600)
+					},
+					func(dir string, vars map[string]any) error {
+						ran = append(ran, "second")
+						return nil
+					},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ran).To(Equal([]string{"first:billing", "second"}))
+			Expect(fileExists(filepath.Join(dst, ".git"))).To(BeFalse())
+			info, err := os.Stat(filepath.Join(dst, "LICENSE"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+		})
+
+		It("should return the first failing post-hook and keep the output", func() {
+			hookErr := errors.New("hook failed")
+			err := ScaffoldFromTemplateDirWithOptions(templateRoot, dst, vars, ScaffoldOptions{
+				PostHooks: []ScaffoldHook{
+					func(string, map[string]any) error { return hookErr },
+					func(string, map[string]any) error {
+						Fail("later hooks should not run")
+						return nil
+					},
+				},
+			})
+			Expect(err).To(MatchError(hookErr))
+			Expect(fileExists(filepath.Join(dst, "LICENSE"))).To(BeTrue())
+		})
+
+		It("should remove its output when rendering fails", func() {
+			delete(vars, "Base")
+			Expect(ScaffoldFromTemplateDir(templateRoot, dst, vars)).To(MatchError(ContainSubstring("Base")))
+			Expect(fileExists(dst)).To(BeFalse())
+		})
+
+		It("should refuse names rendering to paths", func() {
+			vars["Name"] = "../escape"
+			Expect(ScaffoldFromTemplateDir(templateRoot, dst, vars)).To(MatchError(ErrInvalidName))
+			Expect(fileExists(filepath.Join(tempDir, "out", "escape"))).To(BeFalse())
+		})
+
+		It("should refuse a destination that is not empty unless overwriting", func() {
+			createTestDir(dst)
+			createTestFile(filepath.Join(dst, "LICENSE"), "old")
+			Expect(ScaffoldFromTemplateDir(templateRoot, dst, vars)).To(MatchError(ErrDirectoryNotEmpty))
+			Expect(readFileContent(filepath.Join(dst, "LICENSE"))).To(Equal("old"))
+
+			Expect(ScaffoldFromTemplateDirWithOptions(templateRoot, dst, vars, ScaffoldOptions{Overwrite: true})).To(Succeed())
+			Expect(readFileContent(filepath.Join(dst, "LICENSE"))).To(Equal("MIT {{.Name}}"))
+		})
+
+		It("should support a custom suffix and delimiters", func() {
+			root := filepath.Join(tempDir, "templates", "custom")
+			createTestDir(root)
+			createTestFile(filepath.Join(root, "<%.Name%>.txt.tpl"), "hello <%.Name%> {{kept}}")
+			err := ScaffoldFromTemplateDirWithOptions(root, dst, vars, ScaffoldOptions{
+				TemplateSuffix: ".tpl",
+				LeftDelim:      "<%",
+				RightDelim:     "%>",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(readFileContent(filepath.Join(dst, "billing.txt"))).To(Equal("hello billing {{kept}}"))
+		})
+	})
+
+	Describe("SkipUnchanged", func() {
+		var srcDir, dstDir string
+		var old time.Time
+		opts := func(skipped *[]string) CopyOptions {
+			return CopyOptions{
+				SkipUnchanged: true,
+				Templates:     TemplateOptions{Patterns: []string{"*.tmpl"}, TrimSuffix: ".tmpl", Vars: map[string]any{"Version": "1.0"}},
+				OnSkip: func(path string, reason error) {
+					if errors.Is(reason, ErrUnchanged) {
+						*skipped = append(*skipped, filepath.Base(path))
+					}
+				},
+			}
+		}
+		BeforeEach(func() {
+			srcDir = filepath.Join(tempDir, "site")
+			dstDir = filepath.Join(tempDir, "public")
+			createTestDir(srcDir)
+			createTestFile(filepath.Join(srcDir, "index.html.tmpl"), "v{{.Version}}")
+			createTestFile(filepath.Join(srcDir, "style.css"), "body {}")
+			createTestFile(filepath.Join(srcDir, "app.js"), "run()")
+			Expect(CopyDirWithOptions(srcDir, dstDir, opts(new([]string)))).To(Succeed())
+
+			old = time.Now().Add(-time.Hour).Truncate(time.Second)
+			for _, name := range []string{"index.html", "style.css", "app.js"} {
+				Expect(os.Chtimes(filepath.Join(dstDir, name), old, old)).To(Succeed())
+			}
+		})
+
+		modTime := func(name string) time.Time {
+			info, err := os.Stat(filepath.Join(dstDir, name))
+			Expect(err).NotTo(HaveOccurred())
+			return info.ModTime()
+		}
+
+		It("should leave identical rendered and copied files untouched", func() {
+			var skipped []string
+			Expect(CopyDirWithOptions(srcDir, dstDir, opts(&skipped))).To(Succeed())
+			Expect(skipped).To(ConsistOf("index.html.tmpl", "style.css", "app.js"))
+			Expect(modTime("index.html")).To(Equal(old))
+			Expect(modTime("style.css")).To(Equal(old))
+		})
+
+		It("should only rewrite files whose output changed", func() {
+			createTestFile(filepath.Join(srcDir, "style.css"), "body { margin: 0 }")
+			createTestFile(filepath.Join(srcDir, "app.js"), "run")
+			Expect(os.Chmod(filepath.Join(dstDir, "app.js"), 0600)).To(Succeed())
+
+			var skipped []string
+			Expect(CopyDirWithOptions(srcDir, dstDir, opts(&skipped))).To(Succeed())
+			Expect(skipped).To(ConsistOf("index.html.tmpl"))
+			Expect(modTime("index.html")).To(Equal(old))
+
+			Expect(readFileContent(filepath.Join(dstDir, "style.css"))).To(Equal("body { margin: 0 }"))
+			Expect(modTime("style.css")).NotTo(Equal(old))
+			Expect(readFileContent(filepath.Join(dstDir, "app.js"))).To(Equal("run"))
+			info, err := os.Stat(filepath.Join(dstDir, "app.js"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+
+			leftovers, err := filepath.Glob(filepath.Join(dstDir, ".*gstorage-tmp"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(leftovers).To(BeEmpty())
+		})
+
+		It("should rewrite a destination that is longer than the output", func() {
+			createTestFile(filepath.Join(dstDir, "app.js"), "run()\nextra")
+			Expect(CopyDirWithOptions(srcDir, dstDir, opts(new([]string)))).To(Succeed())
+			Expect(readFileContent(filepath.Join(dstDir, "app.js"))).To(Equal("run()"))
+		})
+
+		It("should leave the destination alone when it runs out of space", func() {
+			createTestFile(filepath.Join(srcDir, "app.js"), "run(fast)")
+			full := Transform{Name: "full", Apply: func(path string, r io.Reader) (io.Reader, error) {
+				return iotest.ErrReader(syscall.ENOSPC), nil
+			}}
+			calls := 0
+			err := CopyFileWithOptions(filepath.Join(srcDir, "app.js"), filepath.Join(dstDir, "app.js"), CopyOptions{
+				SkipUnchanged:  true,
+				Transform:      []Transform{full},
+				NoSpaceRetries: 2,
+				OnNoSpace: func(dst string) error {
+					calls++
+					return nil
+				},
+			})
+			Expect(err).To(MatchError(syscall.ENOSPC))
+			Expect(readFileContent(filepath.Join(dstDir, "app.js"))).To(Equal("run()"))
+			Expect(modTime("app.js")).To(Equal(old))
+			if runtime.GOOS != "windows" {
+				Expect(calls).To(Equal(2))
+			}
+
+			leftovers, err := filepath.Glob(filepath.Join(dstDir, ".*gstorage-tmp"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(leftovers).To(BeEmpty())
+		})
+
+		It("should keep the identical prefix of large files it rewrites", func() {
+			prefix := strings.Repeat("0123456789abcdef", 16*1024)
+			createTestFile(filepath.Join(dstDir, "app.js"), prefix+"old tail")
+			createTestFile(filepath.Join(srcDir, "app.js"), prefix+"new tail")
+			Expect(CopyDirWithOptions(srcDir, dstDir, opts(new([]string)))).To(Succeed())
+			Expect(readFileContent(filepath.Join(dstDir, "app.js"))).To(Equal(prefix + "new tail"))
+		})
+
+		It("should keep mtimes when a scaffold is re-run", func() {
+			root := filepath.Join(tempDir, "templates")
+			out := filepath.Join(tempDir, "generated")
+			createTestDir(root)
+			createTestFile(filepath.Join(root, "config.yaml.tmpl"), "name: {{.Name}}\n")
+			createTestFile(filepath.Join(root, "notes.txt.tmpl"), "{{.Note}}\n")
+			vars := map[string]any{"Name": "api", "Note": "first"}
+			Expect(ScaffoldFromTemplateDir(root, out, vars)).To(Succeed())
+			for _, name := range []string{"config.yaml", "notes.txt"} {
+				Expect(os.Chtimes(filepath.Join(out, name), old, old)).To(Succeed())
+			}
+
+			vars["Note"] = "second"
+			Expect(ScaffoldFromTemplateDirWithOptions(root, out, vars, ScaffoldOptions{Overwrite: true, SkipUnchanged: true})).To(Succeed())
+			info, err := os.Stat(filepath.Join(out, "config.yaml"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.ModTime()).To(Equal(old))
+			Expect(readFileContent(filepath.Join(out, "notes.txt"))).To(Equal("second\n"))
+		})
+	})
+
+	Describe("RetryPolicy", func() {
+		var srcDir, dstDir string
+		var mu sync.Mutex
+		var attempts map[string]int
+
+		// flaky fails the first failures reads of every file with err
+		flaky := func(failures int, err error) Transform {
+			return Transform{Name: "flaky", Apply: func(path string, r io.Reader) (io.Reader, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				attempts[filepath.Base(path)]++
+				if attempts[filepath.Base(path)] <= failures {
+					return nil, &fs.PathError{Op: "read", Path: path, Err: err}
+				}
+				return r, nil
+			}}
+		}
+
+		BeforeEach(func() {
+			attempts = make(map[string]int)
+			srcDir = filepath.Join(tempDir, "nfs")
+			dstDir = filepath.Join(tempDir, "local")
+			createTestDir(filepath.Join(srcDir, "sub"))
+			createTestFile(filepath.Join(srcDir, "a.txt"), "alpha")
+			createTestFile(filepath.Join(srcDir, "sub", "b.txt"), "beta")
+		})
+
+		It("should retry transient errors with exponential backoff", func() {
+			var retries []string
+			policy := &RetryPolicy{
+				MaxAttempts:    4,
+				InitialBackoff: time.Millisecond,
+				OnRetry: func(path string, attempt int, err error, wait time.Duration) {
+					Expect(err).To(MatchError(syscall.ESTALE))
+					retries = append(retries, fmt.Sprintf("%s:%d:%s", filepath.Base(path), attempt, wait))
+				},
+			}
+			err := CopyFileWithOptions(filepath.Join(srcDir, "a.txt"), filepath.Join(tempDir, "a.txt"), CopyOptions{
+				Retry:     policy,
+				Transform: []Transform{flaky(2, syscall.ESTALE)},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(readFileContent(filepath.Join(tempDir, "a.txt"))).To(Equal("alpha"))
+			Expect(retries).To(Equal([]string{"a.txt:2:1ms", "a.txt:3:2ms"}))
+		})
+
+		It("should cap the backoff", func() {
+			var waits []time.Duration
+			policy := &RetryPolicy{
+				MaxAttempts:    5,
+				InitialBackoff: time.Millisecond,
+				MaxBackoff:     3 * time.Millisecond,
+				Multiplier:     4,
+				OnRetry: func(path string, attempt int, err error, wait time.Duration) {
+					waits = append(waits, wait)
+				},
+			}
+			err := CopyFileWithOptions(filepath.Join(srcDir, "a.txt"), filepath.Join(tempDir, "a.txt"), CopyOptions{
+				Retry:     policy,
+				Transform: []Transform{flaky(10, syscall.EIO)},
+			})
+			Expect(err).To(MatchError(syscall.EIO))
+			Expect(attempts["a.txt"]).To(Equal(5))
+			Expect(waits).To(Equal([]time.Duration{time.Millisecond, 3 * time.Millisecond, 3 * time.Millisecond, 3 * time.Millisecond}))
+		})
+
+		It("should not retry permanent errors", func() {
+			err := CopyFileWithOptions(filepath.Join(srcDir, "a.txt"), filepath.Join(tempDir, "a.txt"), CopyOptions{
+				Retry:     &RetryPolicy{MaxAttempts: 3},
+				Transform: []Transform{flaky(1, fs.ErrPermission)},
+			})
+			Expect(err).To(MatchError(fs.ErrPermission))
+			Expect(attempts["a.txt"]).To(Equal(1))
+		})
+
+		It("should use a custom classifier", func() {
+			err := CopyFileWithOptions(filepath.Join(srcDir, "a.txt"), filepath.Join(tempDir, "a.txt"), CopyOptions{
+				Retry: &RetryPolicy{
+					MaxAttempts: 3,
+					Retryable:   func(err error) bool { return errors.Is(err, fs.ErrPermission) },
+				},
+				Transform: []Transform{flaky(1, fs.ErrPermission)},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(attempts["a.txt"]).To(Equal(2))
+		})
+
+		It("should stop waiting when the context is cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(20*time.Millisecond, cancel)
+			err := CopyDirWithOptionsCtx(ctx, srcDir, dstDir, CopyOptions{
+				Retry:     &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Hour},
+				Transform: []Transform{flaky(1, syscall.EIO)},
+			})
+			Expect(err).To(MatchError(context.Canceled))
+		})
+
+		copiers := []struct {
+			name string
+			copy func(src, dst string, policy *RetryPolicy, transform Transform) error
+		}{
+			{"CopyDirWithOptions", func(src, dst string, policy *RetryPolicy, transform Transform) error {
+				return CopyDirWithOptions(src, dst, CopyOptions{Retry: policy, Transform: []Transform{transform}})
+			}},
+			{"WorkerPoolCopyDirWithOptions", func(src, dst string, policy *RetryPolicy, transform Transform) error {
+				return WorkerPoolCopyDirWithOptions(src, dst, PoolOptions{Workers: 2, Retry: policy, Transform: []Transform{transform}})
+			}},
+			{"SyncDir", func(src, dst string, policy *RetryPolicy, transform Transform) error {
+				_, err := SyncDir(src, dst, SyncOptions{Copy: CopyOptions{Retry: policy, Transform: []Transform{transform}}})
+				return err
+			}},
+		}
+
+		for _, copier := range copiers {
+			Context("with "+copier.name, func() {
+				It("should retry each file on its own", func() {
+					Expect(copier.copy(srcDir, dstDir, &RetryPolicy{MaxAttempts: 3}, flaky(2, syscall.EIO))).To(Succeed())
+					Expect(readFileContent(filepath.Join(dstDir, "a.txt"))).To(Equal("alpha"))
+					Expect(readFileContent(filepath.Join(dstDir, "sub", "b.txt"))).To(Equal("beta"))
+					Expect(attempts).To(Equal(map[string]int{"a.txt": 3, "b.txt": 3}))
+				})
+
+				It("should make a single attempt without a policy", func() {
+					Expect(copier.copy(srcDir, dstDir, nil, flaky(1, syscall.EIO))).To(MatchError(syscall.EIO))
+					for _, n := range attempts {
+						Expect(n).To(Equal(1))
+					}
+				})
+			})
+		}
+
+		DescribeTable("IsTransientError",
+			func(err error, transient bool) {
+				if runtime.GOOS == "windows" {
+					Skip("errno classification differs on Windows")
+				}
+				Expect(IsTransientError(err)).To(Equal(transient))
+			},
+			Entry("EIO", syscall.EIO, true),
+			Entry("wrapped ESTALE", &fs.PathError{Op: "open", Path: "x", Err: syscall.ESTALE}, true),
+			Entry("ETIMEDOUT", syscall.ETIMEDOUT, true),
+			Entry("deadline", os.ErrDeadlineExceeded, true),
+			Entry("not found", fs.ErrNotExist, false),
+			Entry("permission", &fs.PathError{Op: "open", Path: "x", Err: syscall.EACCES}, false),
+			Entry("cancelled context", context.Canceled, false),
+			Entry("nil", nil, false),
+		)
+	})
+
+	Describe("Compression", func() {
+		var srcFile string
+		var content []byte
+		BeforeEach(func() {
+			// Over 4MiB, so LZ4 writes more than one block, and compressible
+			var buf bytes.Buffer
+			for i := 0; buf.Len() < 4<<20+1000; i++ {
+				fmt.Fprintf(&buf, "line %d: %s\n", i, strings.Repeat("ab", i%37))
+			}
+			content = buf.Bytes()
+			srcFile = filepath.Join(tempDir, "data.txt")
+			Expect(os.WriteFile(srcFile, content, 0644)).To(Succeed())
+		})
+
+		DescribeTable("should round-trip through DecompressFile",
+			func(opts CompressOptions) {
+				packed := filepath.Join(tempDir, "data.packed")
+				Expect(CompressFileWithOptions(srcFile, packed, opts)).To(Succeed())
+				info, err := os.Stat(packed)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(info.Size()).To(BeNumerically("<", len(content)/2))
+
+				out := filepath.Join(tempDir, "data.out")
+				Expect(DecompressFile(packed, out)).To(Succeed())
+				Expect(os.ReadFile(out)).To(Equal(content))
+			},
+			Entry("gzip", CompressOptions{Codec: CodecGzip}),
+			Entry("gzip at best speed", CompressOptions{Codec: CodecGzip, Level: 1}),
+			Entry("gzip at best compression", CompressOptions{Codec: CodecGzip, Level: 9}),
+			Entry("lz4", CompressOptions{Codec: CodecLZ4}),
+			Entry("lz4 at high compression", CompressOptions{Codec: CodecLZ4, Level: 9}),
+			Entry("zstd", CompressOptions{Codec: CodecZstd}),
+			Entry("zstd at best speed", CompressOptions{Codec: CodecZstd, Level: 1}),
+			Entry("zstd at best compression", CompressOptions{Codec: CodecZstd, Level: 19}),
+		)
+
+		It("should handle empty files", func() {
+			empty := filepath.Join(tempDir, "empty")
+			createTestFile(empty, "")
+			for _, codec := range []Codec{CodecGzip, CodecLZ4, CodecZstd} {
+				packed := filepath.Join(tempDir, "empty."+codec.String())
+				Expect(CompressFile(empty, packed, codec)).To(Succeed())
+				Expect(DecompressFile(packed, filepath.Join(tempDir, "empty.out"))).To(Succeed())
+				Expect(readFileContent(filepath.Join(tempDir, "empty.out"))).To(BeEmpty())
+			}
+		})
+
+		It("should write standard gzip", func() {
+			packed := filepath.Join(tempDir, "data.gz")
+			Expect(CompressFile(srcFile, packed, CodecGzip)).To(Succeed())
+			f, err := os.Open(packed)
+			Expect(err).NotTo(HaveOccurred())
+			defer f.Close()
+			gz, err := gzip.NewReader(f)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(io.ReadAll(gz)).To(Equal(content))
+		})
+
+		It("should reject invalid levels", func() {
+			for _, codec := range []Codec{CodecGzip, CodecLZ4, CodecZstd} {
+				packed := filepath.Join(tempDir, "data."+codec.String())
+				err := CompressFileWithOptions(srcFile, packed, CompressOptions{Codec: codec, Level: 42})
+				Expect(err).To(HaveOccurred(), codec.String())
+				Expect(fileExists(packed)).To(BeFalse())
+			}
+		})
+
+		It("should refuse content it cannot detect", func() {
+			out := filepath.Join(tempDir, "data.out")
+			Expect(DecompressFile(srcFile, out)).To(MatchError(ErrUnknownCodec))
+			Expect(fileExists(out)).To(BeFalse())
+		})
+
+		It("should detect corrupt frames", func() {
+			for _, codec := range []Codec{CodecLZ4, CodecZstd} {
+				packed := filepath.Join(tempDir, "data."+codec.String())
+				Expect(CompressFile(srcFile, packed, codec)).To(Succeed())
+				data, err := os.ReadFile(packed)
+				Expect(err).NotTo(HaveOccurred())
+				data[len(data)/2] ^= 0xff
+				Expect(os.WriteFile(packed, data, 0644)).To(Succeed())
+
+				out := filepath.Join(tempDir, "data.out")
+				Expect(DecompressFile(packed, out)).NotTo(Succeed(), codec.String())
+				Expect(fileExists(out)).To(BeFalse())
+			}
+		})
+
+		DescribeTable("should decode frames written by the lz4 tool",
+			func(frame []byte, expected []byte) {
+				packed := filepath.Join(tempDir, "reference.lz4")
+				Expect(os.WriteFile(packed, frame, 0644)).To(Succeed())
+				out := filepath.Join(tempDir, "reference.out")
+				Expect(DecompressFile(packed, out)).To(Succeed())
+				Expect(os.ReadFile(out)).To(Equal(expected))
+			},
+			Entry("with default options", lz4Frame("default"), lz4ReferenceContent()),
+			Entry("with linked blocks", lz4Frame("linked"), lz4ReferenceContent()),
+			Entry("with block checksums and content size", lz4Frame("blockcrc"), lz4ReferenceContent()),
+			Entry("at high compression", lz4Frame("hc"), lz4ReferenceContent()),
+			// lz4 stores input it cannot shrink as an uncompressed block
+			Entry("with a stored block",
+				[]byte{0x04, 0x22, 0x4d, 0x18, 0x64, 0x40, 0xa7, 0x0a, 0x00, 0x00, 0x80, 'h', 'e', 'l', 'l', 'o', ',', ' ', 'l', 'z', '4',
+					0x00, 0x00, 0x00, 0x00, 0xc9, 0x7f, 0xa0, 0xcb},
+				[]byte("hello, lz4")),
+			Entry("of empty input",
+				[]byte{0x04, 0x22, 0x4d, 0x18, 0x64, 0x40, 0xa7, 0x00, 0x00, 0x00, 0x00, 0x05, 0x5d, 0xcc, 0x02},
+				[]byte{}),
+			Entry("concatenated around a skippable frame",
+				slices.Concat(lz4Frame("linked"), []byte{0x50, 0x2a, 0x4d, 0x18, 0x03, 0x00, 0x00, 0x00, 'x', 'y', 'z'}, lz4Frame("hc")),
+				slices.Concat(lz4ReferenceContent(), lz4ReferenceContent())),
+		)
+
+		It("should detect corrupt reference frames", func() {
+			for _, name := range []string{"default", "linked", "blockcrc", "hc"} {
+				frame := lz4Frame(name)
+				for _, at := range []int{5, len(frame) / 3, len(frame) / 2, len(frame) - 5} {
+					corrupt := bytes.Clone(frame)
+					corrupt[at] ^= 0x5a
+					packed := filepath.Join(tempDir, "corrupt.lz4")
+					Expect(os.WriteFile(packed, corrupt, 0644)).To(Succeed())
+					out := filepath.Join(tempDir, "corrupt.out")
+					Expect(DecompressFile(packed, out)).NotTo(Succeed(), "%s corrupted at %d", name, at)
+					Expect(fileExists(out)).To(BeFalse())
+				}
+			}
+		})
+
+		DescribeTable("should write frames the reference tools read",
+			func(codec Codec, name string) {
+				tool, err := exec.LookPath(name)
+				if err != nil {
+					Skip(name + " tool not installed")
+				}
+				packed := filepath.Join(tempDir, "data."+name)
+				Expect(CompressFile(srcFile, packed, codec)).To(Succeed())
+				decoded, err := exec.Command(tool, "-d", "-c", packed).Output()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(decoded).To(Equal(content))
+			},
+			Entry("lz4", CodecLZ4, "lz4"),
+			Entry("zstd", CodecZstd, "zstd"),
+		)
+
+		It("should decode frames written by the zstd tool", func() {
+			tool, err := exec.LookPath("zstd")
+			if err != nil {
+				Skip("zstd tool not installed")
+			}
+			packed := filepath.Join(tempDir, "data.zst")
+			Expect(exec.Command(tool, "-q", "-19", "-o", packed, srcFile).Run()).To(Succeed())
+			out := filepath.Join(tempDir, "data.out")
+			Expect(DecompressFile(packed, out)).To(Succeed())
+			Expect(os.ReadFile(out)).To(Equal(content))
+		})
+	})
+
+	Describe("CalculateFileMD5", func() {
+		It("should calculate correct MD5 hash", func() {
+			testFile := filepath.Join(tempDir, "hashfile.txt")
+			content := "Hello, World!"
+			createTestFile(testFile, content)
+
+			expectedHash := fmt.Sprintf("%x", md5.Sum([]byte(content)))
+
+			hash, err := CalculateFileMD5(testFile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hash).To(Equal(expectedHash))
+		})
+		It("should handle empty files", func() {
+			testFile := filepath.Join(tempDir, "emptyhashfile.txt")
+			createTestFile(testFile, "")
+
+			expectedHash := fmt.Sprintf("%x", md5.Sum([]byte("")))
+
+			hash, err := CalculateFileMD5(testFile)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hash).To(Equal(expectedHash))
+		})
+	})
+	Describe("CalculateFileHash", func() {
+		var testFile string
+		BeforeEach(func() {
+			testFile = filepath.Join(tempDir, "hashfile.txt")
+			createTestFile(testFile, "hello, world")
+		})
+
+		DescribeTable("should match reference digests",
+			func(algo HashAlgorithm, want string) {
+				hash, err := CalculateFileHash(testFile, algo)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(hash).To(Equal(want))
+			},
+			Entry("MD5", HashMD5, fmt.Sprintf("%x", md5.Sum([]byte("hello, world")))),
+			Entry("SHA-1", HashSHA1, "b7e23ec29af22b0b4e41da31e868d57226121c84"),
+			Entry("SHA-256", HashSHA256, "09ca7e4eaa6e8ae9c7d261167129184883644d07dfba7cbfbc4c8a2e08360d5b"),
+			Entry("SHA-512", HashSHA512, "8710339dcb6814d0d9d2290ef422285c9322b7163951f9a0ca8f883d3305286f44139aa374848e4174f5aada663027e4548637b6d19894aec4fb6c46a139fbf9"),
+			Entry("CRC32", HashCRC32, "ffab723a"),
+			Entry("xxHash64", HashXXH64, "b33a384e6d1b1242"),
+		)
+
+		It("should hash inputs longer than an xxHash64 stripe", func() {
+			createTestFile(testFile, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789$")
+			Expect(CalculateFileHash(testFile, HashXXH64)).To(Equal("1032d841e824f998"))
+		})
+
+		It("should verify expected digests", func() {
+			Expect(VerifyFileHash(testFile, HashCRC32, "FFAB723A")).To(Succeed())
+			Expect(VerifyFileHash(testFile, HashCRC32, "00000000")).To(MatchError(ErrHashMismatch))
+		})
+
+		It("should reject unknown algorithms", func() {
+			_, err := CalculateFileHash(testFile, HashAlgorithm(99))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Describe("Stored hashes", func() {
+		content := "Hello, World!"
+		expectedHash := fmt.Sprintf("%x", md5.Sum([]byte(content)))
+
+		for _, store := range []HashStore{HashStoreXattr, HashStoreSidecar} {
+			Context(fmt.Sprintf("with hash store %d", store), func() {
+				BeforeEach(func() {
+					if store == HashStoreXattr {
+						probe := filepath.Join(tempDir, "probe")
+						createTestFile(probe, "")
+						if err := StoreFileMD5(probe, "x", store); errors.Is(err, ErrXattrUnsupported) {
+							Skip("extended attributes not supported here")
+						}
+					}
+				})
+
+				It("should record the hash when writing", func() {
+					testFile := filepath.Join(tempDir, "written.txt")
+					Expect(WriteFileWithOptions(testFile, []byte(content), WriteOptions{StoreHash: store})).To(Succeed())
+
+					hash, ok, err := StoredFileMD5(testFile, store)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(ok).To(BeTrue())
+					Expect(hash).To(Equal(expectedHash))
+				})
+
+				It("should record the hash when copying", func() {
+					src := filepath.Join(tempDir, "src.txt")
+					dst := filepath.Join(tempDir, "dst.txt")
+					createTestFile(src, content)
+
+					Expect(CopyFileWithOptions(src, dst, CopyOptions{StoreHash: store, VerifySize: true})).To(Succeed())
+
+					hash, ok, err := StoredFileMD5(dst, store)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(ok).To(BeTrue())
+					Expect(hash).To(Equal(expectedHash))
+				})
+
+				It("should stop trusting the hash once the file changes", func() {
+					testFile := filepath.Join(tempDir, "changed.txt")
+					Expect(WriteFileWithOptions(testFile, []byte(content), WriteOptions{StoreHash: store})).To(Succeed())
+					createTestFile(testFile, "something else entirely")
+
+					_, ok, err := StoredFileMD5(testFile, store)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(ok).To(BeFalse())
+
+					hash, err := CachedFileMD5(testFile, store)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(hash).To(Equal(fmt.Sprintf("%x", md5.Sum([]byte("something else entirely")))))
+
+					_, ok, err = StoredFileMD5(testFile, store)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(ok).To(BeTrue())
+				})
+			})
+		}
+	})
+	Describe("Tags", func() {
+		var testFile string
+		BeforeEach(func() {
+			testFile = filepath.Join(tempDir, "invoice.pdf")
+			createTestFile(testFile, "content")
+		})
+
+		It("should start with no tags", func() {
+			tags, err := GetTags(testFile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tags).To(BeEmpty())
+		})
+
+		It("should set, replace and remove tags", func() {
+			Expect(SetTag(testFile, "customer", "acme")).To(Succeed())
+			Expect(SetTag(testFile, "year", "2023")).To(Succeed())
+			Expect(SetTag(testFile, "year", "2024")).To(Succeed())
+
+			tags, err := GetTags(testFile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tags).To(Equal(map[string]string{"customer": "acme", "year": "2024"}))
+
+			Expect(RemoveTag(testFile, "customer")).To(Succeed())
+			Expect(RemoveTag(testFile, "missing")).To(Succeed())
+			tags, err = GetTags(testFile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tags).To(Equal(map[string]string{"year": "2024"}))
+		})
+
+		It("should reject empty keys and missing files", func() {
+			Expect(SetTag(testFile, "", "x")).To(MatchError(ErrInvalidTag))
+			_, err := GetTags(filepath.Join(tempDir, "missing"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+
+		It("should find files by tag", func() {
+			other := filepath.Join(tempDir, "receipt.pdf")
+			createTestFile(other, "content")
+			Expect(SetTag(testFile, "year", "2023")).To(Succeed())
+			Expect(SetTag(other, "year", "2024")).To(Succeed())
+
+			found, err := FindByTag(tempDir, "year", "2023")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(found).To(Equal([]string{testFile}))
+
+			found, err = FindByTag(tempDir, "year", "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(found).To(ConsistOf(testFile, other))
+		})
+	})
+	Describe("Concurrency & Progress operations", func() {
+		Describe("CopyFileWithProgress", func() {
+			It("should copy file and report progress", func() {
+				srcFile := filepath.Join(tempDir, "progress_src.txt")
+				dstFile := filepath.Join(tempDir, "progress_dst.txt")
+				bytesToWrite := 1000
+
+				content := strings.Repeat("A", bytesToWrite) //1000 bytes
+				createTestFile(srcFile, content)
+
+				progressChan, err := CopyFileWithProgress(srcFile, dstFile, 100)
+				Expect(err).NotTo(HaveOccurred())
+
+				var totalBytes int64
+				var progressUpdates []int64
+
+				for bytes := range progressChan {
+					totalBytes += bytes
+					progressUpdates = append(progressUpdates, bytes)
+				}
+				// Verify copy complete
+				Expect(FileExists(dstFile)).To(BeTrue())
+				Expect(readFileContent(dstFile)).To(Equal(content))
+
+				//Verify progress updates
+				Expect(len(progressUpdates)).To(BeNumerically(">", 0))
+				Expect(totalBytes).To(Equal(int64(bytesToWrite)))
+			})
+
+			It("should pick a chunk size automatically when chunkSize is 0", func() {
+				srcFile := filepath.Join(tempDir, "auto_src.txt")
+				dstFile := filepath.Join(tempDir, "auto_dst.txt")
+				content := strings.Repeat("B", 10*1024*1024) // 10MB
+				createTestFile(srcFile, content)
+
+				progressChan, err := CopyFileWithProgress(srcFile, dstFile, 0)
+				Expect(err).NotTo(HaveOccurred())
+
+				var totalBytes int64
+				updates := 0
+				for bytes := range progressChan {
+					totalBytes += bytes
+					updates++
+				}
+				Expect(totalBytes).To(Equal(int64(len(content))))
+				Expect(updates).To(BeNumerically(">", 1))
+				Expect(updates).To(BeNumerically("<", 128))
+			})
+
+			It("should reject a negative chunk size without creating the destination", func() {
+				srcFile := filepath.Join(tempDir, "neg_src.txt")
+				dstFile := filepath.Join(tempDir, "neg_dst.txt")
+				createTestFile(srcFile, "content")
+
+				progressChan, err := CopyFileWithProgress(srcFile, dstFile, -1)
+				Expect(err).To(MatchError(ErrInvalidChunkSize))
+				Expect(progressChan).To(BeNil())
+				Expect(fileExists(dstFile)).To(BeFalse())
+			})
+		})
+		Describe("CopyFileWithCallback", func() {
+			It("should copy file and report cumulative progress", func() {
+				srcFile := filepath.Join(tempDir, "callback_src.txt")
+				dstFile := filepath.Join(tempDir, "callback_dst.txt")
+				content := strings.Repeat("C", 5*1024*1024) // 5MB
+				createTestFile(srcFile, content)
+
+				var calls []int64
+				err := CopyFileWithCallback(srcFile, dstFile, func(copied, total int64) {
+					Expect(total).To(Equal(int64(len(content))))
+					calls = append(calls, copied)
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(readFileContent(dstFile)).To(Equal(content))
+
+				Expect(len(calls)).To(BeNumerically(">", 1))
+				for i := 1; i < len(calls); i++ {
+					Expect(calls[i]).To(BeNumerically(">", calls[i-1]))
+				}
+				Expect(calls[len(calls)-1]).To(Equal(int64(len(content))))
+			})
+
+			It("should return an error when the source does not exist", func() {
+				err := CopyFileWithCallback(filepath.Join(tempDir, "nonexistent.txt"), filepath.Join(tempDir, "dst.txt"), func(copied, total int64) {
+					Fail("callback should not be called")
+				})
+				Expect(err).To(HaveOccurred())
+				Expect(os.IsNotExist(err)).To(BeTrue())
+			})
+		})
+		Describe("Progress reporting", func() {
+			var srcDir string
+			var totalBytes int64
+			BeforeEach(func() {
+				srcDir = filepath.Join(tempDir, "progress_tree")
+				createTestDir(filepath.Join(srcDir, "nested"))
+				totalBytes = 0
+				for i := 0; i < 8; i++ {
+					content := strings.Repeat("P", 1000*(i+1))
+					createTestFile(filepath.Join(srcDir, fmt.Sprintf("f%d", i)), content)
+					totalBytes += int64(len(content))
+				}
+				createTestFile(filepath.Join(srcDir, "nested", "inner"), "inner")
+				totalBytes += int64(len("inner"))
+			})
+
+			// collect returns a reporter recording every event and a function
+			// returning them
+			collect := func() (ProgressReporter, func() []Progress) {
+				var mu sync.Mutex
+				var events []Progress
+				return ProgressFunc(func(p Progress) {
+						mu.Lock()
+						defer mu.Unlock()
+						events = append(events, p)
+					}), func() []Progress {
+						mu.Lock()
+						defer mu.Unlock()
+						return events
+					}
+			}
+
+			expectComplete := func(events []Progress) {
+				Expect(events).NotTo(BeEmpty())
+				for i, p := range events {
+					Expect(p.BytesCopied).To(BeNumerically("<=", totalBytes))
+					Expect(p.Done).To(Equal(i == len(events)-1))
+				}
+				last := events[len(events)-1]
+				Expect(last.BytesCopied).To(Equal(totalBytes))
+				Expect(last.TotalBytes).To(Equal(totalBytes))
+				Expect(last.FilesCopied).To(Equal(int64(9)))
+				Expect(last.TotalFiles).To(Equal(int64(9)))
+				Expect(last.Percent).To(Equal(100.0))
+				Expect(last.ETA).To(BeZero())
+			}
+
+			It("should report aggregate progress from CopyDirWithOptions", func() {
+				reporter, events := collect()
+				Expect(CopyDirWithOptions(srcDir, filepath.Join(tempDir, "dir_copy"), CopyOptions{Progress: reporter})).To(Succeed())
+				expectComplete(events())
+			})
+
+			It("should report aggregate progress from WorkerPoolCopyDirWithOptions", func() {
+				reporter, events := collect()
+				err := WorkerPoolCopyDirWithOptions(srcDir, filepath.Join(tempDir, "pool_copy"), PoolOptions{Workers: 4, Progress: reporter})
+				Expect(err).NotTo(HaveOccurred())
+				expectComplete(events())
+			})
+
+			It("should only count the files the copy takes in the totals", func() {
+				keep := func(path string, d fs.DirEntry) bool { return d.Name() != "f7" }
+				copies := map[string]func(ProgressReporter) error{
+					"CopyDir": func(r ProgressReporter) error {
+						return CopyDirWithOptions(srcDir, filepath.Join(tempDir, "dir_copy"), CopyOptions{
+							Progress: r, Exclude: []string{"nested"}, Filter: keep,
+						})
+					},
+					"WorkerPoolCopyDir": func(r ProgressReporter) error {
+						return WorkerPoolCopyDirWithOptions(srcDir, filepath.Join(tempDir, "pool_copy"), PoolOptions{
+							Workers: 4, Progress: r, Exclude: []string{"nested"}, Filter: keep,
+						})
+					},
+				}
+				for name, copyDir := range copies {
+					reporter, events := collect()
+					Expect(copyDir(reporter)).To(Succeed(), name)
+					last := events()[len(events())-1]
+					Expect(last.TotalBytes).To(Equal(totalBytes-8000-5), name)
+					Expect(last.BytesCopied).To(Equal(last.TotalBytes), name)
+					Expect(last.TotalFiles).To(Equal(int64(7)), name)
+					Expect(last.FilesCopied).To(Equal(int64(7)), name)
+				}
+			})
+
+			It("should report without totals when SkipProgressTotals is set", func() {
+				reporter, events := collect()
+				err := WorkerPoolCopyDirWithOptions(srcDir, filepath.Join(tempDir, "pool_copy"), PoolOptions{
+					Workers: 4, Progress: reporter, SkipProgressTotals: true,
+				})
+				Expect(err).NotTo(HaveOccurred())
+				for _, p := range events() {
+					Expect(p.TotalBytes).To(BeZero())
+					Expect(p.TotalFiles).To(BeZero())
+				}
+				last := events()[len(events())-1]
+				Expect(last.BytesCopied).To(Equal(totalBytes))
+				Expect(last.FilesCopied).To(Equal(int64(9)))
+				Expect(last.Percent).To(Equal(100.0))
+			})
+
+			It("should report a single file from CopyFileWithOptions", func() {
+				reporter, events := collect()
+				Expect(CopyFileWithOptions(filepath.Join(srcDir, "f7"), filepath.Join(tempDir, "f7"), CopyOptions{Progress: reporter})).To(Succeed())
+				last := events()[len(events())-1]
+				Expect(last.BytesCopied).To(Equal(int64(8000)))
+				Expect(last.TotalBytes).To(Equal(int64(8000)))
+				Expect(last.FilesCopied).To(Equal(int64(1)))
+				Expect(last.Percent).To(Equal(100.0))
+				Expect(last.Done).To(BeTrue())
+			})
+
+			It("should end with a Done event when the copy fails", func() {
+				reporter, events := collect()
+				err := CopyFileWithOptions(filepath.Join(tempDir, "missing"), filepath.Join(tempDir, "out"), CopyOptions{Progress: reporter})
+				Expect(err).To(HaveOccurred())
+				Expect(events()).To(HaveLen(1))
+				Expect(events()[0].Done).To(BeTrue())
+				Expect(events()[0].Percent).To(BeZero())
+			})
+		})
+	})
+	Describe("WorkerPoolCopyDir", func() {
+		var srcDir, dstDir string
+		BeforeEach(func() {
+			srcDir = filepath.Join(tempDir, "pool_src")
+			dstDir = filepath.Join(tempDir, "pool_dst")
+			//create source directory
+			createTestDir(srcDir)
+
+			for i := 0; i < 10; i++ {
+				filename := fmt.Sprintf("file_%d.txt", i)
+				content := fmt.Sprintf("Content of file %d", i)
+				createTestFile(filepath.Join(srcDir, filename), content)
+			}
+			// Create subdirectory
+			subDir := filepath.Join(dstDir, "subdir")
+			createTestDir(subDir)
+			createTestFile(filepath.Join(subDir, "nested.txt"), "nested content")
+		})
+		It("should copy directory using worker pool", func() {
+
+			err := WorkerPoolCopyDir(srcDir, dstDir, 3) // 3 workers
+			Expect(err).NotTo(HaveOccurred())
+
+			entries, err := ListDir(srcDir)
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, entry := range entries {
+				if entry.IsDir() {
+					Expect(FileExists(filepath.Join(dstDir, entry.Name()))).To(BeTrue())
+				} else {
+					//Check file
+					srcPath := filepath.Join(srcDir, entry.Name())
+					dstPath := filepath.Join(dstDir, entry.Name())
+					Expect(FileExists(dstPath)).To(BeTrue())
+					Expect(readFileContent(dstPath)).To(Equal(readFileContent(srcPath)))
+				}
+			}
+
+		})
+		It("should handle errors from workers", func() {
+			// Create a file with restricted permissions to trigger an error
+			restrictedFile := filepath.Join(srcDir, "restricted.txt")
+			createTestFile(restrictedFile, "restricted")
+			err := os.Chmod(restrictedFile, 0000)
+			Expect(err).NotTo(HaveOccurred())
+
+			// Restore permissions after test
+			defer func() {
+				os.Chmod(restrictedFile, 0644)
+			}()
+
+			err = WorkerPoolCopyDir(srcDir, dstDir, 2)
+
+			Expect(err).To(HaveOccurred())
+		})
+
+		Context("with ContinueOnError", func() {
+			var src, dst string
+			BeforeEach(func() {
+				src = filepath.Join(tempDir, "coe_src")
+				dst = filepath.Join(tempDir, "coe_dst")
+				createTestDir(filepath.Join(src, "sub"))
+				for _, name := range []string{"a.txt", "b.txt", filepath.Join("sub", "c.txt")} {
+					createTestFile(filepath.Join(src, name), "content")
+				}
+				Expect(os.Symlink("a.txt", filepath.Join(src, "link"))).To(Succeed())
+				// A directory where a file should go makes that copy fail
+				createTestDir(filepath.Join(dst, "b.txt"))
+			})
+
+			It("should copy everything else and report every failure", func() {
+				err := WorkerPoolCopyDirWithOptions(src, dst, PoolOptions{Workers: 2, Symlinks: SymlinkError, ContinueOnError: true})
+
+				var copyErr *CopyError
+				Expect(errors.As(err, &copyErr)).To(BeTrue())
+				Expect(copyErr.Copied).To(Equal(int64(2)))
+				Expect(copyErr.Bytes).To(Equal(int64(14)))
+				paths := []string{}
+				for _, f := range copyErr.Failed {
+					paths = append(paths, f.Path)
+				}
+				Expect(paths).To(ConsistOf(filepath.Join(src, "b.txt"), filepath.Join(src, "link")))
+				Expect(errors.Is(err, ErrSymlink)).To(BeTrue())
+
+				Expect(readFileContent(filepath.Join(dst, "a.txt"))).To(Equal("content"))
+				Expect(readFileContent(filepath.Join(dst, "sub", "c.txt"))).To(Equal("content"))
+			})
+
+			It("should stop at the first error without it", func() {
+				err := WorkerPoolCopyDirWithOptions(src, dst, PoolOptions{Workers: 2, Symlinks: SymlinkError})
+				Expect(err).To(HaveOccurred())
+				var copyErr *CopyError
+				Expect(errors.As(err, &copyErr)).To(BeFalse())
+			})
+
+			It("should return nil when nothing fails", func() {
+				Expect(os.Remove(filepath.Join(dst, "b.txt"))).To(Succeed())
+				Expect(WorkerPoolCopyDirWithOptions(src, dst, PoolOptions{Workers: 2, ContinueOnError: true})).To(Succeed())
+				Expect(readFileContent(filepath.Join(dst, "b.txt"))).To(Equal("content"))
+			})
+		})
+
+		It("should fail when source is not a directory", func() {
+			srcFile := filepath.Join(tempDir, "notadir.txt")
+			createTestFile(srcFile, "content")
+			err := WorkerPoolCopyDir(srcFile, dstDir, 2)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should fail when source does not exist", func() {
+			nonExistentSrc := filepath.Join(tempDir, "nonexistent")
+			err := WorkerPoolCopyDir(nonExistentSrc, dstDir, 2)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should fail when destination is a file", func() {
+			destFile := filepath.Join(tempDir, "destfile.txt")
+			createTestFile(destFile, "existing")
+			err := WorkerPoolCopyDir(srcDir, destFile, 2)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should work with existing destination directory", func() {
+			existingDstDir := filepath.Join(tempDir, "existing_pool_dst")
+			createTestDir(existingDstDir)
+			err := WorkerPoolCopyDir(srcDir, existingDstDir, 3)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fileExists(existingDstDir)).To(BeTrue())
+		})
+
+		It("should work with single worker", func() {
+			err := WorkerPoolCopyDir(srcDir, dstDir, 1)
+			Expect(err).NotTo(HaveOccurred())
+
+			entries, err := ListDir(srcDir)
+			Expect(err).NotTo(HaveOccurred())
+			for _, entry := range entries {
+				if !entry.IsDir() {
+					dstPath := filepath.Join(dstDir, entry.Name())
+					Expect(FileExists(dstPath)).To(BeTrue())
+				}
+			}
+		})
+
+		It("should work with many workers", func() {
+			err := WorkerPoolCopyDir(srcDir, dstDir, 16)
+			Expect(err).NotTo(HaveOccurred())
+
+			entries, err := ListDir(srcDir)
+			Expect(err).NotTo(HaveOccurred())
+			for _, entry := range entries {
+				if !entry.IsDir() {
+					dstPath := filepath.Join(dstDir, entry.Name())
+					Expect(FileExists(dstPath)).To(BeTrue())
+				}
+			}
+		})
+
+		It("should handle deeply nested directories", func() {
+			nestedSrc := filepath.Join(tempDir, "nested_pool_src_v2")
+			nestedDst := filepath.Join(tempDir, "nested_pool_dst_v2")
+			createTestDir(filepath.Join(nestedSrc, "a", "b", "c"))
+			createTestDir(nestedDst) // Pre-create dst
+			createTestFile(filepath.Join(nestedSrc, "a", "file1.txt"), "content1")
+			createTestFile(filepath.Join(nestedSrc, "a", "b", "file2.txt"), "content2")
+			createTestFile(filepath.Join(nestedSrc, "a", "b", "c", "file3.txt"), "content3")
+
+			err := WorkerPoolCopyDir(nestedSrc, nestedDst, 4)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(fileExists(filepath.Join(nestedDst, "a", "file1.txt"))).To(BeTrue())
+			Expect(fileExists(filepath.Join(nestedDst, "a", "b", "file2.txt"))).To(BeTrue())
+			Expect(fileExists(filepath.Join(nestedDst, "a", "b", "c", "file3.txt"))).To(BeTrue())
+		})
+
+		It("should handle many files with worker pool", func() {
+			manySrc := filepath.Join(tempDir, "many_pool_src")
+			manyDst := filepath.Join(tempDir, "many_pool_dst")
+			createTestDir(manySrc)
+			createTestDir(manyDst)
+
+			// Create 100 files
+			for i := 0; i < 100; i++ {
+				createTestFile(filepath.Join(manySrc, fmt.Sprintf("file_%d.txt", i)), fmt.Sprintf("content %d", i))
+			}
+
+			err := WorkerPoolCopyDir(manySrc, manyDst, 8)
+			Expect(err).NotTo(HaveOccurred())
+
+			// Verify a few files
+			Expect(fileExists(filepath.Join(manyDst, "file_0.txt"))).To(BeTrue())
+			Expect(fileExists(filepath.Join(manyDst, "file_50.txt"))).To(BeTrue())
+			Expect(fileExists(filepath.Join(manyDst, "file_99.txt"))).To(BeTrue())
+		})
+
+		It("should rename into place and leave no temp files with TempRename", func() {
+			err := WorkerPoolCopyDirWithOptions(srcDir, dstDir, PoolOptions{Workers: 3, TempRename: true})
+			Expect(err).NotTo(HaveOccurred())
+
+			for i := 0; i < 10; i++ {
+				filename := fmt.Sprintf("file_%d.txt", i)
+				Expect(readFileContent(filepath.Join(dstDir, filename))).To(Equal(fmt.Sprintf("Content of file %d", i)))
+			}
+
+			err = filepath.WalkDir(dstDir, func(path string, d fs.DirEntry, err error) error {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(d.Name()).NotTo(HaveSuffix(".gstorage-tmp"))
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should verify sizes and carry modification times with VerifySize", func() {
+			past := time.Now().Add(-time.Hour).Truncate(time.Second)
+			Expect(os.Chtimes(filepath.Join(srcDir, "file_3.txt"), past, past)).To(Succeed())
+
+			err := WorkerPoolCopyDirWithOptions(srcDir, dstDir, PoolOptions{Workers: 3, TempRename: true, VerifySize: true})
+			Expect(err).NotTo(HaveOccurred())
+
+			info, err := os.Stat(filepath.Join(dstDir, "file_3.txt"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.ModTime()).To(BeTemporally("==", past))
+		})
+
+		It("should copy large and small files through separate lanes", func() {
+			createTestFile(filepath.Join(srcDir, "big.bin"), strings.Repeat("x", 4096))
+
+			err := WorkerPoolCopyDirWithOptions(srcDir, dstDir, PoolOptions{
+				Workers:            2,
+				LargeFileThreshold: 1024,
+				LargeFileWorkers:   1,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(readFileContent(filepath.Join(dstDir, "big.bin"))).To(HaveLen(4096))
+			for i := 0; i < 10; i++ {
+				filename := fmt.Sprintf("file_%d.txt", i)
+				Expect(readFileContent(filepath.Join(dstDir, filename))).To(Equal(fmt.Sprintf("Content of file %d", i)))
+			}
+		})
+
+		It("should copy with a parallel walk", func() {
+			createTestDir(filepath.Join(srcDir, "a", "b", "c"))
+			createTestFile(filepath.Join(srcDir, "a", "b", "c", "deep.txt"), "deep")
+
+			err := WorkerPoolCopyDirWithOptions(srcDir, dstDir, PoolOptions{Workers: 3, WalkWorkers: 4})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(readFileContent(filepath.Join(dstDir, "a", "b", "c", "deep.txt"))).To(Equal("deep"))
+			Expect(readFileContent(filepath.Join(dstDir, "file_0.txt"))).To(Equal("Content of file 0"))
+		})
+
+		It("should treat zero workers as one", func() {
+			Expect(WorkerPoolCopyDirWithOptions(srcDir, dstDir, PoolOptions{})).To(Succeed())
+			Expect(readFileContent(filepath.Join(dstDir, "file_9.txt"))).To(Equal("Content of file 9"))
+		})
+	})
+	Describe("ParallelWalkDir", func() {
+		var root string
+		BeforeEach(func() {
+			root = filepath.Join(tempDir, "walk")
+			for i := 0; i < 5; i++ {
+				for j := 0; j < 5; j++ {
+					createTestDir(filepath.Join(root, fmt.Sprintf("d%d", i), fmt.Sprintf("s%d", j)))
+					createTestFile(filepath.Join(root, fmt.Sprintf("d%d", i), fmt.Sprintf("s%d", j), "f.txt"), "x")
+				}
+			}
+		})
+
+		collect := func(workers int, fn fs.WalkDirFunc) ([]string, error) {
+			var mu sync.Mutex
+			var seen []string
+			err := ParallelWalkDir(root, workers, func(path string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				mu.Lock()
+				seen = append(seen, path)
+				mu.Unlock()
+				if fn != nil {
+					return fn(path, d, err)
+				}
+				return nil
+			})
+			sort.Strings(seen)
+			return seen, err
+		}
+
+		It("should visit the same entries as filepath.WalkDir", func() {
+			var want []string
+			Expect(filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+				want = append(want, path)
+				return err
+			})).To(Succeed())
+			sort.Strings(want)
+
+			for _, workers := range []int{1, 4, 16} {
+				got, err := collect(workers, nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(got).To(Equal(want))
+			}
+		})
+
+		It("should skip directories returning fs.SkipDir", func() {
+			got, err := collect(4, func(path string, d fs.DirEntry, err error) error {
+				if d.IsDir() && d.Name() == "d2" {
+					return fs.SkipDir
+				}
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got).To(ContainElement(filepath.Join(root, "d2")))
+			Expect(got).NotTo(ContainElement(filepath.Join(root, "d2", "s0")))
+			Expect(got).To(ContainElement(filepath.Join(root, "d3", "s0", "f.txt")))
+		})
+
+		It("should stop and return the first error", func() {
+			boom := errors.New("boom")
+			_, err := collect(4, func(path string, d fs.DirEntry, err error) error {
+				if d.Name() == "f.txt" {
+					return boom
+				}
+				return nil
+			})
+			Expect(err).To(MatchError(boom))
+		})
+
+		It("should report a missing root to fn", func() {
+			var reported error
+			err := ParallelWalkDir(filepath.Join(tempDir, "missing"), 4, func(path string, d fs.DirEntry, err error) error {
+				reported = err
+				return err
+			})
+			Expect(os.IsNotExist(reported)).To(BeTrue())
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+	})
+	Describe("Copy filters", func() {
+		type filters struct {
+			include []string
+			exclude []string
+			filter  func(string, fs.DirEntry) bool
+		}
+		copiers := []struct {
+			name string
+			copy func(src, dst string, f filters) error
+		}{
+			{"CopyDirWithOptions", func(src, dst string, f filters) error {
+				return CopyDirWithOptions(src, dst, CopyOptions{Include: f.include, Exclude: f.exclude, Filter: f.filter})
+			}},
+			{"WorkerPoolCopyDirWithOptions", func(src, dst string, f filters) error {
+				return WorkerPoolCopyDirWithOptions(src, dst, PoolOptions{Workers: 2, Include: f.include, Exclude: f.exclude, Filter: f.filter})
+			}},
+			{"SyncDir", func(src, dst string, f filters) error {
+				_, err := SyncDir(src, dst, SyncOptions{Copy: CopyOptions{Include: f.include, Exclude: f.exclude, Filter: f.filter}})
+				return err
+			}},
+		}
+
+		var srcDir, dstDir string
+		BeforeEach(func() {
+			srcDir = filepath.Join(tempDir, "src")
+			dstDir = filepath.Join(tempDir, "dst")
+			createTestDir(filepath.Join(srcDir, "node_modules", "lib"))
+			createTestDir(filepath.Join(srcDir, ".git"))
+			createTestDir(filepath.Join(srcDir, "sub"))
+			createTestFile(filepath.Join(srcDir, "main.go"), "main")
+			createTestFile(filepath.Join(srcDir, "scratch.tmp"), "tmp")
+			createTestFile(filepath.Join(srcDir, "node_modules", "lib", "index.js"), "js")
+			createTestFile(filepath.Join(srcDir, ".git", "HEAD"), "ref")
+			createTestFile(filepath.Join(srcDir, "sub", "util.go"), "util")
+			createTestFile(filepath.Join(srcDir, "sub", "notes.tmp"), "tmp")
+		})
+
+		for _, copier := range copiers {
+			Context("with "+copier.name, func() {
+				It("should leave out excluded files and directories at any depth", func() {
+					Expect(copier.copy(srcDir, dstDir, filters{exclude: []string{"node_modules", ".git", "*.tmp"}})).To(Succeed())
+					Expect(readFileContent(filepath.Join(dstDir, "main.go"))).To(Equal("main"))
+					Expect(readFileContent(filepath.Join(dstDir, "sub", "util.go"))).To(Equal("util"))
+					Expect(fileExists(filepath.Join(dstDir, "node_modules"))).To(BeFalse())
+					Expect(fileExists(filepath.Join(dstDir, ".git"))).To(BeFalse())
+					Expect(fileExists(filepath.Join(dstDir, "scratch.tmp"))).To(BeFalse())
+					Expect(fileExists(filepath.Join(dstDir, "sub", "notes.tmp"))).To(BeFalse())
+				})
+
+				It("should limit files to the include patterns", func() {
+					Expect(copier.copy(srcDir, dstDir, filters{include: []string{"**/*.go"}})).To(Succeed())
+					Expect(fileExists(filepath.Join(dstDir, "main.go"))).To(BeTrue())
+					Expect(fileExists(filepath.Join(dstDir, "sub", "util.go"))).To(BeTrue())
+					Expect(fileExists(filepath.Join(dstDir, "sub", "notes.tmp"))).To(BeFalse())
+					Expect(fileExists(filepath.Join(dstDir, ".git", "HEAD"))).To(BeFalse())
+				})
+
+				It("should leave out entries the predicate rejects", func() {
+					skipSub := func(path string, d fs.DirEntry) bool {
+						return !(d.IsDir() && d.Name() == "sub")
+					}
+					Expect(copier.copy(srcDir, dstDir, filters{filter: skipSub})).To(Succeed())
+					Expect(fileExists(filepath.Join(dstDir, "main.go"))).To(BeTrue())
+					Expect(fileExists(filepath.Join(dstDir, "node_modules", "lib", "index.js"))).To(BeTrue())
+					Expect(fileExists(filepath.Join(dstDir, "sub"))).To(BeFalse())
+				})
+			})
+		}
+
+		It("should report filtered entries to OnSkip", func() {
+			var skipped []string
+			opts := CopyOptions{Exclude: []string{"*.tmp"}, OnSkip: func(path string, reason error) {
+				Expect(reason).To(MatchError(ErrFiltered))
+				skipped = append(skipped, path)
+			}}
+			Expect(CopyDirWithOptions(srcDir, dstDir, opts)).To(Succeed())
+			Expect(skipped).To(ConsistOf(filepath.Join(srcDir, "scratch.tmp"), filepath.Join(srcDir, "sub", "notes.tmp")))
+		})
+
+		It("should not delete excluded entries when syncing", func() {
+			createTestDir(filepath.Join(dstDir, "node_modules", "cache"))
+			createTestFile(filepath.Join(dstDir, "node_modules", "cache", "local.js"), "local")
+			createTestFile(filepath.Join(dstDir, "build.tmp"), "local")
+			createTestFile(filepath.Join(dstDir, "stale.go"), "stale")
+
+			opts := SyncOptions{Delete: true, Copy: CopyOptions{Exclude: []string{"node_modules", "*.tmp"}}}
+			_, err := SyncDir(srcDir, dstDir, opts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(readFileContent(filepath.Join(dstDir, "node_modules", "cache", "local.js"))).To(Equal("local"))
+			Expect(readFileContent(filepath.Join(dstDir, "build.tmp"))).To(Equal("local"))
+			Expect(fileExists(filepath.Join(dstDir, "stale.go"))).To(BeFalse())
+			Expect(fileExists(filepath.Join(dstDir, "node_modules", "lib"))).To(BeFalse())
+		})
+	})
+
+	Describe("Duplicate files", func() {
+		var rootA, rootB string
+		BeforeEach(func() {
+			rootA = filepath.Join(tempDir, "a")
+			rootB = filepath.Join(tempDir, "b")
+			createTestDir(filepath.Join(rootA, "sub"))
+			createTestDir(rootB)
+
+			big := strings.Repeat("0123456789", 1000)
+			createTestFile(filepath.Join(rootA, "big1.bin"), big)
+			createTestFile(filepath.Join(rootA, "sub", "big2.bin"), big)
+			createTestFile(filepath.Join(rootB, "big3.bin"), big)
+			// Same size and first 4KB, different tail
+			createTestFile(filepath.Join(rootB, "big-tail.bin"), big[:len(big)-1]+"x")
+			createTestFile(filepath.Join(rootA, "small1.txt"), "hello")
+			createTestFile(filepath.Join(rootB, "small2.txt"), "hello")
+			createTestFile(filepath.Join(rootA, "unique.txt"), "world!")
+			createTestFile(filepath.Join(rootA, "empty1"), "")
+			createTestFile(filepath.Join(rootB, "empty2"), "")
+			Expect(os.Link(filepath.Join(rootA, "small1.txt"), filepath.Join(rootA, "small-link.txt"))).To(Succeed())
+		})
+
+		It("should group identical files across roots", func() {
+			report, err := FindDuplicateFiles([]string{rootA, rootB})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Sets).To(HaveLen(2))
+
+			Expect(report.Sets[0].Size).To(Equal(int64(10000)))
+			Expect(report.Sets[0].Paths).To(Equal([]string{
+				filepath.Join(rootA, "big1.bin"),
+				filepath.Join(rootA, "sub", "big2.bin"),
+				filepath.Join(rootB, "big3.bin"),
+			}))
+			Expect(report.Sets[0].Hash).To(HaveLen(64))
+
+			Expect(report.Sets[1].Paths).To(HaveLen(2))
+			Expect(report.Sets[1].Paths).To(ContainElement(filepath.Join(rootB, "small2.txt")))
+			Expect(report.Reclaimable).To(Equal(int64(2*10000 + 5)))
+		})
+
+		It("should count a file reached twice only once", func() {
+			report, err := FindDuplicateFiles([]string{rootA, rootA})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Sets).To(HaveLen(1))
+			Expect(report.Sets[0].Paths).To(HaveLen(2))
+		})
+
+		It("should fail for a missing root", func() {
+			_, err := FindDuplicateFiles([]string{filepath.Join(tempDir, "missing")})
+			Expect(errors.Is(err, fs.ErrNotExist)).To(BeTrue())
+		})
+
+		It("should replace duplicates with hard links", func() {
+			report, err := FindDuplicateFiles([]string{rootA, rootB})
+			Expect(err).NotTo(HaveOccurred())
+
+			freed, err := DeduplicateWithHardlinks(report.Sets[0])
+			Expect(err).NotTo(HaveOccurred())
+			Expect(freed).To(Equal(int64(20000)))
+
+			keep, err := os.Stat(report.Sets[0].Paths[0])
+			Expect(err).NotTo(HaveOccurred())
+			for _, path := range report.Sets[0].Paths[1:] {
+				info, err := os.Stat(path)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(os.SameFile(keep, info)).To(BeTrue())
+			}
+
+			again, err := FindDuplicateFiles([]string{rootA, rootB})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(again.Sets).To(HaveLen(1))
+
+			freed, err = DeduplicateWithHardlinks(report.Sets[0])
+			Expect(err).NotTo(HaveOccurred())
+			Expect(freed).To(BeZero())
+		})
+
+		It("should leave files that changed since the search alone", func() {
+			report, err := FindDuplicateFiles([]string{rootA, rootB})
+			Expect(err).NotTo(HaveOccurred())
+			changed := report.Sets[1].Paths[1]
+			Expect(os.WriteFile(changed, []byte("HELLO"), 0644)).To(Succeed())
+
+			freed, err := DeduplicateWithHardlinks(report.Sets[1])
+			Expect(err).NotTo(HaveOccurred())
+			Expect(freed).To(BeZero())
+			Expect(readFileContent(changed)).To(Equal("HELLO"))
+		})
+	})
+
+	Describe("FindFiles", func() {
+		var root string
+		BeforeEach(func() {
+			root = filepath.Join(tempDir, "tree")
+			createTestDir(filepath.Join(root, "cmd", "tool"))
+			createTestDir(filepath.Join(root, "docs"))
+			createTestFile(filepath.Join(root, "main.go"), "package main")
+			createTestFile(filepath.Join(root, "cmd", "tool", "tool.go"), strings.Repeat("x", 2048))
+			createTestFile(filepath.Join(root, "cmd", "tool", "tool_test.go"), "test")
+			createTestFile(filepath.Join(root, "docs", "guide.md"), strings.Repeat("y", 100))
+			Expect(os.Symlink("main.go", filepath.Join(root, "link.go"))).To(Succeed())
+
+			old := time.Now().Add(-48 * time.Hour)
+			Expect(os.Chtimes(filepath.Join(root, "docs", "guide.md"), old, old)).To(Succeed())
+		})
+
+		find := func(opts FindOptions) []string {
+			var found []string
+			for path, err := range FindFiles(root, opts) {
+				Expect(err).NotTo(HaveOccurred())
+				rel, _ := filepath.Rel(root, path)
+				found = append(found, filepath.ToSlash(rel))
+			}
+			return found
+		}
+
+		It("should find every entry below the root by default", func() {
+			Expect(find(FindOptions{})).To(ConsistOf(
+				"cmd", "cmd/tool", "cmd/tool/tool.go", "cmd/tool/tool_test.go",
+				"docs", "docs/guide.md", "link.go", "main.go",
+			))
+		})
+
+		DescribeTable("should filter matches",
+			func(opts FindOptions, expected []string) {
+				Expect(find(opts)).To(ConsistOf(expected))
+			},
+			Entry("by base name glob", FindOptions{Patterns: []string{"*.go"}, Types: FindFile},
+				[]string{"main.go", "cmd/tool/tool.go", "cmd/tool/tool_test.go"}),
+			Entry("by doublestar glob", FindOptions{Patterns: []string{"cmd/**/*_test.go"}},
+				[]string{"cmd/tool/tool_test.go"}),
+			Entry("by doublestar matching no directories", FindOptions{Patterns: []string{"**/main.go"}},
+				[]string{"main.go"}),
+			Entry("by name regex", FindOptions{Name: regexp.MustCompile(`^tool`)},
+				[]string{"cmd/tool", "cmd/tool/tool.go", "cmd/tool/tool_test.go"}),
+			Entry("by minimum size", FindOptions{MinSize: 1024, Types: FindFile},
+				[]string{"cmd/tool/tool.go"}),
+			Entry("by maximum size", FindOptions{MaxSize: 50, Types: FindFile},
+				[]string{"main.go", "cmd/tool/tool_test.go"}),
+			Entry("by modification time", FindOptions{ModifiedBefore: time.Now().Add(-24 * time.Hour)},
+				[]string{"docs/guide.md"}),
+			Entry("by directory type", FindOptions{Types: FindDir},
+				[]string{"cmd", "cmd/tool", "docs"}),
+			Entry("by symlink type", FindOptions{Types: FindSymlink},
+				[]string{"link.go"}),
+		)
+
+		It("should stop walking when the consumer stops", func() {
+			count := 0
+			for range FindFiles(root, FindOptions{}) {
+				count++
+				break
+			}
+			Expect(count).To(Equal(1))
+		})
+
+		It("should report malformed patterns", func() {
+			for found, err := range FindFiles(root, FindOptions{Patterns: []string{"[a-"}}) {
+				Expect(found).To(Equal(root))
+				Expect(err).To(MatchError(path.ErrBadPattern))
+			}
+		})
+
+		It("should report a missing root", func() {
+			var errs []error
+			for _, err := range FindFiles(filepath.Join(tempDir, "missing"), FindOptions{}) {
+				errs = append(errs, err)
+			}
+			Expect(errs).To(HaveLen(1))
+			Expect(errors.Is(errs[0], fs.ErrNotExist)).To(BeTrue())
+		})
+	})
+
+	Describe("Batched directory reads", func() {
+		const fileCount = 250
+		var srcDir string
+		BeforeEach(func() {
+			srcDir = filepath.Join(tempDir, "flat")
+			createTestDir(filepath.Join(srcDir, "sub"))
+			for i := 0; i < fileCount; i++ {
+				createTestFile(filepath.Join(srcDir, fmt.Sprintf("f%04d", i)), fmt.Sprint(i))
+			}
+			createTestFile(filepath.Join(srcDir, "sub", "nested.txt"), "nested")
+		})
+
+		expectCopied := func(dst string) {
+			entries, err := os.ReadDir(dst)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries).To(HaveLen(fileCount + 1))
+			Expect(readFileContent(filepath.Join(dst, "f0123"))).To(Equal("123"))
+			Expect(readFileContent(filepath.Join(dst, "sub", "nested.txt"))).To(Equal("nested"))
+		}
+
+		It("should copy every entry with CopyDirWithOptions", func() {
+			dst := filepath.Join(tempDir, "dir_copy")
+			Expect(CopyDirWithOptions(srcDir, dst, CopyOptions{ReadDirBatch: 7})).To(Succeed())
+			expectCopied(dst)
+		})
+
+		It("should copy every entry with WorkerPoolCopyDirWithOptions", func() {
+			dst := filepath.Join(tempDir, "pool_copy")
+			Expect(WorkerPoolCopyDirWithOptions(srcDir, dst, PoolOptions{Workers: 4, ReadDirBatch: 7})).To(Succeed())
+			expectCopied(dst)
+		})
+
+		It("should sync and delete with SyncDir", func() {
+			dst := filepath.Join(tempDir, "sync_copy")
+			createTestDir(dst)
+			for i := 0; i < 20; i++ {
+				createTestFile(filepath.Join(dst, fmt.Sprintf("stale%02d", i)), "stale")
+			}
+
+			actions, err := SyncDir(srcDir, dst, SyncOptions{Delete: true, Copy: CopyOptions{ReadDirBatch: 7}})
+			Expect(err).NotTo(HaveOccurred())
+			expectCopied(dst)
+
+			deleted := 0
+			for _, action := range actions {
+				if action.Kind == SyncDelete {
+					deleted++
+				}
+			}
+			Expect(deleted).To(Equal(20))
+		})
+
+		It("should still stop on errors within a batch", func() {
+			dst := filepath.Join(tempDir, "dir_copy")
+			createTestDir(filepath.Join(dst, "f0042"))
+			err := CopyDirWithOptions(srcDir, dst, CopyOptions{ReadDirBatch: 7})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Watch", func() {
+		var root string
+		BeforeEach(func() {
+			root = filepath.Join(tempDir, "watched")
+			createTestDir(root)
+		})
+
+		// collect drains w.Events in the background and returns a snapshot
+		// function for use with Eventually
+		collect := func(w *Watcher) func() []WatchEvent {
+			var mu sync.Mutex
+			var got []WatchEvent
+			go func() {
+				for event := range w.Events {
+					mu.Lock()
+					got = append(got, event)
+					mu.Unlock()
+				}
+			}()
+			return func() []WatchEvent {
+				mu.Lock()
+				defer mu.Unlock()
+				return append([]WatchEvent(nil), got...)
+			}
+		}
+
+		for _, poll := range []bool{false, true} {
+			Context(fmt.Sprintf("with polling %v", poll), func() {
+				opts := WatchOptions{Poll: poll, PollInterval: 20 * time.Millisecond}
+
+				It("should report creates, modifies and removes", func() {
+					w, err := Watch(root, opts)
+					Expect(err).NotTo(HaveOccurred())
+					defer w.Close()
+					events := collect(w)
+
+					file := filepath.Join(root, "a.txt")
+					createTestFile(file, "one")
+					Eventually(events).Should(ContainElement(WatchEvent{Path: file, Op: WatchCreate}))
+
+					// Polling compares mtimes, which may not tick between writes
+					time.Sleep(30 * time.Millisecond)
+					Expect(os.WriteFile(file, []byte("two, longer"), 0644)).To(Succeed())
+					Eventually(events).Should(ContainElement(WatchEvent{Path: file, Op: WatchModify}))
+
+					Expect(os.Remove(file)).To(Succeed())
+					Eventually(events).Should(ContainElement(WatchEvent{Path: file, Op: WatchRemove}))
+				})
+
+				It("should follow new subdirectories when recursive", func() {
+					opts := opts
+					opts.Recursive = true
+					w, err := Watch(root, opts)
+					Expect(err).NotTo(HaveOccurred())
+					defer w.Close()
+					events := collect(w)
+
+					createTestDir(filepath.Join(root, "sub"))
+					Eventually(events).Should(ContainElement(WatchEvent{Path: filepath.Join(root, "sub"), Op: WatchCreate}))
+					nested := filepath.Join(root, "sub", "b.txt")
+					createTestFile(nested, "nested")
+					Eventually(events).Should(ContainElement(WatchEvent{Path: nested, Op: WatchCreate}))
+				})
+
+				It("should coalesce bursts with Debounce", func() {
+					opts := opts
+					opts.Debounce = 100 * time.Millisecond
+					w, err := Watch(root, opts)
+					Expect(err).NotTo(HaveOccurred())
+					defer w.Close()
+					events := collect(w)
+
+					file := filepath.Join(root, "burst.log")
+					f, err := os.Create(file)
+					Expect(err).NotTo(HaveOccurred())
+					for i := 0; i < 5; i++ {
+						fmt.Fprintln(f, "line", i)
+						time.Sleep(5 * time.Millisecond)
+					}
+					Expect(f.Close()).To(Succeed())
+
+					Eventually(events).Should(HaveLen(1))
+					Consistently(events, 200*time.Millisecond).Should(HaveLen(1))
+					Expect(events()[0].Path).To(Equal(file))
+					Expect(events()[0].Op & WatchCreate).NotTo(BeZero())
+				})
+			})
+		}
+
+		It("should report renames natively", func() {
+			w, err := Watch(root, WatchOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			defer w.Close()
+			events := collect(w)
+
+			old := filepath.Join(root, "old.txt")
+			createTestFile(old, "x")
+			Expect(os.Rename(old, filepath.Join(root, "new.txt"))).To(Succeed())
+			if runtime.GOOS != "linux" {
+				Skip("renames are only reported natively on Linux")
+			}
+			Eventually(events).Should(ContainElement(WatchEvent{Path: old, Op: WatchRename}))
+			Eventually(events).Should(ContainElement(WatchEvent{Path: filepath.Join(root, "new.txt"), Op: WatchCreate}))
+		})
+
+		It("should close its channels", func() {
+			w, err := Watch(root, WatchOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(w.Close()).To(Succeed())
+			Eventually(w.Events).Should(BeClosed())
+			Expect(w.Close()).To(Succeed())
+		})
+	})
+
+	Describe("SyncDir", func() {
+		var src, dst string
+		BeforeEach(func() {
+			src = filepath.Join(tempDir, "src")
+			dst = filepath.Join(tempDir, "dst")
+			createTestDir(filepath.Join(src, "docs"))
+			createTestFile(filepath.Join(src, "a.txt"), "alpha")
+			createTestFile(filepath.Join(src, "docs", "b.txt"), "bravo")
+		})
+
+		kinds := func(actions []SyncAction) []string {
+			var out []string
+			for _, a := range actions {
+				out = append(out, a.Kind.String()+" "+a.Path)
+			}
+			return out
+		}
+
+		It("should copy everything once and then nothing", func() {
+			actions, err := SyncDir(src, dst, SyncOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(kinds(actions)).To(ConsistOf("mkdir .", "mkdir docs", "copy a.txt", "copy "+filepath.Join("docs", "b.txt")))
+			Expect(readFileContent(filepath.Join(dst, "docs", "b.txt"))).To(Equal("bravo"))
+
+			actions, err = SyncDir(src, dst, SyncOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(actions).To(BeEmpty())
+		})
+
+		It("should update changed files", func() {
+			_, err := SyncDir(src, dst, SyncOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			createTestFile(filepath.Join(src, "a.txt"), "ALPHA")
+			later := time.Now().Add(time.Minute)
+			Expect(os.Chtimes(filepath.Join(src, "a.txt"), later, later)).To(Succeed())
+
+			actions, err := SyncDir(src, dst, SyncOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(kinds(actions)).To(Equal([]string{"update a.txt"}))
+			Expect(readFileContent(filepath.Join(dst, "a.txt"))).To(Equal("ALPHA"))
+		})
+
+		It("should ignore touched but identical files when comparing checksums", func() {
+			_, err := SyncDir(src, dst, SyncOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			later := time.Now().Add(time.Minute)
+			Expect(os.Chtimes(filepath.Join(src, "a.txt"), later, later)).To(Succeed())
+
+			actions, err := SyncDir(src, dst, SyncOptions{Compare: SyncByChecksum})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(actions).To(BeEmpty())
+		})
+
+		It("should delete extraneous entries only when asked", func() {
+			createTestDir(filepath.Join(dst, "old", "deep"))
+			createTestFile(filepath.Join(dst, "old", "deep", "c.txt"), "charlie")
+			createTestFile(filepath.Join(dst, "stale.txt"), "stale")
+
+			_, err := SyncDir(src, dst, SyncOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fileExists(filepath.Join(dst, "stale.txt"))).To(BeTrue())
+
+			actions, err := SyncDir(src, dst, SyncOptions{Delete: true})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(kinds(actions)).To(ConsistOf("delete old", "delete stale.txt"))
+			Expect(fileExists(filepath.Join(dst, "old"))).To(BeFalse())
+			Expect(fileExists(filepath.Join(dst, "stale.txt"))).To(BeFalse())
+		})
+
+		It("should plan without touching disk in a dry run", func() {
+			createTestDir(dst)
+			createTestFile(filepath.Join(dst, "stale.txt"), "stale")
+
+			actions, err := SyncDir(src, dst, SyncOptions{Delete: true, DryRun: true})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(kinds(actions)).To(ConsistOf("mkdir docs", "copy a.txt", "copy "+filepath.Join("docs", "b.txt"), "delete stale.txt"))
+			Expect(fileExists(filepath.Join(dst, "a.txt"))).To(BeFalse())
+			Expect(fileExists(filepath.Join(dst, "stale.txt"))).To(BeTrue())
+		})
+
+		It("should neither copy nor delete sidecars", func() {
+			Expect(StoreFileMD5(filepath.Join(src, "a.txt"), "0123", HashStoreSidecar)).To(Succeed())
+			_, err := SyncDir(src, dst, SyncOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fileExists(filepath.Join(dst, ".a.txt.gstorage-md5"))).To(BeFalse())
+
+			sidecar := filepath.Join(dst, "docs", ".b.txt.gstorage-md5")
+			createTestFile(sidecar, "4567")
+			actions, err := SyncDir(src, dst, SyncOptions{Delete: true})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(actions).To(BeEmpty())
+			Expect(fileExists(sidecar)).To(BeTrue())
+		})
+
+		It("should honour MinFileAge and legal holds", func() {
+			createTestDir(dst)
+			held := filepath.Join(dst, "evidence.txt")
+			createTestFile(held, "keep")
+			Expect(SetLegalHoldRoot(dst)).To(Succeed())
+			Expect(PlaceHold(held)).To(Succeed())
+
+			actions, err := SyncDir(src, dst, SyncOptions{Delete: true, Copy: CopyOptions{MinFileAge: time.Hour}})
+			Expect(err).To(MatchError(ErrLegalHold))
+			Expect(kinds(actions)).To(ConsistOf("mkdir docs"))
+			Expect(fileExists(filepath.Join(dst, "a.txt"))).To(BeFalse())
+			Expect(fileExists(held)).To(BeTrue())
+		})
+	})
+
+	Describe("Archives", func() {
+		var srcDir string
+		BeforeEach(func() {
+			srcDir = filepath.Join(tempDir, "archive_src")
+			createTestDir(filepath.Join(srcDir, "bin"))
+			createTestDir(filepath.Join(srcDir, "node_modules", "pkg"))
+			createTestFile(filepath.Join(srcDir, "readme.txt"), "readme")
+			createTestFile(filepath.Join(srcDir, "scratch.tmp"), "scratch")
+			createTestFile(filepath.Join(srcDir, "bin", "run.sh"), "#!/bin/sh")
+			createTestFile(filepath.Join(srcDir, "node_modules", "pkg", "index.js"), "js")
+			Expect(os.Chmod(filepath.Join(srcDir, "bin", "run.sh"), 0750)).To(Succeed())
+			Expect(os.Symlink("readme.txt", filepath.Join(srcDir, "link.txt"))).To(Succeed())
+		})
+
+		for _, format := range []struct {
+			name    string
+			create  func(string, string, ArchiveOptions) error
+			extract func(string, string, ArchiveOptions) error
+			opts    ArchiveOptions
+		}{
+			{"tar", CreateTar, ExtractTar, ArchiveOptions{}},
+			{"tar.gz", CreateTar, ExtractTar, ArchiveOptions{Compression: ArchiveGzip}},
+			{"zip", CreateZip, ExtractZip, ArchiveOptions{}},
+		} {
+			Context(format.name, func() {
+				It("should round-trip a tree with permissions and links", func() {
+					archive := filepath.Join(tempDir, "out."+format.name)
+					Expect(format.create(srcDir, archive, format.opts)).To(Succeed())
+
+					dst := filepath.Join(tempDir, "extracted")
+					Expect(format.extract(archive, dst, ArchiveOptions{})).To(Succeed())
+					Expect(readFileContent(filepath.Join(dst, "readme.txt"))).To(Equal("readme"))
+					Expect(readFileContent(filepath.Join(dst, "node_modules", "pkg", "index.js"))).To(Equal("js"))
+
+					info, err := os.Stat(filepath.Join(dst, "bin", "run.sh"))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(info.Mode().Perm()).To(Equal(fs.FileMode(0750)))
+
+					target, err := os.Readlink(filepath.Join(dst, "link.txt"))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(target).To(Equal("readme.txt"))
+				})
+
+				It("should apply include and exclude patterns", func() {
+					archive := filepath.Join(tempDir, "out."+format.name)
+					opts := format.opts
+					opts.Exclude = []string{"node_modules", "*.tmp"}
+					Expect(format.create(srcDir, archive, opts)).To(Succeed())
+
+					dst := filepath.Join(tempDir, "extracted")
+					Expect(format.extract(archive, dst, ArchiveOptions{Include: []string{"*.sh"}})).To(Succeed())
+					Expect(fileExists(filepath.Join(dst, "bin", "run.sh"))).To(BeTrue())
+					Expect(fileExists(filepath.Join(dst, "readme.txt"))).To(BeFalse())
+					Expect(fileExists(filepath.Join(dst, "scratch.tmp"))).To(BeFalse())
+					Expect(fileExists(filepath.Join(dst, "node_modules"))).To(BeFalse())
+				})
+
+				It("should leave out an archive written inside the tree", func() {
+					archive := filepath.Join(srcDir, "self."+format.name)
+					Expect(format.create(srcDir, archive, format.opts)).To(Succeed())
+
+					dst := filepath.Join(tempDir, "extracted")
+					Expect(format.extract(archive, dst, ArchiveOptions{})).To(Succeed())
+					Expect(fileExists(filepath.Join(dst, "self."+format.name))).To(BeFalse())
+				})
+			})
+		}
+
+		Context("with hostile archives", func() {
+			writeTar := func(headers ...*tar.Header) string {
+				archive := filepath.Join(tempDir, "hostile.tar")
+				f, err := os.Create(archive)
+				Expect(err).NotTo(HaveOccurred())
+				defer f.Close()
+				tw := tar.NewWriter(f)
+				for _, hdr := range headers {
+					if hdr.Typeflag == tar.TypeReg {
+						hdr.Size = 4
+					}
+					Expect(tw.WriteHeader(hdr)).To(Succeed())
+					if hdr.Typeflag == tar.TypeReg {
+						_, err := tw.Write([]byte("evil"))
+						Expect(err).NotTo(HaveOccurred())
+					}
+				}
+				Expect(tw.Close()).To(Succeed())
+				return archive
+			}
+
+			DescribeTable("should refuse tar entries escaping the destination",
+				func(headers ...*tar.Header) {
+					dst := filepath.Join(tempDir, "extracted")
+					err := ExtractTar(writeTar(headers...), dst, ArchiveOptions{})
+					Expect(errors.Is(err, ErrUnsafeArchivePath)).To(BeTrue())
+					Expect(fileExists(filepath.Join(tempDir, "evil.txt"))).To(BeFalse())
+				},
+				Entry("parent components", &tar.Header{Name: "../evil.txt", Typeflag: tar.TypeReg, Mode: 0644}),
+				Entry("nested parent components", &tar.Header{Name: "a/../../evil.txt", Typeflag: tar.TypeReg, Mode: 0644}),
+				Entry("an absolute name", &tar.Header{Name: "/evil.txt", Typeflag: tar.TypeReg, Mode: 0644}),
+				Entry("a link leading out", &tar.Header{Name: "out", Typeflag: tar.TypeSymlink, Linkname: ".."}),
+				Entry("an absolute link", &tar.Header{Name: "out", Typeflag: tar.TypeSymlink, Linkname: "/tmp"}),
+			)
+
+			It("should not write through links already in the destination", func() {
+				dst := filepath.Join(tempDir, "extracted")
+				createTestDir(dst)
+				Expect(os.Symlink(tempDir, filepath.Join(dst, "out"))).To(Succeed())
+
+				err := ExtractTar(writeTar(&tar.Header{Name: "out/evil.txt", Typeflag: tar.TypeReg, Mode: 0644}), dst, ArchiveOptions{})
+				Expect(errors.Is(err, ErrUnsafeArchivePath)).To(BeTrue())
+				Expect(fileExists(filepath.Join(tempDir, "evil.txt"))).To(BeFalse())
+			})
+
+			It("should refuse chained links that lead out together", func() {
+				dst := filepath.Join(tempDir, "parent", "extracted")
+				createTestDir(dst)
+				parent := filepath.Join(tempDir, "parent")
+				Expect(os.Chmod(parent, 0750)).To(Succeed())
+
+				err := ExtractTar(writeTar(
+					&tar.Header{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "."},
+					&tar.Header{Name: "a/b", Typeflag: tar.TypeSymlink, Linkname: ".."},
+					&tar.Header{Name: "a/b/", Typeflag: tar.TypeDir, Mode: 0777},
+					&tar.Header{Name: "a/b/newdir/x.txt", Typeflag: tar.TypeReg, Mode: 0644},
+				), dst, ArchiveOptions{})
+				Expect(errors.Is(err, ErrUnsafeArchivePath)).To(BeTrue())
+
+				info, err := os.Stat(parent)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(info.Mode().Perm()).To(Equal(os.FileMode(0750)))
+				Expect(fileExists(filepath.Join(parent, "newdir"))).To(BeFalse())
+			})
+
+			It("should refuse entries below existing links that lead out together", func() {
+				dst := filepath.Join(tempDir, "parent", "extracted")
+				createTestDir(dst)
+				Expect(os.Symlink(".", filepath.Join(dst, "a"))).To(Succeed())
+				Expect(os.Symlink("..", filepath.Join(dst, "b"))).To(Succeed())
+
+				for _, name := range []string{"a/b/", "a/b/newdir/x.txt"} {
+					typ := byte(tar.TypeReg)
+					if strings.HasSuffix(name, "/") {
+						typ = tar.TypeDir
+					}
+					err := ExtractTar(writeTar(&tar.Header{Name: name, Typeflag: typ, Mode: 0777}), dst, ArchiveOptions{})
+					Expect(errors.Is(err, ErrUnsafeArchivePath)).To(BeTrue(), name)
+				}
+				Expect(fileExists(filepath.Join(tempDir, "parent", "newdir"))).To(BeFalse())
+			})
+
+			It("should still follow links that stay inside", func() {
+				dst := filepath.Join(tempDir, "extracted")
+				err := ExtractTar(writeTar(
+					&tar.Header{Name: "real/", Typeflag: tar.TypeDir, Mode: 0755},
+					&tar.Header{Name: "alias", Typeflag: tar.TypeSymlink, Linkname: "real"},
+					&tar.Header{Name: "alias/", Typeflag: tar.TypeDir, Mode: 0700},
+					&tar.Header{Name: "alias/x.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 1},
+				), dst, ArchiveOptions{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(fileExists(filepath.Join(dst, "real", "x.txt"))).To(BeTrue())
+			})
+
+			It("should refuse zip entries escaping the destination", func() {
+				archive := filepath.Join(tempDir, "hostile.zip")
+				f, err := os.Create(archive)
+				Expect(err).NotTo(HaveOccurred())
+				zw := zip.NewWriter(f)
+				w, err := zw.Create("../evil.txt")
+				Expect(err).NotTo(HaveOccurred())
+				_, err = w.Write([]byte("evil"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(zw.Close()).To(Succeed())
+				Expect(f.Close()).To(Succeed())
+
+				err = ExtractZip(archive, filepath.Join(tempDir, "extracted"), ArchiveOptions{})
+				Expect(errors.Is(err, ErrUnsafeArchivePath)).To(BeTrue())
+				Expect(fileExists(filepath.Join(tempDir, "evil.txt"))).To(BeFalse())
+			})
+		})
+	})
+
+	Describe("FanoutPath", func() {
+		It("should spread keys over hashed subdirectories", func() {
+			Expect(FanoutPath("/blobs", "report.pdf", 2, 2)).To(Equal(filepath.Join("/blobs", "64", "66", "report.pdf")))
+			Expect(FanoutPath("/blobs", "report.pdf", 1, 3)).To(Equal(filepath.Join("/blobs", "646", "report.pdf")))
+			Expect(FanoutPath("/blobs", "report.pdf", 0, 2)).To(Equal(filepath.Join("/blobs", "report.pdf")))
+		})
+
+		It("should cap the levels at the digits of the hash", func() {
+			path := FanoutPath("/blobs", "k", 100, 16)
+			rel, err := filepath.Rel("/blobs", path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(strings.Split(rel, string(filepath.Separator))).To(HaveLen(5))
+		})
+
+		DescribeTable("should keep awkward keys inside their leaf directory",
+			func(key string) {
+				path := FanoutPath("/blobs", key, 2, 2)
+				Expect(filepath.Dir(filepath.Dir(filepath.Dir(path)))).To(Equal("/blobs"))
+				Expect(filepath.Base(path)).NotTo(HavePrefix("."))
+			},
+			Entry("with separators", "a/b/../../c"),
+			Entry("dot-dot", ".."),
+			Entry("hidden", ".profile"),
+			Entry("with backslashes", `a\b`),
+		)
+	})
+
+	Describe("FanoutStore", func() {
+		var store *FanoutStore
+		BeforeEach(func() {
+			store = NewFanoutStore(filepath.Join(tempDir, "blobs"), 2, 2)
+		})
+
+		It("should put, get and delete objects", func() {
+			Expect(store.Put("user/42/avatar.png", strings.NewReader("png"))).To(Succeed())
+			Expect(readFileContent(store.Path("user/42/avatar.png"))).To(Equal("png"))
+
+			r, err := store.Get("user/42/avatar.png")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(io.ReadAll(r)).To(Equal([]byte("png")))
+			Expect(r.Close()).To(Succeed())
+
+			Expect(store.Put("user/42/avatar.png", strings.NewReader("new"))).To(Succeed())
+			Expect(readFileContent(store.Path("user/42/avatar.png"))).To(Equal("new"))
+
+			Expect(store.Delete("user/42/avatar.png")).To(Succeed())
+			_, err = store.Get("user/42/avatar.png")
+			Expect(errors.Is(err, fs.ErrNotExist)).To(BeTrue())
+			Expect(store.Delete("user/42/avatar.png")).To(Succeed())
+		})
+
+		It("should spread many objects thinly", func() {
+			for i := 0; i < 200; i++ {
+				Expect(store.Put(fmt.Sprintf("obj-%d", i), strings.NewReader("x"))).To(Succeed())
+			}
+			top, err := os.ReadDir(store.Root)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(len(top)).To(BeNumerically(">", 100))
+		})
+
+		It("should reject empty keys", func() {
+			Expect(store.Put("", strings.NewReader("x"))).To(MatchError(ErrInvalidKey))
+			_, err := store.Get("")
+			Expect(err).To(MatchError(ErrInvalidKey))
+			Expect(store.Delete("")).To(MatchError(ErrInvalidKey))
+		})
+	})
+
+	Describe("SanitizeFileName", func() {
+		DescribeTable("should make names valid on the target platform",
+			func(name string, target Platform, expected string) {
+				Expect(SanitizeFileName(name, target)).To(Equal(expected))
+			},
+			Entry("valid names unchanged", "report 2024.pdf", PlatformPortable, "report 2024.pdf"),
+			Entry("POSIX separators", "a/b", PlatformPOSIX, "a_b"),
+			Entry("POSIX keeps colons", "12:30.log", PlatformPOSIX, "12:30.log"),
+			Entry("Darwin colons", "12:30.log", PlatformDarwin, "12_30.log"),
+			Entry("Windows reserved characters", `a<b>c:d"e\f|g?h*i`, PlatformWindows, "a_b_c_d_e_f_g_h_i"),
+			Entry("Windows control characters", "tab\there", PlatformWindows, "tab_here"),
+			Entry("Windows trailing dots and spaces", "notes. . ", PlatformWindows, "notes"),
+			Entry("Windows device names", "con", PlatformWindows, "_con"),
+			Entry("Windows device names with extensions", "LPT1.txt", PlatformWindows, "_LPT1.txt"),
+			Entry("Windows names merely starting like devices", "console.log", PlatformWindows, "console.log"),
+			Entry("invalid UTF-8 off POSIX", "caf\xe9", PlatformPortable, "caf_"),
+			Entry("invalid UTF-8 on POSIX", "caf\xe9", PlatformPOSIX, "caf\xe9"),
+			Entry("empty names", "", PlatformPOSIX, "_"),
+			Entry("dot", ".", PlatformPOSIX, "_"),
+			Entry("dot-dot", "..", PlatformPOSIX, "__"),
+			Entry("names trimmed to nothing", "...", PlatformWindows, "_"),
+		)
+
+		It("should truncate long names at a rune boundary keeping the extension", func() {
+			name := SanitizeFileName(strings.Repeat("é", 200)+".txt", PlatformPOSIX)
+			Expect(len(name)).To(BeNumerically("<=", 255))
+			Expect(name).To(HaveSuffix(".txt"))
+			Expect(utf8.ValidString(name)).To(BeTrue())
+		})
+
+		Context("during copies", func() {
+			var srcDir, dstDir string
+			BeforeEach(func() {
+				srcDir = filepath.Join(tempDir, "src")
+				dstDir = filepath.Join(tempDir, "dst")
+				createTestDir(filepath.Join(srcDir, "logs:old"))
+				createTestFile(filepath.Join(srcDir, "logs:old", "12:30.log"), "log")
+				createTestFile(filepath.Join(srcDir, "plain.txt"), "plain")
+			})
+
+			expectSanitized := func() {
+				Expect(readFileContent(filepath.Join(dstDir, "logs_old", "12_30.log"))).To(Equal("log"))
+				Expect(readFileContent(filepath.Join(dstDir, "plain.txt"))).To(Equal("plain"))
+				Expect(fileExists(filepath.Join(dstDir, "logs:old"))).To(BeFalse())
+			}
+
+			It("should sanitize names in CopyDirWithOptions", func() {
+				Expect(CopyDirWithOptions(srcDir, dstDir, CopyOptions{SanitizeNames: true, SanitizePlatform: PlatformWindows})).To(Succeed())
+				expectSanitized()
+			})
+
+			It("should sanitize names in WorkerPoolCopyDirWithOptions", func() {
+				Expect(WorkerPoolCopyDirWithOptions(srcDir, dstDir, PoolOptions{Workers: 2, SanitizeNames: true, SanitizePlatform: PlatformWindows})).To(Succeed())
+				expectSanitized()
+			})
+
+			It("should sanitize names in SyncDir and keep them on deletes", func() {
+				opts := SyncOptions{Delete: true, Copy: CopyOptions{SanitizeNames: true, SanitizePlatform: PlatformWindows}}
+				_, err := SyncDir(srcDir, dstDir, opts)
+				Expect(err).NotTo(HaveOccurred())
+				expectSanitized()
+
+				createTestFile(filepath.Join(dstDir, "stale.txt"), "stale")
+				actions, err := SyncDir(srcDir, dstDir, opts)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(actions).To(Equal([]SyncAction{{Kind: SyncDelete, Path: "stale.txt"}}))
+				expectSanitized()
+			})
+
+			It("should leave names alone by default", func() {
+				Expect(CopyDir(srcDir, dstDir)).To(Succeed())
+				Expect(readFileContent(filepath.Join(dstDir, "logs:old", "12:30.log"))).To(Equal("log"))
+			})
+		})
+
+		Context("with colliding names", func() {
+			var srcDir, dstDir, manifest string
+			BeforeEach(func() {
+				srcDir = filepath.Join(tempDir, "src")
+				dstDir = filepath.Join(tempDir, "dst")
+				manifest = filepath.Join(tempDir, "names.json")
+				createTestDir(filepath.Join(srcDir, "dir:1"))
+				createTestFile(filepath.Join(srcDir, "a_b.txt"), "plain")
+				createTestFile(filepath.Join(srcDir, "a:b.txt"), "colon")
+				createTestFile(filepath.Join(srcDir, "README"), "upper")
+				createTestFile(filepath.Join(srcDir, "readme"), "lower")
+				createTestFile(filepath.Join(srcDir, "dir:1", "x?.txt"), "nested")
+			})
+
+			dstNames := func(dir string) []string {
+				entries, err := os.ReadDir(dir)
+				Expect(err).NotTo(HaveOccurred())
+				names := []string{}
+				for _, entry := range entries {
+					names = append(names, entry.Name())
+				}
+				return names
+			}
+
+			It("should keep colliding names apart with a hash suffix", func() {
+				opts := CopyOptions{SanitizeNames: true, SanitizePlatform: PlatformWindows}
+				Expect(CopyDirWithOptions(srcDir, dstDir, opts)).To(Succeed())
+
+				names := dstNames(dstDir)
+				Expect(names).To(HaveLen(5))
+				Expect(names).To(ContainElement(MatchRegexp(`^a_b~[0-9a-f]{8}\.txt$`)))
+				Expect(names).To(ContainElement(MatchRegexp(`^readme~[0-9a-f]{8}$`)))
+				Expect(readFileContent(filepath.Join(dstDir, "a_b.txt"))).To(Equal("plain"))
+				Expect(readFileContent(filepath.Join(dstDir, "README"))).To(Equal("upper"))
+			})
+
+			It("should pick the same names in every kind of copy", func() {
+				Expect(CopyDirWithOptions(srcDir, dstDir, CopyOptions{SanitizeNames: true, SanitizePlatform: PlatformWindows})).To(Succeed())
+				poolDst := filepath.Join(tempDir, "pool")
+				Expect(WorkerPoolCopyDirWithOptions(srcDir, poolDst, PoolOptions{Workers: 2, SanitizeNames: true, SanitizePlatform: PlatformWindows})).To(Succeed())
+				syncDst := filepath.Join(tempDir, "sync")
+				_, err := SyncDir(srcDir, syncDst, SyncOptions{Copy: CopyOptions{SanitizeNames: true, SanitizePlatform: PlatformWindows}})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(dstNames(poolDst)).To(Equal(dstNames(dstDir)))
+				Expect(dstNames(syncDst)).To(Equal(dstNames(dstDir)))
+			})
+
+			It("should restore the original names from the manifest", func() {
+				opts := PoolOptions{Workers: 2, SanitizeNames: true, SanitizePlatform: PlatformWindows, Manifest: manifest}
+				Expect(WorkerPoolCopyDirWithOptions(srcDir, dstDir, opts)).To(Succeed())
+
+				m, err := ReadManifest(manifest)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(m.Entries).To(HaveLen(6))
+				Expect(m.Entries).To(ContainElement(HaveField("Path", "dir_1/x_.txt")))
+				Expect(m.Entries).To(ContainElement(HaveField("Source", "dir:1/x?.txt")))
+
+				Expect(RestoreManifest(dstDir, m)).To(Succeed())
+				Expect(dstNames(dstDir)).To(ConsistOf(dstNames(srcDir)))
+				Expect(readFileContent(filepath.Join(dstDir, "a:b.txt"))).To(Equal("colon"))
+				Expect(readFileContent(filepath.Join(dstDir, "readme"))).To(Equal("lower"))
+				Expect(readFileContent(filepath.Join(dstDir, "dir:1", "x?.txt"))).To(Equal("nested"))
+			})
+		})
+	})
+
+	Describe("Manifests", func() {
+		var srcDir, dstDir, manifest string
+		var stamp time.Time
+		BeforeEach(func() {
+			srcDir = filepath.Join(tempDir, "src")
+			dstDir = filepath.Join(tempDir, "dst")
+			manifest = filepath.Join(tempDir, "manifest.json")
+			createTestDir(filepath.Join(srcDir, "bin"))
+			createTestFile(filepath.Join(srcDir, "bin", "run.sh"), "#!/bin/sh")
+			createTestFile(filepath.Join(srcDir, "notes.txt"), "notes")
+			Expect(os.Chmod(filepath.Join(srcDir, "bin", "run.sh"), 0750)).To(Succeed())
+			Expect(os.Chmod(filepath.Join(srcDir, "notes.txt"), 0600)).To(Succeed())
+
+			stamp = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+			for _, path := range []string{filepath.Join(srcDir, "bin", "run.sh"), filepath.Join(srcDir, "notes.txt"), filepath.Join(srcDir, "bin")} {
+				Expect(os.Chtimes(path, stamp, stamp)).To(Succeed())
+			}
+		})
+
+		expectRestored := func() {
+			m, err := ReadManifest(manifest)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(m.Entries).To(HaveLen(3))
+			Expect(m.Entries[0]).To(Equal(ManifestEntry{Path: "bin", Source: "bin", Mode: fs.ModeDir | 0755, ModTime: m.Entries[0].ModTime}))
+
+			Expect(RestoreManifest(dstDir, m)).To(Succeed())
+			for _, rel := range []string{filepath.Join("bin", "run.sh"), "notes.txt", "bin"} {
+				src, err := os.Stat(filepath.Join(srcDir, rel))
+				Expect(err).NotTo(HaveOccurred())
+				dst, err := os.Stat(filepath.Join(dstDir, rel))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dst.Mode()).To(Equal(src.Mode()), rel)
+				Expect(dst.ModTime().Equal(stamp)).To(BeTrue(), rel)
+			}
+		}
+
+		It("should restore the metadata a CopyDir dropped", func() {
+			Expect(CopyDirWithOptions(srcDir, dstDir, CopyOptions{Manifest: manifest})).To(Succeed())
+			info, err := os.Stat(filepath.Join(dstDir, "notes.txt"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.ModTime().Equal(stamp)).To(BeFalse())
+			expectRestored()
+		})
+
+		It("should restore the metadata a worker pool copy dropped", func() {
+			Expect(WorkerPoolCopyDirWithOptions(srcDir, dstDir, PoolOptions{Workers: 2, Manifest: manifest})).To(Succeed())
+			expectRestored()
+		})
+
+		It("should write a manifest from SyncDir but not from a dry run", func() {
+			_, err := SyncDir(srcDir, dstDir, SyncOptions{DryRun: true, Copy: CopyOptions{Manifest: manifest}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fileExists(manifest)).To(BeFalse())
+
+			_, err = SyncDir(srcDir, dstDir, SyncOptions{Copy: CopyOptions{Manifest: manifest}})
+			Expect(err).NotTo(HaveOccurred())
+			expectRestored()
+		})
+
+		It("should skip entries missing from the copy", func() {
+			m := &Manifest{Entries: []ManifestEntry{{Path: "gone.txt", Source: "gone.txt", Mode: 0644}}}
+			Expect(RestoreManifest(dstDir, m)).To(Succeed())
+		})
+
+		It("should refuse entries outside the directory", func() {
+			m := &Manifest{Entries: []ManifestEntry{{Path: "../escape", Source: "x"}}}
+			Expect(RestoreManifest(dstDir, m)).To(MatchError(ErrInvalidManifest))
+			m = &Manifest{Entries: []ManifestEntry{{Path: "x", Source: "../escape"}}}
+			Expect(RestoreManifest(dstDir, m)).To(MatchError(ErrInvalidManifest))
+		})
+	})
+
+	Describe("Write-once roots", func() {
+		var root, archived string
+		BeforeEach(func() {
+			root = filepath.Join(tempDir, "vault")
+			createTestDir(root)
+			archived = filepath.Join(root, "ledger.csv")
+			createTestFile(archived, "2023 totals")
+			Expect(SetWriteOnceRoot(root, time.Hour)).To(Succeed())
+		})
+
+		It("should allow new files", func() {
+			Expect(WriteFile(filepath.Join(root, "new.csv"), []byte("2024"))).To(Succeed())
+			Expect(CopyFile(archived, filepath.Join(root, "copy.csv"))).To(Succeed())
+		})
+
+		It("should refuse to overwrite existing files", func() {
+			src := filepath.Join(tempDir, "other.csv")
+			createTestFile(src, "tampered")
+
+			Expect(WriteFile(archived, []byte("tampered"))).To(MatchError(ErrWriteOnce))
+			Expect(WriteFileAtomic(archived, []byte("tampered"))).To(MatchError(ErrWriteOnce))
+			Expect(CopyFile(src, archived)).To(MatchError(ErrWriteOnce))
+			Expect(MoveFile(src, archived)).To(MatchError(ErrWriteOnce))
+			Expect(AppendFile(archived, []byte("tampered"))).To(MatchError(ErrWriteOnce))
+			Expect(WriteAt(archived, []byte("tampered"), 0)).To(MatchError(ErrWriteOnce))
+			Expect(TruncateFile(archived, 0)).To(MatchError(ErrWriteOnce))
+			Expect(readFileContent(archived)).To(Equal("2023 totals"))
+		})
+
+		It("should refuse deletes inside the retention period", func() {
+			Expect(RemoveFile(archived)).To(MatchError(ErrRetention))
+			Expect(RemoveDirAll(root)).To(MatchError(ErrRetention))
+			Expect(MoveFile(archived, filepath.Join(tempDir, "escaped.csv"))).To(MatchError(ErrRetention))
+			Expect(readFileContent(archived)).To(Equal("2023 totals"))
+		})
+
+		It("should allow deletes once retention has elapsed", func() {
+			past := time.Now().Add(-2 * time.Hour)
+			Expect(os.Chtimes(archived, past, past)).To(Succeed())
+			Expect(RemoveFile(archived)).To(Succeed())
+		})
+
+		It("should not affect paths outside the root", func() {
+			outside := filepath.Join(tempDir, "vault-sibling.txt")
+			createTestFile(outside, "x")
+			Expect(WriteFile(outside, []byte("y"))).To(Succeed())
+			Expect(RemoveFile(outside)).To(Succeed())
+		})
+	})
+
+	Describe("Soft delete", func() {
+		var root string
+		BeforeEach(func() {
+			root = filepath.Join(tempDir, "recoverable")
+			createTestDir(filepath.Join(root, "reports"))
+			createTestFile(filepath.Join(root, "reports", "q1.pdf"), "q1")
+			createTestFile(filepath.Join(root, "notes.txt"), "notes")
+			Expect(SetSoftDeleteRoot(root, time.Hour)).To(Succeed())
+		})
+
+		deleted := func() []string {
+			var found []string
+			filepath.WalkDir(filepath.Join(root, ".deleted"), func(path string, d fs.DirEntry, err error) error {
+				if err == nil && !d.IsDir() {
+					rel, _ := filepath.Rel(filepath.Join(root, ".deleted"), path)
+					_, rest, _ := strings.Cut(rel, string(filepath.Separator))
+					found = append(found, rest)
+				}
+				return nil
+			})
+			return found
+		}
+
+		It("should move removed files and directories into .deleted", func() {
+			Expect(RemoveFile(filepath.Join(root, "notes.txt"))).To(Succeed())
+			Expect(RemoveDirAll(filepath.Join(root, "reports"))).To(Succeed())
+
+			Expect(fileExists(filepath.Join(root, "notes.txt"))).To(BeFalse())
+			Expect(fileExists(filepath.Join(root, "reports"))).To(BeFalse())
+			Expect(deleted()).To(ConsistOf("notes.txt", filepath.Join("reports", "q1.pdf")))
+		})
+
+		It("should purge only content past its retention", func() {
+			Expect(RemoveFile(filepath.Join(root, "notes.txt"))).To(Succeed())
+			Expect(PurgeDeleted()).To(Equal(0))
+			Expect(deleted()).To(HaveLen(1))
+
+			Expect(SetSoftDeleteRoot(root, 0)).To(Succeed())
+			Expect(PurgeDeleted()).To(Equal(1))
+			Expect(deleted()).To(BeEmpty())
+		})
+
+		It("should delete outright inside .deleted", func() {
+			Expect(RemoveFile(filepath.Join(root, "notes.txt"))).To(Succeed())
+			Expect(RemoveDirAll(filepath.Join(root, ".deleted"))).To(Succeed())
+			Expect(fileExists(filepath.Join(root, ".deleted"))).To(BeFalse())
+		})
+
+		It("should not affect paths outside the root", func() {
+			outside := filepath.Join(tempDir, "recoverable-sibling.txt")
+			createTestFile(outside, "x")
+			Expect(RemoveFile(outside)).To(Succeed())
+			Expect(fileExists(outside)).To(BeFalse())
+			Expect(deleted()).To(BeEmpty())
+		})
+	})
+
+	Describe("Legal hold", func() {
+		for _, sidecars := range []bool{false, true} {
+			mode := "in extended attributes"
+			if sidecars {
+				mode = "in sidecar files"
+			}
+			Context("with holds recorded "+mode, func() {
+				var caseDir, held string
+				BeforeEach(func() {
+					if sidecars {
+						DeferCleanup(UseHoldSidecars())
+					}
+					caseDir = filepath.Join(tempDir, "case")
+					createTestDir(caseDir)
+					held = filepath.Join(caseDir, "evidence.eml")
+					createTestFile(held, "original")
+					Expect(SetLegalHoldRoot(caseDir)).To(Succeed())
+					err := PlaceHold(held)
+					if !sidecars && errors.Is(err, ErrXattrUnsupported) {
+						Skip("extended attributes not supported here")
+					}
+					Expect(err).NotTo(HaveOccurred())
+				})
+
+				It("should report held files", func() {
+					Expect(IsHeld(held)).To(BeTrue())
+					Expect(IsHeld(filepath.Join(tempDir, "missing"))).To(BeFalse())
+					Expect(fileExists(filepath.Join(caseDir, ".evidence.eml.gstorage-hold"))).To(Equal(sidecars))
+				})
+
+				It("should refuse overwrites and deletes while held", func() {
+					src := filepath.Join(tempDir, "other.eml")
+					createTestFile(src, "tampered")
+
+					Expect(WriteFile(held, []byte("tampered"))).To(MatchError(ErrLegalHold))
+					Expect(CopyFile(src, held)).To(MatchError(ErrLegalHold))
+					Expect(RemoveFile(held)).To(MatchError(ErrLegalHold))
+					Expect(MoveFile(held, filepath.Join(tempDir, "moved.eml"))).To(MatchError(ErrLegalHold))
+					Expect(RemoveDirAll(caseDir)).To(MatchError(ErrLegalHold))
+					Expect(readFileContent(held)).To(Equal("original"))
+				})
+
+				It("should refuse to lift the hold by deleting its marker", func() {
+					if !sidecars {
+						Skip("holds in extended attributes have no marker file")
+					}
+					marker := filepath.Join(caseDir, ".evidence.eml.gstorage-hold")
+					Expect(RemoveFile(marker)).To(MatchError(ErrLegalHold))
+					Expect(WriteFile(marker, nil)).To(MatchError(ErrLegalHold))
+
+					// The marker sorts before the file, so a sync must not
+					// remove it on the way to the held file
+					empty := filepath.Join(tempDir, "empty")
+					createTestDir(empty)
+					_, err := SyncDir(empty, caseDir, SyncOptions{Delete: true})
+					Expect(err).To(MatchError(ErrLegalHold))
+					Expect(IsHeld(held)).To(BeTrue())
+					Expect(readFileContent(held)).To(Equal("original"))
+				})
+
+				It("should not copy holds along with the file", func() {
+					dst := filepath.Join(tempDir, "copy")
+					Expect(CopyDir(caseDir, dst)).To(Succeed())
+					Expect(WorkerPoolCopyDir(caseDir, filepath.Join(tempDir, "pool"), 2)).To(Succeed())
+					Expect(readFileContent(filepath.Join(dst, "evidence.eml"))).To(Equal("original"))
+					Expect(fileExists(filepath.Join(dst, ".evidence.eml.gstorage-hold"))).To(BeFalse())
+					Expect(fileExists(filepath.Join(tempDir, "pool", ".evidence.eml.gstorage-hold"))).To(BeFalse())
+				})
+
+				It("should allow changes once released", func() {
+					Expect(ReleaseHold(held)).To(Succeed())
+					Expect(IsHeld(held)).To(BeFalse())
+					Expect(WriteFile(held, []byte("redacted"))).To(Succeed())
+					Expect(RemoveFile(held)).To(Succeed())
+				})
+
+				It("should not mind releasing a file twice", func() {
+					Expect(ReleaseHold(held)).To(Succeed())
+					Expect(ReleaseHold(held)).To(Succeed())
+				})
+
+				It("should leave other files alone", func() {
+					other := filepath.Join(caseDir, "notes.txt")
+					createTestFile(other, "x")
+					Expect(RemoveFile(other)).To(Succeed())
+				})
+			})
+		}
+
+		It("should only place holds where they are enforced", func() {
+			outside := filepath.Join(tempDir, "loose.eml")
+			createTestFile(outside, "x")
+			Expect(PlaceHold(outside)).To(MatchError(ErrHoldsDisabled))
+			Expect(RemoveFile(outside)).To(Succeed())
+		})
+	})
+
+	Describe("QuarantineArea", func() {
+		var area *QuarantineArea
+		BeforeEach(func() {
+			var err error
+			area, err = NewQuarantineArea(filepath.Join(tempDir, "quarantine"))
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should hold files until released", func() {
+			suspect := filepath.Join(tempDir, "inbox", "suspect.exe")
+			createTestDir(filepath.Dir(suspect))
+			createTestFile(suspect, "payload")
+
+			id, err := area.Quarantine(suspect, "manual review")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fileExists(suspect)).To(BeFalse())
+
+			files, err := area.ListQuarantined()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(files).To(HaveLen(1))
+			Expect(files[0].ID).To(Equal(id))
+			Expect(files[0].OriginalPath).To(Equal(suspect))
+			Expect(files[0].Reason).To(Equal("manual review"))
+			Expect(files[0].Size).To(Equal(int64(7)))
+
+			Expect(area.Release(id)).To(Succeed())
+			Expect(readFileContent(suspect)).To(Equal("payload"))
+			files, err = area.ListQuarantined()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(files).To(BeEmpty())
+		})
+
+		It("should refuse to release over a newer file", func() {
+			suspect := filepath.Join(tempDir, "suspect.txt")
+			createTestFile(suspect, "old")
+			id, err := area.Quarantine(suspect, "review")
+			Expect(err).NotTo(HaveOccurred())
+			createTestFile(suspect, "new")
+
+			Expect(area.Release(id)).To(MatchError(fs.ErrExist))
+			Expect(readFileContent(suspect)).To(Equal("new"))
+		})
+
+		It("should deny and purge files", func() {
+			for _, name := range []string{"a.txt", "b.txt"} {
+				createTestFile(filepath.Join(tempDir, name), name)
+			}
+			idA, err := area.Quarantine(filepath.Join(tempDir, "a.txt"), "bad")
+			Expect(err).NotTo(HaveOccurred())
+			_, err = area.Quarantine(filepath.Join(tempDir, "b.txt"), "bad")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(area.Deny(idA)).To(Succeed())
+			Expect(area.Deny(idA)).To(MatchError(ErrNotQuarantined))
+
+			purged, err := area.Purge(time.Hour)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(purged).To(BeZero())
+
+			purged, err = area.Purge(0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(purged).To(Equal(1))
+			Expect(area.ListQuarantined()).To(BeEmpty())
+		})
+
+		It("should receive files rejected on ingest", func() {
+			rejectAll := ScannerFunc(func(path string) error {
+				return fmt.Errorf("%w: test signature", ErrRejected)
+			})
+			dst := filepath.Join(tempDir, "upload.bin")
+
+			err := WriteFileWithOptions(dst, []byte("payload"), WriteOptions{Scanner: rejectAll, Quarantine: area})
+			Expect(err).To(MatchError(ErrRejected))
+			Expect(fileExists(dst)).To(BeFalse())
+
+			files, err := area.ListQuarantined()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(files).To(HaveLen(1))
+			Expect(files[0].OriginalPath).To(Equal(dst))
+			Expect(files[0].Reason).To(ContainSubstring("test signature"))
+
+			Expect(area.Release(files[0].ID)).To(Succeed())
+			Expect(readFileContent(dst)).To(Equal("payload"))
+		})
+	})
+
+	Describe("Context-aware operations", func() {
+		var srcDir, srcFile string
+		var cancelled context.Context
+		BeforeEach(func() {
+			srcDir = filepath.Join(tempDir, "ctx_src")
+			createTestDir(filepath.Join(srcDir, "nested"))
+			srcFile = filepath.Join(srcDir, "file.txt")
+			createTestFile(srcFile, "content")
+			createTestFile(filepath.Join(srcDir, "nested", "inner.txt"), "inner")
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			cancelled = ctx
+		})
+
+		It("should behave like the plain variants with a live context", func() {
+			ctx := context.Background()
+			Expect(CopyFileCtx(ctx, srcFile, filepath.Join(tempDir, "copy.txt"))).To(Succeed())
+			Expect(CopyDirCtx(ctx, srcDir, filepath.Join(tempDir, "dir_copy"))).To(Succeed())
+			Expect(WorkerPoolCopyDirCtx(ctx, srcDir, filepath.Join(tempDir, "pool_copy"), 2)).To(Succeed())
+			Expect(MoveFileCtx(ctx, filepath.Join(tempDir, "copy.txt"), filepath.Join(tempDir, "moved.txt"))).To(Succeed())
+			Expect(RemoveFileCtx(ctx, filepath.Join(tempDir, "moved.txt"))).To(Succeed())
+			Expect(RemoveDirAllCtx(ctx, filepath.Join(tempDir, "dir_copy"))).To(Succeed())
+
+			Expect(readFileContent(filepath.Join(tempDir, "pool_copy", "nested", "inner.txt"))).To(Equal("inner"))
+			Expect(fileExists(filepath.Join(tempDir, "moved.txt"))).To(BeFalse())
+			Expect(fileExists(filepath.Join(tempDir, "dir_copy"))).To(BeFalse())
+		})
+
+		It("should not copy files once cancelled", func() {
+			dst := filepath.Join(tempDir, "copy.txt")
+			Expect(CopyFileCtx(cancelled, srcFile, dst)).To(MatchError(context.Canceled))
+			Expect(fileExists(dst)).To(BeFalse())
+		})
+
+		It("should stop directory copies once cancelled", func() {
+			dst := filepath.Join(tempDir, "dir_copy")
+			Expect(CopyDirCtx(cancelled, srcDir, dst)).To(MatchError(context.Canceled))
+			Expect(fileExists(filepath.Join(dst, "file.txt"))).To(BeFalse())
+
+			poolDst := filepath.Join(tempDir, "pool_copy")
+			Expect(WorkerPoolCopyDirCtx(cancelled, srcDir, poolDst, 2)).To(MatchError(context.Canceled))
+			Expect(fileExists(filepath.Join(poolDst, "file.txt"))).To(BeFalse())
+		})
+
+		It("should keep a populated destination when a pool copy is cancelled", func() {
+			poolDst := filepath.Join(tempDir, "pool_copy")
+			createTestDir(poolDst)
+			createTestFile(filepath.Join(poolDst, "precious.txt"), "precious")
+
+			Expect(WorkerPoolCopyDirCtx(cancelled, srcDir, poolDst, 2)).To(MatchError(context.Canceled))
+			Expect(readFileContent(filepath.Join(poolDst, "precious.txt"))).To(Equal("precious"))
+		})
+
+		It("should only remove the directories a failed pool copy created", func() {
+			poolDst := filepath.Join(tempDir, "pool_copy")
+			createTestDir(poolDst)
+			createTestFile(filepath.Join(poolDst, "precious.txt"), "precious")
+			// A file where the source has a directory fails the layout pass
+			createTestFile(filepath.Join(poolDst, "nested"), "in the way")
+			createTestDir(filepath.Join(srcDir, "aaa", "deep"))
+			createTestFile(filepath.Join(srcDir, "aaa", "deep", "file.txt"), "deep")
+
+			Expect(WorkerPoolCopyDirCtx(context.Background(), srcDir, poolDst, 2)).NotTo(Succeed())
+			Expect(readFileContent(filepath.Join(poolDst, "precious.txt"))).To(Equal("precious"))
+			Expect(readFileContent(filepath.Join(poolDst, "nested"))).To(Equal("in the way"))
+			Expect(fileExists(filepath.Join(poolDst, "aaa"))).To(BeFalse())
+		})
+
+		It("should leave files in place once cancelled", func() {
+			Expect(MoveFileCtx(cancelled, srcFile, filepath.Join(tempDir, "moved.txt"))).To(MatchError(context.Canceled))
+			Expect(RemoveFileCtx(cancelled, srcFile)).To(MatchError(context.Canceled))
+			Expect(RemoveDirAllCtx(cancelled, srcDir)).To(MatchError(context.Canceled))
+			Expect(readFileContent(srcFile)).To(Equal("content"))
+		})
+	})
+	Describe("OpHandle", func() {
+		var srcDir string
+		BeforeEach(func() {
+			srcDir = filepath.Join(tempDir, "handle_src")
+			createTestDir(filepath.Join(srcDir, "nested"))
+			for i := 0; i < 5; i++ {
+				createTestFile(filepath.Join(srcDir, fmt.Sprintf("file%d.txt", i)), "content")
+			}
+			createTestFile(filepath.Join(srcDir, "nested", "inner.txt"), "inner")
+		})
 
-			hash, err := CalculateFileMD5(testFile)
+		// runPaused starts copy with a paused handle, checks it makes no
+		// progress, then resumes it and waits for it to finish
+		runPaused := func(dst string, copy func(*OpHandle) error) {
+			handle := &OpHandle{}
+			handle.Pause()
+			Expect(handle.Paused()).To(BeTrue())
 
-			Expect(err).NotTo(HaveOccurred())
-			Expect(hash).To(Equal(expectedHash))
+			done := make(chan error, 1)
+			go func() { done <- copy(handle) }()
+			Consistently(done, 100*time.Millisecond).ShouldNot(Receive())
+			Expect(fileExists(filepath.Join(dst, "nested", "inner.txt"))).To(BeFalse())
+
+			handle.Resume()
+			Expect(handle.Paused()).To(BeFalse())
+			Eventually(done).Should(Receive(BeNil()))
+			Expect(readFileContent(filepath.Join(dst, "nested", "inner.txt"))).To(Equal("inner"))
+		}
+
+		It("should pause and resume CopyDirWithOptions", func() {
+			dst := filepath.Join(tempDir, "dir_copy")
+			runPaused(dst, func(h *OpHandle) error {
+				return CopyDirWithOptions(srcDir, dst, CopyOptions{Handle: h})
+			})
+		})
+
+		It("should pause and resume WorkerPoolCopyDirWithOptions", func() {
+			dst := filepath.Join(tempDir, "pool_copy")
+			runPaused(dst, func(h *OpHandle) error {
+				return WorkerPoolCopyDirWithOptions(srcDir, dst, PoolOptions{Workers: 3, Handle: h})
+			})
+		})
+
+		It("should pause and resume SyncDir", func() {
+			dst := filepath.Join(tempDir, "sync_copy")
+			runPaused(dst, func(h *OpHandle) error {
+				_, err := SyncDir(srcDir, dst, SyncOptions{Copy: CopyOptions{Handle: h}})
+				return err
+			})
+		})
+
+		It("should still honour cancellation while paused", func() {
+			handle := &OpHandle{}
+			handle.Pause()
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error, 1)
+			go func() {
+				done <- WorkerPoolCopyDirWithOptionsCtx(ctx, srcDir, filepath.Join(tempDir, "pool_copy"), PoolOptions{Workers: 2, Handle: handle})
+			}()
+			Consistently(done, 50*time.Millisecond).ShouldNot(Receive())
+			cancel()
+			Eventually(done).Should(Receive(MatchError(context.Canceled)))
+		})
+
+		It("should report live statistics for the worker pool", func() {
+			handle := &OpHandle{}
+			Expect(handle.Stats()).To(Equal(OpStats{}))
+			handle.Pause()
+
+			dst := filepath.Join(tempDir, "pool_copy")
+			done := make(chan error, 1)
+			go func() {
+				done <- WorkerPoolCopyDirWithOptions(srcDir, dst, PoolOptions{Workers: 3, Handle: handle})
+			}()
+
+			// Paused workers each hold one job; the rest wait in the queue
+			Eventually(func() int { return handle.Stats().Queued }).Should(Equal(3))
+			stats := handle.Stats()
+			Expect(stats.Op).To(Equal("WorkerPoolCopyDir"))
+			Expect(stats.Files).To(BeZero())
+			Expect(stats.Workers).To(HaveLen(3))
+			for _, w := range stats.Workers {
+				Expect(w.File).To(BeEmpty())
+			}
+
+			handle.Resume()
+			Eventually(done).Should(Receive(BeNil()))
+			stats = handle.Stats()
+			Expect(stats.Files).To(Equal(int64(6)))
+			Expect(stats.Bytes).To(Equal(int64(5*len("content") + len("inner"))))
+			Expect(stats.Queued).To(BeZero())
+			Expect(stats.Elapsed).To(BeNumerically(">", 0))
+
+			var files int64
+			for _, w := range stats.Workers {
+				Expect(w.File).To(BeEmpty())
+				files += w.Files
+			}
+			Expect(files).To(Equal(int64(6)))
+		})
+
+		It("should report statistics for single-worker operations", func() {
+			handle := &OpHandle{}
+			Expect(CopyDirWithOptions(srcDir, filepath.Join(tempDir, "dir_copy"), CopyOptions{Handle: handle})).To(Succeed())
+			stats := handle.Stats()
+			Expect(stats.Op).To(Equal("CopyDir"))
+			Expect(stats.Files).To(Equal(int64(6)))
+			Expect(stats.Workers).To(ConsistOf(WorkerStats{ID: 1, Files: 6, Bytes: stats.Bytes}))
+
+			Expect(CreateZip(srcDir, filepath.Join(tempDir, "out.zip"), ArchiveOptions{Handle: handle})).To(Succeed())
+			Expect(handle.Stats().Op).To(Equal("CreateZip"))
+			Expect(handle.Stats().Files).To(Equal(int64(6)))
+		})
+
+		It("should ignore repeated calls", func() {
+			handle := &OpHandle{}
+			handle.Resume()
+			handle.Pause()
+			handle.Pause()
+			Expect(handle.Paused()).To(BeTrue())
+			handle.Resume()
+			handle.Resume()
+			Expect(handle.Paused()).To(BeFalse())
 		})
 	})
-	Describe("Concurrency & Progress operations", func() {
-		Describe("CopyFileWithProgress", func() {
-			It("should copy file and report progress", func() {
-				srcFile := filepath.Join(tempDir, "progress_src.txt")
-				dstFile := filepath.Join(tempDir, "progress_dst.txt")
-				bytesToWrite := 1000
+	Describe("Storage backends", func() {
+		var local LocalBackend
+		var bucket *S3Backend
+		var fake *fakeS3
+		var server *httptest.Server
 
-				content := strings.Repeat("A", bytesToWrite) //1000 bytes
-				createTestFile(srcFile, content)
+		BeforeEach(func() {
+			local = LocalBackend{Root: tempDir}
+			fake, server = newFakeS3("test-bucket")
+			bucket = &S3Backend{
+				Endpoint:  server.URL,
+				Region:    "us-east-1",
+				Bucket:    "test-bucket",
+				AccessKey: "AKIDEXAMPLE",
+				SecretKey: "secret",
+			}
+		})
 
-				progressChan, err := CopyFileWithProgress(srcFile, dstFile, 100)
-				Expect(err).NotTo(HaveOccurred())
+		AfterEach(func() {
+			server.Close()
+		})
 
-				var totalBytes int64
-				var progressUpdates []int64
+		It("should resolve local names under Root", func() {
+			w, err := local.Create("a/b/c.txt")
+			Expect(err).NotTo(HaveOccurred())
+			_, err = io.WriteString(w, "hello")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(w.Close()).To(Succeed())
 
-				for bytes := range progressChan {
-					totalBytes += bytes
-					progressUpdates = append(progressUpdates, bytes)
+			Expect(readFileContent(filepath.Join(tempDir, "a", "b", "c.txt"))).To(Equal("hello"))
+			info, err := local.Stat("../../a/b/c.txt")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Size()).To(Equal(int64(5)))
+		})
+
+		It("should store, list, stat, rename and remove objects", func() {
+			w, err := bucket.Create("docs/report.txt")
+			Expect(err).NotTo(HaveOccurred())
+			_, err = io.WriteString(w, "quarterly numbers")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(w.Close()).To(Succeed())
+			Expect(fake.objects).To(HaveKeyWithValue("docs/report.txt", []byte("quarterly numbers")))
+
+			info, err := bucket.Stat("docs/report.txt")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Size()).To(Equal(int64(17)))
+			Expect(info.IsDir()).To(BeFalse())
+
+			info, err = bucket.Stat("docs")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.IsDir()).To(BeTrue())
+
+			entries, err := bucket.List("")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries).To(HaveLen(1))
+			Expect(entries[0].Name()).To(Equal("docs"))
+			Expect(entries[0].IsDir()).To(BeTrue())
+
+			Expect(bucket.Rename("docs/report.txt", "archive/report.txt")).To(Succeed())
+			Expect(fake.objects).NotTo(HaveKey("docs/report.txt"))
+
+			r, err := bucket.Open("archive/report.txt")
+			Expect(err).NotTo(HaveOccurred())
+			data, err := io.ReadAll(r)
+			r.Close()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal("quarterly numbers"))
+
+			Expect(bucket.Remove("archive/report.txt")).To(Succeed())
+			_, err = bucket.Stat("archive/report.txt")
+			Expect(errors.Is(err, fs.ErrNotExist)).To(BeTrue())
+		})
+
+		It("should keep the source when a rename's copy fails after the 200 status", func() {
+			fake.objects["docs/report.txt"] = []byte("only copy")
+			fake.copyError = true
+
+			err := bucket.Rename("docs/report.txt", "archive/report.txt")
+			var s3err *S3Error
+			Expect(errors.As(err, &s3err)).To(BeTrue())
+			Expect(s3err.Code).To(Equal("InternalError"))
+			Expect(fake.objects).To(HaveKeyWithValue("docs/report.txt", []byte("only copy")))
+		})
+
+		It("should report missing objects as fs.ErrNotExist", func() {
+			_, err := bucket.Open("missing.txt")
+			Expect(errors.Is(err, fs.ErrNotExist)).To(BeTrue())
+			var s3err *S3Error
+			Expect(errors.As(err, &s3err)).To(BeTrue())
+			Expect(s3err.Code).To(Equal("NoSuchKey"))
+		})
+
+		It("should copy trees between local storage and a bucket", func() {
+			srcDir := filepath.Join(tempDir, "upload")
+			createTestDir(filepath.Join(srcDir, "nested"))
+			createTestFile(filepath.Join(srcDir, "top.txt"), "top")
+			createTestFile(filepath.Join(srcDir, "nested", "inner.txt"), "inner")
+
+			Expect(CopyDirBetween(local, "upload", bucket, "backup")).To(Succeed())
+			Expect(fake.objects).To(HaveKeyWithValue("backup/top.txt", []byte("top")))
+			Expect(fake.objects).To(HaveKeyWithValue("backup/nested/inner.txt", []byte("inner")))
+
+			Expect(CopyDirBetween(bucket, "backup", local, "restored")).To(Succeed())
+			Expect(readFileContent(filepath.Join(tempDir, "restored", "top.txt"))).To(Equal("top"))
+			Expect(readFileContent(filepath.Join(tempDir, "restored", "nested", "inner.txt"))).To(Equal("inner"))
+		})
+
+		Context("when the bucket is mounted", func() {
+			BeforeEach(func() {
+				Expect(Mount("s3://test-bucket/", bucket)).To(Succeed())
+				DeferCleanup(Unmount, "s3://test-bucket")
+			})
+
+			It("should run the package functions on the bucket", func() {
+				photo := filepath.Join(tempDir, "photo.jpg")
+				createTestFile(photo, "jpeg")
+
+				Expect(CopyFile(photo, "s3://test-bucket/2024/photo.jpg")).To(Succeed())
+				Expect(fake.objects).To(HaveKeyWithValue("2024/photo.jpg", []byte("jpeg")))
+				Expect(Exists("s3://test-bucket/2024/photo.jpg")).To(BeTrue())
+				Expect(DirExists("s3://test-bucket/2024")).To(BeTrue())
+				Expect(Exists("s3://test-bucket/2025/photo.jpg")).To(BeFalse())
+				Expect(GetFileSize("s3://test-bucket/2024/photo.jpg")).To(Equal(int64(4)))
+				Expect(ReadFile("s3://test-bucket/2024/photo.jpg")).To(Equal([]byte("jpeg")))
+
+				Expect(WriteFile("s3://test-bucket/notes.txt", []byte("notes"))).To(Succeed())
+				entries, err := ListDir("s3://test-bucket")
+				Expect(err).NotTo(HaveOccurred())
+				names := []string{}
+				for _, entry := range entries {
+					names = append(names, entry.Name())
 				}
-				// Verify copy complete
-				Expect(FileExists(dstFile)).To(BeTrue())
-				Expect(readFileContent(dstFile)).To(Equal(content))
+				Expect(names).To(ConsistOf("2024", "notes.txt"))
 
-				//Verify progress updates
-				Expect(len(progressUpdates)).To(BeNumerically(">", 0))
-				Expect(totalBytes).To(Equal(int64(bytesToWrite)))
+				Expect(MoveFile("s3://test-bucket/notes.txt", "s3://test-bucket/old/notes.txt")).To(Succeed())
+				Expect(fake.objects).NotTo(HaveKey("notes.txt"))
+				Expect(MoveFile("s3://test-bucket/old/notes.txt", filepath.Join(tempDir, "notes.txt"))).To(Succeed())
+				Expect(fake.objects).NotTo(HaveKey("old/notes.txt"))
+				Expect(readFileContent(filepath.Join(tempDir, "notes.txt"))).To(Equal("notes"))
+
+				Expect(RemoveFile("s3://test-bucket/2024/photo.jpg")).To(Succeed())
+				Expect(RemoveFile("s3://test-bucket/2024/photo.jpg")).To(Succeed())
+				Expect(fake.objects).To(BeEmpty())
+			})
+
+			It("should copy trees to and from the bucket", func() {
+				srcDir := filepath.Join(tempDir, "upload")
+				createTestDir(filepath.Join(srcDir, "nested"))
+				createTestFile(filepath.Join(srcDir, "nested", "inner.txt"), "inner")
+
+				Expect(CopyDir(srcDir, "s3://test-bucket/backup")).To(Succeed())
+				Expect(fake.objects).To(HaveKeyWithValue("backup/nested/inner.txt", []byte("inner")))
+
+				restored := filepath.Join(tempDir, "restored")
+				Expect(CopyDir("s3://test-bucket/backup", restored)).To(Succeed())
+				Expect(readFileContent(filepath.Join(restored, "nested", "inner.txt"))).To(Equal("inner"))
+			})
+
+			It("should leave paths outside the mount local", func() {
+				src := filepath.Join(tempDir, "local.txt")
+				createTestFile(src, "local")
+
+				Expect(CopyFile(src, filepath.Join(tempDir, "copy.txt"))).To(Succeed())
+				Expect(readFileContent(filepath.Join(tempDir, "copy.txt"))).To(Equal("local"))
+				Expect(fake.objects).To(BeEmpty())
+			})
+
+			It("should refuse an empty prefix", func() {
+				Expect(Mount("", bucket)).To(MatchError(fs.ErrInvalid))
+				Expect(Mount("/", bucket)).To(MatchError(fs.ErrInvalid))
 			})
 		})
 	})
-	Describe("WorkerPoolCopyDir", func() {
-		var srcDir, dstDir string
+	Describe("PreviewCache", func() {
+		var cache *PreviewCache
+		var imagePath string
 		BeforeEach(func() {
-			srcDir = filepath.Join(tempDir, "pool_src")
-			dstDir = filepath.Join(tempDir, "pool_dst")
-			//create source directory
-			createTestDir(srcDir)
+			cache = NewPreviewCache(filepath.Join(tempDir, "previews"), 64)
 
-			for i := 0; i < 10; i++ {
-				filename := fmt.Sprintf("file_%d.txt", i)
-				content := fmt.Sprintf("Content of file %d", i)
-				createTestFile(filepath.Join(srcDir, filename), content)
+			img := image.NewRGBA(image.Rect(0, 0, 200, 100))
+			for y := 0; y < 100; y++ {
+				for x := 0; x < 200; x++ {
+					img.Set(x, y, color.RGBA{R: 255, A: 255})
+				}
 			}
-			// Create subdirectory
-			subDir := filepath.Join(dstDir, "subdir")
-			createTestDir(subDir)
-			createTestFile(filepath.Join(subDir, "nested.txt"), "nested content")
+			var buf bytes.Buffer
+			Expect(png.Encode(&buf, img)).To(Succeed())
+			imagePath = filepath.Join(tempDir, "photo.png")
+			Expect(os.WriteFile(imagePath, buf.Bytes(), 0644)).To(Succeed())
 		})
-		It("should copy directory using worker pool", func() {
 
-			err := WorkerPoolCopyDir(srcDir, dstDir, 3) // 3 workers
+		decode := func(path string) image.Image {
+			file, err := os.Open(path)
+			Expect(err).NotTo(HaveOccurred())
+			defer file.Close()
+			img, err := png.Decode(file)
 			Expect(err).NotTo(HaveOccurred())
+			return img
+		}
 
-			entries, err := ListDir(srcDir)
+		It("should generate a scaled thumbnail and reuse it", func() {
+			preview, err := cache.Preview(imagePath)
 			Expect(err).NotTo(HaveOccurred())
 
-			for _, entry := range entries {
-				if entry.IsDir() {
-					Expect(FileExists(filepath.Join(dstDir, entry.Name()))).To(BeTrue())
-				} else {
-					//Check file
-					srcPath := filepath.Join(srcDir, entry.Name())
-					dstPath := filepath.Join(dstDir, entry.Name())
-					Expect(FileExists(dstPath)).To(BeTrue())
-					Expect(readFileContent(dstPath)).To(Equal(readFileContent(srcPath)))
-				}
-			}
+			img := decode(preview)
+			Expect(img.Bounds().Dx()).To(Equal(64))
+			Expect(img.Bounds().Dy()).To(Equal(32))
+			r, g, _, _ := img.At(10, 10).RGBA()
+			Expect(r).To(Equal(uint32(0xffff)))
+			Expect(g).To(BeZero())
 
+			again, err := cache.Preview(imagePath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(again).To(Equal(preview))
 		})
-		It("should handle errors from workers", func() {
-			// Create a file with restricted permissions to trigger an error
-			restrictedFile := filepath.Join(srcDir, "restricted.txt")
-			createTestFile(restrictedFile, "restricted")
-			err := os.Chmod(restrictedFile, 0000)
+
+		It("should reject types without a generator", func() {
+			textFile := filepath.Join(tempDir, "notes.txt")
+			createTestFile(textFile, "plain text")
+			_, err := cache.Preview(textFile)
+			Expect(err).To(MatchError(ErrNoPreviewGenerator))
+		})
+
+		It("should use registered generators", func() {
+			textFile := filepath.Join(tempDir, "notes.txt")
+			createTestFile(textFile, "plain text")
+			cache.RegisterGenerator("text/plain", func(path string, maxDim int) (image.Image, error) {
+				return image.NewGray(image.Rect(0, 0, maxDim, maxDim)), nil
+			})
+
+			preview, err := cache.Preview(textFile)
 			Expect(err).NotTo(HaveOccurred())
+			Expect(decode(preview).Bounds().Dx()).To(Equal(64))
+		})
+	})
+	Describe("MetadataCache", func() {
+		var dir, file string
+		var cache *MetadataCache
+		BeforeEach(func() {
+			dir = filepath.Join(tempDir, "cached")
+			file = filepath.Join(dir, "a.txt")
+			createTestDir(dir)
+			createTestFile(file, "first")
+			cache = NewMetadataCache(time.Hour)
+		})
 
-			// Restore permissions after test
-			defer func() {
-				os.Chmod(restrictedFile, 0644)
-			}()
+		It("should serve repeated lookups from the cache", func() {
+			entries, err := cache.ListDir(dir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries).To(HaveLen(1))
+			hash, err := cache.CalculateFileMD5(file)
+			Expect(err).NotTo(HaveOccurred())
+			exists, err := cache.FileExists(filepath.Join(dir, "b.txt"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(exists).To(BeFalse())
 
-			err = WorkerPoolCopyDir(srcDir, dstDir, 2)
+			createTestFile(filepath.Join(dir, "b.txt"), "second")
+			createTestFile(file, "changed")
 
-			Expect(err).To(HaveOccurred())
+			entries, _ = cache.ListDir(dir)
+			Expect(entries).To(HaveLen(1))
+			Expect(cache.CalculateFileMD5(file)).To(Equal(hash))
+			Expect(cache.FileExists(filepath.Join(dir, "b.txt"))).To(BeFalse())
 		})
 
-		It("should fail when source is not a directory", func() {
-			srcFile := filepath.Join(tempDir, "notadir.txt")
-			createTestFile(srcFile, "content")
-			err := WorkerPoolCopyDir(srcFile, dstDir, 2)
-			Expect(err).To(HaveOccurred())
+		It("should reload entries after Invalidate", func() {
+			hash, _ := cache.CalculateFileMD5(file)
+			cache.ListDir(dir)
+			cache.FileExists(filepath.Join(dir, "b.txt"))
+
+			createTestFile(filepath.Join(dir, "b.txt"), "second")
+			createTestFile(file, "changed")
+			cache.Invalidate(file)
+			cache.Invalidate(filepath.Join(dir, "b.txt"))
+
+			Expect(cache.CalculateFileMD5(file)).NotTo(Equal(hash))
+			Expect(cache.FileExists(filepath.Join(dir, "b.txt"))).To(BeTrue())
+			entries, _ := cache.ListDir(dir)
+			Expect(entries).To(HaveLen(2))
 		})
 
-		It("should fail when source does not exist", func() {
-			nonExistentSrc := filepath.Join(tempDir, "nonexistent")
-			err := WorkerPoolCopyDir(nonExistentSrc, dstDir, 2)
-			Expect(err).To(HaveOccurred())
+		It("should expire entries after the TTL", func() {
+			cache = NewMetadataCache(20 * time.Millisecond)
+			cache.ListDir(dir)
+			createTestFile(filepath.Join(dir, "b.txt"), "second")
+
+			Eventually(func() int {
+				entries, _ := cache.ListDir(dir)
+				return len(entries)
+			}).Should(Equal(2))
 		})
 
-		It("should fail when destination is a file", func() {
-			destFile := filepath.Join(tempDir, "destfile.txt")
-			createTestFile(destFile, "existing")
-			err := WorkerPoolCopyDir(srcDir, destFile, 2)
+		It("should not cache errors other than missing files", func() {
+			_, err := cache.ListDir(filepath.Join(tempDir, "nonexistent"))
 			Expect(err).To(HaveOccurred())
+			createTestDir(filepath.Join(tempDir, "nonexistent"))
+			_, err = cache.ListDir(filepath.Join(tempDir, "nonexistent"))
+			Expect(err).NotTo(HaveOccurred())
 		})
+	})
+	Describe("Prefetch", func() {
+		It("should read every file in the background and signal completion", func() {
+			var paths []string
+			for i := 0; i < 10; i++ {
+				path := filepath.Join(tempDir, fmt.Sprintf("warm_%d.txt", i))
+				createTestFile(path, strings.Repeat("w", 4096))
+				paths = append(paths, path)
+			}
+			paths = append(paths, filepath.Join(tempDir, "nonexistent.txt"))
 
-		It("should work with existing destination directory", func() {
-			existingDstDir := filepath.Join(tempDir, "existing_pool_dst")
-			createTestDir(existingDstDir)
-			err := WorkerPoolCopyDir(srcDir, existingDstDir, 3)
+			done := Prefetch(paths...)
+			Eventually(done).Should(BeClosed())
+
+			data, err := ReadFile(paths[0])
 			Expect(err).NotTo(HaveOccurred())
-			Expect(fileExists(existingDstDir)).To(BeTrue())
+			Expect(data).To(HaveLen(4096))
 		})
 
-		It("should work with single worker", func() {
-			err := WorkerPoolCopyDir(srcDir, dstDir, 1)
+		It("should complete immediately with no paths", func() {
+			Eventually(Prefetch()).Should(BeClosed())
+		})
+	})
+	Describe("RunBenchmark", func() {
+		It("should measure every throughput and clean up after itself", func() {
+			result, err := RunBenchmark(tempDir, BenchmarkOptions{
+				FileSize:      256 * 1024,
+				SmallFiles:    20,
+				SmallFileSize: 128,
+				Workers:       []int{1, 2},
+			})
 			Expect(err).NotTo(HaveOccurred())
+			Expect(result.SequentialCopy).To(BeNumerically(">", 0))
+			Expect(result.Hash).To(BeNumerically(">", 0))
+			Expect(result.SmallFileOps).To(BeNumerically(">", 0))
+			Expect(result.ParallelCopy).To(HaveLen(2))
+			Expect(result.ParallelCopy[2]).To(BeNumerically(">", 0))
 
-			entries, err := ListDir(srcDir)
+			entries, err := os.ReadDir(tempDir)
 			Expect(err).NotTo(HaveOccurred())
-			for _, entry := range entries {
-				if !entry.IsDir() {
-					dstPath := filepath.Join(dstDir, entry.Name())
-					Expect(FileExists(dstPath)).To(BeTrue())
-				}
-			}
+			Expect(entries).To(BeEmpty())
 		})
 
-		It("should work with many workers", func() {
-			err := WorkerPoolCopyDir(srcDir, dstDir, 16)
+		It("should measure each worker count once", func() {
+			result, err := RunBenchmark(tempDir, BenchmarkOptions{
+				FileSize:      1024,
+				SmallFiles:    5,
+				SmallFileSize: 16,
+				Workers:       []int{2, 2, 0, 1},
+			})
 			Expect(err).NotTo(HaveOccurred())
+			Expect(result.ParallelCopy).To(HaveLen(2))
+			Expect(result.ParallelCopy).To(HaveKey(1))
+			Expect(result.ParallelCopy).To(HaveKey(2))
+		})
 
-			entries, err := ListDir(srcDir)
-			Expect(err).NotTo(HaveOccurred())
-			for _, entry := range entries {
-				if !entry.IsDir() {
-					dstPath := filepath.Join(dstDir, entry.Name())
-					Expect(FileExists(dstPath)).To(BeTrue())
-				}
-			}
+		It("should fail when the target does not exist", func() {
+			_, err := RunBenchmark(filepath.Join(tempDir, "nonexistent"), BenchmarkOptions{})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Describe("Logging", func() {
+		var buf *bytes.Buffer
+		var srcDir, dstDir string
+		BeforeEach(func() {
+			buf = &bytes.Buffer{}
+			srcDir = filepath.Join(tempDir, "log_src")
+			dstDir = filepath.Join(tempDir, "log_dst")
+			createTestDir(srcDir)
+			createTestFile(filepath.Join(srcDir, "a.txt"), "aaaa")
+			createTestFile(filepath.Join(srcDir, "b.txt"), "bb")
 		})
 
-		It("should handle deeply nested directories", func() {
-			nestedSrc := filepath.Join(tempDir, "nested_pool_src_v2")
-			nestedDst := filepath.Join(tempDir, "nested_pool_dst_v2")
-			createTestDir(filepath.Join(nestedSrc, "a", "b", "c"))
-			createTestDir(nestedDst) // Pre-create dst
-			createTestFile(filepath.Join(nestedSrc, "a", "file1.txt"), "content1")
-			createTestFile(filepath.Join(nestedSrc, "a", "b", "file2.txt"), "content2")
-			createTestFile(filepath.Join(nestedSrc, "a", "b", "c", "file3.txt"), "content3")
+		AfterEach(func() {
+			SetLogger(nil)
+		})
 
-			err := WorkerPoolCopyDir(nestedSrc, nestedDst, 4)
-			Expect(err).NotTo(HaveOccurred())
+		It("should emit one summary record and no per-file records at info level", func() {
+			SetLogger(slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelInfo})))
 
-			Expect(fileExists(filepath.Join(nestedDst, "a", "file1.txt"))).To(BeTrue())
-			Expect(fileExists(filepath.Join(nestedDst, "a", "b", "file2.txt"))).To(BeTrue())
-			Expect(fileExists(filepath.Join(nestedDst, "a", "b", "c", "file3.txt"))).To(BeTrue())
+			Expect(CopyDir(srcDir, dstDir)).To(Succeed())
+			output := buf.String()
+			Expect(strings.Count(output, "\n")).To(Equal(1))
+			Expect(output).To(ContainSubstring("msg=\"operation finished\" op=CopyDir files=2 bytes=6"))
+			Expect(output).To(ContainSubstring("errors=0"))
 		})
 
-		It("should handle many files with worker pool", func() {
-			manySrc := filepath.Join(tempDir, "many_pool_src")
-			manyDst := filepath.Join(tempDir, "many_pool_dst")
-			createTestDir(manySrc)
-			createTestDir(manyDst)
+		It("should log per-file activity at debug level", func() {
+			SetLogger(slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
 
-			// Create 100 files
-			for i := 0; i < 100; i++ {
-				createTestFile(filepath.Join(manySrc, fmt.Sprintf("file_%d.txt", i)), fmt.Sprintf("content %d", i))
+			createTestDir(dstDir)
+			Expect(WorkerPoolCopyDir(srcDir, dstDir, 2)).To(Succeed())
+			output := buf.String()
+			Expect(strings.Count(output, "successfully copied file")).To(Equal(2))
+			Expect(output).To(ContainSubstring("op=WorkerPoolCopyDir files=2 bytes=6"))
+		})
+
+		It("should count failures in the summary", func() {
+			SetLogger(slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelInfo})))
+
+			err := CopyDirWithOptions(srcDir, dstDir, CopyOptions{MaxTotalSize: 5})
+			Expect(err).To(HaveOccurred())
+			Expect(buf.String()).To(ContainSubstring("errors=1"))
+		})
+	})
+	Describe("Error handling guarantees", func() {
+		It("should never panic on I/O errors", func() {
+			missing := filepath.Join(tempDir, "missing", "nested", "path")
+			file := filepath.Join(tempDir, "regular.txt")
+			dir := filepath.Join(tempDir, "directory")
+			createTestFile(file, "content")
+			createTestDir(dir)
+			underFile := filepath.Join(file, "child")
+
+			calls := map[string]func(){
+				"CopyFile":             func() { CopyFile(missing, file) },
+				"CopyFile to dir":      func() { CopyFile(file, dir) },
+				"MoveFile":             func() { MoveFile(missing, underFile) },
+				"SafeMoveFile":         func() { SafeMoveFile(file, underFile) },
+				"Publish":              func() { Publish(file, []string{underFile}) },
+				"RemoveFile":           func() { RemoveFile(underFile) },
+				"RemoveFile dir":       func() { RemoveFile(dir) },
+				"ReadFile":             func() { ReadFile(dir) },
+				"WriteFile":            func() { WriteFile(dir, []byte("x")) },
+				"WriteFile under file": func() { WriteFile(filepath.Join(underFile, "x"), []byte("x")) },
+				"ListDir":              func() { ListDir(file) },
+				"CreateDir":            func() { CreateDir(underFile, false) },
+				"CreateDir recursive":  func() { CreateDir(underFile, true) },
+				"RemoveDir":            func() { RemoveDir(file) },
+				"RemoveDirAll":         func() { RemoveDirAll(underFile) },
+				"CopyDir":              func() { CopyDir(dir, underFile) },
+				"CopyDir from file":    func() { CopyDir(file, dir) },
+				"FileExists":           func() { FileExists(underFile) },
+				"GetFileSize":          func() { GetFileSize(missing) },
+				"CalculateFileMD5":     func() { CalculateFileMD5(dir) },
+				"CopyFileWithProgress": func() { CopyFileWithProgress(missing, underFile, 0) },
+				"WorkerPoolCopyDir":    func() { WorkerPoolCopyDir(dir, underFile, 2) },
+				"StatsByExtension":     func() { StatsByExtension(missing) },
+				"TopFiles":             func() { TopFiles(missing, 3, SortBySize) },
+				"NewestFileIn":         func() { NewestFileIn(file) },
+				"NextSequence":         func() { NextSequence(underFile) },
+				"AcquirePIDLock":       func() { AcquirePIDLock(underFile) },
+			}
+			for name, call := range calls {
+				Expect(call).NotTo(Panic(), name)
 			}
+		})
 
-			err := WorkerPoolCopyDir(manySrc, manyDst, 8)
-			Expect(err).NotTo(HaveOccurred())
+		It("should return typed errors that name the path", func() {
+			dir := filepath.Join(tempDir, "directory")
+			createTestDir(dir)
+			createTestFile(filepath.Join(dir, "file.txt"), "content")
 
-			// Verify a few files
-			Expect(fileExists(filepath.Join(manyDst, "file_0.txt"))).To(BeTrue())
-			Expect(fileExists(filepath.Join(manyDst, "file_50.txt"))).To(BeTrue())
-			Expect(fileExists(filepath.Join(manyDst, "file_99.txt"))).To(BeTrue())
+			err := RemoveFile(dir)
+			Expect(err).To(MatchError(ErrIsDirectory))
+			var pathErr *fs.PathError
+			Expect(errors.As(err, &pathErr)).To(BeTrue())
+			Expect(pathErr.Path).To(Equal(dir))
+
+			Expect(RemoveDir(dir)).To(MatchError(ErrDirectoryNotEmpty))
+			Expect(CopyDir(filepath.Join(dir, "file.txt"), tempDir)).To(MatchError(ErrNotDirectory))
 		})
 	})
 	// Integration Tests
@@ -0,0 +1,171 @@
+package gstorage
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// OpHandle controls a running operation from another goroutine. Pass the
+// same handle in the operation's options, e.g. CopyOptions.Handle or
+// PoolOptions.Handle, then call its methods while the operation runs. The
+// zero value is ready to use and a nil handle is never paused. A handle
+// follows one operation at a time; reusing it starts over.
+type OpHandle struct {
+	mu     sync.Mutex
+	paused bool
+	resume chan struct{}
+
+	summary *opSummary
+	queued  func() int
+	workers []WorkerStats
+}
+
+// OpStats is a snapshot of the operation followed by an OpHandle
+type OpStats struct {
+	Op      string
+	Files   int64 // files completed
+	Bytes   int64 // bytes of completed files
+	Errors  int64
+	Elapsed time.Duration
+
+	// Queued is the number of files found but not yet picked up by a
+	// worker. Only the worker pool queues files
+	Queued int
+
+	// Workers has one entry per worker; operations copying one file at a
+	// time report a single worker
+	Workers []WorkerStats
+}
+
+// WorkerStats is a snapshot of one worker of an operation
+type WorkerStats struct {
+	ID    int
+	File  string // source path being processed, empty when idle
+	Files int64
+	Bytes int64
+}
+
+// Pause stops the operation from starting any more files. Files already
+// being copied finish first, so the operation quiesces at a file boundary
+// shortly after Pause returns. Pausing a paused handle does nothing
+func (h *OpHandle) Pause() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.paused {
+		return
+	}
+	h.paused = true
+	h.resume = make(chan struct{})
+	logger().Debug("operation paused")
+}
+
+// Resume lets a paused operation continue where it stopped
+func (h *OpHandle) Resume() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.paused {
+		return
+	}
+	h.paused = false
+	close(h.resume)
+	logger().Debug("operation resumed")
+}
+
+// Paused reports whether the handle is paused
+func (h *OpHandle) Paused() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.paused
+}
+
+// wait blocks while the handle is paused. It returns the context's error
+// if ctx is cancelled while waiting, so a paused operation can still be
+// cancelled
+func (h *OpHandle) wait(ctx context.Context) error {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	paused, resume := h.paused, h.resume
+	h.mu.Unlock()
+	if !paused {
+		return nil
+	}
+
+	select {
+	case <-resume:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stats returns a snapshot of the operation's progress, safe to call while
+// it runs. Before any operation has started it returns the zero OpStats
+func (h *OpHandle) Stats() OpStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.summary == nil {
+		return OpStats{}
+	}
+
+	stats := OpStats{
+		Op:      h.summary.op,
+		Files:   h.summary.files.Load(),
+		Bytes:   h.summary.bytes.Load(),
+		Errors:  h.summary.errors.Load(),
+		Elapsed: time.Since(h.summary.start),
+		Workers: append([]WorkerStats(nil), h.workers...),
+	}
+	if h.queued != nil {
+		stats.Queued = h.queued()
+	}
+	return stats
+}
+
+// begin attaches the handle to an operation with the given number of
+// workers. queued, when set, reports how many files are waiting
+func (h *OpHandle) begin(summary *opSummary, workers int, queued func() int) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.summary = summary
+	h.queued = queued
+	h.workers = make([]WorkerStats, workers)
+	for i := range h.workers {
+		h.workers[i].ID = i + 1
+	}
+}
+
+// working records that worker id started on path
+func (h *OpHandle) working(id int, path string) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if id >= 1 && id <= len(h.workers) {
+		h.workers[id-1].File = path
+	}
+}
+
+// idle records that worker id finished its file, having copied size bytes
+// if done is set
+func (h *OpHandle) idle(id int, size int64, done bool) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if id >= 1 && id <= len(h.workers) {
+		w := &h.workers[id-1]
+		w.File = ""
+		if done {
+			w.Files++
+			w.Bytes += size
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package gstorage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// HashStore selects where a file's MD5 is recorded when it is written
+type HashStore int
+
+const (
+	// HashStoreNone does not record hashes
+	HashStoreNone HashStore = iota
+	// HashStoreXattr records the hash in the user.gstorage.md5 extended
+	// attribute of the file itself
+	HashStoreXattr
+	// HashStoreSidecar records the hash in a hidden ".<name>.gstorage-md5"
+	// file next to it, for filesystems without extended attributes
+	HashStoreSidecar
+)
+
+// ErrXattrUnsupported is returned when the platform or filesystem does not
+// support extended attributes
+var ErrXattrUnsupported = errors.New("extended attributes not supported")
+
+const (
+	hashXattrName     = "user.gstorage.md5"
+	hashSidecarSuffix = ".gstorage-md5"
+)
+
+// hashSidecarPath returns the sidecar file holding the hash of path
+func hashSidecarPath(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+hashSidecarSuffix)
+}
+
+// isSidecarName reports whether name is one of the hidden files this
+// package keeps next to the file they describe. They belong with that
+// file, so copies and syncs leave them out
+func isSidecarName(name string) bool {
+	return strings.HasPrefix(name, ".") &&
+		(strings.HasSuffix(name, hashSidecarSuffix) || strings.HasSuffix(name, holdSidecarSuffix))
+}
+
+// StoreFileMD5 records hash as the MD5 of path in store. The record carries
+// the file's current size and modification time, so it stops being trusted
+// as soon as the file changes
+func StoreFileMD5(path string, hash string, store HashStore) error {
+	stat, err := os.Stat(path)
+	if err != nil {
+		logger().Error("error reading file info", "path", path, "err", err)
+		return err
+	}
+
+	record := []byte(fmt.Sprintf("%s %d %d", hash, stat.Size(), stat.ModTime().UnixNano()))
+
+	switch store {
+	case HashStoreNone:
+		return nil
+	case HashStoreXattr:
+		err = setXattr(path, hashXattrName, record)
+	case HashStoreSidecar:
+		err = os.WriteFile(hashSidecarPath(path), record, 0644)
+	default:
+		err = fmt.Errorf("unknown hash store %d", store)
+	}
+
+	if err != nil {
+		logger().Error("error storing file hash", "path", path, "err", err)
+		return err
+	}
+	logger().Debug("stored file hash", "path", path, "md5", hash)
+	return nil
+}
+
+// StoredFileMD5 returns the MD5 recorded for path in store. ok is false
+// when nothing is recorded or the file has changed since it was recorded
+func StoredFileMD5(path string, store HashStore) (hash string, ok bool, err error) {
+	var record []byte
+
+	switch store {
+	case HashStoreNone:
+		return "", false, nil
+	case HashStoreXattr:
+		record, ok, err = getXattr(path, hashXattrName)
+		if err != nil || !ok {
+			return "", false, err
+		}
+	case HashStoreSidecar:
+		record, err = os.ReadFile(hashSidecarPath(path))
+		if os.IsNotExist(err) {
+			return "", false, nil
+		}
+		if err != nil {
+			return "", false, err
+		}
+	default:
+		return "", false, fmt.Errorf("unknown hash store %d", store)
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return "", false, err
+	}
+
+	fields := strings.Fields(string(record))
+	if len(fields) != 3 {
+		logger().Debug("ignoring malformed hash record", "path", path)
+		return "", false, nil
+	}
+	size, sizeErr := strconv.ParseInt(fields[1], 10, 64)
+	mtime, mtimeErr := strconv.ParseInt(fields[2], 10, 64)
+	if sizeErr != nil || mtimeErr != nil || size != stat.Size() || mtime != stat.ModTime().UnixNano() {
+		logger().Debug("ignoring stale hash record", "path", path)
+		return "", false, nil
+	}
+	return fields[0], true, nil
+}
+
+// CachedFileMD5 returns the MD5 of path, using the record in store when it
+// is still valid and hashing the file (and recording the result) otherwise
+func CachedFileMD5(path string, store HashStore) (string, error) {
+	hash, ok, err := StoredFileMD5(path, store)
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		return hash, nil
+	}
+
+	hash, err = CalculateFileMD5(path)
+	if err != nil {
+		return "", err
+	}
+	if err := StoreFileMD5(path, hash, store); err != nil {
+		return "", err
+	}
+	return hash, nil
+}
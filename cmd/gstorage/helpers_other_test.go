@@ -0,0 +1,9 @@
+//go:build !unix
+
+package gstorage_test
+
+import "errors"
+
+func mkfifo(path string) error {
+	return errors.New("fifos are not supported on this platform")
+}
@@ -0,0 +1,9 @@
+//go:build unix
+
+package gstorage_test
+
+import "syscall"
+
+func mkfifo(path string) error {
+	return syscall.Mkfifo(path, 0644)
+}
@@ -0,0 +1,206 @@
+package gstorage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrLegalHold is returned when an operation would overwrite or delete
+	// a file under legal hold
+	ErrLegalHold = errors.New("file is under legal hold")
+	// ErrHoldsDisabled is returned by PlaceHold for files outside every
+	// root passed to SetLegalHoldRoot, where holds would not be enforced
+	ErrHoldsDisabled = errors.New("legal holds are not enabled here")
+)
+
+const (
+	holdXattrName     = "user.gstorage.hold"
+	holdSidecarSuffix = ".gstorage-hold"
+)
+
+// holdXattrs is cleared by tests to record holds in sidecars, as on
+// filesystems without extended attributes
+var holdXattrs = true
+
+var (
+	holdMu    sync.RWMutex
+	holdRoots []string
+)
+
+// SetLegalHoldRoot enables legal holds below root for the rest of the
+// process. Holds are only placed and enforced below such roots, so
+// operations elsewhere never pay for looking them up. Every process
+// working on held files has to enable the same roots.
+func SetLegalHoldRoot(root string) error {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+
+	holdMu.Lock()
+	defer holdMu.Unlock()
+	for _, r := range holdRoots {
+		if r == abs {
+			return nil
+		}
+	}
+	holdRoots = append(holdRoots, abs)
+	logger().Info("legal hold root configured", "root", abs)
+	return nil
+}
+
+// underHoldRoot reports whether path is below a root with holds enabled
+func underHoldRoot(path string) bool {
+	holdMu.RLock()
+	defer holdMu.RUnlock()
+	if len(holdRoots) == 0 {
+		return false
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	for _, r := range holdRoots {
+		if withinRoot(r, abs) {
+			return true
+		}
+	}
+	return false
+}
+
+// holdSidecarPath returns the sidecar file marking path as held on
+// filesystems without extended attributes
+func holdSidecarPath(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+holdSidecarSuffix)
+}
+
+// holdSidecarSubject returns the file the hold sidecar path marks, if
+// path names a hold sidecar
+func holdSidecarSubject(path string) (string, bool) {
+	name, ok := strings.CutPrefix(filepath.Base(path), ".")
+	if !ok {
+		return "", false
+	}
+	name, ok = strings.CutSuffix(name, holdSidecarSuffix)
+	if !ok || name == "" {
+		return "", false
+	}
+	return filepath.Join(filepath.Dir(path), name), true
+}
+
+// PlaceHold puts path under legal hold: until ReleaseHold is called, this
+// package's operations refuse to overwrite, move or delete it, and refuse
+// to remove directories containing it. The hold is recorded with the file,
+// in the user.gstorage.hold extended attribute or a hidden
+// ".<name>.gstorage-hold" sidecar, so it persists across processes.
+// path must be below a root passed to SetLegalHoldRoot, or the error
+// wraps ErrHoldsDisabled.
+//
+// Like write-once roots, holds bind this package only, not the filesystem.
+func PlaceHold(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		logger().Error("error reading file info", "path", path, "err", err)
+		return err
+	}
+	if info.IsDir() {
+		return pathError("hold", path, ErrIsDirectory)
+	}
+	if !underHoldRoot(path) {
+		logger().Error("legal holds not enabled for path", "path", path)
+		return pathError("hold", path, ErrHoldsDisabled)
+	}
+
+	placed := []byte(time.Now().UTC().Format(time.RFC3339))
+	err = ErrXattrUnsupported
+	if holdXattrs {
+		err = setXattr(path, holdXattrName, placed)
+	}
+	if errors.Is(err, ErrXattrUnsupported) {
+		err = os.WriteFile(holdSidecarPath(path), placed, 0644)
+	}
+	if err != nil {
+		logger().Error("error placing legal hold", "path", path, "err", err)
+		return err
+	}
+	logger().Info("legal hold placed", "path", path)
+	return nil
+}
+
+// ReleaseHold lifts the legal hold on path. Releasing a file that is not
+// held is not an error
+func ReleaseHold(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		logger().Error("error reading file info", "path", path, "err", err)
+		return err
+	}
+
+	err := ErrXattrUnsupported
+	if holdXattrs {
+		err = removeXattr(path, holdXattrName)
+	}
+	if errors.Is(err, ErrXattrUnsupported) {
+		err = os.Remove(holdSidecarPath(path))
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	}
+	if err != nil {
+		logger().Error("error releasing legal hold", "path", path, "err", err)
+		return err
+	}
+	logger().Info("legal hold released", "path", path)
+	return nil
+}
+
+// IsHeld reports whether path is under legal hold
+func IsHeld(path string) (bool, error) {
+	var ok bool
+	err := ErrXattrUnsupported
+	if holdXattrs {
+		_, ok, err = getXattr(path, holdXattrName)
+	}
+	if errors.Is(err, ErrXattrUnsupported) {
+		_, err = os.Stat(holdSidecarPath(path))
+		ok = err == nil
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return ok, err
+}
+
+// checkHold fails if path is under legal hold, or is the sidecar keeping
+// a file that still exists under legal hold. Paths outside every hold root
+// are not looked at
+func checkHold(op string, path string) error {
+	if !underHoldRoot(path) {
+		return nil
+	}
+	if subject, ok := holdSidecarSubject(path); ok {
+		if _, err := os.Lstat(subject); err == nil {
+			logger().Warn("refusing to modify legal hold marker", "path", path, "op", op)
+			return pathError(op, path, ErrLegalHold)
+		}
+		return nil
+	}
+
+	held, err := IsHeld(path)
+	if err != nil {
+		return err
+	}
+	if held {
+		logger().Warn("refusing to modify file under legal hold", "path", path, "op", op)
+		return pathError(op, path, ErrLegalHold)
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package gstorage
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// ErrRejected is returned, wrapped with the scanner's reason, when a
+// Scanner refuses a file
+var ErrRejected = errors.New("file rejected by scanner")
+
+// Scanner inspects an incoming file before it reaches its final location.
+// Scan returns nil to accept the file, an error wrapping ErrRejected to
+// refuse it, or any other error if the scan itself failed. Both kinds of
+// error keep the file out: ingest fails closed
+type Scanner interface {
+	Scan(path string) error
+}
+
+// ScannerFunc adapts a function to the Scanner interface
+type ScannerFunc func(path string) error
+
+func (f ScannerFunc) Scan(path string) error {
+	return f(path)
+}
+
+// WriteFileFromReader streams r into dstFile, creating missing parent
+// directories. The content is staged in a hidden file next to dstFile and
+// only renamed into place once complete, so dstFile never holds a partial
+// upload. Set WriteOptions.Atomic as well to survive crashes
+func WriteFileFromReader(dstFile string, r io.Reader) error {
+	return WriteFileFromReaderWithOptions(dstFile, r, WriteOptions{})
+}
+
+// WriteFileFromReaderWithOptions writes r to dstFile like
+// WriteFileFromReader, applying opts. With a Scanner set, the staged file
+// is scanned before the rename and discarded if the scanner refuses it
+func WriteFileFromReaderWithOptions(dstFile string, r io.Reader, opts WriteOptions) error {
+	return writeStaged(dstFile, r, -1, opts)
+}
+
+// writeStaged implements the staged write shared by the reader and byte
+// slice entry points. expected is the content length if known, or -1
+func writeStaged(dstFile string, r io.Reader, expected int64, opts WriteOptions) error {
+	if err := checkOverwrite(dstFile); err != nil {
+		return err
+	}
+
+	dirpath := filepath.Dir(dstFile)
+	if err := os.MkdirAll(dirpath, 0755); err != nil {
+		logger().Error("unable to create path", "dir", dirpath, "err", err)
+		return err
+	}
+
+	// A unique staging name lets concurrent writers to the same target
+	// each finish cleanly; the last rename wins
+	file, err := os.CreateTemp(dirpath, "."+filepath.Base(dstFile)+".*.gstorage-ingest")
+	if err != nil {
+		logger().Error("error while creating destination", "dst", dstFile, "err", err)
+		return err
+	}
+	staged := file.Name()
+	discard := func() {
+		os.Remove(staged)
+	}
+
+	// Replacing a file keeps its permissions; new files get the usual 0644
+	mode := fs.FileMode(0644)
+	if existing, err := os.Stat(dstFile); err == nil {
+		mode = existing.Mode().Perm()
+	}
+	if err := file.Chmod(mode); err != nil {
+		file.Close()
+		discard()
+		return err
+	}
+
+	var sum hash.Hash
+	if opts.StoreHash != HashStoreNone {
+		sum = md5.New()
+		r = io.TeeReader(r, sum)
+	}
+
+	written, err := io.Copy(file, r)
+	if err != nil {
+		file.Close()
+		discard()
+		logger().Error("error while writing destination", "dst", dstFile, "err", err)
+		return err
+	}
+	if opts.Atomic {
+		if err := file.Sync(); err != nil {
+			file.Close()
+			discard()
+			logger().Error("error while syncing destination", "dst", dstFile, "err", err)
+			return err
+		}
+	}
+	if err := file.Close(); err != nil {
+		discard()
+		logger().Error("error while closing destination", "dst", dstFile, "err", err)
+		return err
+	}
+
+	if opts.VerifyLength {
+		stat, err := os.Stat(staged)
+		if err != nil {
+			discard()
+			return err
+		}
+		if stat.Size() != written || (expected >= 0 && written != expected) {
+			discard()
+			logger().Error("short write", "dst", dstFile, "want", written, "got", stat.Size())
+			return pathError("write", dstFile, io.ErrShortWrite)
+		}
+	}
+
+	if opts.Scanner != nil {
+		if err := opts.Scanner.Scan(staged); err != nil {
+			logger().Error("file refused on ingest", "dst", dstFile, "err", err)
+			if opts.Quarantine != nil && errors.Is(err, ErrRejected) {
+				id, qerr := opts.Quarantine.quarantineAs(staged, dstFile, err.Error())
+				if qerr == nil {
+					return pathError("scan", dstFile, fmt.Errorf("%w (quarantined as %s)", err, id))
+				}
+			}
+			discard()
+			return pathError("scan", dstFile, err)
+		}
+	}
+
+	if err := os.Rename(staged, dstFile); err != nil {
+		discard()
+		logger().Error("error while moving file into place", "dst", dstFile, "err", err)
+		return err
+	}
+	if opts.Atomic {
+		syncDir(dirpath)
+	}
+
+	if sum != nil {
+		return StoreFileMD5(dstFile, hex.EncodeToString(sum.Sum(nil)), opts.StoreHash)
+	}
+	return nil
+}
+
+// syncDir flushes the directory entry of a rename to disk. Platforms that
+// cannot open directories for syncing, such as Windows, are skipped
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		logger().Debug("unable to sync directory", "dir", dir, "err", err)
+	}
+}
+
+// writeStagedBytes is writeStaged for an in-memory payload
+func writeStagedBytes(dstFile string, content []byte, opts WriteOptions) error {
+	return writeStaged(dstFile, bytes.NewReader(content), int64(len(content)), opts)
+}
@@ -0,0 +1,227 @@
+package gstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errLockBusy is returned by lockFile when a non-blocking lock is unavailable
+var errLockBusy = errors.New("lock is held by another process")
+
+// ErrLocked is returned by TryLockFile when another holder has a
+// conflicting lock on the file
+var ErrLocked = errors.New("file is locked")
+
+// ErrAlreadyRunning is returned by AcquirePIDLock when another live process
+// holds the PID file
+var ErrAlreadyRunning = errors.New("another instance is already running")
+
+// PIDLock is a held PID file lock
+type PIDLock struct {
+	path string
+	file *os.File
+}
+
+// AcquirePIDLock writes the current PID to path while holding an exclusive
+// lock on it, ensuring a single running instance.
+//
+// The lock is released by the OS when its owner exits, so a PID file left
+// behind by a process that died is detected as stale and taken over. If the
+// owner is still alive the error wraps ErrAlreadyRunning and names its PID.
+func AcquirePIDLock(path string) (*PIDLock, error) {
+	var file *os.File
+	for {
+		var err error
+		file, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			logger().Error("error opening pid file", "path", path, "err", err)
+			return nil, err
+		}
+
+		if err := lockFile(file, true, false); err != nil {
+			file.Close()
+			if err == errLockBusy {
+				content, _ := os.ReadFile(path)
+				return nil, fmt.Errorf("%w: pid %s", ErrAlreadyRunning, strings.TrimSpace(string(content)))
+			}
+			return nil, err
+		}
+
+		// A releasing owner removes the file before unlocking it, so the
+		// file opened may have been removed, or replaced by the next
+		// owner's, by the time the lock is granted. Only a lock on the
+		// file still at path counts; otherwise start over with that one
+		current, err := lockedPathCurrent(file, path)
+		if err != nil {
+			unlockFile(file)
+			file.Close()
+			return nil, err
+		}
+		if current {
+			break
+		}
+		unlockFile(file)
+		file.Close()
+	}
+
+	content, _ := os.ReadFile(path)
+	if stale := strings.TrimSpace(string(content)); stale != "" {
+		logger().Info("taking over stale pid file", "path", path, "pid", stale)
+	}
+
+	if err := file.Truncate(0); err != nil {
+		unlockFile(file)
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		unlockFile(file)
+		file.Close()
+		return nil, err
+	}
+	if err := file.Sync(); err != nil {
+		unlockFile(file)
+		file.Close()
+		return nil, err
+	}
+
+	return &PIDLock{path: path, file: file}, nil
+}
+
+// lockedPathCurrent reports whether file is still the file at path
+func lockedPathCurrent(file *os.File, path string) (bool, error) {
+	locked, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return os.SameFile(locked, info), nil
+}
+
+// Release removes the PID file and releases the lock. The file is removed
+// while the lock is still held, so a process that opened it meanwhile sees
+// it gone once it gets the lock, and AcquirePIDLock starts over
+func (l *PIDLock) Release() error {
+	removeErr := os.Remove(l.path)
+	unlockFile(l.file)
+	closeErr := l.file.Close()
+	if removeErr != nil && !os.IsNotExist(removeErr) {
+		// Windows refuses to remove open files, so try again once closed
+		if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return closeErr
+}
+
+// LockMode selects between shared and exclusive file locks
+type LockMode int
+
+const (
+	// LockExclusive excludes every other lock on the file
+	LockExclusive LockMode = iota
+	// LockShared can be held by several holders at once, but excludes
+	// exclusive locks
+	LockShared
+)
+
+// lockRetryMax caps the wait between attempts of LockFile
+const lockRetryMax = 100 * time.Millisecond
+
+// Lock is a held advisory lock on a file, placed with flock on Unix and
+// LockFileEx on Windows. Advisory locks only coordinate processes that
+// take them; they do not stop anyone from reading or writing the file
+type Lock struct {
+	path string
+	mode LockMode
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+// LockFile locks path in mode, creating it if missing, and waits until the
+// lock is granted or ctx is done, returning ctx.Err() then. Use
+// context.WithTimeout to bound the wait
+func LockFile(ctx context.Context, path string, mode LockMode) (*Lock, error) {
+	wait := time.Millisecond
+	for {
+		lock, err := TryLockFile(path, mode)
+		if !errors.Is(err, ErrLocked) {
+			return lock, err
+		}
+
+		// Locks cannot be waited for and cancelled portably, so poll
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			logger().Debug("gave up waiting for lock", "path", path, "err", ctx.Err())
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		wait = min(wait*2, lockRetryMax)
+	}
+}
+
+// TryLockFile locks path in mode, creating it if missing, without waiting.
+// If the lock is held elsewhere it fails with ErrLocked
+func TryLockFile(path string, mode LockMode) (*Lock, error) {
+	flag := os.O_RDWR | os.O_CREATE
+	if mode == LockShared {
+		flag = os.O_RDONLY | os.O_CREATE
+	}
+	file, err := os.OpenFile(path, flag, 0644)
+	if err != nil {
+		logger().Error("error opening lock file", "path", path, "err", err)
+		return nil, err
+	}
+
+	if err := lockFile(file, mode == LockExclusive, false); err != nil {
+		file.Close()
+		if err == errLockBusy {
+			return nil, fmt.Errorf("%s: %w", path, ErrLocked)
+		}
+		logger().Error("error locking file", "path", path, "err", err)
+		return nil, err
+	}
+	return &Lock{path: path, mode: mode, file: file}, nil
+}
+
+// Path returns the locked file
+func (l *Lock) Path() string {
+	return l.path
+}
+
+// Mode returns whether the lock is shared or exclusive
+func (l *Lock) Mode() LockMode {
+	return l.mode
+}
+
+// Unlock releases the lock. The file itself is left in place. Unlocking
+// an already released lock does nothing
+func (l *Lock) Unlock() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	unlockErr := unlockFile(l.file)
+	closeErr := l.file.Close()
+	l.file = nil
+	if unlockErr != nil {
+		return unlockErr
+	}
+	return closeErr
+}
@@ -0,0 +1,16 @@
+//go:build !unix && !windows
+
+package gstorage
+
+import (
+	"errors"
+	"os"
+)
+
+func lockFile(f *os.File, exclusive bool, block bool) error {
+	return errors.New("file locking is not supported on this platform")
+}
+
+func unlockFile(f *os.File) error {
+	return errors.New("file locking is not supported on this platform")
+}
@@ -0,0 +1,36 @@
+//go:build unix
+
+package gstorage
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile places an advisory flock on f. When block is false and the lock
+// is held elsewhere it returns errLockBusy instead of waiting.
+func lockFile(f *os.File, exclusive bool, block bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	if !block {
+		how |= syscall.LOCK_NB
+	}
+
+	for {
+		err := syscall.Flock(int(f.Fd()), how)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err == syscall.EWOULDBLOCK {
+			return errLockBusy
+		}
+		return err
+	}
+}
+
+// unlockFile releases a lock placed by lockFile
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
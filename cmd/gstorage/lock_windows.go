@@ -0,0 +1,34 @@
+//go:build windows
+
+package gstorage
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockFile places a LockFileEx lock on f. When block is false and the lock
+// is held elsewhere it returns errLockBusy instead of waiting.
+func lockFile(f *os.File, exclusive bool, block bool) error {
+	var flags uint32
+	if exclusive {
+		flags |= windows.LOCKFILE_EXCLUSIVE_LOCK
+	}
+	if !block {
+		flags |= windows.LOCKFILE_FAIL_IMMEDIATELY
+	}
+
+	overlapped := new(windows.Overlapped)
+	err := windows.LockFileEx(windows.Handle(f.Fd()), flags, 0, 1, 0, overlapped)
+	if err == windows.ERROR_LOCK_VIOLATION {
+		return errLockBusy
+	}
+	return err
+}
+
+// unlockFile releases a lock placed by lockFile
+func unlockFile(f *os.File) error {
+	overlapped := new(windows.Overlapped)
+	return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, overlapped)
+}
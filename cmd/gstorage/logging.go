@@ -0,0 +1,80 @@
+package gstorage
+
+import (
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	logLevel      = new(slog.LevelVar)
+	defaultLogger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel}))
+	currentLogger atomic.Pointer[slog.Logger]
+)
+
+func init() {
+	currentLogger.Store(defaultLogger)
+}
+
+// SetLogLevel sets the minimum level written by the package's default
+// logger. Per-file activity is logged at slog.LevelDebug, one summary per
+// operation at slog.LevelInfo and failures at slog.LevelError. The default
+// level is slog.LevelInfo.
+func SetLogLevel(level slog.Level) {
+	logLevel.Set(level)
+}
+
+// SetLogger sends the package's log records to l instead of stderr. The
+// handler of l decides which levels are written. Passing nil restores the
+// default logger.
+func SetLogger(l *slog.Logger) {
+	if l == nil {
+		l = defaultLogger
+	}
+	currentLogger.Store(l)
+}
+
+func logger() *slog.Logger {
+	return currentLogger.Load()
+}
+
+// opSummary collects the totals of one operation so they can be logged as
+// a single record when it ends. Counters are safe for concurrent use.
+type opSummary struct {
+	op     string
+	start  time.Time
+	files  atomic.Int64
+	bytes  atomic.Int64
+	errors atomic.Int64
+}
+
+func newOpSummary(op string) *opSummary {
+	return &opSummary{op: op, start: time.Now()}
+}
+
+// fileDone records one successfully copied file of size bytes
+func (s *opSummary) fileDone(size int64) {
+	s.files.Add(1)
+	s.bytes.Add(size)
+}
+
+// fileFailed records one file that failed without ending the operation
+func (s *opSummary) fileFailed() {
+	s.errors.Add(1)
+}
+
+// finish logs the summary record, counting err as a failure when set and
+// no file failures were recorded already
+func (s *opSummary) finish(err error) {
+	if err != nil && s.errors.Load() == 0 {
+		s.errors.Add(1)
+	}
+	logger().Info("operation finished",
+		"op", s.op,
+		"files", s.files.Load(),
+		"bytes", s.bytes.Load(),
+		"duration", time.Since(s.start),
+		"errors", s.errors.Load(),
+	)
+}
@@ -0,0 +1,197 @@
+package gstorage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidManifest is returned by RestoreManifest for entries that would
+// reach outside the directory being restored
+var ErrInvalidManifest = errors.New("invalid manifest entry")
+
+// Manifest maps a copied tree back to its source. Copies that change names
+// with SanitizeNames, or drop permissions and times by not preserving them,
+// write one through CopyOptions.Manifest or PoolOptions.Manifest so
+// RestoreManifest can undo those changes later
+type Manifest struct {
+	Entries []ManifestEntry `json:"entries"`
+}
+
+// ManifestEntry describes one copied entry. Path and Source are
+// slash-separated and relative to the destination and source roots; Mode,
+// ModTime and Size are those of the source. Transforms names, in order,
+// the transforms the content of a file went through
+type ManifestEntry struct {
+	Path       string      `json:"path"`
+	Source     string      `json:"source"`
+	Mode       fs.FileMode `json:"mode"`
+	ModTime    time.Time   `json:"mod_time"`
+	Size       int64       `json:"size,omitempty"`
+	Transforms []string    `json:"transforms,omitempty"`
+}
+
+// ReadManifest loads a manifest written by a copy
+func ReadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		logger().Error("error reading manifest", "path", path, "err", err)
+		return nil, err
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		logger().Error("error decoding manifest", "path", path, "err", err)
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+// RestoreManifest undoes the changes a copy into dir recorded in manifest:
+// entries get their source names back, and their source permissions and
+// modification times. Entries are handled deepest first, so directories
+// are renamed and stamped after their contents. Entries missing from dir,
+// such as files the copy skipped, are left out. The source names must be
+// valid on the running platform. Transforms are not undone; reverse them
+// with their inverse, e.g. DecompressFile, before or after restoring
+func RestoreManifest(dir string, manifest *Manifest) error {
+	entries := append([]ManifestEntry(nil), manifest.Entries...)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return strings.Count(entries[i].Path, "/") > strings.Count(entries[j].Path, "/")
+	})
+
+	for _, entry := range entries {
+		rel, source := filepath.FromSlash(entry.Path), filepath.FromSlash(entry.Source)
+		if !filepath.IsLocal(rel) || !filepath.IsLocal(source) {
+			return fmt.Errorf("%s: %w", entry.Path, ErrInvalidManifest)
+		}
+		from := filepath.Join(dir, rel)
+		if _, err := os.Lstat(from); os.IsNotExist(err) {
+			logger().Debug("skipping missing manifest entry", "path", from)
+			continue
+		}
+
+		to := filepath.Join(filepath.Dir(from), filepath.Base(source))
+		if to != from {
+			if err := os.Rename(from, to); err != nil {
+				logger().Error("error restoring name", "path", from, "source", entry.Source, "err", err)
+				return err
+			}
+		}
+		if isSymlink(entry.Mode) {
+			continue
+		}
+		if err := os.Chmod(to, entry.Mode&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky)); err != nil {
+			logger().Error("error restoring mode", "path", to, "err", err)
+			return err
+		}
+		if err := os.Chtimes(to, entry.ModTime, entry.ModTime); err != nil {
+			logger().Error("error restoring times", "path", to, "err", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// copyManifest collects the entries of one copy for its Manifest. A nil
+// copyManifest records nothing
+type copyManifest struct {
+	dstRoot    string
+	transforms []string
+
+	mu      sync.Mutex
+	entries map[string]ManifestEntry
+}
+
+// newCopyManifest returns the manifest for a copy into dstRoot applying
+// transforms to files, or nil if path is empty
+func newCopyManifest(path string, dstRoot string, transforms []Transform) *copyManifest {
+	if path == "" {
+		return nil
+	}
+	return &copyManifest{dstRoot: dstRoot, transforms: transformNames(transforms), entries: make(map[string]ManifestEntry)}
+}
+
+// add records the entry d at srcPath, found at srcRel below the source
+// root and copied to dstPath. Followed links are recorded as their targets
+func (m *copyManifest) add(srcPath string, srcRel string, dstPath string, d fs.DirEntry, follow bool) {
+	if m == nil {
+		return
+	}
+	info, err := d.Info()
+	if follow && isSymlink(d.Type()) {
+		info, err = os.Stat(srcPath)
+	}
+	if err != nil {
+		// Copying the entry fails on its own
+		return
+	}
+	dstRel, err := filepath.Rel(m.dstRoot, dstPath)
+	if err != nil {
+		return
+	}
+
+	entry := ManifestEntry{
+		Path:    filepath.ToSlash(dstRel),
+		Source:  filepath.ToSlash(srcRel),
+		Mode:    info.Mode(),
+		ModTime: info.ModTime(),
+	}
+	if info.Mode().IsRegular() {
+		entry.Size = info.Size()
+		entry.Transforms = m.transforms
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[entry.Path] = entry
+}
+
+// rendered notes that the file recorded at dstPath was rendered as a
+// template ahead of the copy's transforms
+func (m *copyManifest) rendered(dstPath string) {
+	if m == nil {
+		return
+	}
+	dstRel, err := filepath.Rel(m.dstRoot, dstPath)
+	if err != nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if entry, ok := m.entries[filepath.ToSlash(dstRel)]; ok {
+		entry.Transforms = append([]string{templateTransformName}, m.transforms...)
+		m.entries[entry.Path] = entry
+	}
+}
+
+// write saves the recorded entries, sorted by path, to path
+func (m *copyManifest) write(path string) error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	manifest := Manifest{Entries: make([]ManifestEntry, 0, len(m.entries))}
+	for _, entry := range m.entries {
+		manifest.Entries = append(manifest.Entries, entry)
+	}
+	m.mu.Unlock()
+	sort.Slice(manifest.Entries, func(i, j int) bool {
+		return manifest.Entries[i].Path < manifest.Entries[j].Path
+	})
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := WriteFileAtomic(path, data); err != nil {
+		logger().Error("error writing manifest", "path", path, "err", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package gstorage
+
+import (
+	"io/fs"
+	"os"
+)
+
+// preserveMetadata copies the metadata of info selected by opts onto dst.
+// Ownership goes first, as changing it can clear setuid and setgid bits
+func preserveMetadata(info fs.FileInfo, dst string, opts CopyOptions) error {
+	if opts.PreserveOwner {
+		if err := copyOwner(info, dst); err != nil {
+			if !os.IsPermission(err) {
+				logger().Error("error preserving owner", "path", dst, "err", err)
+				return err
+			}
+			logger().Debug("not permitted to preserve owner", "path", dst)
+		}
+	}
+
+	if opts.PreserveMode {
+		if err := os.Chmod(dst, info.Mode()&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky)); err != nil {
+			logger().Error("error preserving mode", "path", dst, "err", err)
+			return err
+		}
+	}
+
+	if opts.PreserveTimes {
+		if err := os.Chtimes(dst, accessTime(info), info.ModTime()); err != nil {
+			logger().Error("error preserving times", "path", dst, "err", err)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package gstorage
+
+import (
+	"sync"
+	"time"
+)
+
+// UsageThreshold is a usage limit watched by a UsageMonitor. Zero fields
+// are not checked
+type UsageThreshold struct {
+	Name      string
+	MaxBytes  int64 // cumulative file size
+	MaxInodes int64 // files and directories
+}
+
+// exceededBy reports whether usage is above t
+func (t UsageThreshold) exceededBy(usage DirSize) bool {
+	return (t.MaxBytes > 0 && usage.Bytes > t.MaxBytes) ||
+		(t.MaxInodes > 0 && usage.Files+usage.Dirs > t.MaxInodes)
+}
+
+// UsageEvent reports a threshold crossing. Exceeded is true when usage
+// went above the threshold and false when it dropped back below it
+type UsageEvent struct {
+	Root      string
+	Threshold UsageThreshold
+	Usage     DirSize
+	Exceeded  bool
+	At        time.Time
+}
+
+// UsageMonitor tracks the disk usage of a managed root and calls OnEvent
+// whenever a threshold is crossed, in either direction, so applications
+// can alert or prune before the disk fills. Usage is measured with
+// GetDirSize, either on demand with Check or every interval after Start
+type UsageMonitor struct {
+	Root     string
+	Interval time.Duration
+	OnEvent  func(UsageEvent)
+
+	mu         sync.Mutex
+	thresholds []UsageThreshold
+	exceeded   []bool
+	usage      DirSize
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+// NewUsageMonitor returns a monitor for root, measuring every interval once
+// started and reporting crossings to onEvent
+func NewUsageMonitor(root string, interval time.Duration, onEvent func(UsageEvent)) *UsageMonitor {
+	return &UsageMonitor{Root: root, Interval: interval, OnEvent: onEvent}
+}
+
+// AddThreshold starts watching t. It is considered not exceeded until the
+// next measurement says otherwise
+func (m *UsageMonitor) AddThreshold(t UsageThreshold) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.thresholds = append(m.thresholds, t)
+	m.exceeded = append(m.exceeded, false)
+}
+
+// Usage returns the most recent measurement
+func (m *UsageMonitor) Usage() DirSize {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.usage
+}
+
+// Check measures the root now, fires events for any thresholds crossed
+// since the last measurement and returns the usage
+func (m *UsageMonitor) Check() (DirSize, error) {
+	usage, err := GetDirSize(m.Root)
+	if err != nil {
+		return DirSize{}, err
+	}
+
+	m.mu.Lock()
+	m.usage = usage
+	var events []UsageEvent
+	now := time.Now()
+	for i, t := range m.thresholds {
+		exceeded := t.exceededBy(usage)
+		if exceeded == m.exceeded[i] {
+			continue
+		}
+		m.exceeded[i] = exceeded
+		events = append(events, UsageEvent{Root: m.Root, Threshold: t, Usage: usage, Exceeded: exceeded, At: now})
+	}
+	m.mu.Unlock()
+
+	// Called without the lock so handlers may use the monitor
+	for _, event := range events {
+		if event.Exceeded {
+			logger().Warn("usage threshold exceeded", "root", m.Root, "threshold", event.Threshold.Name, "bytes", usage.Bytes)
+		} else {
+			logger().Info("usage back below threshold", "root", m.Root, "threshold", event.Threshold.Name, "bytes", usage.Bytes)
+		}
+		if m.OnEvent != nil {
+			m.OnEvent(event)
+		}
+	}
+	return usage, nil
+}
+
+// Start measures the root every Interval, or every minute if Interval is
+// not set, in the background until Stop is called. Measurement errors are
+// logged and retried on the next tick. Starting a running monitor does
+// nothing
+func (m *UsageMonitor) Start() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stop != nil {
+		return
+	}
+	m.stop = make(chan struct{})
+	m.done = make(chan struct{})
+	interval := m.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	go func(stop <-chan struct{}, done chan<- struct{}) {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if _, err := m.Check(); err != nil {
+				logger().Error("error measuring usage", "root", m.Root, "err", err)
+			}
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}(m.stop, m.done)
+}
+
+// Stop ends background measurement and waits for an in-flight measurement
+// to finish
+func (m *UsageMonitor) Stop() {
+	m.mu.Lock()
+	stop, done := m.stop, m.done
+	m.stop, m.done = nil, nil
+	m.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
@@ -0,0 +1,212 @@
+package gstorage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// mount routes the paths below prefix to names on storage
+type mount struct {
+	prefix  string
+	storage Storage
+}
+
+var (
+	mountMu sync.RWMutex
+	mounts  []mount
+)
+
+// Mount makes the paths below prefix name files on s, so CopyFile, CopyDir,
+// MoveFile, RemoveFile, ReadFile, WriteFile, ListDir, Exists, FileExists,
+// FileExistsStrict, DirExists and GetFileSize work on s without changes to
+// the calling code.
+// After
+//
+//	Mount("s3://media", &S3Backend{...})
+//
+// CopyFile("photo.jpg", "s3://media/2024/photo.jpg") uploads photo.jpg as
+// the object 2024/photo.jpg, and CopyDir copies whole trees either way.
+//
+// Paths outside every mount are local, as on LocalBackend{}, so existing
+// code keeps working unchanged. Mounting a prefix again replaces its
+// Storage, and the longest matching prefix wins. The package's other
+// functions only work on local paths.
+func Mount(prefix string, s Storage) error {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" || s == nil {
+		return pathError("mount", prefix, fs.ErrInvalid)
+	}
+
+	mountMu.Lock()
+	defer mountMu.Unlock()
+	for i, m := range mounts {
+		if m.prefix == prefix {
+			mounts[i].storage = s
+			return nil
+		}
+	}
+	mounts = append(mounts, mount{prefix: prefix, storage: s})
+	logger().Info("storage mounted", "prefix", prefix)
+	return nil
+}
+
+// Unmount removes the mount at prefix, if there is one
+func Unmount(prefix string) {
+	prefix = strings.TrimSuffix(prefix, "/")
+
+	mountMu.Lock()
+	defer mountMu.Unlock()
+	for i, m := range mounts {
+		if m.prefix == prefix {
+			mounts = append(mounts[:i], mounts[i+1:]...)
+			logger().Info("storage unmounted", "prefix", prefix)
+			return
+		}
+	}
+}
+
+// mountOf returns the mount p is below, and the name of p on its Storage
+func mountOf(p string) (mount, string, bool) {
+	mountMu.RLock()
+	defer mountMu.RUnlock()
+
+	var found mount
+	var name string
+	ok := false
+	for _, m := range mounts {
+		rest, match := strings.CutPrefix(p, m.prefix)
+		if !match || rest != "" && rest[0] != '/' {
+			continue
+		}
+		if !ok || len(m.prefix) > len(found.prefix) {
+			found, name, ok = m, strings.TrimPrefix(rest, "/"), true
+		}
+	}
+	return found, name, ok
+}
+
+// mountedStorage returns the Storage holding p and the name of p on it, if
+// p is below a mount
+func mountedStorage(p string) (Storage, string, bool) {
+	m, name, ok := mountOf(p)
+	return m.storage, name, ok
+}
+
+// storageOf returns the Storage holding p and the name of p on it,
+// LocalBackend{} for paths outside every mount
+func storageOf(p string) (Storage, string) {
+	if s, name, ok := mountedStorage(p); ok {
+		return s, name
+	}
+	return LocalBackend{}, filepath.ToSlash(p)
+}
+
+// mountedPair resolves src and dst to their Storage when either of them is
+// below a mount
+func mountedPair(src string, dst string) (srcStorage Storage, srcName string, dstStorage Storage, dstName string, ok bool) {
+	_, _, srcMounted := mountOf(src)
+	_, _, dstMounted := mountOf(dst)
+	if !srcMounted && !dstMounted {
+		return nil, "", nil, "", false
+	}
+	srcStorage, srcName = storageOf(src)
+	dstStorage, dstName = storageOf(dst)
+	return srcStorage, srcName, dstStorage, dstName, true
+}
+
+// moveMounted moves src to dst when either is below a mount: a rename
+// within one mount, otherwise a copy followed by removing src
+func moveMounted(src string, dst string) (bool, error) {
+	srcMount, srcName, srcMounted := mountOf(src)
+	dstMount, dstName, dstMounted := mountOf(dst)
+	if !srcMounted && !dstMounted {
+		return false, nil
+	}
+
+	if srcMounted && dstMounted && srcMount.prefix == dstMount.prefix {
+		if err := srcMount.storage.Rename(srcName, dstName); err != nil {
+			logger().Error("error while moving file", "src", src, "dst", dst, "err", err)
+			return true, err
+		}
+		return true, nil
+	}
+
+	srcStorage, srcName := storageOf(src)
+	dstStorage, dstName := storageOf(dst)
+	if err := CopyFileBetween(srcStorage, srcName, dstStorage, dstName); err != nil {
+		return true, err
+	}
+	if err := srcStorage.Remove(srcName); err != nil {
+		logger().Error("error removing source file after copy", "src", src, "err", err)
+		return true, err
+	}
+	return true, nil
+}
+
+// readStorageFile reads the whole of name on s
+func readStorageFile(s Storage, name string) ([]byte, error) {
+	r, err := s.Open(name)
+	if err != nil {
+		logger().Error("error reading file", "path", name, "err", err)
+		return []byte{}, err
+	}
+	defer r.Close()
+
+	content, err := io.ReadAll(r)
+	if err != nil {
+		logger().Error("error reading file", "path", name, "err", err)
+		return []byte{}, err
+	}
+	return content, nil
+}
+
+// writeStorageFile replaces name on s with content
+func writeStorageFile(s Storage, name string, content []byte) error {
+	w, err := s.Create(name)
+	if err != nil {
+		logger().Error("error creating file", "path", name, "err", err)
+		return err
+	}
+	if _, err := w.Write(content); err != nil {
+		w.Close()
+		logger().Error("error writing file", "path", name, "err", err)
+		return err
+	}
+	if err := w.Close(); err != nil {
+		logger().Error("error closing file", "path", name, "err", err)
+		return err
+	}
+	return nil
+}
+
+// removeStorageFile removes name on s. A missing file is not an error
+func removeStorageFile(s Storage, name string) error {
+	if err := s.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		logger().Error("error removing file", "path", name, "err", err)
+		return err
+	}
+	return nil
+}
+
+// mountedStat is os.Stat, except that paths below a mount are stat'ed on
+// their Storage
+func mountedStat(p string) (fs.FileInfo, error) {
+	if s, name, ok := mountedStorage(p); ok {
+		return s.Stat(name)
+	}
+	return os.Stat(p)
+}
+
+// listStorageDir lists the entries directly inside name on s
+func listStorageDir(s Storage, name string) ([]os.DirEntry, error) {
+	entries, err := s.List(name)
+	if err != nil {
+		return []os.DirEntry{}, err
+	}
+	return entries, nil
+}
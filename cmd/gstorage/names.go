@@ -0,0 +1,139 @@
+package gstorage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// nameMapper picks destination names for a copy with SanitizeNames. Names
+// are passed through SanitizeFileName; where several names in one source
+// directory would land on the same destination name, compared without case
+// on platforms whose filesystems ignore it, all but one get a short hash of
+// their original name as a suffix. The choice depends only on the names in
+// the directory, so repeated copies agree. A nil mapper keeps every name
+type nameMapper struct {
+	target Platform
+
+	mu sync.Mutex
+	// suffixed holds the hash-suffixed names of each source directory seen
+	suffixed map[string]map[string]string
+}
+
+// newNameMapper returns the mapper for a copy, or nil if sanitize is not
+// set
+func newNameMapper(sanitize bool, target Platform) *nameMapper {
+	if !sanitize {
+		return nil
+	}
+	return &nameMapper{
+		target:   target.resolve(),
+		suffixed: make(map[string]map[string]string),
+	}
+}
+
+// name returns the destination name for the entry name of srcDir
+func (m *nameMapper) name(srcDir string, name string) string {
+	if m == nil {
+		return name
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	suffixed, ok := m.suffixed[srcDir]
+	if !ok {
+		suffixed = m.disambiguate(srcDir)
+		m.suffixed[srcDir] = suffixed
+	}
+	if mapped, ok := suffixed[name]; ok {
+		return mapped
+	}
+	return SanitizeFileName(name, m.target)
+}
+
+// rel maps rel, relative to srcRoot, to its destination path one element
+// at a time
+func (m *nameMapper) rel(srcRoot string, rel string) string {
+	if m == nil || rel == "." {
+		return rel
+	}
+	srcDir := srcRoot
+	parts := strings.Split(rel, string(filepath.Separator))
+	for i, part := range parts {
+		parts[i] = m.name(srcDir, part)
+		srcDir = filepath.Join(srcDir, part)
+	}
+	return filepath.Join(parts...)
+}
+
+// disambiguate reads the names in srcDir and returns the hash-suffixed
+// names of those that collide. Sanitized names that are valid as they
+// are keep precedence, then the rest in byte order. A directory that
+// cannot be read has no collisions; copying it fails on its own
+func (m *nameMapper) disambiguate(srcDir string) map[string]string {
+	names, err := readDirNames(srcDir)
+	if err != nil {
+		logger().Debug("unable to read names for collision checks", "dir", srcDir, "err", err)
+		return nil
+	}
+
+	foldCase := m.target != PlatformPOSIX
+	groups := make(map[string][]string)
+	for _, name := range names {
+		key := SanitizeFileName(name, m.target)
+		if foldCase {
+			key = strings.ToLower(key)
+		}
+		groups[key] = append(groups[key], name)
+	}
+
+	var suffixed map[string]string
+	for _, group := range groups {
+		if len(group) < 2 {
+			continue
+		}
+		sort.Slice(group, func(i, j int) bool {
+			validI := SanitizeFileName(group[i], m.target) == group[i]
+			validJ := SanitizeFileName(group[j], m.target) == group[j]
+			if validI != validJ {
+				return validI
+			}
+			return group[i] < group[j]
+		})
+		if suffixed == nil {
+			suffixed = make(map[string]string)
+		}
+		for _, name := range group[1:] {
+			suffixed[name] = hashSuffixedName(SanitizeFileName(name, m.target), name)
+		}
+	}
+	return suffixed
+}
+
+// hashSuffixedName inserts "~" and 8 hex digits of the SHA-256 of original
+// before the extension of name, shortening name to keep the result within
+// maxNameBytes
+func hashSuffixedName(name string, original string) string {
+	sum := sha256.Sum256([]byte(original))
+	suffix := "~" + hex.EncodeToString(sum[:4])
+	ext := filepath.Ext(name)
+	if len(ext) > 16 || len(ext) == len(name) {
+		ext = ""
+	}
+	stem := truncateFileName(name[:len(name)-len(ext)], maxNameBytes-len(suffix)-len(ext))
+	return stem + suffix + ext
+}
+
+// readDirNames returns the names in dir without statting its entries
+func readDirNames(dir string) ([]string, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Readdirnames(-1)
+}
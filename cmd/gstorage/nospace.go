@@ -0,0 +1,48 @@
+package gstorage
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// retryNoSpace runs run and, while it fails because the filesystem of dst
+// is full, gives the space back, calls onNoSpace, waits and tries again, up
+// to retries times, or once if only onNoSpace is set. A non-nil error from
+// onNoSpace ends the retries early. partial is the file a failed attempt
+// leaves its partial copy in, truncated between attempts, or "" when run
+// removes its own partial output
+func retryNoSpace(ctx context.Context, dst string, partial string, retries int, wait time.Duration, onNoSpace func(dst string) error, run func() error) error {
+	if onNoSpace != nil && retries < 1 {
+		retries = 1
+	}
+
+	err := run()
+	for attempt := 1; attempt <= retries && isNoSpace(err); attempt++ {
+		logger().Warn("destination out of space", "dst", dst, "attempt", attempt, "err", err)
+
+		// The partial copy holds space the next attempt needs
+		if partial != "" {
+			os.Truncate(partial, 0)
+		}
+
+		if onNoSpace != nil {
+			if hookErr := onNoSpace(dst); hookErr != nil {
+				logger().Error("out of space handler failed", "dst", dst, "err", hookErr)
+				return err
+			}
+		}
+
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+		err = run()
+	}
+	return err
+}
@@ -0,0 +1,8 @@
+//go:build !unix && !windows
+
+package gstorage
+
+// isNoSpace cannot recognise full filesystems on this platform
+func isNoSpace(err error) bool {
+	return false
+}
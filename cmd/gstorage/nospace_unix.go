@@ -0,0 +1,13 @@
+//go:build unix
+
+package gstorage
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isNoSpace reports whether err means the filesystem is full
+func isNoSpace(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT)
+}
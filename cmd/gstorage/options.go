@@ -0,0 +1,385 @@
+package gstorage
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// SpecialFilePolicy controls how copies treat FIFOs, sockets and device nodes
+type SpecialFilePolicy int
+
+const (
+	// SpecialFileSkip leaves special files out of the copy and reports them
+	SpecialFileSkip SpecialFilePolicy = iota
+	// SpecialFileError aborts the copy with ErrSpecialFile
+	SpecialFileError
+	// SpecialFileRecreate recreates FIFOs and device nodes at the destination
+	// where the platform and privileges permit it, and skips the rest
+	SpecialFileRecreate
+)
+
+// SymlinkPolicy controls how copies treat symbolic links
+type SymlinkPolicy int
+
+const (
+	// SymlinkFollow copies what the link points to. Linked directories are
+	// copied recursively; links leading back into a directory already being
+	// copied are skipped with ErrSymlinkLoop
+	SymlinkFollow SymlinkPolicy = iota
+	// SymlinkPreserve recreates the link itself, with the same target text
+	SymlinkPreserve
+	// SymlinkSkip leaves links out of the copy and reports them
+	SymlinkSkip
+	// SymlinkError aborts the copy with ErrSymlink
+	SymlinkError
+)
+
+// VerifyMode selects how copies are checked against their source after
+// being written
+type VerifyMode int
+
+const (
+	// VerifyNone trusts the copy
+	VerifyNone VerifyMode = iota
+	// VerifyChecksum re-reads source and copy and compares their digests
+	// under the configured HashAlgorithm
+	VerifyChecksum
+	// VerifyBytes re-reads source and copy side by side and compares them
+	// byte for byte, stopping at the first difference
+	VerifyBytes
+)
+
+var (
+	// ErrSpecialFile is returned when a FIFO, socket or device node is found
+	// where a regular file is required
+	ErrSpecialFile = errors.New("special file cannot be copied")
+	// ErrFileTooRecent is reported for files skipped because of MinFileAge
+	ErrFileTooRecent = errors.New("file modified too recently")
+	// ErrFiltered is reported for entries left out by Include, Exclude or
+	// Filter
+	ErrFiltered = errors.New("excluded by filter")
+	// ErrUnchanged is reported for destination files SkipUnchanged leaves
+	// alone
+	ErrUnchanged = errors.New("destination already up to date")
+	// ErrFileTooLarge is reported for files larger than MaxFileSize
+	ErrFileTooLarge = errors.New("file exceeds maximum size")
+	// ErrTotalSizeExceeded is returned when a copy would exceed MaxTotalSize
+	ErrTotalSizeExceeded = errors.New("copy exceeds maximum total size")
+	// ErrSymlink is reported for links skipped or refused by SymlinkPolicy
+	ErrSymlink = errors.New("file is a symbolic link")
+	// ErrSymlinkLoop is reported for followed links that lead back into a
+	// directory already being copied
+	ErrSymlinkLoop = errors.New("symbolic link loop")
+)
+
+// CopyOptions tunes the behaviour of the *WithOptions copy functions.
+// The zero value behaves exactly like CopyDir.
+type CopyOptions struct {
+	// MinFileAge skips files modified less than MinFileAge ago, so files
+	// that are still being written are not picked up mid-write
+	MinFileAge time.Duration
+
+	// MaxFileSize skips files larger than MaxFileSize bytes, or fails the
+	// copy with ErrFileTooLarge when FailOnMaxFileSize is set. 0 means no limit
+	MaxFileSize       int64
+	FailOnMaxFileSize bool
+
+	// MaxTotalSize aborts the copy with ErrTotalSizeExceeded before the
+	// cumulative bytes copied would exceed it. 0 means no limit
+	MaxTotalSize int64
+
+	// SpecialFiles selects how FIFOs, sockets and device nodes are handled
+	SpecialFiles SpecialFilePolicy
+
+	// Symlinks selects how symbolic links are handled
+	Symlinks SymlinkPolicy
+
+	// OnSkip, when set, is called for every file left out of the copy
+	OnSkip func(path string, reason error)
+
+	// Include, when set, limits the files copied to those matching one of
+	// its patterns. Exclude leaves out matching files and directories, with
+	// everything below them. Patterns follow the ArchiveOptions syntax and
+	// are matched against the path relative to the source root, so
+	// Exclude: []string{"node_modules", ".git", "*.tmp"} leaves those out
+	// at any depth
+	Include []string
+	Exclude []string
+	// Filter, when set, is called with the source path of every entry that
+	// passes Include and Exclude; returning false leaves the entry out, and
+	// everything below it for directories
+	Filter func(path string, d fs.DirEntry) bool
+
+	// VerifySize copies the source modification time onto each copied file
+	// and fails with ErrSizeMismatch if the sizes differ afterwards
+	VerifySize bool
+
+	// StoreHash records the MD5 of each copied file, computed while it is
+	// copied, so later verification can use StoredFileMD5 instead of
+	// rereading the file
+	StoreHash HashStore
+
+	// PreserveMode copies permission bits, including setuid, setgid and
+	// sticky, onto copied files and directories
+	PreserveMode bool
+
+	// PreserveTimes copies access and modification times onto copied files
+	// and directories
+	PreserveTimes bool
+
+	// PreserveOwner copies the owning user and group. Changing ownership
+	// usually needs privileges; without them the owner is left as is
+	PreserveOwner bool
+
+	// Verify re-reads every copied file and compares it with its source.
+	// Mismatched copies are left in place for inspection and reported
+	// together, once the rest of the copy is done, in a VerificationError
+	Verify VerifyMode
+
+	// VerifyHash is the digest used by VerifyChecksum, MD5 by default
+	VerifyHash HashAlgorithm
+
+	// OnNoSpace, when set, is called when copying a file fails because the
+	// destination filesystem is full, e.g. to prune old data, after which
+	// the file is copied again. NoSpaceRetries bounds the attempts (1 if
+	// unset) and NoSpaceWait pauses before each. Returning an error from
+	// OnNoSpace gives up on the file with the original error
+	OnNoSpace      func(dst string) error
+	NoSpaceRetries int
+	NoSpaceWait    time.Duration
+
+	// Retry, when set, copies a file again after transient failures, such
+	// as those of network filesystems. Running out of space is handled by
+	// OnNoSpace instead
+	Retry *RetryPolicy
+
+	// Handle, when set, lets the copy be paused and resumed between files
+	Handle *OpHandle
+
+	// ReadDirBatch, when set, lists each directory this many entries at a
+	// time instead of all at once, keeping memory flat for directories of
+	// millions of files. Entries are then copied in directory order
+	// rather than sorted by name
+	ReadDirBatch int
+
+	// Progress, when set, receives progress events for the whole copy.
+	// Directory copies measure the files they will copy first, honouring
+	// Include, Exclude and Filter, to report totals. SkipProgressTotals
+	// starts copying right away instead, with TotalBytes, TotalFiles and
+	// Percent left at 0 until the copy is done, for trees too large to
+	// walk twice
+	Progress           ProgressReporter
+	SkipProgressTotals bool
+
+	// SanitizeNames passes every destination name through SanitizeFileName
+	// for SanitizePlatform, for copies onto filesystems with stricter naming
+	// rules than the source. Names that would then collide in a directory,
+	// including by case on Windows and macOS, are told apart by a short hash
+	// suffix
+	SanitizeNames    bool
+	SanitizePlatform Platform
+
+	// Manifest, when set, is the file a Manifest of every copied entry is
+	// written to once the copy ends, so RestoreManifest can later give the
+	// copy back its source names, permissions and times
+	Manifest string
+
+	// Transform rewrites the content of every copied file through the
+	// chain, e.g. CompressTransform. Transformed files differ from their
+	// source by design, so Verify and VerifySize are not applied to them,
+	// StoreHash records the hash of the transformed content, and SyncDir
+	// sees them as changed whenever their size differs from the source
+	Transform []Transform
+
+	// Templates renders the files it selects as Go templates instead of
+	// copying them verbatim, ahead of any Transform. Like the filtering
+	// options it only applies to directory copies
+	Templates TemplateOptions
+
+	// SkipUnchanged compares the content a copy would write, after
+	// Templates and Transform, with the existing destination file, and
+	// leaves identical files alone, modification time included, so
+	// incremental build systems see no change. They are reported to OnSkip
+	// with ErrUnchanged. Changed files are written to a temporary file and
+	// renamed into place. Identical files are read in full but never
+	// written
+	SkipUnchanged bool
+}
+
+// preservesMetadata reports whether any Preserve option is set
+func (o CopyOptions) preservesMetadata() bool {
+	return o.PreserveMode || o.PreserveTimes || o.PreserveOwner
+}
+
+// filtered reports whether Include, Exclude or Filter leave out the entry
+// d at path, found at rel below the source root. Hash and hold sidecars
+// are always left out
+func (o CopyOptions) filtered(path string, rel string, d fs.DirEntry) bool {
+	return entryFiltered(o.Include, o.Exclude, o.Filter, path, rel, d)
+}
+
+func entryFiltered(include []string, exclude []string, filter func(string, fs.DirEntry) bool, path string, rel string, d fs.DirEntry) bool {
+	isDir := d.IsDir()
+	if !isDir && isSidecarName(d.Name()) {
+		return true
+	}
+	if isSymlink(d.Type()) && len(include) > 0 {
+		// Include only limits files, so links to directories are kept
+		if info, err := os.Stat(path); err == nil {
+			isDir = info.IsDir()
+		}
+	}
+	if (pathFilter{include: include, exclude: exclude}).excluded(rel, isDir) {
+		return true
+	}
+	return filter != nil && !filter(path, d)
+}
+
+// skip logs path as skipped and reports it to OnSkip
+func (o CopyOptions) skip(path string, reason error) {
+	logger().Debug("skipping", "path", path, "reason", reason)
+	if o.OnSkip != nil {
+		o.OnSkip(path, reason)
+	}
+}
+
+// WriteOptions tunes the behaviour of WriteFileWithOptions.
+// The zero value behaves exactly like WriteFile.
+type WriteOptions struct {
+	// VerifyLength re-reads the size of the written file and fails with
+	// io.ErrShortWrite if it does not match the content length
+	VerifyLength bool
+
+	// StoreHash records the MD5 of the written content
+	StoreHash HashStore
+
+	// Scanner, when set, inspects the content in a staging file before it
+	// is moved into place. Refused files never reach the destination
+	Scanner Scanner
+	// Quarantine, when set, receives files the Scanner rejects instead of
+	// them being deleted
+	Quarantine *QuarantineArea
+
+	// Atomic writes to a temporary file, fsyncs it and renames it over the
+	// destination, as WriteFileAtomic does
+	Atomic bool
+}
+
+// RemoveOptions tunes the behaviour of the Remove*WithOptions functions.
+// Unlike the other option structs, the zero value is strict: removing a
+// missing path is an error. RemoveFile and RemoveDirAll set IgnoreNotExist,
+// RemoveDir does not.
+type RemoveOptions struct {
+	// IgnoreNotExist treats a missing path as already removed
+	IgnoreNotExist bool
+}
+
+// PoolOptions tunes the behaviour of WorkerPoolCopyDirWithOptions.
+type PoolOptions struct {
+	// Workers is the number of files copied in parallel. Values below 1
+	// are treated as 1
+	Workers int
+
+	// TempRename writes each file to ".<name>.gstorage-tmp" next to its
+	// destination and renames it into place once complete, so concurrent
+	// readers of the destination only ever see whole files
+	TempRename bool
+
+	// VerifySize behaves like CopyOptions.VerifySize. With TempRename the
+	// check runs before the rename, so a short copy never becomes visible
+	VerifySize bool
+
+	// LargeFileThreshold routes files of at least this many bytes to a
+	// separate lane served by LargeFileWorkers, so they cannot block the
+	// small files behind them. 0 keeps a single lane
+	LargeFileThreshold int64
+	// LargeFileWorkers is the number of workers in the large-file lane.
+	// Values below 1 are treated as 1
+	LargeFileWorkers int
+
+	// WalkWorkers, when above 1, walks the source tree with ParallelWalkDir
+	// using that many workers instead of a single filepath.WalkDir
+	WalkWorkers int
+
+	// Symlinks selects how symbolic links are handled
+	Symlinks SymlinkPolicy
+
+	// SpecialFiles behaves like CopyOptions.SpecialFiles
+	SpecialFiles SpecialFilePolicy
+
+	// OnSkip, when set, is called for every file left out of the copy.
+	// With WalkWorkers it may be called from several goroutines at once
+	OnSkip func(path string, reason error)
+
+	// Include, Exclude and Filter behave like their CopyOptions
+	// counterparts
+	Include []string
+	Exclude []string
+	Filter  func(path string, d fs.DirEntry) bool
+
+	// Verify re-reads every copied file and compares it with its source,
+	// reporting mismatches together in a VerificationError. With
+	// TempRename a mismatched copy is discarded instead of renamed into
+	// place
+	Verify VerifyMode
+
+	// VerifyHash is the digest used by VerifyChecksum, MD5 by default
+	VerifyHash HashAlgorithm
+
+	// OnNoSpace, when set, is called when copying a file fails because the
+	// destination filesystem is full, e.g. to prune old data, after which
+	// the file is copied again. NoSpaceRetries bounds the attempts (1 if
+	// unset) and NoSpaceWait pauses before each. Returning an error from
+	// OnNoSpace gives up on the file with the original error
+	OnNoSpace      func(dst string) error
+	NoSpaceRetries int
+	NoSpaceWait    time.Duration
+
+	// Retry, when set, copies a file again after transient failures, such
+	// as those of network filesystems. Running out of space is handled by
+	// OnNoSpace instead
+	Retry *RetryPolicy
+
+	// Handle, when set, lets the copy be paused and resumed between files
+	Handle *OpHandle
+
+	// ReadDirBatch behaves like CopyOptions.ReadDirBatch for the walks
+	// over the source tree. It is ignored with WalkWorkers, whose workers
+	// read whole directories
+	ReadDirBatch int
+
+	// Progress and SkipProgressTotals behave like their CopyOptions
+	// counterparts, with events aggregated over every worker
+	Progress           ProgressReporter
+	SkipProgressTotals bool
+
+	// SanitizeNames, SanitizePlatform and Manifest behave like their
+	// CopyOptions counterparts
+	SanitizeNames    bool
+	SanitizePlatform Platform
+	Manifest         string
+
+	// Transform behaves like CopyOptions.Transform
+	Transform []Transform
+
+	// ContinueOnError keeps copying after a file fails instead of stopping
+	// at the first error. Every failure is then returned together in a
+	// CopyError, alongside the totals of what was copied. Failing to create
+	// the destination directories still aborts the copy
+	ContinueOnError bool
+}
+
+// filtered behaves like CopyOptions.filtered
+func (o PoolOptions) filtered(path string, rel string, d fs.DirEntry) bool {
+	return entryFiltered(o.Include, o.Exclude, o.Filter, path, rel, d)
+}
+
+// skip behaves like CopyOptions.skip
+func (o PoolOptions) skip(path string, reason error) {
+	logger().Debug("skipping", "path", path, "reason", reason)
+	if o.OnSkip != nil {
+		o.OnSkip(path, reason)
+	}
+}
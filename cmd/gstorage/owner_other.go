@@ -0,0 +1,10 @@
+//go:build !unix
+
+package gstorage
+
+import "io/fs"
+
+// copyOwner is a no-op where files have no Unix ownership
+func copyOwner(info fs.FileInfo, dst string) error {
+	return nil
+}
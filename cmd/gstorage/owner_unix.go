@@ -0,0 +1,18 @@
+//go:build unix
+
+package gstorage
+
+import (
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// copyOwner gives dst the owning user and group recorded in info
+func copyOwner(info fs.FileInfo, dst string) error {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	return os.Lchown(dst, int(stat.Uid), int(stat.Gid))
+}
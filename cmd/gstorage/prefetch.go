@@ -0,0 +1,54 @@
+package gstorage
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// prefetchWorkers bounds how many files Prefetch reads at once
+const prefetchWorkers = 4
+
+// Prefetch reads paths in the background so their contents are in the OS
+// page cache by the time the caller reads them. It returns immediately; the
+// returned channel is closed once every file has been read. Files that
+// cannot be read are logged and skipped.
+func Prefetch(paths ...string) <-chan struct{} {
+	done := make(chan struct{})
+	jobs := make(chan string)
+
+	var wg sync.WaitGroup
+	for i := 0; i < prefetchWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range jobs {
+				if err := warmFile(path); err != nil {
+					logger().Debug("error prefetching file", "path", path, "err", err)
+				}
+			}
+		}()
+	}
+
+	go func() {
+		for _, path := range paths {
+			jobs <- path
+		}
+		close(jobs)
+		wg.Wait()
+		close(done)
+	}()
+
+	return done
+}
+
+func warmFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(io.Discard, file)
+	return err
+}
@@ -0,0 +1,178 @@
+package gstorage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	// Decoders for the built-in image generator
+	_ "image/gif"
+	_ "image/jpeg"
+)
+
+// ErrNoPreviewGenerator is returned for files whose type has no registered
+// PreviewGenerator
+var ErrNoPreviewGenerator = errors.New("no preview generator for file type")
+
+// PreviewGenerator renders a preview of the file at path that fits within
+// maxDim x maxDim pixels. Generators for formats the standard library cannot
+// decode, such as PDF first pages, are plugged in with RegisterGenerator
+type PreviewGenerator func(path string, maxDim int) (image.Image, error)
+
+// PreviewCache generates previews on demand and caches them as PNG files in
+// Dir. Cache entries are keyed by path, size and modification time, so an
+// edited file gets a fresh preview. Stale entries are left for the caller
+// to prune, e.g. with FindColdFiles
+type PreviewCache struct {
+	Dir    string
+	MaxDim int
+
+	mu         sync.RWMutex
+	generators map[string]PreviewGenerator
+}
+
+// NewPreviewCache returns a cache writing maxDim-bounded previews to dir,
+// with a generator for JPEG, PNG and GIF images already registered
+func NewPreviewCache(dir string, maxDim int) *PreviewCache {
+	c := &PreviewCache{Dir: dir, MaxDim: maxDim, generators: make(map[string]PreviewGenerator)}
+	for _, mimeType := range []string{"image/jpeg", "image/png", "image/gif"} {
+		c.generators[mimeType] = ImageThumbnail
+	}
+	return c
+}
+
+// RegisterGenerator sets the generator used for files sniffed as mimeType,
+// replacing any previous one
+func (c *PreviewCache) RegisterGenerator(mimeType string, gen PreviewGenerator) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generators[mimeType] = gen
+}
+
+// Preview returns the path of the cached PNG preview of path, generating
+// it first if needed
+func (c *PreviewCache) Preview(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		logger().Error("error reading file info", "path", path, "err", err)
+		return "", err
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	key := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d\x00%d\x00%d", abs, info.Size(), info.ModTime().UnixNano(), c.MaxDim)))
+	cached := filepath.Join(c.Dir, hex.EncodeToString(key[:16])+".png")
+	if _, err := os.Stat(cached); err == nil {
+		logger().Debug("preview cache hit", "path", path)
+		return cached, nil
+	}
+
+	mimeType, err := detectMIMEType(path)
+	if err != nil {
+		return "", err
+	}
+	mimeType, _, _ = strings.Cut(mimeType, ";")
+
+	c.mu.RLock()
+	gen := c.generators[mimeType]
+	c.mu.RUnlock()
+	if gen == nil {
+		return "", pathError("preview", path, ErrNoPreviewGenerator)
+	}
+
+	img, err := gen(path, c.MaxDim)
+	if err != nil {
+		logger().Error("error generating preview", "path", path, "err", err)
+		return "", err
+	}
+
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return "", err
+	}
+	// Write under a temporary name so concurrent callers never read a
+	// half-written preview
+	tmp, err := os.CreateTemp(c.Dir, ".preview-*")
+	if err != nil {
+		return "", err
+	}
+	if err := png.Encode(tmp, img); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), cached); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	logger().Debug("generated preview", "path", path, "preview", cached)
+	return cached, nil
+}
+
+// ImageThumbnail is the PreviewGenerator for images the standard library
+// can decode. It downscales with a box filter, keeping the aspect ratio
+func ImageThumbnail(path string, maxDim int) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	src, _, err := image.Decode(file)
+	if err != nil {
+		return nil, err
+	}
+	return scaleDown(src, maxDim), nil
+}
+
+// scaleDown shrinks src to fit within maxDim x maxDim by averaging each
+// block of source pixels. Images already small enough are returned as is
+func scaleDown(src image.Image, maxDim int) image.Image {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if maxDim <= 0 || (w <= maxDim && h <= maxDim) {
+		return src
+	}
+
+	dw, dh := maxDim, maxDim
+	if w > h {
+		dh = max(1, h*maxDim/w)
+	} else {
+		dw = max(1, w*maxDim/h)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := bounds.Min.Y+y*h/dh, bounds.Min.Y+(y+1)*h/dh
+		for x := 0; x < dw; x++ {
+			x0, x1 := bounds.Min.X+x*w/dw, bounds.Min.X+(x+1)*w/dw
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			dst.Set(x, y, color.RGBA64{
+				R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n),
+			})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,200 @@
+package gstorage
+
+import (
+	"io"
+	"io/fs"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// progressInterval is the minimum time between Progress events, so fast
+// copies of many small files do not flood the reporter
+const progressInterval = 100 * time.Millisecond
+
+// Progress is a snapshot of a running copy
+type Progress struct {
+	BytesCopied int64
+	TotalBytes  int64 // 0 when unknown
+	FilesCopied int64
+	TotalFiles  int64 // 0 when unknown
+
+	// Percent is BytesCopied as a share of TotalBytes, from 0 to 100. It
+	// stays 0 while the total is unknown and is 100 once a copy succeeds
+	Percent float64
+	// Rate is the average throughput so far in bytes per second
+	Rate float64
+	// ETA estimates the time left at Rate, 0 when unknown
+	ETA time.Duration
+
+	// Path is the source file being copied, if any
+	Path string
+	// Done is set on the last event of a copy, whether it succeeded or not
+	Done bool
+}
+
+// ProgressReporter receives Progress events from copies that set
+// CopyOptions.Progress or PoolOptions.Progress. Events come at most every
+// 100ms, plus a final one with Done set. Report is never called
+// concurrently for one copy, but blocks it while running, so it should
+// return quickly
+type ProgressReporter interface {
+	Report(p Progress)
+}
+
+// ProgressFunc adapts a function to the ProgressReporter interface
+type ProgressFunc func(p Progress)
+
+func (f ProgressFunc) Report(p Progress) {
+	f(p)
+}
+
+// progressTracker aggregates the progress of one copy, possibly across
+// several workers, and reports it. A nil tracker tracks nothing
+type progressTracker struct {
+	reporter   ProgressReporter
+	start      time.Time
+	totalBytes int64
+	totalFiles int64
+	bytes      atomic.Int64
+	files      atomic.Int64
+
+	mu   sync.Mutex
+	last time.Time
+}
+
+// newProgressTracker returns a tracker reporting to r, or nil if r is nil
+func newProgressTracker(r ProgressReporter, totalBytes int64, totalFiles int64) *progressTracker {
+	if r == nil {
+		return nil
+	}
+	now := time.Now()
+	return &progressTracker{reporter: r, start: now, last: now, totalBytes: totalBytes, totalFiles: totalFiles}
+}
+
+// newDirProgressTracker returns a tracker for copying the tree at srcDir.
+// Unless skipTotals is set the files the copy will take, as selected by
+// opts, are measured first so events carry totals. A tree that cannot be
+// measured is copied with the totals unknown
+func newDirProgressTracker(r ProgressReporter, srcDir string, skipTotals bool, opts DirSizeOptions) *progressTracker {
+	if r == nil {
+		return nil
+	}
+	if skipTotals {
+		return newProgressTracker(r, 0, 0)
+	}
+	// Always filtering leaves sidecars out of the totals, as from the copy
+	filter := opts.Filter
+	opts.Filter = func(path string, d fs.DirEntry) bool {
+		return filter == nil || filter(path, d)
+	}
+	size, err := GetDirSizeWithOptions(srcDir, opts)
+	if err != nil {
+		logger().Debug("unable to measure source for progress", "src", srcDir, "err", err)
+	}
+	return newProgressTracker(r, size.Bytes, size.Files)
+}
+
+// file returns the writer counting the bytes of one file through the
+// copy, or nil for a nil tracker
+func (t *progressTracker) file(path string) *fileProgress {
+	if t == nil {
+		return nil
+	}
+	return &fileProgress{t: t, path: path}
+}
+
+// finish sends the final event
+func (t *progressTracker) finish(err error) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p := t.snapshot("", time.Now())
+	p.Done = true
+	if err == nil {
+		p.Percent = 100
+		p.ETA = 0
+	}
+	t.reporter.Report(p)
+}
+
+// report sends an event unless one was sent less than progressInterval ago
+func (t *progressTracker) report(path string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	if now.Sub(t.last) < progressInterval {
+		return
+	}
+	t.last = now
+	t.reporter.Report(t.snapshot(path, now))
+}
+
+func (t *progressTracker) snapshot(path string, now time.Time) Progress {
+	p := Progress{
+		BytesCopied: t.bytes.Load(),
+		TotalBytes:  t.totalBytes,
+		FilesCopied: t.files.Load(),
+		TotalFiles:  t.totalFiles,
+		Path:        path,
+	}
+	if elapsed := now.Sub(t.start).Seconds(); elapsed > 0 {
+		p.Rate = float64(p.BytesCopied) / elapsed
+	}
+	if p.TotalBytes > 0 {
+		p.Percent = min(100, float64(p.BytesCopied)*100/float64(p.TotalBytes))
+		if p.Rate > 0 && p.TotalBytes > p.BytesCopied {
+			p.ETA = time.Duration(float64(p.TotalBytes-p.BytesCopied) / p.Rate * float64(time.Second))
+		}
+	}
+	return p
+}
+
+// fileProgress counts the bytes of one file written through it into its
+// tracker. A nil fileProgress counts nothing
+type fileProgress struct {
+	t    *progressTracker
+	path string
+	n    int64
+}
+
+func (f *fileProgress) Write(p []byte) (int, error) {
+	f.n += int64(len(p))
+	f.t.bytes.Add(int64(len(p)))
+	f.t.report(f.path)
+	return len(p), nil
+}
+
+// reset takes the bytes counted so far back out, before a retry or after
+// a failure
+func (f *fileProgress) reset() {
+	if f == nil {
+		return
+	}
+	f.t.bytes.Add(-f.n)
+	f.n = 0
+}
+
+// done counts the file as copied, or takes its bytes back out if it failed
+func (f *fileProgress) done(err error) {
+	if f == nil {
+		return
+	}
+	if err != nil {
+		f.reset()
+		return
+	}
+	f.t.files.Add(1)
+	f.t.report(f.path)
+}
+
+// writer returns f as an io.Writer, or nil for a nil f, so it can be
+// passed on as an optional tee
+func (f *fileProgress) writer() io.Writer {
+	if f == nil {
+		return nil
+	}
+	return f
+}
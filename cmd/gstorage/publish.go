@@ -0,0 +1,94 @@
+package gstorage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Publish copies src to every path in dsts as a two-phase commit.
+//
+//	Phase 1 stages a verified copy next to each destination under a temporary name.
+//	Phase 2 renames every staged copy over its destination.
+//
+// If any destination fails to stage, nothing is published. If a rename fails,
+// destinations that were already published are restored to their previous state.
+func Publish(src string, dsts []string) error {
+	srcHash, err := CalculateFileMD5(src)
+	if err != nil {
+		logger().Error("error reading source file", "src", src, "err", err)
+		return err
+	}
+
+	for _, dst := range dsts {
+		if err := checkOverwrite(dst); err != nil {
+			return err
+		}
+	}
+
+	staged := make([]string, len(dsts))
+	cleanup := func() {
+		for _, tmp := range staged {
+			if tmp != "" {
+				os.Remove(tmp)
+			}
+		}
+	}
+
+	for i, dst := range dsts {
+		staged[i] = filepath.Join(filepath.Dir(dst), "."+filepath.Base(dst)+".gstorage-publish")
+		if err := CopyFile(src, staged[i]); err != nil {
+			cleanup()
+			return fmt.Errorf("staging %s: %w", dst, err)
+		}
+		hash, err := CalculateFileMD5(staged[i])
+		if err != nil {
+			cleanup()
+			return fmt.Errorf("verifying %s: %w", dst, err)
+		}
+		if hash != srcHash {
+			cleanup()
+			return fmt.Errorf("verifying %s: %w", dst, ErrChecksumMismatch)
+		}
+	}
+
+	backups := make([]string, len(dsts))
+	rollback := func(published int) {
+		for j := 0; j < published; j++ {
+			if backups[j] != "" {
+				os.Rename(backups[j], dsts[j])
+			} else {
+				os.Remove(dsts[j])
+			}
+		}
+		if published < len(dsts) && backups[published] != "" {
+			os.Rename(backups[published], dsts[published])
+		}
+		cleanup()
+	}
+
+	for i, dst := range dsts {
+		if _, err := os.Lstat(dst); err == nil {
+			backups[i] = staged[i] + ".bak"
+			if err := os.Rename(dst, backups[i]); err != nil {
+				backups[i] = ""
+				rollback(i)
+				return fmt.Errorf("publishing %s: %w", dst, err)
+			}
+		}
+		if err := os.Rename(staged[i], dst); err != nil {
+			rollback(i)
+			return fmt.Errorf("publishing %s: %w", dst, err)
+		}
+	}
+
+	for _, backup := range backups {
+		if backup != "" {
+			os.Remove(backup)
+		}
+	}
+
+	logger().Debug("successfully published file", "src", src, "destinations", len(dsts))
+
+	return nil
+}
@@ -0,0 +1,241 @@
+package gstorage
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// ErrNotQuarantined is returned for quarantine IDs that do not exist
+var ErrNotQuarantined = errors.New("no such quarantined file")
+
+// QuarantineArea is a managed directory holding files withheld from use,
+// e.g. because a Scanner refused them. Each file is kept with a record of
+// where it came from and why, until it is released back or denied.
+//
+// Layout: Dir/<id>/data holds the file, Dir/<id>/record.json its record.
+type QuarantineArea struct {
+	Dir string
+}
+
+// QuarantinedFile describes one file in a QuarantineArea
+type QuarantinedFile struct {
+	ID            string    `json:"id"`
+	OriginalPath  string    `json:"original_path"`
+	Reason        string    `json:"reason"`
+	QuarantinedAt time.Time `json:"quarantined_at"`
+	Size          int64     `json:"size"`
+}
+
+// NewQuarantineArea returns the quarantine area rooted at dir, creating it
+// with owner-only permissions if needed
+func NewQuarantineArea(dir string) (*QuarantineArea, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		logger().Error("unable to create quarantine", "dir", dir, "err", err)
+		return nil, err
+	}
+	return &QuarantineArea{Dir: dir}, nil
+}
+
+// Quarantine moves path into the area and returns its ID. The quarantined
+// copy is made read-only so it is not modified by accident
+func (q *QuarantineArea) Quarantine(path string, reason string) (string, error) {
+	if err := checkDelete(path); err != nil {
+		return "", err
+	}
+	return q.quarantineAs(path, path, reason)
+}
+
+// quarantineAs moves path into the area, recording originalPath as where
+// it should be released to. Staged ingest files use this to record their
+// intended destination rather than the staging name
+func (q *QuarantineArea) quarantineAs(path string, originalPath string, reason string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		logger().Error("error reading file info", "path", path, "err", err)
+		return "", err
+	}
+	if info.IsDir() {
+		return "", pathError("quarantine", path, ErrIsDirectory)
+	}
+	if abs, err := filepath.Abs(originalPath); err == nil {
+		originalPath = abs
+	}
+
+	id, err := newQuarantineID()
+	if err != nil {
+		return "", err
+	}
+	entryDir := filepath.Join(q.Dir, id)
+	if err := os.Mkdir(entryDir, 0700); err != nil {
+		return "", err
+	}
+
+	record := QuarantinedFile{
+		ID:            id,
+		OriginalPath:  originalPath,
+		Reason:        reason,
+		QuarantinedAt: time.Now(),
+		Size:          info.Size(),
+	}
+	if err := writeQuarantineRecord(entryDir, record); err != nil {
+		os.RemoveAll(entryDir)
+		return "", err
+	}
+
+	data := filepath.Join(entryDir, "data")
+	if err := moveAcrossDevices(path, data); err != nil {
+		os.RemoveAll(entryDir)
+		logger().Error("unable to quarantine file", "path", path, "err", err)
+		return "", err
+	}
+	os.Chmod(data, 0400)
+
+	logger().Info("file quarantined", "path", originalPath, "id", id, "reason", reason)
+	return id, nil
+}
+
+// ListQuarantined returns the files in the area, oldest first
+func (q *QuarantineArea) ListQuarantined() ([]QuarantinedFile, error) {
+	entries, err := os.ReadDir(q.Dir)
+	if err != nil {
+		logger().Error("error reading quarantine", "dir", q.Dir, "err", err)
+		return nil, err
+	}
+
+	var files []QuarantinedFile
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		record, err := q.record(entry.Name())
+		if err != nil {
+			// A half-written entry from an interrupted Quarantine
+			logger().Debug("skipping unreadable quarantine entry", "id", entry.Name(), "err", err)
+			continue
+		}
+		files = append(files, record)
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].QuarantinedAt.Before(files[j].QuarantinedAt)
+	})
+	return files, nil
+}
+
+// Release moves the file with the given ID back to its original path. It
+// fails with fs.ErrExist rather than overwrite a file that now lives there
+func (q *QuarantineArea) Release(id string) error {
+	record, err := q.record(id)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Lstat(record.OriginalPath); err == nil {
+		return pathError("release", record.OriginalPath, fs.ErrExist)
+	}
+	if err := os.MkdirAll(filepath.Dir(record.OriginalPath), 0755); err != nil {
+		return err
+	}
+
+	entryDir := filepath.Join(q.Dir, id)
+	data := filepath.Join(entryDir, "data")
+	if err := moveAcrossDevices(data, record.OriginalPath); err != nil {
+		logger().Error("unable to release file", "id", id, "err", err)
+		return err
+	}
+	os.Chmod(record.OriginalPath, 0644)
+	os.RemoveAll(entryDir)
+
+	logger().Info("file released from quarantine", "path", record.OriginalPath, "id", id)
+	return nil
+}
+
+// Deny permanently deletes the file with the given ID
+func (q *QuarantineArea) Deny(id string) error {
+	record, err := q.record(id)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(filepath.Join(q.Dir, id)); err != nil {
+		logger().Error("unable to delete quarantined file", "id", id, "err", err)
+		return err
+	}
+	logger().Info("quarantined file denied", "path", record.OriginalPath, "id", id)
+	return nil
+}
+
+// Purge deletes every file quarantined more than olderThan ago and returns
+// how many were removed
+func (q *QuarantineArea) Purge(olderThan time.Duration) (int, error) {
+	files, err := q.ListQuarantined()
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := time.Now().Add(-olderThan)
+	purged := 0
+	for _, file := range files {
+		if file.QuarantinedAt.After(cutoff) {
+			break
+		}
+		if err := q.Deny(file.ID); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// record loads the record of id, reporting unknown IDs as ErrNotQuarantined
+func (q *QuarantineArea) record(id string) (QuarantinedFile, error) {
+	var record QuarantinedFile
+	if id == "" || filepath.Base(id) != id {
+		return record, fmt.Errorf("%q: %w", id, ErrNotQuarantined)
+	}
+	data, err := os.ReadFile(filepath.Join(q.Dir, id, "record.json"))
+	if os.IsNotExist(err) {
+		return record, fmt.Errorf("%q: %w", id, ErrNotQuarantined)
+	}
+	if err != nil {
+		return record, err
+	}
+	err = json.Unmarshal(data, &record)
+	return record, err
+}
+
+func writeQuarantineRecord(entryDir string, record QuarantinedFile) error {
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(entryDir, "record.json"), data, 0600)
+}
+
+// newQuarantineID returns a sortable, unique ID: a timestamp and 4 random bytes
+func newQuarantineID() (string, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	return time.Now().UTC().Format("20060102T150405") + "-" + hex.EncodeToString(suffix), nil
+}
+
+// moveAcrossDevices renames src to dst, falling back to copy and delete
+// when they are on different filesystems
+func moveAcrossDevices(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	if err := CopyFile(src, dst); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
@@ -0,0 +1,105 @@
+package gstorage
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// RetryPolicy retries file copies that fail with transient errors, such as
+// the EIO and ESTALE network filesystems return while a server fails over.
+// Each file is retried on its own; the rest of the operation carries on
+// as usual. A nil policy makes a single attempt
+type RetryPolicy struct {
+	// MaxAttempts bounds the attempts per file, the first included. Values
+	// below 2 make a single attempt
+	MaxAttempts int
+
+	// InitialBackoff is the wait before the second attempt. Each later
+	// wait is Multiplier times the one before, up to MaxBackoff when that
+	// is set. A Multiplier below 1 is treated as 2
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+
+	// Retryable reports whether err is worth another attempt. Nil uses
+	// IsTransientError. Cancelled contexts are never retried
+	Retryable func(err error) bool
+
+	// OnRetry, when set, is called before each retry with the file, the
+	// number of the attempt about to be made, the error that failed the
+	// one before and the wait ahead of it, e.g. to log or count retries
+	OnRetry func(path string, attempt int, err error, wait time.Duration)
+}
+
+// IsTransientError reports whether err is one that may go away if the
+// operation is tried again: timeouts, and errors such as EIO, ESTALE,
+// EAGAIN and ECONNRESET on Unix or lost network names and sharing
+// violations on Windows
+func IsTransientError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var timeout interface{ Timeout() bool }
+	if errors.As(err, &timeout) && timeout.Timeout() {
+		return true
+	}
+	return isTransientErrno(err)
+}
+
+// do runs run for path until it succeeds, fails with an error the policy
+// does not retry, or runs out of attempts, and returns its last error
+func (p *RetryPolicy) do(ctx context.Context, path string, run func() error) error {
+	err := run()
+	if p == nil {
+		return err
+	}
+
+	wait := p.InitialBackoff
+	for attempt := 2; attempt <= p.MaxAttempts && p.retryable(err); attempt++ {
+		if ctx.Err() != nil {
+			return err
+		}
+		logger().Warn("retrying after transient error", "path", path, "attempt", attempt, "wait", wait, "err", err)
+		if p.OnRetry != nil {
+			p.OnRetry(path, attempt, err, wait)
+		}
+
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+		err = run()
+		wait = p.next(wait)
+	}
+	return err
+}
+
+// retryable reports whether err calls for another attempt
+func (p *RetryPolicy) retryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return IsTransientError(err)
+}
+
+// next returns the wait following wait
+func (p *RetryPolicy) next(wait time.Duration) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	wait = time.Duration(float64(wait) * multiplier)
+	if p.MaxBackoff > 0 && wait > p.MaxBackoff {
+		wait = p.MaxBackoff
+	}
+	return wait
+}
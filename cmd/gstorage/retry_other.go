@@ -0,0 +1,8 @@
+//go:build !unix && !windows
+
+package gstorage
+
+// isTransientErrno cannot recognise transient errors on this platform
+func isTransientErrno(err error) bool {
+	return false
+}
@@ -0,0 +1,19 @@
+//go:build unix
+
+package gstorage
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isTransientErrno reports whether err is an errno that network
+// filesystems return for failures that may clear up
+func isTransientErrno(err error) bool {
+	for _, errno := range []syscall.Errno{syscall.EIO, syscall.ESTALE, syscall.EAGAIN, syscall.ETIMEDOUT, syscall.ECONNRESET} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,29 @@
+//go:build windows
+
+package gstorage
+
+import (
+	"errors"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// isTransientErrno reports whether err is an error that SMB shares, or
+// scanners briefly holding files open, cause for failures that may clear up
+func isTransientErrno(err error) bool {
+	for _, errno := range []syscall.Errno{
+		windows.ERROR_NETNAME_DELETED,
+		windows.ERROR_UNEXP_NET_ERR,
+		windows.ERROR_NETWORK_BUSY,
+		windows.ERROR_BAD_NET_RESP,
+		windows.ERROR_SEM_TIMEOUT,
+		windows.ERROR_IO_DEVICE,
+		windows.ERROR_SHARING_VIOLATION,
+	} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,357 @@
+package gstorage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Backend is a Storage backed by a bucket on any S3-compatible service
+// (AWS S3, MinIO, Ceph, R2...). Requests are signed with AWS Signature
+// Version 4 and use path-style URLs: Endpoint/Bucket/name.
+//
+// Objects are written with a single PUT, so files are limited to the
+// service's single-upload maximum (5 GiB on AWS). Rename is a server-side
+// copy followed by a delete and is not atomic.
+type S3Backend struct {
+	// Endpoint is the service URL, e.g. "https://s3.us-east-1.amazonaws.com"
+	// or "http://localhost:9000"
+	Endpoint string
+	Region   string
+	Bucket   string
+
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+
+	// Client is used for requests; nil means http.DefaultClient
+	Client *http.Client
+}
+
+var _ Storage = (*S3Backend)(nil)
+
+// S3Error is returned when the service answers with an error status
+type S3Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *S3Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("s3: %s", http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("s3: %s: %s", e.Code, e.Message)
+}
+
+// Unwrap maps a 404 onto fs.ErrNotExist, so os.IsNotExist-style checks via
+// errors.Is work across backends
+func (e *S3Error) Unwrap() error {
+	if e.StatusCode == http.StatusNotFound {
+		return fs.ErrNotExist
+	}
+	return nil
+}
+
+// s3Key turns a slash-separated name into an object key
+func s3Key(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// s3Prefix turns a directory name into the prefix of the keys below it
+func s3Prefix(dir string) string {
+	if key := s3Key(dir); key != "" {
+		return key + "/"
+	}
+	return ""
+}
+
+// do signs and sends a request for key with the given query and body.
+// Error statuses are returned as *S3Error
+func (b *S3Backend) do(method, key string, query url.Values, header http.Header, body io.Reader, size int64, payloadHash string) (*http.Response, error) {
+	endpoint, err := url.Parse(b.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	objectPath := "/" + b.Bucket
+	if key != "" {
+		objectPath += "/" + key
+	}
+	endpoint.Path = objectPath
+	endpoint.RawPath = uriEncode(objectPath, false)
+	endpoint.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequest(method, endpoint.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if b.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", b.SessionToken)
+	}
+	signV4(req, b.AccessKey, b.SecretKey, b.Region, "s3", payloadHash, time.Now())
+
+	client := b.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		s3err := &S3Error{StatusCode: resp.StatusCode}
+		// HEAD responses carry no body; others usually hold an XML error
+		var payload struct {
+			Code    string
+			Message string
+		}
+		if xml.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&payload) == nil {
+			s3err.Code, s3err.Message = payload.Code, payload.Message
+		}
+		return nil, s3err
+	}
+	return resp, nil
+}
+
+// Open returns the object's content. Reading streams from the service
+func (b *S3Backend) Open(name string) (io.ReadCloser, error) {
+	resp, err := b.do(http.MethodGet, s3Key(name), nil, nil, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+	return resp.Body, nil
+}
+
+// Create buffers the written content in a local temporary file and uploads
+// it on Close, since the signed PUT needs the payload's length and hash
+func (b *S3Backend) Create(name string) (io.WriteCloser, error) {
+	tmp, err := os.CreateTemp("", "gstorage-s3-*")
+	if err != nil {
+		return nil, err
+	}
+	return &s3Writer{backend: b, name: name, tmp: tmp, sum: sha256.New()}, nil
+}
+
+type s3Writer struct {
+	backend *S3Backend
+	name    string
+	tmp     *os.File
+	sum     hash.Hash
+	size    int64
+	closed  bool
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	n, err := w.tmp.Write(p)
+	w.sum.Write(p[:n])
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *s3Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	defer os.Remove(w.tmp.Name())
+	defer w.tmp.Close()
+
+	if _, err := w.tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	resp, err := w.backend.do(http.MethodPut, s3Key(w.name), nil, nil, w.tmp, w.size, hex.EncodeToString(w.sum.Sum(nil)))
+	if err != nil {
+		return pathError("create", w.name, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// s3FileInfo describes an object, or a prefix standing in for a directory
+type s3FileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (fi s3FileInfo) Name() string       { return fi.name }
+func (fi s3FileInfo) Size() int64        { return fi.size }
+func (fi s3FileInfo) ModTime() time.Time { return fi.modTime }
+func (fi s3FileInfo) IsDir() bool        { return fi.dir }
+func (fi s3FileInfo) Sys() any           { return nil }
+
+func (fi s3FileInfo) Mode() fs.FileMode {
+	if fi.dir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
+
+// Stat returns the object's size and modification time. A name with no
+// object but with keys below it is reported as a directory
+func (b *S3Backend) Stat(name string) (fs.FileInfo, error) {
+	key := s3Key(name)
+	base := path.Base("/" + key)
+
+	if key != "" {
+		resp, err := b.do(http.MethodHead, key, nil, nil, nil, 0, emptyPayloadHash)
+		if err == nil {
+			resp.Body.Close()
+			size, _ := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+			modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+			return s3FileInfo{name: base, size: size, modTime: modTime}, nil
+		}
+		if !isS3NotExist(err) {
+			return nil, pathError("stat", name, err)
+		}
+	}
+
+	page, err := b.listPage(s3Prefix(name), "", 1)
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+	if key != "" && len(page.Contents) == 0 && len(page.CommonPrefixes) == 0 {
+		return nil, pathError("stat", name, fs.ErrNotExist)
+	}
+	return s3FileInfo{name: base, dir: true}, nil
+}
+
+// isS3NotExist reports whether err is an S3 404
+func isS3NotExist(err error) bool {
+	s3err, ok := err.(*S3Error)
+	return ok && s3err.StatusCode == http.StatusNotFound
+}
+
+type s3ListResult struct {
+	Contents []struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+	}
+	CommonPrefixes []struct {
+		Prefix string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+func (b *S3Backend) listPage(prefix, token string, maxKeys int) (*s3ListResult, error) {
+	query := url.Values{
+		"list-type": {"2"},
+		"delimiter": {"/"},
+		"prefix":    {prefix},
+	}
+	if token != "" {
+		query.Set("continuation-token", token)
+	}
+	if maxKeys > 0 {
+		query.Set("max-keys", strconv.Itoa(maxKeys))
+	}
+
+	resp, err := b.do(http.MethodGet, "", query, nil, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result s3ListResult
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// List returns the objects and sub-prefixes directly below dir
+func (b *S3Backend) List(dir string) ([]fs.DirEntry, error) {
+	prefix := s3Prefix(dir)
+	var entries []fs.DirEntry
+	token := ""
+
+	for {
+		page, err := b.listPage(prefix, token, 0)
+		if err != nil {
+			return nil, pathError("list", dir, err)
+		}
+		for _, p := range page.CommonPrefixes {
+			name := strings.TrimSuffix(strings.TrimPrefix(p.Prefix, prefix), "/")
+			entries = append(entries, fs.FileInfoToDirEntry(s3FileInfo{name: name, dir: true}))
+		}
+		for _, obj := range page.Contents {
+			name := strings.TrimPrefix(obj.Key, prefix)
+			if name == "" {
+				// The placeholder object some tools create for a "folder"
+				continue
+			}
+			entries = append(entries, fs.FileInfoToDirEntry(s3FileInfo{name: name, size: obj.Size, modTime: obj.LastModified}))
+		}
+		if !page.IsTruncated {
+			break
+		}
+		token = page.NextContinuationToken
+	}
+
+	if len(entries) == 0 && prefix != "" {
+		return nil, pathError("list", dir, fs.ErrNotExist)
+	}
+	return entries, nil
+}
+
+// Remove deletes the object. Like the S3 API, removing a missing object
+// succeeds
+func (b *S3Backend) Remove(name string) error {
+	resp, err := b.do(http.MethodDelete, s3Key(name), nil, nil, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return pathError("remove", name, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Rename copies oldname to newname on the server and then deletes oldname.
+// oldname is only deleted once the service has confirmed the copy
+func (b *S3Backend) Rename(oldname, newname string) error {
+	header := http.Header{}
+	header.Set("X-Amz-Copy-Source", uriEncode("/"+b.Bucket+"/"+s3Key(oldname), false))
+
+	resp, err := b.do(http.MethodPut, s3Key(newname), nil, header, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return pathError("rename", oldname, err)
+	}
+	defer resp.Body.Close()
+
+	// CopyObject can fail after the 200 status has been sent, in which case
+	// the body holds an Error instead of a CopyObjectResult
+	var result struct {
+		XMLName xml.Name
+		Code    string
+		Message string
+	}
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&result); err != nil {
+		return pathError("rename", oldname, fmt.Errorf("reading copy result: %w", err))
+	}
+	if result.XMLName.Local != "CopyObjectResult" {
+		return pathError("rename", oldname, &S3Error{StatusCode: resp.StatusCode, Code: result.Code, Message: result.Message})
+	}
+	return b.Remove(oldname)
+}
@@ -0,0 +1,132 @@
+package gstorage
+
+import (
+	"path/filepath"
+	"runtime"
+	"strings"
+	"unicode/utf8"
+)
+
+// Platform selects the file naming rules SanitizeFileName applies
+type Platform int
+
+const (
+	// PlatformNative follows the rules of the platform the program runs on
+	PlatformNative Platform = iota
+	// PlatformPOSIX covers Linux and other Unix systems, where only '/'
+	// and NUL are invalid
+	PlatformPOSIX
+	// PlatformDarwin also forbids ':' and invalid UTF-8
+	PlatformDarwin
+	// PlatformWindows forbids <>:"/\|?*, control characters, invalid
+	// UTF-8, trailing dots and spaces, and device names such as CON or
+	// LPT1 with or without an extension
+	PlatformWindows
+	// PlatformPortable applies every rule above, for names that must be
+	// valid wherever they end up
+	PlatformPortable
+)
+
+// maxNameBytes is the longest file name, in bytes, accepted by common
+// filesystems on every platform
+const maxNameBytes = 255
+
+// resolve turns PlatformNative into the platform running the program
+func (p Platform) resolve() Platform {
+	if p != PlatformNative {
+		return p
+	}
+	switch runtime.GOOS {
+	case "windows":
+		return PlatformWindows
+	case "darwin", "ios":
+		return PlatformDarwin
+	default:
+		return PlatformPOSIX
+	}
+}
+
+// invalid reports whether r may not appear in a file name on p
+func (p Platform) invalid(r rune) bool {
+	if r == '/' || r == 0 {
+		return true
+	}
+	windows := p == PlatformWindows || p == PlatformPortable
+	darwin := p == PlatformDarwin || p == PlatformPortable
+	switch {
+	case darwin && r == ':':
+		return true
+	case windows && (r < 0x20 || strings.ContainsRune(`<>:"\|?*`, r)):
+		return true
+	}
+	return false
+}
+
+// windowsDeviceNames are reserved on Windows whatever their extension
+var windowsDeviceNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// SanitizeFileName returns name made valid as a single file name on
+// target: invalid characters become '_', the name is truncated to 255
+// bytes keeping its extension where possible, and on Windows trailing dots
+// and spaces are trimmed and device names prefixed with '_'. Names that
+// are already valid are returned unchanged; empty names and "." or ".."
+// become underscores
+func SanitizeFileName(name string, target Platform) string {
+	target = target.resolve()
+	strictUTF8 := target != PlatformPOSIX
+
+	var b strings.Builder
+	b.Grow(len(name))
+	for i := 0; i < len(name); {
+		r, size := utf8.DecodeRuneInString(name[i:])
+		invalidUTF8 := r == utf8.RuneError && size == 1
+		switch {
+		case invalidUTF8 && strictUTF8, !invalidUTF8 && target.invalid(r):
+			b.WriteByte('_')
+		default:
+			b.WriteString(name[i : i+size])
+		}
+		i += size
+	}
+	name = truncateFileName(b.String(), maxNameBytes)
+
+	if target == PlatformWindows || target == PlatformPortable {
+		name = strings.TrimRight(name, ". ")
+		stem, _, _ := strings.Cut(name, ".")
+		if windowsDeviceNames[strings.ToUpper(strings.TrimRight(stem, " "))] {
+			name = truncateFileName("_"+name, maxNameBytes)
+		}
+	}
+
+	switch name {
+	case "", ".":
+		return "_"
+	case "..":
+		return "__"
+	}
+	return name
+}
+
+// truncateFileName shortens name to at most limit bytes without splitting
+// a UTF-8 sequence, keeping a short extension intact
+func truncateFileName(name string, limit int) string {
+	if len(name) <= limit {
+		return name
+	}
+	ext := filepath.Ext(name)
+	if len(ext) > 16 || len(ext) == len(name) {
+		ext = ""
+	}
+	stem := name[:len(name)-len(ext)]
+	cut := limit - len(ext)
+	for cut > 0 && !utf8.RuneStart(stem[cut]) {
+		cut--
+	}
+	return stem[:cut] + ext
+}
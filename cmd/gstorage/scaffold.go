@@ -0,0 +1,113 @@
+package gstorage
+
+import (
+	"io/fs"
+	"os"
+	"text/template"
+)
+
+// defaultTemplateSuffix marks the files a scaffold renders
+const defaultTemplateSuffix = ".tmpl"
+
+// ScaffoldHook runs once a scaffold has been copied into dst, e.g. to mark
+// scripts executable or initialise a repository
+type ScaffoldHook func(dst string, vars map[string]any) error
+
+// ScaffoldOptions tunes ScaffoldFromTemplateDirWithOptions. The zero value
+// behaves like ScaffoldFromTemplateDir.
+type ScaffoldOptions struct {
+	// Include, Exclude and Filter select the entries of the template tree
+	// to copy, as they do for CopyOptions
+	Include []string
+	Exclude []string
+	Filter  func(path string, d fs.DirEntry) bool
+
+	// TemplateSuffix marks the files rendered as templates, and is removed
+	// from their names. Empty means ".tmpl"
+	TemplateSuffix string
+
+	// Funcs, LeftDelim and RightDelim behave like their TemplateOptions
+	// counterparts, for both file contents and names
+	Funcs      template.FuncMap
+	LeftDelim  string
+	RightDelim string
+
+	// Overwrite allows scaffolding into a directory that is not empty,
+	// replacing the files the template tree also holds
+	Overwrite bool
+
+	// SkipUnchanged, with Overwrite, only rewrites files whose rendered
+	// content differs from what dst holds, as CopyOptions.SkipUnchanged
+	// does, so re-running a scaffold or deploy keeps the mtimes of
+	// identical files
+	SkipUnchanged bool
+
+	// PostHooks run in order once everything is copied. The first failure
+	// stops the rest and is returned
+	PostHooks []ScaffoldHook
+}
+
+// ScaffoldFromTemplateDir creates dst from the template tree templateRoot.
+// Files ending in ".tmpl" are rendered as Go templates with vars and lose
+// the suffix; other files are copied as they are. Names holding "{{" are
+// rendered with vars too, so "cmd/{{.Name}}/main.go.tmpl" may become
+// "cmd/demo/main.go", and entries whose name renders empty are left out.
+// Missing variables are errors. dst must not exist or be empty
+func ScaffoldFromTemplateDir(templateRoot string, dst string, vars map[string]any) error {
+	return ScaffoldFromTemplateDirWithOptions(templateRoot, dst, vars, ScaffoldOptions{})
+}
+
+// ScaffoldFromTemplateDirWithOptions scaffolds dst like
+// ScaffoldFromTemplateDir, applying opts. If the copy fails, a dst it
+// created is removed again; output is kept when a post-hook fails
+func ScaffoldFromTemplateDirWithOptions(templateRoot string, dst string, vars map[string]any, opts ScaffoldOptions) error {
+	suffix := opts.TemplateSuffix
+	if suffix == "" {
+		suffix = defaultTemplateSuffix
+	}
+
+	_, statErr := os.Stat(dst)
+	created := os.IsNotExist(statErr)
+	if !created && !opts.Overwrite {
+		entries, err := os.ReadDir(dst)
+		if err != nil {
+			logger().Error("error reading scaffold destination", "dst", dst, "err", err)
+			return err
+		}
+		if len(entries) > 0 {
+			logger().Error("scaffold destination is not empty", "dst", dst)
+			return pathError("scaffold", dst, ErrDirectoryNotEmpty)
+		}
+	}
+
+	err := CopyDirWithOptions(templateRoot, dst, CopyOptions{
+		Include:       opts.Include,
+		Exclude:       opts.Exclude,
+		Filter:        opts.Filter,
+		SkipUnchanged: opts.SkipUnchanged,
+		Templates: TemplateOptions{
+			Patterns:    []string{"*" + suffix},
+			Vars:        vars,
+			Funcs:       opts.Funcs,
+			LeftDelim:   opts.LeftDelim,
+			RightDelim:  opts.RightDelim,
+			TrimSuffix:  suffix,
+			RenderNames: true,
+		},
+	})
+	if err != nil {
+		if created {
+			os.RemoveAll(dst)
+		}
+		return err
+	}
+
+	for _, hook := range opts.PostHooks {
+		if err := hook(dst, vars); err != nil {
+			logger().Error("scaffold post-hook failed", "dst", dst, "err", err)
+			return err
+		}
+	}
+	logger().Info("scaffolded directory", "template", templateRoot, "dst", dst)
+	return nil
+}
@@ -0,0 +1,70 @@
+package gstorage
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// NextSequence increments the counter stored in path and returns the new
+// value. The first call on a missing file returns 1.
+//
+// Callers are serialised with an exclusive lock on path+".lock", so the
+// counter is safe to share between processes. The new value is written to a
+// temporary file, synced and renamed over path, so a crash never leaves a
+// half-written counter behind.
+func NextSequence(path string) (uint64, error) {
+	lock, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		logger().Error("error opening sequence lock", "path", path, "err", err)
+		return 0, err
+	}
+	defer lock.Close()
+
+	if err := lockFile(lock, true, true); err != nil {
+		logger().Error("error locking sequence", "path", path, "err", err)
+		return 0, err
+	}
+	defer unlockFile(lock)
+
+	var current uint64
+	content, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		logger().Error("error reading sequence", "path", path, "err", err)
+		return 0, err
+	}
+	if text := strings.TrimSpace(string(content)); text != "" {
+		current, err = strconv.ParseUint(text, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("corrupt sequence file %s: %w", path, err)
+		}
+	}
+
+	next := current + 1
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := file.WriteString(strconv.FormatUint(next, 10) + "\n"); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return 0, err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return 0, err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+
+	return next, nil
+}
@@ -0,0 +1,172 @@
+package gstorage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// deletedDirName is the directory below each soft-delete root that holds
+// removed content until it is purged
+const deletedDirName = ".deleted"
+
+type softDeleteRoot struct {
+	root      string
+	retention time.Duration
+}
+
+var (
+	softDeleteMu    sync.RWMutex
+	softDeleteRoots []softDeleteRoot
+)
+
+// SetSoftDeleteRoot makes RemoveFile and RemoveDirAll soft-delete below
+// root for the rest of the process: removed files and directories are
+// moved into root/.deleted/<timestamp>-<id>/, keeping their path relative
+// to root, instead of being deleted. PurgeDeleted deletes them for real
+// once retention has passed. Calling it again for the same root replaces
+// the retention.
+//
+// Removing root itself, or anything inside .deleted, still deletes outright.
+func SetSoftDeleteRoot(root string, retention time.Duration) error {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+
+	softDeleteMu.Lock()
+	defer softDeleteMu.Unlock()
+	for i, r := range softDeleteRoots {
+		if r.root == abs {
+			softDeleteRoots[i].retention = retention
+			return nil
+		}
+	}
+	softDeleteRoots = append(softDeleteRoots, softDeleteRoot{root: abs, retention: retention})
+	logger().Info("soft-delete root configured", "root", abs, "retention", retention)
+	return nil
+}
+
+// softDeleteRootOf returns the soft-delete root containing path and the
+// path relative to it. Paths that are the root itself or inside its
+// .deleted area are not soft-deleted
+func softDeleteRootOf(path string) (softDeleteRoot, string, bool) {
+	softDeleteMu.RLock()
+	defer softDeleteMu.RUnlock()
+	if len(softDeleteRoots) == 0 {
+		return softDeleteRoot{}, "", false
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return softDeleteRoot{}, "", false
+	}
+	for _, r := range softDeleteRoots {
+		rel, err := filepath.Rel(r.root, abs)
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if rel == deletedDirName || strings.HasPrefix(rel, deletedDirName+string(filepath.Separator)) {
+			continue
+		}
+		return r, rel, true
+	}
+	return softDeleteRoot{}, "", false
+}
+
+// isDeletedArea reports whether path is the .deleted area of a soft-delete
+// root, which tree operations such as SyncDir leave alone
+func isDeletedArea(path string) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	softDeleteMu.RLock()
+	defer softDeleteMu.RUnlock()
+	for _, r := range softDeleteRoots {
+		if abs == filepath.Join(r.root, deletedDirName) {
+			return true
+		}
+	}
+	return false
+}
+
+// softDelete moves path into the .deleted area of its soft-delete root.
+// It reports false, leaving path alone, when path is not under such a
+// root or does not exist
+func softDelete(path string) (bool, error) {
+	root, rel, ok := softDeleteRootOf(path)
+	if !ok {
+		return false, nil
+	}
+	if _, err := os.Lstat(path); err != nil {
+		return false, nil
+	}
+
+	id, err := newQuarantineID()
+	if err != nil {
+		return false, err
+	}
+	dst := filepath.Join(root.root, deletedDirName, id, rel)
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		logger().Error("unable to create deleted area", "root", root.root, "err", err)
+		return false, err
+	}
+	if err := os.Rename(path, dst); err != nil {
+		os.RemoveAll(filepath.Join(root.root, deletedDirName, id))
+		logger().Error("unable to soft-delete", "path", path, "err", err)
+		return false, err
+	}
+
+	logger().Debug("soft-deleted", "path", path, "to", dst)
+	return true, nil
+}
+
+// PurgeDeleted permanently deletes soft-deleted content older than the
+// retention of its root, across every soft-delete root, and returns how
+// many removals were purged. Run it periodically, e.g. from a time.Ticker
+func PurgeDeleted() (int, error) {
+	softDeleteMu.RLock()
+	roots := append([]softDeleteRoot(nil), softDeleteRoots...)
+	softDeleteMu.RUnlock()
+
+	now := time.Now()
+	purged := 0
+	for _, r := range roots {
+		area := filepath.Join(r.root, deletedDirName)
+		entries, err := os.ReadDir(area)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			logger().Error("error reading deleted area", "dir", area, "err", err)
+			return purged, err
+		}
+
+		for _, entry := range entries {
+			// Entries are named by newQuarantineID, which starts with the
+			// UTC time of the removal
+			stamp, _, _ := strings.Cut(entry.Name(), "-")
+			deletedAt, err := time.Parse("20060102T150405", stamp)
+			if err != nil {
+				logger().Debug("skipping unknown entry in deleted area", "path", filepath.Join(area, entry.Name()))
+				continue
+			}
+			if now.Sub(deletedAt) < r.retention {
+				continue
+			}
+			if err := os.RemoveAll(filepath.Join(area, entry.Name())); err != nil {
+				logger().Error("unable to purge deleted content", "path", filepath.Join(area, entry.Name()), "err", err)
+				return purged, err
+			}
+			purged++
+		}
+	}
+
+	if purged > 0 {
+		logger().Info("purged soft-deleted content", "count", purged)
+	}
+	return purged, nil
+}
@@ -0,0 +1,54 @@
+package gstorage
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// errSpecialUnsupported is returned by recreateSpecial for special files
+// that cannot be recreated on this platform
+var errSpecialUnsupported = errors.New("special file cannot be recreated on this platform")
+
+const specialModes = fs.ModeNamedPipe | fs.ModeSocket | fs.ModeDevice | fs.ModeCharDevice
+
+// isSpecial reports whether mode describes a FIFO, socket or device node
+func isSpecial(mode fs.FileMode) bool {
+	return mode&specialModes != 0
+}
+
+// copySpecial handles a special file found during a directory copy,
+// reporting it to skip when it is left out. An existing dstPath is only
+// replaced once it is known the file can be recreated and dstPath is not
+// protected
+func copySpecial(srcPath, dstPath string, policy SpecialFilePolicy, skip func(path string, reason error)) error {
+	switch policy {
+	case SpecialFileError:
+		logger().Error("refusing to copy special file", "path", srcPath)
+		return fmt.Errorf("%s: %w", srcPath, ErrSpecialFile)
+	case SpecialFileRecreate:
+		info, err := os.Lstat(srcPath)
+		if err != nil {
+			return err
+		}
+		if !canRecreateSpecial(info) {
+			skip(srcPath, ErrSpecialFile)
+			return nil
+		}
+		if err := checkOverwrite(dstPath); err != nil {
+			return err
+		}
+		if err := os.Remove(dstPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := recreateSpecial(dstPath, info); err != nil {
+			logger().Error("error recreating special file", "path", dstPath, "err", err)
+			return err
+		}
+		return nil
+	default:
+		skip(srcPath, ErrSpecialFile)
+		return nil
+	}
+}
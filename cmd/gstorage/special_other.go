@@ -0,0 +1,16 @@
+//go:build !linux && !darwin
+
+package gstorage
+
+import "io/fs"
+
+// canRecreateSpecial reports false: no special file can be recreated on
+// this platform
+func canRecreateSpecial(info fs.FileInfo) bool {
+	return false
+}
+
+// recreateSpecial is not supported on this platform
+func recreateSpecial(dst string, info fs.FileInfo) error {
+	return errSpecialUnsupported
+}
@@ -0,0 +1,45 @@
+//go:build linux || darwin
+
+package gstorage
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// canRecreateSpecial reports whether recreateSpecial supports info
+func canRecreateSpecial(info fs.FileInfo) bool {
+	mode := info.Mode()
+	switch {
+	case mode&fs.ModeNamedPipe != 0:
+		return true
+	case mode&fs.ModeDevice != 0:
+		_, ok := info.Sys().(*syscall.Stat_t)
+		return ok
+	default:
+		return false
+	}
+}
+
+// recreateSpecial creates a FIFO or device node at dst matching info
+func recreateSpecial(dst string, info fs.FileInfo) error {
+	mode := info.Mode()
+	perm := uint32(mode.Perm())
+
+	switch {
+	case mode&fs.ModeNamedPipe != 0:
+		return syscall.Mkfifo(dst, perm)
+	case mode&fs.ModeDevice != 0:
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return errSpecialUnsupported
+		}
+		kind := uint32(syscall.S_IFBLK)
+		if mode&fs.ModeCharDevice != 0 {
+			kind = syscall.S_IFCHR
+		}
+		return syscall.Mknod(dst, kind|perm, int(stat.Rdev))
+	default:
+		return errSpecialUnsupported
+	}
+}
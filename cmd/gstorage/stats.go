@@ -0,0 +1,357 @@
+package gstorage
+
+import (
+	"container/heap"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// TypeStats holds the number of files of one type and their cumulative size
+type TypeStats struct {
+	Count int64
+	Size  int64
+}
+
+// StatsByExtension walks root and returns the file count and cumulative size
+// for every file extension found. Extensions are lower-cased and include the
+// leading dot; files without an extension are counted under "".
+func StatsByExtension(root string) (map[string]TypeStats, error) {
+	return collectTypeStats(root, func(path string) (string, error) {
+		return strings.ToLower(filepath.Ext(path)), nil
+	})
+}
+
+// StatsByMIMEType walks root like StatsByExtension but groups files by the
+// MIME type detected from their first 512 bytes.
+func StatsByMIMEType(root string) (map[string]TypeStats, error) {
+	return collectTypeStats(root, detectMIMEType)
+}
+
+func detectMIMEType(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	header := make([]byte, 512)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	return http.DetectContentType(header[:n]), nil
+}
+
+func collectTypeStats(root string, classify func(path string) (string, error)) (map[string]TypeStats, error) {
+	stats := make(map[string]TypeStats)
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		key, err := classify(path)
+		if err != nil {
+			return err
+		}
+		entry := stats[key]
+		entry.Count++
+		entry.Size += info.Size()
+		stats[key] = entry
+		return nil
+	})
+
+	if err != nil {
+		logger().Error("error while collecting file statistics", "root", root, "err", err)
+		return nil, err
+	}
+	return stats, nil
+}
+
+// SortKey selects how TopFiles ranks files
+type SortKey int
+
+const (
+	// SortBySize ranks the largest files first
+	SortBySize SortKey = iota
+	// SortByOldest ranks the least recently modified files first
+	SortByOldest
+	// SortByNewest ranks the most recently modified files first
+	SortByNewest
+)
+
+// FileRecord describes a regular file found during a walk
+type FileRecord struct {
+	Path       string
+	Size       int64
+	ModTime    time.Time
+	AccessTime time.Time
+}
+
+func newFileRecord(path string, info fs.FileInfo) FileRecord {
+	return FileRecord{
+		Path:       path,
+		Size:       info.Size(),
+		ModTime:    info.ModTime(),
+		AccessTime: accessTime(info),
+	}
+}
+
+// TopFiles returns up to n regular files under root ranked by key. Only n
+// records are held in memory at any time, so it is safe on huge trees.
+func TopFiles(root string, n int, by SortKey) ([]FileRecord, error) {
+	if n <= 0 {
+		return []FileRecord{}, nil
+	}
+
+	h := &fileHeap{better: rankFunc(by)}
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		record := newFileRecord(path, info)
+		if h.Len() < n {
+			heap.Push(h, record)
+		} else if h.better(record, h.records[0]) {
+			h.records[0] = record
+			heap.Fix(h, 0)
+		}
+		return nil
+	})
+
+	if err != nil {
+		logger().Error("error while ranking files", "root", root, "err", err)
+		return nil, err
+	}
+
+	sort.Slice(h.records, func(i, j int) bool {
+		return h.better(h.records[i], h.records[j])
+	})
+	return h.records, nil
+}
+
+func rankFunc(by SortKey) func(a, b FileRecord) bool {
+	switch by {
+	case SortByOldest:
+		return func(a, b FileRecord) bool { return a.ModTime.Before(b.ModTime) }
+	case SortByNewest:
+		return func(a, b FileRecord) bool { return a.ModTime.After(b.ModTime) }
+	default:
+		return func(a, b FileRecord) bool { return a.Size > b.Size }
+	}
+}
+
+// fileHeap keeps the worst ranked record at the root so it can be evicted
+type fileHeap struct {
+	records []FileRecord
+	better  func(a, b FileRecord) bool
+}
+
+func (h *fileHeap) Len() int           { return len(h.records) }
+func (h *fileHeap) Less(i, j int) bool { return h.better(h.records[j], h.records[i]) }
+func (h *fileHeap) Swap(i, j int)      { h.records[i], h.records[j] = h.records[j], h.records[i] }
+func (h *fileHeap) Push(x any)         { h.records = append(h.records, x.(FileRecord)) }
+func (h *fileHeap) Pop() any {
+	last := h.records[len(h.records)-1]
+	h.records = h.records[:len(h.records)-1]
+	return last
+}
+
+// FindColdFiles returns the regular files under root that have not been read
+// or written for at least olderThan, as candidates for archiving or tiering.
+//
+// A file's last use is the later of its atime and mtime, which keeps files on
+// noatime mounts from looking cold right after they are written. On relatime
+// mounts (the Linux default) atime is refreshed at most once a day, so
+// thresholds below 24 hours may report files that were read recently.
+func FindColdFiles(root string, olderThan time.Duration) ([]FileRecord, error) {
+	cutoff := time.Now().Add(-olderThan)
+	cold := []FileRecord{}
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		record := newFileRecord(path, info)
+		lastUse := record.AccessTime
+		if record.ModTime.After(lastUse) {
+			lastUse = record.ModTime
+		}
+		if lastUse.Before(cutoff) {
+			cold = append(cold, record)
+		}
+		return nil
+	})
+
+	if err != nil {
+		logger().Error("error while searching for cold files", "root", root, "err", err)
+		return nil, err
+	}
+	return cold, nil
+}
+
+// DirSize is the disk usage of a directory tree
+type DirSize struct {
+	Bytes int64 // cumulative size of the files
+	Files int64 // number of files, at any depth
+	Dirs  int64 // number of directories below the root
+}
+
+// DirSizeOptions tunes GetDirSizeWithOptions.
+// The zero value behaves exactly like GetDirSize.
+type DirSizeOptions struct {
+	// FollowSymlinks counts what symbolic links point to. Linked
+	// directories leading back into the tree are skipped. Without it links
+	// are not counted at all
+	FollowSymlinks bool
+
+	// Workers, when above 1, walks the tree with ParallelWalkDir using that
+	// many workers
+	Workers int
+
+	// Include, Exclude and Filter leave files and directories out of the
+	// totals like their CopyOptions counterparts. When any of them is set,
+	// hash and hold sidecars are left out too, as they are from copies
+	Include []string
+	Exclude []string
+	Filter  func(path string, d fs.DirEntry) bool
+}
+
+// filtering reports whether any of Include, Exclude or Filter is set
+func (o DirSizeOptions) filtering() bool {
+	return len(o.Include) > 0 || len(o.Exclude) > 0 || o.Filter != nil
+}
+
+// GetDirSize walks root and returns the total size of its files along with
+// how many files and directories it holds. Symbolic links are skipped
+func GetDirSize(root string) (DirSize, error) {
+	return GetDirSizeWithOptions(root, DirSizeOptions{})
+}
+
+// GetDirSizeWithOptions is GetDirSize applying opts
+func GetDirSizeWithOptions(root string, opts DirSizeOptions) (DirSize, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		logger().Error("error reading file info", "path", root, "err", err)
+		return DirSize{}, err
+	}
+	if !info.IsDir() {
+		return DirSize{}, pathError("dirsize", root, ErrNotDirectory)
+	}
+	// A linked root is walked at its target, since the walk would otherwise
+	// report the link itself and never enter it
+	resolved, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		logger().Error("error resolving root", "path", root, "err", err)
+		return DirSize{}, err
+	}
+	root = resolved
+
+	walk := filepath.WalkDir
+	if opts.Workers > 1 {
+		walk = func(root string, fn fs.WalkDirFunc) error {
+			return ParallelWalkDir(root, opts.Workers, fn)
+		}
+	}
+
+	// Counted atomically, as ParallelWalkDir calls back concurrently
+	var bytes, files, dirs atomic.Int64
+	count := func(info fs.FileInfo) {
+		bytes.Add(info.Size())
+		files.Add(1)
+	}
+
+	// Linked directories are walked rooted at their target, with relRoot
+	// their path below root for the filters. chain holds the roots walked
+	// so far, for symlink loop checks
+	var visit func(srcRoot, relRoot string, chain []string) fs.WalkDirFunc
+	visit = func(srcRoot, relRoot string, chain []string) fs.WalkDirFunc {
+		return func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			relPath, _ := filepath.Rel(srcRoot, path)
+			rel := filepath.Join(relRoot, relPath)
+			if opts.filtering() && rel != "." && entryFiltered(opts.Include, opts.Exclude, opts.Filter, path, rel, d) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if d.IsDir() {
+				dirs.Add(1)
+				return nil
+			}
+			if !isSymlink(d.Type()) {
+				info, err := d.Info()
+				if err != nil {
+					return err
+				}
+				count(info)
+				return nil
+			}
+
+			if !opts.FollowSymlinks {
+				return nil
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				logger().Debug("skipping broken symlink", "path", path, "err", err)
+				return nil
+			}
+			if !info.IsDir() {
+				count(info)
+				return nil
+			}
+			loops, err := symlinkLoops(path, chain)
+			if err != nil {
+				return err
+			}
+			if loops {
+				logger().Debug("skipping", "path", path, "reason", ErrSymlinkLoop)
+				return nil
+			}
+			target, err := filepath.EvalSymlinks(path)
+			if err != nil {
+				return err
+			}
+			return walk(target, visit(target, rel, append(chain[:len(chain):len(chain)], target)))
+		}
+	}
+
+	if err := walk(root, visit(root, "", []string{root})); err != nil {
+		logger().Error("error while calculating directory size", "root", root, "err", err)
+		return DirSize{}, err
+	}
+
+	// The root itself is not counted
+	return DirSize{Bytes: bytes.Load(), Files: files.Load(), Dirs: dirs.Load() - 1}, nil
+}
@@ -0,0 +1,149 @@
+package gstorage
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// Storage is the minimal set of operations gstorage needs from a place that
+// holds files. Names are slash-separated and relative to the backend's root.
+// Backends without real directories, such as object stores, treat a
+// directory as the set of names sharing its prefix.
+type Storage interface {
+	// Open opens name for reading
+	Open(name string) (io.ReadCloser, error)
+	// Create creates or truncates name for writing. The content is only
+	// guaranteed to be stored once Close returns nil
+	Create(name string) (io.WriteCloser, error)
+	// Stat returns information about name
+	Stat(name string) (fs.FileInfo, error)
+	// List returns the entries directly inside dir
+	List(dir string) ([]fs.DirEntry, error)
+	// Remove removes the file name
+	Remove(name string) error
+	// Rename moves oldname to newname, replacing newname if it exists
+	Rename(oldname, newname string) error
+}
+
+// LocalBackend is the Storage implementation for the local filesystem. It is
+// the default for paths outside every Mount, and can be used directly with
+// CopyFileBetween and CopyDirBetween. Names are resolved under Root and
+// cannot escape it, or used as they are when Root is empty.
+type LocalBackend struct {
+	Root string
+}
+
+var _ Storage = LocalBackend{}
+
+func (b LocalBackend) path(name string) string {
+	if b.Root == "" {
+		return filepath.FromSlash(name)
+	}
+	return filepath.Join(b.Root, filepath.FromSlash(path.Clean("/"+name)))
+}
+
+func (b LocalBackend) Open(name string) (io.ReadCloser, error) {
+	return os.Open(b.path(name))
+}
+
+// Create creates any missing parent directories, mirroring object stores
+// where a name can always be written
+func (b LocalBackend) Create(name string) (io.WriteCloser, error) {
+	p := b.path(name)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return nil, err
+	}
+	return os.Create(p)
+}
+
+func (b LocalBackend) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(b.path(name))
+}
+
+func (b LocalBackend) List(dir string) ([]fs.DirEntry, error) {
+	return os.ReadDir(b.path(dir))
+}
+
+func (b LocalBackend) Remove(name string) error {
+	return os.Remove(b.path(name))
+}
+
+func (b LocalBackend) Rename(oldname, newname string) error {
+	return os.Rename(b.path(oldname), b.path(newname))
+}
+
+// CopyFileBetween copies srcName on src to dstName on dst, so the same code
+// copies local to local, local to object storage and back
+func CopyFileBetween(src Storage, srcName string, dst Storage, dstName string) error {
+	reader, err := src.Open(srcName)
+	if err != nil {
+		logger().Error("error reading source file", "src", srcName, "err", err)
+		return err
+	}
+	defer reader.Close()
+
+	writer, err := dst.Create(dstName)
+	if err != nil {
+		logger().Error("error creating destination file", "dst", dstName, "err", err)
+		return err
+	}
+
+	if _, err := io.Copy(writer, reader); err != nil {
+		writer.Close()
+		logger().Error("error while copying files", "src", srcName, "dst", dstName, "err", err)
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		logger().Error("error while closing destination file", "dst", dstName, "err", err)
+		return err
+	}
+
+	logger().Debug("successfully copied file", "src", srcName, "dst", dstName)
+	return nil
+}
+
+// CopyDirBetween copies the tree under srcDir on src to dstDir on dst
+func CopyDirBetween(src Storage, srcDir string, dst Storage, dstDir string) (err error) {
+	summary := newOpSummary("CopyDirBetween")
+	defer func() {
+		summary.finish(err)
+	}()
+	return copyDirBetween(src, srcDir, dst, dstDir, summary)
+}
+
+func copyDirBetween(src Storage, srcDir string, dst Storage, dstDir string, summary *opSummary) error {
+	entries, err := src.List(srcDir)
+	if err != nil {
+		logger().Error("error reading source directory", "src", srcDir, "err", err)
+		return err
+	}
+
+	for _, entry := range entries {
+		srcName := path.Join(srcDir, entry.Name())
+		dstName := path.Join(dstDir, entry.Name())
+
+		if entry.IsDir() {
+			if err := copyDirBetween(src, srcName, dst, dstName, summary); err != nil {
+				return err
+			}
+			continue
+		}
+		if isSpecial(entry.Type()) {
+			logger().Debug("skipping", "path", srcName, "reason", ErrSpecialFile)
+			continue
+		}
+
+		if err := CopyFileBetween(src, srcName, dst, dstName); err != nil {
+			return err
+		}
+		var size int64
+		if info, err := entry.Info(); err == nil {
+			size = info.Size()
+		}
+		summary.fileDone(size)
+	}
+	return nil
+}
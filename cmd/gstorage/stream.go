@@ -0,0 +1,87 @@
+package gstorage
+
+import (
+	"bufio"
+	"io"
+	"iter"
+	"os"
+	"strings"
+)
+
+// ReadFileLines yields the lines of path one at a time, without their
+// "\n" or "\r\n" ending, so files of any size are read in constant memory
+// apart from the longest line. A final line without a line break is
+// yielded too. The file is opened when the sequence is consumed and
+// closed when the consumer stops; an error is yielded once, with an empty
+// line, and ends the sequence
+func ReadFileLines(path string) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		file, err := os.Open(path)
+		if err != nil {
+			logger().Error("error reading file", "path", path, "err", err)
+			yield("", err)
+			return
+		}
+		defer file.Close()
+
+		r := bufio.NewReader(file)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil && err != io.EOF {
+				logger().Error("error reading file", "path", path, "err", err)
+				yield("", err)
+				return
+			}
+			if line == "" && err == io.EOF {
+				return
+			}
+			line = strings.TrimSuffix(line, "\n")
+			line = strings.TrimSuffix(line, "\r")
+			if !yield(line, nil) || err == io.EOF {
+				return
+			}
+		}
+	}
+}
+
+// ReadFileChunks yields the content of path in chunks of chunkSize bytes,
+// the last one possibly shorter. A chunkSize of 0 picks a default, and a
+// negative one yields ErrInvalidChunkSize. The chunk's backing array is
+// reused, so copy a chunk to keep it past the next iteration. The file is
+// opened when the sequence is consumed and closed when the consumer stops;
+// an error is yielded once, with a nil chunk, and ends the sequence
+func ReadFileChunks(path string, chunkSize int) iter.Seq2[[]byte, error] {
+	return func(yield func([]byte, error) bool) {
+		if chunkSize < 0 {
+			yield(nil, ErrInvalidChunkSize)
+			return
+		}
+		if chunkSize == 0 {
+			chunkSize = defaultAutoChunkSize
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			logger().Error("error reading file", "path", path, "err", err)
+			yield(nil, err)
+			return
+		}
+		defer file.Close()
+
+		buf := make([]byte, chunkSize)
+		for {
+			n, err := io.ReadFull(file, buf)
+			if n > 0 && !yield(buf[:n], nil) {
+				return
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return
+			}
+			if err != nil {
+				logger().Error("error reading file", "path", path, "err", err)
+				yield(nil, err)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package gstorage
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// isSymlink reports whether mode describes a symbolic link
+func isSymlink(mode fs.FileMode) bool {
+	return mode&fs.ModeSymlink != 0
+}
+
+// preserveSymlink recreates the link at src as dst, replacing whatever
+// non-directory is at dst
+func preserveSymlink(src, dst string) error {
+	target, err := os.Readlink(src)
+	if err != nil {
+		logger().Error("error reading symlink", "path", src, "err", err)
+		return err
+	}
+	if err := checkOverwrite(dst); err != nil {
+		return err
+	}
+	if info, err := os.Lstat(dst); err == nil && !info.IsDir() {
+		if err := os.Remove(dst); err != nil {
+			return err
+		}
+	}
+	if err := os.Symlink(target, dst); err != nil {
+		logger().Error("error creating symlink", "path", dst, "err", err)
+		return err
+	}
+	logger().Debug("preserved symlink", "src", src, "dst", dst, "target", target)
+	return nil
+}
+
+// symlinkLoops reports whether following the directory link at link would
+// re-enter one of dirs, the directories currently being copied, or the
+// directory holding the link. Paths are compared after resolving links
+func symlinkLoops(link string, dirs []string) (bool, error) {
+	target, err := filepath.EvalSymlinks(link)
+	if err != nil {
+		return false, err
+	}
+
+	for _, dir := range append(dirs, filepath.Dir(link)) {
+		real, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			return false, err
+		}
+		// Looping means target is dir itself or one of its ancestors
+		rel, err := filepath.Rel(target, real)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// copySymlink applies the symlink policy to a link met during CopyDir. A
+// followed link to a file is rendered as a template if render is set
+func (c *dirCopy) copySymlink(srcPath string, dstPath string, render bool) error {
+	switch c.opts.Symlinks {
+	case SymlinkSkip:
+		c.opts.skip(srcPath, ErrSymlink)
+		return nil
+	case SymlinkError:
+		logger().Error("refusing to copy symlink", "path", srcPath)
+		return fmt.Errorf("%s: %w", srcPath, ErrSymlink)
+	case SymlinkPreserve:
+		return preserveSymlink(srcPath, dstPath)
+	}
+
+	info, err := os.Stat(srcPath)
+	if err != nil {
+		logger().Error("error following symlink", "path", srcPath, "err", err)
+		return err
+	}
+	if !info.IsDir() {
+		return c.copyFile(srcPath, dstPath, fs.FileInfoToDirEntry(info), render)
+	}
+
+	loops, err := symlinkLoops(srcPath, c.stack)
+	if err != nil {
+		return err
+	}
+	if loops {
+		c.opts.skip(srcPath, ErrSymlinkLoop)
+		return nil
+	}
+	return c.copyDir(srcPath, dstPath)
+}
@@ -0,0 +1,349 @@
+package gstorage
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SyncCompare selects how SyncDir decides whether a file has changed
+type SyncCompare int
+
+const (
+	// SyncBySizeAndTime treats files with the same size and modification
+	// time as unchanged, like rsync's default quick check
+	SyncBySizeAndTime SyncCompare = iota
+	// SyncByChecksum treats files with the same size and MD5 as unchanged,
+	// whatever their modification times
+	SyncByChecksum
+)
+
+// SyncActionKind is what a SyncAction does to the destination
+type SyncActionKind int
+
+const (
+	SyncMkdir  SyncActionKind = iota // create a missing directory
+	SyncCopy                         // copy a file missing from the destination
+	SyncUpdate                       // overwrite a file that changed
+	SyncLink                         // recreate a symbolic link
+	SyncDelete                       // remove an entry not in the source
+)
+
+func (k SyncActionKind) String() string {
+	switch k {
+	case SyncMkdir:
+		return "mkdir"
+	case SyncCopy:
+		return "copy"
+	case SyncUpdate:
+		return "update"
+	case SyncLink:
+		return "link"
+	case SyncDelete:
+		return "delete"
+	default:
+		return fmt.Sprintf("SyncActionKind(%d)", int(k))
+	}
+}
+
+// SyncAction is one change SyncDir made, or would make in a dry run. Path
+// is relative to both roots; with Copy.SanitizeNames it is the sanitized
+// destination path
+type SyncAction struct {
+	Kind SyncActionKind
+	Path string
+	Size int64
+}
+
+// SyncOptions tunes SyncDir. The zero value copies new and changed files,
+// compared by size and modification time, and deletes nothing.
+type SyncOptions struct {
+	Compare SyncCompare
+
+	// Delete removes destination entries that are not in the source. It
+	// goes through RemoveDirAll, so legal holds, write-once retention and
+	// soft-delete roots apply
+	Delete bool
+
+	// DryRun plans the sync and returns its actions without touching disk
+	DryRun bool
+
+	// Copy holds the options applied to each copied file, including the
+	// MinFileAge and MaxFileSize filters. Modification times are always
+	// preserved, so the next sync can tell the file is unchanged. Symbolic
+	// links are recreated with SymlinkPreserve, refused with SymlinkError
+	// and otherwise skipped. Copy.Handle pauses the sync between actions
+	// and Copy.ReadDirBatch applies to the walks over both trees.
+	// Copy.Progress reports the files copied, without totals
+	Copy CopyOptions
+}
+
+// SyncDir makes dstDir mirror srcDir the way rsync does: only files that
+// are new or changed are copied, and with opts.Delete anything in dstDir
+// that is not in srcDir is removed. It returns the actions taken, in order,
+// including on error; with opts.DryRun they are only planned
+func SyncDir(srcDir string, dstDir string, opts SyncOptions) (actions []SyncAction, err error) {
+	summary := newOpSummary("SyncDir")
+	// Which files need copying is only known as the sync goes, so there
+	// are no totals
+	progress := newProgressTracker(opts.Copy.Progress, 0, 0)
+	defer func() {
+		progress.finish(err)
+		summary.finish(err)
+	}()
+
+	srcStat, err := os.Stat(srcDir)
+	if err != nil {
+		logger().Error("error while getting source info", "src", srcDir, "err", err)
+		return nil, err
+	}
+	if !srcStat.IsDir() {
+		return nil, pathError("sync", srcDir, ErrNotDirectory)
+	}
+
+	opts.Copy.Handle.begin(summary, 1, nil)
+	names := newNameMapper(opts.Copy.SanitizeNames, opts.Copy.SanitizePlatform)
+	var manifest *copyManifest
+	if !opts.DryRun {
+		manifest = newCopyManifest(opts.Copy.Manifest, dstDir, opts.Copy.Transform)
+		defer func() {
+			if manifestErr := manifest.write(opts.Copy.Manifest); err == nil {
+				err = manifestErr
+			}
+		}()
+	}
+	copyOpts := opts.Copy
+	copyOpts.PreserveTimes = true
+	now := time.Now()
+
+	// apply runs do, unless this is a dry run, and records the action once
+	// it has succeeded
+	apply := func(kind SyncActionKind, rel string, size int64, do func() error) error {
+		if !opts.DryRun {
+			if err := opts.Copy.Handle.wait(context.Background()); err != nil {
+				return err
+			}
+			if err := do(); err != nil {
+				return err
+			}
+		}
+		actions = append(actions, SyncAction{Kind: kind, Path: rel, Size: size})
+		logger().Debug("sync", "action", kind, "path", rel, "dry_run", opts.DryRun)
+		return nil
+	}
+
+	walk := filepath.WalkDir
+	if opts.Copy.ReadDirBatch > 0 {
+		walk = func(root string, fn fs.WalkDirFunc) error {
+			return walkDirBatched(root, opts.Copy.ReadDirBatch, fn)
+		}
+	}
+
+	// With sanitized names the source counterpart of a destination entry
+	// cannot be found by path, so the forward walk records which
+	// destination paths it covered
+	var kept map[string]bool
+	if opts.Copy.SanitizeNames {
+		kept = make(map[string]bool)
+	}
+	// Entries left out by the filters are neither copied nor deleted
+	protected := make(map[string]bool)
+
+	err = walk(srcDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		srcRel, _ := filepath.Rel(srcDir, path)
+		rel := names.rel(srcDir, srcRel)
+		if kept != nil {
+			kept[rel] = true
+		}
+		if srcRel != "." && opts.Copy.filtered(path, srcRel, d) {
+			opts.Copy.skip(path, ErrFiltered)
+			protected[rel] = true
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		dst := filepath.Join(dstDir, rel)
+		if srcRel != "." {
+			manifest.add(path, srcRel, dst, d, false)
+		}
+		dstInfo, dstErr := os.Lstat(dst)
+		if dstErr != nil && !os.IsNotExist(dstErr) {
+			return dstErr
+		}
+		exists := dstErr == nil
+
+		switch {
+		case d.IsDir():
+			if exists && dstInfo.IsDir() {
+				return nil
+			}
+			if exists {
+				return pathError("sync", dst, ErrNotDirectory)
+			}
+			return apply(SyncMkdir, rel, 0, func() error {
+				return os.MkdirAll(dst, 0755)
+			})
+
+		case isSymlink(d.Type()):
+			switch opts.Copy.Symlinks {
+			case SymlinkPreserve:
+			case SymlinkError:
+				return fmt.Errorf("%s: %w", path, ErrSymlink)
+			default:
+				opts.Copy.skip(path, ErrSymlink)
+				return nil
+			}
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if exists && isSymlink(dstInfo.Mode()) {
+				if current, err := os.Readlink(dst); err == nil && current == target {
+					return nil
+				}
+			}
+			return apply(SyncLink, rel, 0, func() error {
+				return preserveSymlink(path, dst)
+			})
+
+		case isSpecial(d.Type()):
+			opts.Copy.skip(path, ErrSpecialFile)
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if copyOpts.MinFileAge > 0 && now.Sub(info.ModTime()) < copyOpts.MinFileAge {
+			opts.Copy.skip(path, ErrFileTooRecent)
+			return nil
+		}
+		if copyOpts.MaxFileSize > 0 && info.Size() > copyOpts.MaxFileSize {
+			opts.Copy.skip(path, ErrFileTooLarge)
+			return nil
+		}
+
+		kind := SyncCopy
+		if exists {
+			if dstInfo.IsDir() {
+				return pathError("sync", dst, ErrIsDirectory)
+			}
+			changed, err := syncChanged(path, info, dst, dstInfo, opts.Compare)
+			if err != nil {
+				return err
+			}
+			if !changed {
+				return nil
+			}
+			kind = SyncUpdate
+		}
+
+		return apply(kind, rel, info.Size(), func() error {
+			copyOpts.Handle.working(1, path)
+			err := copyFileWithOptions(context.Background(), path, dst, info, copyOpts, progress)
+			copyOpts.Handle.idle(1, info.Size(), err == nil)
+			if err != nil {
+				return err
+			}
+			summary.fileDone(info.Size())
+			return nil
+		})
+	})
+	if err != nil {
+		logger().Error("error while syncing", "src", srcDir, "dst", dstDir, "err", err)
+		return actions, err
+	}
+
+	if opts.Delete {
+		filter := pathFilter{include: opts.Copy.Include, exclude: opts.Copy.Exclude}
+		keep := func(rel string, d fs.DirEntry) (bool, error) {
+			if protected[rel] || filter.excluded(rel, d.IsDir()) {
+				if d.IsDir() {
+					return true, filepath.SkipDir
+				}
+				return true, nil
+			}
+			if kept != nil {
+				return kept[rel], nil
+			}
+			_, err := os.Lstat(filepath.Join(srcDir, rel))
+			if os.IsNotExist(err) {
+				return false, nil
+			}
+			return err == nil, err
+		}
+		if err := syncDelete(dstDir, keep, walk, apply); err != nil {
+			logger().Error("error while deleting extraneous files", "dst", dstDir, "err", err)
+			return actions, err
+		}
+	}
+	return actions, nil
+}
+
+// syncChanged reports whether dst differs from src under compare
+func syncChanged(src string, srcInfo fs.FileInfo, dst string, dstInfo fs.FileInfo, compare SyncCompare) (bool, error) {
+	if !dstInfo.Mode().IsRegular() || srcInfo.Size() != dstInfo.Size() {
+		return true, nil
+	}
+	if compare != SyncByChecksum {
+		return !srcInfo.ModTime().Equal(dstInfo.ModTime()), nil
+	}
+
+	srcHash, err := CalculateFileMD5(src)
+	if err != nil {
+		return false, err
+	}
+	dstHash, err := CalculateFileMD5(dst)
+	if err != nil {
+		return false, err
+	}
+	return srcHash != dstHash, nil
+}
+
+// syncDelete removes the entries of dstDir that keep rejects. keep may
+// return filepath.SkipDir to keep a directory with everything below it. A
+// removed directory is reported once, not per entry below it
+func syncDelete(dstDir string, keep func(rel string, d fs.DirEntry) (bool, error), walk func(string, fs.WalkDirFunc) error, apply func(SyncActionKind, string, int64, func() error) error) error {
+	return walk(dstDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == dstDir {
+				// Nothing was synced yet, as in a dry run to a new destination
+				return filepath.SkipAll
+			}
+			return err
+		}
+		rel, _ := filepath.Rel(dstDir, path)
+		if rel == "." {
+			return nil
+		}
+		if isDeletedArea(path) {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && isSidecarName(d.Name()) {
+			// Sidecars go with the file they describe
+			return nil
+		}
+		if kept, err := keep(rel, d); kept || err != nil {
+			return err
+		}
+
+		err = apply(SyncDelete, rel, 0, func() error {
+			return RemoveDirAll(path)
+		})
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}
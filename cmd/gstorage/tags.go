@@ -0,0 +1,128 @@
+package gstorage
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// ErrInvalidTag is returned for an empty tag key
+var ErrInvalidTag = errors.New("tag key must not be empty")
+
+const tagsXattrName = "user.gstorage.tags"
+
+// tagsSidecarPath returns the sidecar file holding the tags of path on
+// filesystems without extended attributes
+func tagsSidecarPath(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".gstorage-tags")
+}
+
+// SetTag attaches the tag key=value to path, replacing any previous value
+// for key. Tags live in the user.gstorage.tags extended attribute, or in a
+// hidden ".<name>.gstorage-tags" sidecar where xattrs are unsupported.
+// Concurrent SetTag calls on the same file may lose updates
+func SetTag(path string, key string, value string) error {
+	if key == "" {
+		return pathError("settag", path, ErrInvalidTag)
+	}
+	tags, err := GetTags(path)
+	if err != nil {
+		return err
+	}
+	tags[key] = value
+	return writeTags(path, tags)
+}
+
+// RemoveTag removes the tag key from path. Removing a missing tag is not
+// an error
+func RemoveTag(path string, key string) error {
+	tags, err := GetTags(path)
+	if err != nil {
+		return err
+	}
+	if _, ok := tags[key]; !ok {
+		return nil
+	}
+	delete(tags, key)
+	return writeTags(path, tags)
+}
+
+// GetTags returns the tags attached to path. A file without tags returns
+// an empty, non-nil map
+func GetTags(path string) (map[string]string, error) {
+	if _, err := os.Stat(path); err != nil {
+		logger().Error("error reading file info", "path", path, "err", err)
+		return nil, err
+	}
+
+	data, ok, err := getXattr(path, tagsXattrName)
+	if errors.Is(err, ErrXattrUnsupported) {
+		data, err = os.ReadFile(tagsSidecarPath(path))
+		ok = err == nil
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	}
+	if err != nil {
+		logger().Error("error reading tags", "path", path, "err", err)
+		return nil, err
+	}
+
+	tags := make(map[string]string)
+	if ok && len(data) > 0 {
+		if err := json.Unmarshal(data, &tags); err != nil {
+			logger().Error("error decoding tags", "path", path, "err", err)
+			return nil, err
+		}
+	}
+	return tags, nil
+}
+
+// writeTags replaces the stored tags of path with tags
+func writeTags(path string, tags map[string]string) error {
+	data, err := json.Marshal(tags)
+	if err != nil {
+		return err
+	}
+
+	err = setXattr(path, tagsXattrName, data)
+	if errors.Is(err, ErrXattrUnsupported) {
+		err = os.WriteFile(tagsSidecarPath(path), data, 0644)
+	}
+	if err != nil {
+		logger().Error("error writing tags", "path", path, "err", err)
+		return err
+	}
+	logger().Debug("updated tags", "path", path, "tags", len(tags))
+	return nil
+}
+
+// FindByTag walks root and returns the regular files tagged key=value. An
+// empty value matches any file carrying key
+func FindByTag(root string, key string, value string) ([]string, error) {
+	var matches []string
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		tags, err := GetTags(path)
+		if err != nil {
+			return err
+		}
+		if got, ok := tags[key]; ok && (value == "" || got == value) {
+			matches = append(matches, path)
+		}
+		return nil
+	})
+	if err != nil {
+		logger().Error("error searching tags", "root", root, "err", err)
+		return nil, err
+	}
+	return matches, nil
+}
@@ -0,0 +1,126 @@
+package gstorage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// ErrInvalidName is returned when a rendered file name is not a single
+// valid path element
+var ErrInvalidName = errors.New("invalid rendered file name")
+
+// templateTransformName names template rendering in manifests
+const templateTransformName = "template"
+
+// TemplateOptions renders files as text/template templates during a
+// directory copy, e.g. to scaffold projects from a skeleton tree. The zero
+// value renders nothing
+type TemplateOptions struct {
+	// Patterns selects the files to render, matched like
+	// CopyOptions.Include, e.g. "*.tmpl". Other files are copied as they are
+	Patterns []string
+
+	// Vars is the data each template is executed with, so {{.Name}}
+	// expands to Vars["Name"]. Referring to a missing variable fails the
+	// copy rather than rendering "<no value>"
+	Vars map[string]any
+
+	// Funcs are made available to every template
+	Funcs template.FuncMap
+
+	// LeftDelim and RightDelim replace "{{" and "}}", for templates of
+	// files that use those themselves. Empty keeps the default
+	LeftDelim  string
+	RightDelim string
+
+	// TrimSuffix is removed from the names of rendered files, so with
+	// ".tmpl" the file "main.go.tmpl" is rendered to "main.go"
+	TrimSuffix string
+
+	// RenderNames renders the names of files and directories holding the
+	// left delimiter as templates too, whether or not Patterns selects
+	// them, so "cmd/{{.Name}}" is copied to "cmd/demo". TrimSuffix is
+	// removed first, and entries whose name then renders empty are left
+	// out, which makes names such as "{{if .Docker}}Dockerfile{{end}}.tmpl"
+	// optional
+	RenderNames bool
+}
+
+// matches reports whether the file at rel below the source root is rendered
+func (o TemplateOptions) matches(rel string) bool {
+	return len(o.Patterns) > 0 && matchAny(o.Patterns, filepath.ToSlash(rel))
+}
+
+// renderedName returns the destination name of the rendered file name
+func (o TemplateOptions) renderedName(name string) string {
+	if o.TrimSuffix == "" {
+		return name
+	}
+	if trimmed, ok := strings.CutSuffix(name, o.TrimSuffix); ok && trimmed != "" {
+		return trimmed
+	}
+	return name
+}
+
+// renderName renders name when RenderNames is set, returning "" for
+// entries to leave out
+func (o TemplateOptions) renderName(name string) (string, error) {
+	left := o.LeftDelim
+	if left == "" {
+		left = "{{"
+	}
+	if !o.RenderNames || !strings.Contains(name, left) {
+		return name, nil
+	}
+	tmpl, err := o.parse(name, name)
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	if err := tmpl.Execute(&out, o.Vars); err != nil {
+		return "", err
+	}
+	rendered := out.String()
+	if rendered == "." || rendered == ".." || strings.ContainsAny(rendered, `/\`) {
+		return "", fmt.Errorf("%q: %w", rendered, ErrInvalidName)
+	}
+	return rendered, nil
+}
+
+// parse parses text as the template called name
+func (o TemplateOptions) parse(name string, text string) (*template.Template, error) {
+	return template.New(name).
+		Delims(o.LeftDelim, o.RightDelim).
+		Funcs(o.Funcs).
+		Option("missingkey=error").
+		Parse(text)
+}
+
+// transform returns the Transform rendering a file, which runs ahead of
+// the copy's own transforms. Templates are rendered whole before any
+// output is written, so a failing template never leaves partial output
+func (o TemplateOptions) transform() Transform {
+	return Transform{
+		Name: templateTransformName,
+		Apply: func(path string, r io.Reader) (io.Reader, error) {
+			text, err := io.ReadAll(r)
+			if err != nil {
+				return nil, err
+			}
+			tmpl, err := o.parse(filepath.Base(path), string(text))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			var out bytes.Buffer
+			if err := tmpl.Execute(&out, o.Vars); err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			return &out, nil
+		},
+	}
+}
@@ -0,0 +1,212 @@
+package gstorage
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"unicode/utf8"
+)
+
+// ErrBinaryContent is returned by ConvertLineEndings and ConvertToUTF8 for
+// files that look binary, which text conversions would corrupt
+var ErrBinaryContent = errors.New("file has binary content")
+
+// binarySniffSize is how much of a file is checked for NUL bytes to tell
+// binary content from text, as git does
+const binarySniffSize = 8000
+
+// LineEnding is the line break written by line ending conversions
+type LineEnding int
+
+const (
+	// LineEndingLF ends lines with "\n", as Unix does
+	LineEndingLF LineEnding = iota
+	// LineEndingCRLF ends lines with "\r\n", as Windows does
+	LineEndingCRLF
+)
+
+func (e LineEnding) String() string {
+	switch e {
+	case LineEndingLF:
+		return "lf"
+	case LineEndingCRLF:
+		return "crlf"
+	default:
+		return fmt.Sprintf("LineEnding(%d)", int(e))
+	}
+}
+
+// Charset is a legacy single-byte character set that ConvertToUTF8 reads
+type Charset int
+
+const (
+	// CharsetLatin1 is ISO-8859-1, whose bytes are the first 256 code points
+	CharsetLatin1 Charset = iota
+	// CharsetWindows1252 is Latin-1 with printable characters such as the
+	// euro sign and curly quotes in place of the C1 controls 0x80 to 0x9F
+	CharsetWindows1252
+)
+
+func (c Charset) String() string {
+	switch c {
+	case CharsetLatin1:
+		return "latin1"
+	case CharsetWindows1252:
+		return "windows-1252"
+	default:
+		return fmt.Sprintf("Charset(%d)", int(c))
+	}
+}
+
+// windows1252 maps the bytes 0x80 to 0x9F of Windows-1252. Bytes the
+// charset leaves undefined keep their Latin-1 code points
+var windows1252 = [32]rune{
+	0x20AC, 0x0081, 0x201A, 0x0192, 0x201E, 0x2026, 0x2020, 0x2021,
+	0x02C6, 0x2030, 0x0160, 0x2039, 0x0152, 0x008D, 0x017D, 0x008F,
+	0x0090, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014,
+	0x02DC, 0x2122, 0x0161, 0x203A, 0x0153, 0x009D, 0x017E, 0x0178,
+}
+
+// ConvertLineEndings rewrites the text file at path in place so every line
+// ends with ending. Both "\n" and "\r\n" count as line breaks; a lone "\r"
+// is left alone. The file is replaced atomically and keeps its
+// permissions. Binary files fail with ErrBinaryContent
+func ConvertLineEndings(path string, ending LineEnding) error {
+	return convertText(path, func(r *bufio.Reader) io.Reader {
+		return newLineEndingReader(r, ending)
+	})
+}
+
+// ConvertToUTF8 rewrites the text file at path, encoded in from, in place
+// as UTF-8. The file is replaced atomically and keeps its permissions.
+// Binary files fail with ErrBinaryContent. Files already in UTF-8 are not
+// detected, and would be converted twice
+func ConvertToUTF8(path string, from Charset) error {
+	return convertText(path, func(r *bufio.Reader) io.Reader {
+		return newCharsetReader(r, from)
+	})
+}
+
+// LineEndingTransform returns a Transform converting the line endings of
+// text files as ConvertLineEndings does. Binary files pass through as
+// they are
+func LineEndingTransform(ending LineEnding) Transform {
+	return Transform{
+		Name: ending.String(),
+		Apply: func(path string, r io.Reader) (io.Reader, error) {
+			br := bufio.NewReaderSize(r, binarySniffSize)
+			if looksBinary(br) {
+				return br, nil
+			}
+			return newLineEndingReader(br, ending), nil
+		},
+	}
+}
+
+// CharsetTransform returns a Transform converting text files from the
+// charset from to UTF-8 as ConvertToUTF8 does. Binary files pass through
+// as they are
+func CharsetTransform(from Charset) Transform {
+	return Transform{
+		Name: from.String() + "-to-utf8",
+		Apply: func(path string, r io.Reader) (io.Reader, error) {
+			br := bufio.NewReaderSize(r, binarySniffSize)
+			if looksBinary(br) {
+				return br, nil
+			}
+			return newCharsetReader(br, from), nil
+		},
+	}
+}
+
+// convertText rewrites the text file at path through the reader convert
+// wraps around its content
+func convertText(path string, convert func(r *bufio.Reader) io.Reader) error {
+	file, err := os.Open(path)
+	if err != nil {
+		logger().Error("error opening file", "path", path, "err", err)
+		return err
+	}
+	defer file.Close()
+
+	br := bufio.NewReaderSize(file, binarySniffSize)
+	if looksBinary(br) {
+		return fmt.Errorf("%s: %w", path, ErrBinaryContent)
+	}
+	return WriteFileFromReaderWithOptions(path, convert(br), WriteOptions{Atomic: true})
+}
+
+// looksBinary reports whether the start of r holds a NUL byte, without
+// consuming it. r must buffer at least binarySniffSize bytes
+func looksBinary(r *bufio.Reader) bool {
+	head, _ := r.Peek(binarySniffSize)
+	return bytes.IndexByte(head, 0) >= 0
+}
+
+// byteExpander reads src a byte at a time, replacing each byte with the
+// bytes expand returns. expand may consume further bytes of src
+type byteExpander struct {
+	src     *bufio.Reader
+	expand  func(b byte) []byte
+	pending []byte
+}
+
+func (x *byteExpander) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(x.pending) > 0 {
+			copied := copy(p[n:], x.pending)
+			x.pending = x.pending[copied:]
+			n += copied
+			continue
+		}
+		b, err := x.src.ReadByte()
+		if err != nil {
+			if err == io.EOF && n > 0 {
+				return n, nil
+			}
+			return n, err
+		}
+		x.pending = x.expand(b)
+	}
+	return n, nil
+}
+
+// newLineEndingReader returns a reader converting the line breaks of src
+// to ending
+func newLineEndingReader(src *bufio.Reader, ending LineEnding) io.Reader {
+	brk := []byte("\n")
+	if ending == LineEndingCRLF {
+		brk = []byte("\r\n")
+	}
+	var same [1]byte
+	return &byteExpander{src: src, expand: func(b byte) []byte {
+		switch b {
+		case '\r':
+			if next, err := src.Peek(1); err == nil && next[0] == '\n' {
+				src.ReadByte()
+				return brk
+			}
+		case '\n':
+			return brk
+		}
+		same[0] = b
+		return same[:]
+	}}
+}
+
+// newCharsetReader returns a reader decoding src from the charset from
+// into UTF-8
+func newCharsetReader(src *bufio.Reader, from Charset) io.Reader {
+	var buf [utf8.UTFMax]byte
+	return &byteExpander{src: src, expand: func(b byte) []byte {
+		r := rune(b)
+		if from == CharsetWindows1252 && b >= 0x80 && b < 0xA0 {
+			r = windows1252[b-0x80]
+		}
+		return utf8.AppendRune(buf[:0], r)
+	}}
+}
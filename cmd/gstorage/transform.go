@@ -0,0 +1,84 @@
+package gstorage
+
+import (
+	"io"
+)
+
+// Transform rewrites file contents on their way through a copy, so build
+// and deploy pipelines need no temporary files. Transforms given to a copy
+// are chained in order, each reading the output of the one before
+type Transform struct {
+	// Name identifies the transform in manifests
+	Name string
+
+	// Apply returns a reader yielding the transformed content of r, which
+	// reads the file at path. Returning r itself leaves the file as it is.
+	// A returned reader that is also an io.Closer is closed once the copy
+	// is done with it, even if the copy fails
+	Apply func(path string, r io.Reader) (io.Reader, error)
+}
+
+// CompressTransform returns a Transform compressing file contents as
+// CompressFileWithOptions does. The compression runs in a goroutine for
+// each file, feeding the copy through a pipe
+func CompressTransform(opts CompressOptions) Transform {
+	return Transform{
+		Name: opts.Codec.String(),
+		Apply: func(path string, r io.Reader) (io.Reader, error) {
+			pr, pw := io.Pipe()
+			go func() {
+				w, err := compressWriter(pw, opts)
+				if err == nil {
+					_, err = io.Copy(w, r)
+					if closeErr := w.Close(); err == nil {
+						err = closeErr
+					}
+				}
+				pw.CloseWithError(err)
+			}()
+			return pr, nil
+		},
+	}
+}
+
+// applyTransforms chains transforms over r, the content of the file at
+// path. The returned close func closes every stage that needs it and must
+// be called once the content has been read, or on error
+func applyTransforms(path string, r io.Reader, transforms []Transform) (io.Reader, func(), error) {
+	var closers []io.Closer
+	closeAll := func() {
+		for i := len(closers) - 1; i >= 0; i-- {
+			closers[i].Close()
+		}
+	}
+	for _, t := range transforms {
+		next, err := t.Apply(path, r)
+		if err != nil {
+			closeAll()
+			logger().Error("error applying transform", "path", path, "transform", t.Name, "err", err)
+			return nil, nil, err
+		}
+		if c, ok := next.(io.Closer); ok {
+			closers = append(closers, c)
+		}
+		r = next
+	}
+	return r, closeAll, nil
+}
+
+// teeTransform is an unnamed Transform feeding the content, as it leaves
+// the chain, to w
+func teeTransform(w io.Writer) Transform {
+	return Transform{Apply: func(path string, r io.Reader) (io.Reader, error) {
+		return io.TeeReader(r, w), nil
+	}}
+}
+
+// transformNames returns the names of transforms, or nil if there are none
+func transformNames(transforms []Transform) []string {
+	var names []string
+	for _, t := range transforms {
+		names = append(names, t.Name)
+	}
+	return names
+}
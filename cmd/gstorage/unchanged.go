@@ -0,0 +1,142 @@
+package gstorage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// copyFileIfChanged copies srcfile to dstfile like copyFile, unless the
+// content that would be written, after tee and transforms, is already what
+// dstfile holds. Identical destinations are left untouched and reported
+// as unchanged; the rest are replaced atomically, keeping their permissions
+func copyFileIfChanged(ctx context.Context, srcfile string, dstfile string, tee io.Writer, transforms []Transform) (changed bool, err error) {
+	dstInfo, err := os.Lstat(dstfile)
+	if err != nil || !dstInfo.Mode().IsRegular() {
+		return true, copyFile(ctx, srcfile, dstfile, tee, transforms)
+	}
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	reader, closeSource, err := openSource(ctx, srcfile, tee, transforms)
+	if err != nil {
+		return false, err
+	}
+	defer closeSource()
+
+	existing, err := os.Open(dstfile)
+	if err != nil {
+		logger().Error("error reading destination file", "dst", dstfile, "err", err)
+		return false, err
+	}
+	defer existing.Close()
+
+	w := &changeWriter{dst: dstfile, existing: existing}
+	defer w.discard()
+	if _, err := io.Copy(w, reader); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return false, ctxErr
+		}
+		logger().Error("error while copying files", "src", srcfile, "dst", dstfile, "err", err)
+		return false, err
+	}
+	if err := w.finish(); err != nil {
+		return false, err
+	}
+	if w.staged == nil {
+		logger().Debug("destination unchanged", "src", srcfile, "dst", dstfile)
+		return false, nil
+	}
+
+	if err := w.staged.Chmod(dstInfo.Mode().Perm()); err != nil {
+		return false, err
+	}
+	if err := w.staged.Close(); err != nil {
+		logger().Error("error while closing destination file", "dst", dstfile, "err", err)
+		return false, err
+	}
+	if err := os.Rename(w.staged.Name(), dstfile); err != nil {
+		logger().Error("error replacing destination file", "dst", dstfile, "err", err)
+		return false, err
+	}
+	w.staged = nil
+	logger().Debug("successfully copied file", "src", srcfile, "dst", dstfile)
+	return true, nil
+}
+
+// changeWriter compares the content written to it with the file existing
+// holds. Nothing is written while the two agree; at the first difference
+// the agreeing prefix is copied from existing into a staging file next to
+// dst, which receives the rest
+type changeWriter struct {
+	dst      string
+	existing *os.File
+	offset   int64 // bytes found identical so far
+	buf      []byte
+	staged   *os.File
+}
+
+func (w *changeWriter) Write(p []byte) (int, error) {
+	if w.staged == nil {
+		if cap(w.buf) < len(p) {
+			w.buf = make([]byte, len(p))
+		}
+		n, err := io.ReadFull(w.existing, w.buf[:len(p)])
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+		if n == len(p) && bytes.Equal(w.buf[:n], p) {
+			w.offset += int64(n)
+			return len(p), nil
+		}
+		if err := w.stage(); err != nil {
+			return 0, err
+		}
+	}
+	return w.staged.Write(p)
+}
+
+// finish stages the content if it ends before the existing file does
+func (w *changeWriter) finish() error {
+	if w.staged != nil {
+		return nil
+	}
+	var probe [1]byte
+	n, err := w.existing.Read(probe[:])
+	if n > 0 {
+		return w.stage()
+	}
+	if err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// stage creates the staging file holding the identical prefix, once the
+// destination is known to differ and may be replaced
+func (w *changeWriter) stage() error {
+	if err := checkOverwrite(w.dst); err != nil {
+		return err
+	}
+	staged, err := os.CreateTemp(filepath.Dir(w.dst), "."+filepath.Base(w.dst)+".*.gstorage-tmp")
+	if err != nil {
+		logger().Error("error creating destination file", "dst", w.dst, "err", err)
+		return err
+	}
+	w.staged = staged
+	if _, err := io.Copy(staged, io.NewSectionReader(w.existing, 0, w.offset)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// discard removes a staging file that was not renamed into place
+func (w *changeWriter) discard() {
+	if w.staged != nil {
+		w.staged.Close()
+		os.Remove(w.staged.Name())
+	}
+}
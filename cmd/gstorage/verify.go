@@ -0,0 +1,125 @@
+package gstorage
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// VerificationError lists the copied files that did not match their
+// source. It unwraps to ErrChecksumMismatch
+type VerificationError struct {
+	Files []string
+}
+
+func (e *VerificationError) Error() string {
+	return fmt.Sprintf("verification failed for %d file(s): %s", len(e.Files), strings.Join(e.Files, ", "))
+}
+
+func (e *VerificationError) Unwrap() error {
+	return ErrChecksumMismatch
+}
+
+// mismatchList collects the destinations that failed verification during
+// a directory copy
+type mismatchList struct {
+	mu    sync.Mutex
+	files []string
+}
+
+func (l *mismatchList) add(path string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.files = append(l.files, path)
+}
+
+// err returns the collected mismatches as a VerificationError, or nil
+func (l *mismatchList) err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.files) == 0 {
+		return nil
+	}
+	return &VerificationError{Files: append([]string(nil), l.files...)}
+}
+
+// isVerifyMismatch reports whether err is a failed verification rather
+// than a failure to copy
+func isVerifyMismatch(err error) bool {
+	return errors.Is(err, ErrChecksumMismatch)
+}
+
+// verifyCopy re-reads dst and compares it with src as mode asks, returning
+// ErrChecksumMismatch if they differ
+func verifyCopy(src, dst string, mode VerifyMode, algo HashAlgorithm) error {
+	var same bool
+	switch mode {
+	case VerifyNone:
+		return nil
+	case VerifyChecksum:
+		srcHash, err := CalculateFileHash(src, algo)
+		if err != nil {
+			return err
+		}
+		dstHash, err := CalculateFileHash(dst, algo)
+		if err != nil {
+			return err
+		}
+		same = srcHash == dstHash
+	case VerifyBytes:
+		var err error
+		if same, err = sameContent(src, dst); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown verify mode %d", mode)
+	}
+
+	if !same {
+		logger().Error("copy does not match source", "src", src, "dst", dst)
+		return pathError("verify", dst, ErrChecksumMismatch)
+	}
+	logger().Debug("verified copy", "src", src, "dst", dst)
+	return nil
+}
+
+// sameContent streams a and b side by side and reports whether their
+// contents are identical
+func sameContent(a, b string) (bool, error) {
+	fa, err := os.Open(a)
+	if err != nil {
+		return false, err
+	}
+	defer fa.Close()
+	fb, err := os.Open(b)
+	if err != nil {
+		return false, err
+	}
+	defer fb.Close()
+
+	ra, rb := bufio.NewReaderSize(fa, 64*1024), bufio.NewReaderSize(fb, 64*1024)
+	bufA, bufB := make([]byte, 32*1024), make([]byte, 32*1024)
+	for {
+		na, errA := io.ReadFull(ra, bufA)
+		nb, errB := io.ReadFull(rb, bufB)
+		if !bytes.Equal(bufA[:na], bufB[:nb]) {
+			return false, nil
+		}
+		endA := errA == io.EOF || errA == io.ErrUnexpectedEOF
+		endB := errB == io.EOF || errB == io.ErrUnexpectedEOF
+		if errA != nil && !endA {
+			return false, errA
+		}
+		if errB != nil && !endB {
+			return false, errB
+		}
+		if endA || endB {
+			return endA && endB, nil
+		}
+	}
+}
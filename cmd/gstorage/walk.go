@@ -0,0 +1,316 @@
+package gstorage
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+// walkDeque is one worker's queue of directories still to be read. The
+// owner pushes and pops at the tail, idle workers steal from the head, so
+// thieves take the oldest and usually largest subtrees.
+type walkDeque struct {
+	mu   sync.Mutex
+	dirs []walkItem
+}
+
+type walkItem struct {
+	path  string
+	entry fs.DirEntry
+}
+
+func (q *walkDeque) push(item walkItem) {
+	q.mu.Lock()
+	q.dirs = append(q.dirs, item)
+	q.mu.Unlock()
+}
+
+func (q *walkDeque) pop() (walkItem, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.dirs) == 0 {
+		return walkItem{}, false
+	}
+	item := q.dirs[len(q.dirs)-1]
+	q.dirs = q.dirs[:len(q.dirs)-1]
+	return item, true
+}
+
+func (q *walkDeque) steal() (walkItem, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.dirs) == 0 {
+		return walkItem{}, false
+	}
+	item := q.dirs[0]
+	q.dirs = q.dirs[1:]
+	return item, true
+}
+
+// parallelWalk holds the state shared by the workers of ParallelWalkDir
+type parallelWalk struct {
+	fn      fs.WalkDirFunc
+	queues  []*walkDeque
+	pending atomic.Int64 // directories queued or being read
+	queued  atomic.Int64 // directories queued and not yet taken
+	stop    atomic.Bool
+
+	// idle parks workers with nothing to do until a directory is queued
+	// or the walk ends
+	mu      sync.Mutex
+	idle    *sync.Cond
+	errOnce sync.Once
+	err     error
+}
+
+// ParallelWalkDir walks the tree rooted at root like filepath.WalkDir, but
+// reads directories concurrently with the given number of workers. Each
+// worker keeps its own queue of subdirectories and steals from the others
+// when it runs dry, so wide and deep trees both keep every worker busy.
+//
+// fn is called from several goroutines at once and must be safe for
+// concurrent use. Entries are visited in no particular order, except that a
+// directory is always visited before anything inside it. Returning
+// fs.SkipDir from fn for a directory skips its contents; fs.SkipAll stops
+// the walk without error; any other error stops the walk and is returned.
+func ParallelWalkDir(root string, workers int, fn fs.WalkDirFunc) error {
+	if workers < 1 {
+		workers = 1
+	}
+
+	info, err := os.Lstat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else if !info.IsDir() {
+		err = fn(root, fs.FileInfoToDirEntry(info), nil)
+	} else {
+		w := &parallelWalk{fn: fn, queues: make([]*walkDeque, workers)}
+		w.idle = sync.NewCond(&w.mu)
+		for i := range w.queues {
+			w.queues[i] = &walkDeque{}
+		}
+		w.enqueue(w.queues[0], walkItem{path: root, entry: fs.FileInfoToDirEntry(info)})
+
+		var wg sync.WaitGroup
+		for i := range workers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				w.work(i)
+			}()
+		}
+		wg.Wait()
+		err = w.err
+	}
+
+	if err == fs.SkipDir || err == fs.SkipAll {
+		return nil
+	}
+	return err
+}
+
+// work runs worker id until the walk finishes or is stopped
+func (w *parallelWalk) work(id int) {
+	own := w.queues[id]
+	for !w.stop.Load() {
+		item, ok := own.pop()
+		if !ok {
+			item, ok = w.stealFrom(id)
+		}
+		if !ok {
+			// Another worker may still be reading a directory and yet
+			// produce work for us
+			if !w.wait() {
+				return
+			}
+			continue
+		}
+		w.queued.Add(-1)
+
+		if err := w.visitDir(own, item); err != nil {
+			w.fail(err)
+		}
+		if w.pending.Add(-1) == 0 {
+			w.wake(true)
+		}
+	}
+}
+
+// enqueue queues a directory on q and wakes an idle worker to take it
+func (w *parallelWalk) enqueue(q *walkDeque, item walkItem) {
+	w.pending.Add(1)
+	q.push(item)
+	w.queued.Add(1)
+	w.wake(false)
+}
+
+// wait parks an idle worker until a directory is queued or the walk ends,
+// reporting whether there may be work left
+func (w *parallelWalk) wait() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for w.queued.Load() == 0 && w.pending.Load() > 0 && !w.stop.Load() {
+		w.idle.Wait()
+	}
+	return w.pending.Load() > 0 && !w.stop.Load()
+}
+
+// wake wakes one idle worker, or all of them when the walk ends. Holding
+// mu orders the wakeup after a waiter's check, so none is missed
+func (w *parallelWalk) wake(all bool) {
+	w.mu.Lock()
+	if all {
+		w.idle.Broadcast()
+	} else {
+		w.idle.Signal()
+	}
+	w.mu.Unlock()
+}
+
+// stealFrom takes a directory from the first other worker that has one
+func (w *parallelWalk) stealFrom(id int) (walkItem, bool) {
+	for i := 1; i < len(w.queues); i++ {
+		if item, ok := w.queues[(id+i)%len(w.queues)].steal(); ok {
+			return item, true
+		}
+	}
+	return walkItem{}, false
+}
+
+// visitDir calls fn for the directory and its entries, queueing
+// subdirectories on own
+func (w *parallelWalk) visitDir(own *walkDeque, item walkItem) error {
+	if err := w.fn(item.path, item.entry, nil); err != nil {
+		if err == fs.SkipDir {
+			return nil
+		}
+		return err
+	}
+
+	entries, err := os.ReadDir(item.path)
+	if err != nil {
+		// Like filepath.WalkDir, report the read error with a second call
+		if err = w.fn(item.path, item.entry, err); err != nil && err != fs.SkipDir {
+			return err
+		}
+		if len(entries) == 0 {
+			return nil
+		}
+	}
+
+	for _, entry := range entries {
+		if w.stop.Load() {
+			return nil
+		}
+		path := filepath.Join(item.path, entry.Name())
+		if entry.IsDir() {
+			w.enqueue(own, walkItem{path: path, entry: entry})
+			continue
+		}
+		if err := w.fn(path, entry, nil); err != nil {
+			if err == fs.SkipDir {
+				// SkipDir on a file skips the rest of its directory
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// fail records the first error and tells every worker to stop
+func (w *parallelWalk) fail(err error) {
+	w.errOnce.Do(func() {
+		w.err = err
+	})
+	w.stop.Store(true)
+	w.wake(true)
+}
+
+// readDirBatches calls fn with the entries of dir, at most batch at a time
+// and in directory order, so a directory of millions of entries is never
+// held in memory whole. With batch below 1 it reads the whole directory,
+// sorted by name, like os.ReadDir
+func readDirBatches(dir string, batch int, fn func([]fs.DirEntry) error) error {
+	if batch < 1 {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		return fn(entries)
+	}
+
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	for {
+		entries, err := f.ReadDir(batch)
+		if len(entries) > 0 {
+			if err := fn(entries); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// walkDirBatched walks the tree rooted at root like filepath.WalkDir, but
+// reads each directory with readDirBatches, so entries are visited in
+// directory order rather than sorted
+func walkDirBatched(root string, batch int, fn fs.WalkDirFunc) error {
+	info, err := os.Lstat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walkBatched(root, fs.FileInfoToDirEntry(info), batch, fn)
+	}
+	if err == fs.SkipDir || err == fs.SkipAll {
+		return nil
+	}
+	return err
+}
+
+func walkBatched(path string, d fs.DirEntry, batch int, fn fs.WalkDirFunc) error {
+	if err := fn(path, d, nil); err != nil || !d.IsDir() {
+		if err == fs.SkipDir && d.IsDir() {
+			err = nil
+		}
+		return err
+	}
+
+	// Errors from fn are kept apart from errors reading the directory,
+	// which fn gets a second call for, as with filepath.WalkDir
+	var fnErr error
+	readErr := readDirBatches(path, batch, func(entries []fs.DirEntry) error {
+		for _, entry := range entries {
+			if err := walkBatched(filepath.Join(path, entry.Name()), entry, batch, fn); err != nil {
+				fnErr = err
+				return err
+			}
+		}
+		return nil
+	})
+	if fnErr != nil {
+		if fnErr == fs.SkipDir {
+			// SkipDir on a file skips the rest of its directory
+			return nil
+		}
+		return fnErr
+	}
+	if readErr != nil {
+		if err := fn(path, d, readErr); err != nil && err != fs.SkipDir {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,296 @@
+package gstorage
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WatchOp describes what happened to a watched path. Debounced events may
+// combine several operations
+type WatchOp uint32
+
+const (
+	WatchCreate WatchOp = 1 << iota
+	WatchModify
+	WatchRemove
+	// WatchRename is reported for the old name; the new name, if it is
+	// still being watched, gets a WatchCreate
+	WatchRename
+)
+
+func (op WatchOp) String() string {
+	var names []string
+	for _, o := range []struct {
+		op   WatchOp
+		name string
+	}{{WatchCreate, "create"}, {WatchModify, "modify"}, {WatchRemove, "remove"}, {WatchRename, "rename"}} {
+		if op&o.op != 0 {
+			names = append(names, o.name)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// WatchEvent reports a change to Path
+type WatchEvent struct {
+	Path string
+	Op   WatchOp
+}
+
+// ErrWatchOverflow is sent on Watcher.Errors when the platform dropped
+// events because they were not read fast enough
+var ErrWatchOverflow = errors.New("watch event queue overflowed")
+
+// WatchOptions tunes Watch. The zero value watches the path itself, or the
+// entries directly inside it, and reports every event as it happens.
+type WatchOptions struct {
+	// Recursive watches every directory below the path, including ones
+	// created later
+	Recursive bool
+
+	// Debounce holds back events for a path until it has been quiet for
+	// this long, then reports them as one event with the operations
+	// combined. Useful for files written in many small chunks
+	Debounce time.Duration
+
+	// Poll forces the polling backend, e.g. for network filesystems that
+	// do not deliver native notifications
+	Poll bool
+
+	// PollInterval is how often the polling backend rescans, one second
+	// if unset. Polling reports renames as a remove and a create
+	PollInterval time.Duration
+}
+
+// Watcher delivers change events for a watched path until it is closed
+type Watcher struct {
+	Events <-chan WatchEvent
+	Errors <-chan error
+
+	events  chan WatchEvent
+	errors  chan error
+	raw     chan WatchEvent
+	done    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+	backend watchBackend
+}
+
+// watchBackend is a source of raw events feeding a Watcher
+type watchBackend interface {
+	close() error
+}
+
+// Watch reports create, modify, remove and rename events below path on
+// the returned Watcher's Events channel. It uses the platform's native
+// notifications where supported (inotify on Linux) and polls otherwise.
+// Close the watcher to release it
+func Watch(path string, opts WatchOptions) (*Watcher, error) {
+	if _, err := os.Stat(path); err != nil {
+		logger().Error("error reading file info", "path", path, "err", err)
+		return nil, err
+	}
+
+	w := &Watcher{
+		events:  make(chan WatchEvent, 64),
+		errors:  make(chan error, 8),
+		raw:     make(chan WatchEvent, 64),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	w.Events, w.Errors = w.events, w.errors
+
+	var err error
+	if !opts.Poll {
+		w.backend, err = newNativeWatch(w, path, opts)
+		if errors.Is(err, errors.ErrUnsupported) {
+			logger().Debug("native watching unsupported, polling", "path", path)
+		} else if err != nil {
+			logger().Error("unable to watch", "path", path, "err", err)
+			return nil, err
+		}
+	}
+	if w.backend == nil {
+		w.backend = newPollWatch(w, path, opts)
+	}
+
+	go w.dispatch(opts.Debounce)
+	logger().Debug("watching", "path", path, "recursive", opts.Recursive)
+	return w, nil
+}
+
+// Close stops the watcher and closes its Events and Errors channels
+func (w *Watcher) Close() error {
+	var err error
+	w.once.Do(func() {
+		close(w.done)
+		err = w.backend.close()
+		<-w.stopped
+		close(w.events)
+		close(w.errors)
+	})
+	return err
+}
+
+// emit queues a raw event from the backend, giving up once closed
+func (w *Watcher) emit(path string, op WatchOp) {
+	select {
+	case w.raw <- WatchEvent{Path: path, Op: op}:
+	case <-w.done:
+	}
+}
+
+// fail reports a backend error without ever blocking the backend
+func (w *Watcher) fail(err error) {
+	select {
+	case w.errors <- err:
+	default:
+		logger().Error("dropping watch error", "err", err)
+	}
+}
+
+// dispatch forwards raw events to Events, coalescing them per path when
+// debounce is set
+func (w *Watcher) dispatch(debounce time.Duration) {
+	defer close(w.stopped)
+
+	pending := make(map[string]WatchOp)
+	deadline := make(map[string]time.Time)
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+
+	send := func(event WatchEvent) bool {
+		select {
+		case w.events <- event:
+			return true
+		case <-w.done:
+			return false
+		}
+	}
+
+	for {
+		select {
+		case <-w.done:
+			return
+
+		case event := <-w.raw:
+			if debounce <= 0 {
+				if !send(event) {
+					return
+				}
+				continue
+			}
+			pending[event.Path] |= event.Op
+			deadline[event.Path] = time.Now().Add(debounce)
+			if len(pending) == 1 {
+				timer.Reset(debounce)
+			}
+
+		case now := <-timer.C:
+			var next time.Time
+			for path, due := range deadline {
+				if !due.After(now) {
+					op := pending[path]
+					delete(pending, path)
+					delete(deadline, path)
+					if !send(WatchEvent{Path: path, Op: op}) {
+						return
+					}
+				} else if next.IsZero() || due.Before(next) {
+					next = due
+				}
+			}
+			if !next.IsZero() {
+				timer.Reset(time.Until(next))
+			}
+		}
+	}
+}
+
+// pollWatch detects changes by rescanning the tree and comparing snapshots
+type pollWatch struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+type pollState struct {
+	size  int64
+	mtime time.Time
+	dir   bool
+}
+
+func newPollWatch(w *Watcher, root string, opts WatchOptions) *pollWatch {
+	interval := opts.PollInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	p := &pollWatch{stop: make(chan struct{}), done: make(chan struct{})}
+	prev := pollSnapshot(root, opts.Recursive)
+
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+			}
+
+			cur := pollSnapshot(root, opts.Recursive)
+			for path, state := range cur {
+				old, ok := prev[path]
+				switch {
+				case !ok:
+					w.emit(path, WatchCreate)
+				case !state.dir && (state.size != old.size || !state.mtime.Equal(old.mtime)):
+					w.emit(path, WatchModify)
+				}
+			}
+			for path := range prev {
+				if _, ok := cur[path]; !ok {
+					w.emit(path, WatchRemove)
+				}
+			}
+			prev = cur
+		}
+	}()
+	return p
+}
+
+func (p *pollWatch) close() error {
+	close(p.stop)
+	<-p.done
+	return nil
+}
+
+// pollSnapshot records the state of root and the entries below it. Errors
+// leave entries out, so they show up as removed until readable again
+func pollSnapshot(root string, recursive bool) map[string]pollState {
+	snapshot := make(map[string]pollState)
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		snapshot[path] = pollState{size: info.Size(), mtime: info.ModTime(), dir: d.IsDir()}
+		if d.IsDir() && path != root && !recursive {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	// The root itself is only reported when it is a watched file
+	if state, ok := snapshot[root]; ok && state.dir {
+		delete(snapshot, root)
+	}
+	return snapshot
+}
@@ -0,0 +1,178 @@
+package gstorage
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const inotifyMask = unix.IN_CREATE | unix.IN_MODIFY | unix.IN_DELETE | unix.IN_DELETE_SELF |
+	unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_MOVE_SELF
+
+// inotifyWatch is the Linux backend. A pipe wakes the reader on close,
+// since closing an fd does not interrupt a blocked poll
+type inotifyWatch struct {
+	w         *Watcher
+	fd        int
+	wake      [2]int
+	root      string
+	recursive bool
+	done      chan struct{}
+
+	mu    sync.Mutex
+	paths map[int]string // watch descriptor to watched path
+}
+
+func newNativeWatch(w *Watcher, root string, opts WatchOptions) (watchBackend, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	b := &inotifyWatch{w: w, fd: fd, root: root, recursive: opts.Recursive, done: make(chan struct{}), paths: make(map[int]string)}
+	if err := unix.Pipe2(b.wake[:], unix.O_CLOEXEC|unix.O_NONBLOCK); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+
+	if err := b.add(root, false); err != nil {
+		b.release()
+		return nil, err
+	}
+	go b.read()
+	return b, nil
+}
+
+// add watches path and, when recursive, every directory below it. With
+// report set the entries found are reported as created, covering anything
+// made in a new directory before its watch was in place
+func (b *inotifyWatch) add(path string, report bool) error {
+	return filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p != path {
+				return nil
+			}
+			return err
+		}
+		if p != path {
+			if report {
+				b.w.emit(p, WatchCreate)
+			}
+			if !d.IsDir() {
+				return nil
+			}
+			if !b.recursive {
+				return filepath.SkipDir
+			}
+		}
+
+		wd, err := unix.InotifyAddWatch(b.fd, p, inotifyMask)
+		if err != nil {
+			return &fs.PathError{Op: "watch", Path: p, Err: err}
+		}
+		b.mu.Lock()
+		b.paths[wd] = p
+		b.mu.Unlock()
+		return nil
+	})
+}
+
+func (b *inotifyWatch) read() {
+	defer close(b.done)
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	fds := []unix.PollFd{{Fd: int32(b.fd), Events: unix.POLLIN}, {Fd: int32(b.wake[0]), Events: unix.POLLIN}}
+
+	for {
+		if _, err := unix.Poll(fds, -1); err != nil {
+			if errors.Is(err, unix.EINTR) {
+				continue
+			}
+			b.w.fail(err)
+			return
+		}
+		if fds[1].Revents != 0 {
+			return
+		}
+
+		n, err := unix.Read(b.fd, buf)
+		if errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EINTR) {
+			continue
+		}
+		if err != nil {
+			b.w.fail(err)
+			return
+		}
+		b.parse(buf[:n])
+	}
+}
+
+// parse turns a buffer of inotify records into events
+func (b *inotifyWatch) parse(buf []byte) {
+	for offset := 0; offset+unix.SizeofInotifyEvent <= len(buf); {
+		raw := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+		nameBytes := buf[offset+unix.SizeofInotifyEvent : offset+unix.SizeofInotifyEvent+int(raw.Len)]
+		offset += unix.SizeofInotifyEvent + int(raw.Len)
+
+		if raw.Mask&unix.IN_Q_OVERFLOW != 0 {
+			b.w.fail(ErrWatchOverflow)
+			continue
+		}
+
+		b.mu.Lock()
+		dir, ok := b.paths[int(raw.Wd)]
+		if raw.Mask&unix.IN_IGNORED != 0 {
+			delete(b.paths, int(raw.Wd))
+		}
+		b.mu.Unlock()
+		if !ok {
+			continue
+		}
+
+		path := dir
+		if name := string(trimNUL(nameBytes)); name != "" {
+			path = filepath.Join(dir, name)
+		}
+
+		switch {
+		case raw.Mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0:
+			b.w.emit(path, WatchCreate)
+			if raw.Mask&unix.IN_ISDIR != 0 && b.recursive {
+				if err := b.add(path, true); err != nil {
+					b.w.fail(err)
+				}
+			}
+		case raw.Mask&unix.IN_MODIFY != 0:
+			b.w.emit(path, WatchModify)
+		case raw.Mask&unix.IN_MOVED_FROM != 0:
+			b.w.emit(path, WatchRename)
+		case raw.Mask&unix.IN_DELETE != 0:
+			b.w.emit(path, WatchRemove)
+		case raw.Mask&(unix.IN_DELETE_SELF|unix.IN_MOVE_SELF) != 0 && path == b.root:
+			// Anything below the root is reported by its parent instead
+			b.w.emit(path, WatchRemove)
+		}
+	}
+}
+
+func trimNUL(b []byte) []byte {
+	for len(b) > 0 && b[len(b)-1] == 0 {
+		b = b[:len(b)-1]
+	}
+	return b
+}
+
+func (b *inotifyWatch) close() error {
+	unix.Write(b.wake[1], []byte{0})
+	<-b.done
+	return b.release()
+}
+
+func (b *inotifyWatch) release() error {
+	unix.Close(b.wake[0])
+	unix.Close(b.wake[1])
+	return unix.Close(b.fd)
+}
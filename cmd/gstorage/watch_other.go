@@ -0,0 +1,11 @@
+//go:build !linux
+
+package gstorage
+
+import "errors"
+
+// newNativeWatch reports that this platform has no native backend yet, so
+// Watch falls back to polling
+func newNativeWatch(w *Watcher, root string, opts WatchOptions) (watchBackend, error) {
+	return nil, errors.ErrUnsupported
+}
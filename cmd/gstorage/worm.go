@@ -0,0 +1,177 @@
+package gstorage
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrWriteOnce is returned when an operation would overwrite a file
+	// under a write-once root
+	ErrWriteOnce = errors.New("file is write-once")
+	// ErrRetention is returned when an operation would delete a file under
+	// a write-once root before its retention period has elapsed
+	ErrRetention = errors.New("file is under retention")
+)
+
+type writeOnceRoot struct {
+	root      string
+	retention time.Duration
+}
+
+var (
+	writeOnceMu    sync.RWMutex
+	writeOnceRoots []writeOnceRoot
+)
+
+// SetWriteOnceRoot makes every file below root write-once (WORM) for the
+// rest of the process: new files can be created, but existing ones cannot
+// be overwritten, and cannot be deleted until retention has passed since
+// they were written. A retention of 0 keeps files forever.
+//
+// The rule is enforced by this package's own operations only. Anything
+// with filesystem access can still bypass it; pair it with OS permissions
+// or storage-level immutability where that matters.
+func SetWriteOnceRoot(root string, retention time.Duration) error {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+
+	writeOnceMu.Lock()
+	defer writeOnceMu.Unlock()
+	for i, r := range writeOnceRoots {
+		if r.root == abs {
+			// Retention can only be extended, never shortened
+			if r.retention != 0 && (retention == 0 || retention > r.retention) {
+				writeOnceRoots[i].retention = retention
+			}
+			return nil
+		}
+	}
+	writeOnceRoots = append(writeOnceRoots, writeOnceRoot{root: abs, retention: retention})
+	logger().Info("write-once root configured", "root", abs, "retention", retention)
+	return nil
+}
+
+// writeOnceRootOf returns the write-once root containing path, if any
+func writeOnceRootOf(path string) (writeOnceRoot, bool) {
+	writeOnceMu.RLock()
+	defer writeOnceMu.RUnlock()
+	if len(writeOnceRoots) == 0 {
+		return writeOnceRoot{}, false
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return writeOnceRoot{}, false
+	}
+	for _, r := range writeOnceRoots {
+		if withinRoot(r.root, abs) {
+			return r, true
+		}
+	}
+	return writeOnceRoot{}, false
+}
+
+// withinRoot reports whether the absolute path abs is root or below it
+func withinRoot(root string, abs string) bool {
+	rel, err := filepath.Rel(root, abs)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// overlapsProtectedRoot reports whether the tree at the absolute path abs
+// holds anything below a write-once or legal hold root, either because it
+// lies below one or because one lies inside it
+func overlapsProtectedRoot(abs string) bool {
+	writeOnceMu.RLock()
+	for _, r := range writeOnceRoots {
+		if withinRoot(r.root, abs) || withinRoot(abs, r.root) {
+			writeOnceMu.RUnlock()
+			return true
+		}
+	}
+	writeOnceMu.RUnlock()
+
+	holdMu.RLock()
+	defer holdMu.RUnlock()
+	for _, r := range holdRoots {
+		if withinRoot(r, abs) || withinRoot(abs, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkOverwrite fails if writing path would replace a protected file:
+// one under legal hold or below a write-once root. Paths outside those
+// roots are not looked at
+func checkOverwrite(path string) error {
+	_, worm := writeOnceRootOf(path)
+	if !worm && !underHoldRoot(path) {
+		return nil
+	}
+	info, err := os.Lstat(path)
+	if err != nil || info.IsDir() {
+		return nil
+	}
+	if err := checkHold("write", path); err != nil {
+		return err
+	}
+	if worm {
+		logger().Warn("refusing to overwrite write-once file", "path", path)
+		return pathError("write", path, ErrWriteOnce)
+	}
+	return nil
+}
+
+// checkDelete fails if removing path, or anything below it, would delete a
+// file under legal hold or a write-once file still under retention. Trees
+// clear of write-once and legal hold roots are not walked
+func checkDelete(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if !overlapsProtectedRoot(abs) {
+		return nil
+	}
+
+	now := time.Now()
+	err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		// Removing a link leaves its held target alone
+		if !isSymlink(d.Type()) {
+			if err := checkHold("remove", p); err != nil {
+				return err
+			}
+		}
+		root, worm := writeOnceRootOf(p)
+		if !worm {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if root.retention == 0 || now.Sub(info.ModTime()) < root.retention {
+			logger().Warn("refusing to delete file under retention", "path", p)
+			return pathError("remove", p, ErrRetention)
+		}
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,6 @@
+package gstorage
+
+import "golang.org/x/sys/unix"
+
+// errNoAttr is returned by the xattr calls when the attribute is missing
+const errNoAttr = unix.ENOATTR
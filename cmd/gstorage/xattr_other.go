@@ -0,0 +1,15 @@
+//go:build !linux && !darwin
+
+package gstorage
+
+func getXattr(path, name string) ([]byte, bool, error) {
+	return nil, false, ErrXattrUnsupported
+}
+
+func setXattr(path, name string, value []byte) error {
+	return ErrXattrUnsupported
+}
+
+func removeXattr(path, name string) error {
+	return ErrXattrUnsupported
+}
@@ -0,0 +1,55 @@
+//go:build linux || darwin
+
+package gstorage
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// getXattr returns the value of the extended attribute name on path.
+// A missing attribute reports ok == false with a nil error
+func getXattr(path, name string) (value []byte, ok bool, err error) {
+	for {
+		size, err := unix.Getxattr(path, name, nil)
+		if err != nil {
+			return nil, false, xattrError(err)
+		}
+		value = make([]byte, size)
+		n, err := unix.Getxattr(path, name, value)
+		if errors.Is(err, unix.ERANGE) {
+			// The attribute grew between the two calls
+			continue
+		}
+		if err != nil {
+			return nil, false, xattrError(err)
+		}
+		return value[:n], true, nil
+	}
+}
+
+// setXattr sets the extended attribute name on path to value
+func setXattr(path, name string, value []byte) error {
+	return xattrError(unix.Setxattr(path, name, value, 0))
+}
+
+// removeXattr removes the extended attribute name from path. Removing a
+// missing attribute is not an error
+func removeXattr(path, name string) error {
+	return xattrError(unix.Removexattr(path, name))
+}
+
+// xattrError maps platform errors onto the package's view of xattrs: a
+// missing attribute is not an error and unsupported filesystems report
+// ErrXattrUnsupported
+func xattrError(err error) error {
+	switch {
+	case err == nil, errors.Is(err, errNoAttr):
+		return nil
+	case errors.Is(err, unix.ENOTSUP), errors.Is(err, unix.EOPNOTSUPP):
+		return ErrXattrUnsupported
+	default:
+		return err
+	}
+}
@@ -0,0 +1,118 @@
+package gstorage
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+// xxHash64 primes, from the reference implementation
+const (
+	xxPrime1 uint64 = 0x9e3779b185ebca87
+	xxPrime2 uint64 = 0xc2b2ae3d27d4eb4f
+	xxPrime3 uint64 = 0x165667b19e3779f9
+	xxPrime4 uint64 = 0x85ebca77c2b2ae63
+	xxPrime5 uint64 = 0x27d4eb2f165667c5
+)
+
+// xxHash64 computes XXH64 with a seed of 0. Sum appends the digest in
+// big-endian order, matching the output of xxhsum
+type xxHash64 struct {
+	v     [4]uint64
+	total uint64
+	buf   [32]byte
+	n     int
+}
+
+func newXXHash64() hash.Hash64 {
+	h := &xxHash64{}
+	h.Reset()
+	return h
+}
+
+func (h *xxHash64) Reset() {
+	// The seed is 0; the primes go through variables so the additions wrap
+	p1, p2 := xxPrime1, xxPrime2
+	h.v = [4]uint64{p1 + p2, p2, 0, -p1}
+	h.total = 0
+	h.n = 0
+}
+
+func (h *xxHash64) Size() int      { return 8 }
+func (h *xxHash64) BlockSize() int { return 32 }
+
+func (h *xxHash64) Write(p []byte) (int, error) {
+	written := len(p)
+	h.total += uint64(written)
+
+	if h.n > 0 {
+		c := copy(h.buf[h.n:], p)
+		h.n += c
+		p = p[c:]
+		if h.n < len(h.buf) {
+			return written, nil
+		}
+		h.stripe(h.buf[:])
+		h.n = 0
+	}
+	for len(p) >= 32 {
+		h.stripe(p[:32])
+		p = p[32:]
+	}
+	h.n = copy(h.buf[:], p)
+	return written, nil
+}
+
+// stripe folds one 32 byte block into the accumulators
+func (h *xxHash64) stripe(b []byte) {
+	for i := range h.v {
+		h.v[i] = xxRound(h.v[i], binary.LittleEndian.Uint64(b[i*8:]))
+	}
+}
+
+func (h *xxHash64) Sum64() uint64 {
+	var acc uint64
+	if h.total >= 32 {
+		acc = bits.RotateLeft64(h.v[0], 1) + bits.RotateLeft64(h.v[1], 7) +
+			bits.RotateLeft64(h.v[2], 12) + bits.RotateLeft64(h.v[3], 18)
+		for _, v := range h.v {
+			acc ^= xxRound(0, v)
+			acc = acc*xxPrime1 + xxPrime4
+		}
+	} else {
+		acc = xxPrime5
+	}
+	acc += h.total
+
+	tail := h.buf[:h.n]
+	for ; len(tail) >= 8; tail = tail[8:] {
+		acc ^= xxRound(0, binary.LittleEndian.Uint64(tail))
+		acc = bits.RotateLeft64(acc, 27)*xxPrime1 + xxPrime4
+	}
+	if len(tail) >= 4 {
+		acc ^= uint64(binary.LittleEndian.Uint32(tail)) * xxPrime1
+		acc = bits.RotateLeft64(acc, 23)*xxPrime2 + xxPrime3
+		tail = tail[4:]
+	}
+	for _, b := range tail {
+		acc ^= uint64(b) * xxPrime5
+		acc = bits.RotateLeft64(acc, 11) * xxPrime1
+	}
+
+	acc ^= acc >> 33
+	acc *= xxPrime2
+	acc ^= acc >> 29
+	acc *= xxPrime3
+	acc ^= acc >> 32
+	return acc
+}
+
+func (h *xxHash64) Sum(b []byte) []byte {
+	return binary.BigEndian.AppendUint64(b, h.Sum64())
+}
+
+func xxRound(acc, input uint64) uint64 {
+	acc += input * xxPrime2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * xxPrime1
+}